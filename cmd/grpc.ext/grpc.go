@@ -61,6 +61,7 @@ func main() {
 		insecureGRPC,
 		certPins,
 		rootPool,
+		0,
 		logger,
 	)
 	if err != nil {
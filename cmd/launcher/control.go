@@ -4,6 +4,8 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
+	"io/ioutil"
 
 	"github.com/boltdb/bolt"
 	"github.com/go-kit/kit/log"
@@ -20,6 +22,17 @@ func createControl(ctx context.Context, db *bolt.DB, logger log.Logger, opts *op
 		control.WithLogger(logger),
 		control.WithGetShellsInterval(opts.getShellsInterval),
 	}
+	if opts.controlRootPEM != "" {
+		pemContents, err := ioutil.ReadFile(opts.controlRootPEM)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading control root certs PEM at path: %s", opts.controlRootPEM)
+		}
+		rootPool := x509.NewCertPool()
+		if ok := rootPool.AppendCertsFromPEM(pemContents); !ok {
+			return nil, errors.Errorf("found no valid certs in PEM at path: %s", opts.controlRootPEM)
+		}
+		controlOpts = append(controlOpts, control.WithRootCAs(rootPool))
+	}
 	if opts.insecureTLS {
 		controlOpts = append(controlOpts, control.WithInsecureSkipVerify())
 	}
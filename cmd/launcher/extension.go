@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io/ioutil"
 
 	"github.com/boltdb/bolt"
@@ -40,8 +41,22 @@ func createExtensionRuntime(ctx context.Context, rootDirectory string, db *bolt.
 		enrollSecret = string(bytes.TrimSpace(content))
 	}
 
+	// read the static osquery config, if there is one. It's checked
+	// here, as osquery would otherwise silently ignore a bad one.
+	var staticConfig string
+	if opts.osqueryConfigPath != "" {
+		content, err := ioutil.ReadFile(opts.osqueryConfigPath)
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "could not read osquery_config_path: %s", opts.osqueryConfigPath)
+		}
+		if !json.Valid(content) {
+			return nil, nil, nil, errors.Errorf("osquery_config_path %s is not valid JSON", opts.osqueryConfigPath)
+		}
+		staticConfig = string(content)
+	}
+
 	// create the client of the grpc service
-	launcherClient := service.New(grpcConn, level.Debug(logger))
+	launcherClient := service.New(grpcConn, level.Debug(logger), service.WithRequestTimeout(opts.requestTimeout))
 
 	// create the osquery extension
 	extOpts := osquery.ExtensionOpts{
@@ -49,6 +64,7 @@ func createExtensionRuntime(ctx context.Context, rootDirectory string, db *bolt.
 		Logger:                            logger,
 		LoggingInterval:                   opts.loggingInterval,
 		RunDifferentialQueriesImmediately: opts.enableInitialRunner,
+		StaticConfig:                      staticConfig,
 	}
 
 	// create the extension
@@ -62,6 +78,9 @@ func createExtensionRuntime(ctx context.Context, rootDirectory string, db *bolt.
 
 	runner := runtime.LaunchUnstartedInstance(
 		runtime.WithOsquerydBinary(opts.osquerydPath),
+		runtime.WithFlagfile(opts.osqueryFlagfile),
+		runtime.WithExtensionNames(opts.osqueryExtensions...),
+		runtime.WithWatchdogLimits(opts.watchdogMemoryLimit, opts.watchdogUtilization),
 		runtime.WithRootDirectory(rootDirectory),
 		runtime.WithConfigPluginFlag("kolide_grpc"),
 		runtime.WithLoggerPluginFlag("kolide_grpc"),
@@ -9,6 +9,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -113,7 +114,7 @@ func runFlare(args []string) error {
 	logger := log.NewLogfmtLogger(b)
 	err = reportGRPCNetwork(
 		logger,
-		serverURL,
+		selectServer(serverURL, 5*time.Second, net.DialTimeout, logger),
 		insecureTLS,
 		insecureGRPC,
 		enrollSecret,
@@ -273,6 +274,7 @@ func reportGRPCNetwork(
 		insecureGRPC,
 		certPins,
 		rootPool,
+		0,
 		logger,
 	)
 
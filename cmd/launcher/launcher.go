@@ -8,11 +8,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"text/tabwriter"
 	"time"
@@ -259,9 +261,16 @@ func runLauncher(ctx context.Context, cancel func(), opts *options, logger log.L
 	}
 
 	// connect to the grpc server
-	grpcConn, err := service.DialGRPC(opts.kolideServerURL, opts.insecureTLS, opts.insecureGRPC, opts.certPins, rootPool, logger)
+	serverURL := selectServer(opts.kolideServerURL, opts.connectTimeout, net.DialTimeout, logger)
+	grpcConn, err := service.DialGRPC(serverURL, opts.insecureTLS, opts.insecureGRPC, opts.certPins, rootPool, opts.connectTimeout, logger)
 	if err != nil {
-		return errors.Wrap(err, "dialing grpc server")
+		// Don't fail to start while offline. osquery still runs, and
+		// grpc keeps trying to connect in the background.
+		level.Info(logger).Log("msg", "grpc server didn't connect in time, connecting in the background", "server", serverURL, "connect_timeout", opts.connectTimeout, "err", err)
+		grpcConn, err = service.DialGRPC(serverURL, opts.insecureTLS, opts.insecureGRPC, opts.certPins, rootPool, 0, logger)
+		if err != nil {
+			return errors.Wrap(err, "dialing grpc server")
+		}
 	}
 
 	// create a rungroup for all the actors we create to allow for easy start/stop
@@ -349,6 +358,45 @@ func runLauncher(ctx context.Context, cancel func(), opts *options, logger log.L
 	return errors.Wrap(err, "run service")
 }
 
+// selectServer picks the gRPC server to use from a comma separated
+// list, in priority order. It's the first one that dial connects to
+// within timeout, with servers lacking a port probed on 443. If none
+// do, the primary is used, and left to the usual grpc retries.
+//
+// Servers are only probed once, at startup. A server that becomes
+// unreachable later is never failed over from, until launcher restarts.
+func selectServer(serverURLs string, timeout time.Duration, dial dialFunc, logger log.Logger) string {
+	servers := strings.Split(serverURLs, ",")
+	if len(servers) == 1 {
+		return serverURLs
+	}
+
+	for i, server := range servers {
+		server = strings.TrimSpace(server)
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "443")
+		}
+		servers[i] = server
+	}
+
+	for _, server := range servers {
+		conn, err := dial("tcp", server, timeout)
+		if err != nil {
+			level.Info(logger).Log("msg", "grpc server unreachable, trying next", "server", server, "err", err)
+			continue
+		}
+		conn.Close()
+		return server
+	}
+
+	level.Info(logger).Log("msg", "no grpc servers reachable, using primary", "server", servers[0])
+	return servers[0]
+}
+
+// dialFunc matches net.DialTimeout, so tests can fake which servers
+// are reachable.
+type dialFunc func(network, address string, timeout time.Duration) (net.Conn, error)
+
 func writePidFile(path string) error {
 	err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0600)
 	return errors.Wrap(err, "writing pidfile")
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectServer(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		servers   string
+		reachable []string
+		dialed    []string
+		out       string
+	}{
+		{
+			name:      "single server isn't probed",
+			servers:   "a.example.com:443",
+			reachable: []string{},
+			out:       "a.example.com:443",
+		},
+		{
+			name:      "portless host",
+			servers:   "a.example.com,b.example.com:8443",
+			reachable: []string{"a.example.com:443"},
+			dialed:    []string{"a.example.com:443"},
+			out:       "a.example.com:443",
+		},
+		{
+			name:      "first unreachable",
+			servers:   "a.example.com:443, b.example.com:443",
+			reachable: []string{"b.example.com:443"},
+			dialed:    []string{"a.example.com:443", "b.example.com:443"},
+			out:       "b.example.com:443",
+		},
+		{
+			name:      "all unreachable",
+			servers:   "a.example.com,b.example.com:443",
+			reachable: []string{},
+			dialed:    []string{"a.example.com:443", "b.example.com:443"},
+			out:       "a.example.com:443",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var dialed []string
+			dial := func(network, address string, timeout time.Duration) (net.Conn, error) {
+				require.Equal(t, 3*time.Second, timeout)
+				dialed = append(dialed, address)
+				for _, r := range tt.reachable {
+					if address == r {
+						client, server := net.Pipe()
+						server.Close()
+						return client, nil
+					}
+				}
+				return nil, errors.New("connection refused")
+			}
+
+			require.Equal(t, tt.out, selectServer(tt.servers, 3*time.Second, dial, log.NewNopLogger()))
+			require.Equal(t, tt.dialed, dialed)
+		})
+	}
+}
@@ -44,7 +44,8 @@ func main() {
 		os.Exit(0)
 	}
 
-	logger = logutil.NewServerLogger(opts.debug)
+	logger = logutil.NewServerLogger(opts.debug || opts.logLevel == "debug")
+	logger = filterLogLevel(logger, opts.logLevel)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -82,6 +82,8 @@ func main() {
 		os.Exit(0)
 	}
 
+	logger = filterLogLevel(logger, opts.logLevel)
+
 	err = run(serviceName, &winSvc{logger: logger, opts: opts})
 	if err != nil {
 		logutil.Fatal(logger, "err", errors.Wrap(err, "run"))
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/env"
 	"github.com/kolide/kit/version"
 	"github.com/kolide/launcher/pkg/autoupdate"
@@ -23,19 +26,28 @@ type options struct {
 	enrollSecretPath    string
 	rootDirectory       string
 	osquerydPath        string
+	osqueryFlagfile     string
+	osqueryConfigPath   string
+	osqueryExtensions   []string // file names of custom extensions, next to launcher
+	watchdogMemoryLimit int      // MB. With a limit, osquery's watchdog is enabled.
+	watchdogUtilization int      // CPU percent
 	certPins            [][]byte
 	rootPEM             string
 	loggingInterval     time.Duration
+	connectTimeout      time.Duration
+	requestTimeout      time.Duration
 	enableInitialRunner bool
 
 	control           bool
 	controlServerURL  string
+	controlRootPEM    string
 	getShellsInterval time.Duration
 
 	autoupdate         bool
 	printVersion       bool
 	developerUsage     bool
 	debug              bool
+	logLevel           string
 	disableControlTLS  bool
 	insecureTLS        bool
 	insecureGRPC       bool
@@ -63,7 +75,7 @@ func parseOptions() (*options, error) {
 		flKolideServerURL = flag.String(
 			"hostname",
 			env.String("KOLIDE_LAUNCHER_HOSTNAME", ""),
-			"The hostname of the gRPC server",
+			"The hostname of the gRPC server. Comma separate multiple servers, in priority order, for failover",
 		)
 
 		flControl = flag.Bool(
@@ -76,6 +88,11 @@ func parseOptions() (*options, error) {
 			env.String("KOLIDE_CONTROL_HOSTNAME", ""),
 			"The hostname of the control server",
 		)
+		flControlRootPEM = flag.String(
+			"control_root_pem",
+			env.String("KOLIDE_LAUNCHER_CONTROL_ROOT_PEM", ""),
+			"Path to PEM file including root certificates to verify the control server against",
+		)
 		flGetShellsInterval = flag.Duration(
 			"control_get_shells_interval",
 			env.Duration("KOLIDE_CONTROL_GET_SHELLS_INTERVAL", 3*time.Second),
@@ -97,6 +114,31 @@ func parseOptions() (*options, error) {
 			env.String("KOLIDE_LAUNCHER_OSQUERYD_PATH", ""),
 			"Path to the osqueryd binary to use (Default: find osqueryd in $PATH)",
 		)
+		flOsqueryFlagfile = flag.String(
+			"osquery_flagfile",
+			env.String("KOLIDE_LAUNCHER_OSQUERY_FLAGFILE", ""),
+			"Path to an osquery flagfile, with additional flags for osqueryd",
+		)
+		flOsqueryConfigPath = flag.String(
+			"osquery_config_path",
+			env.String("KOLIDE_LAUNCHER_OSQUERY_CONFIG_PATH", ""),
+			"Path to a static osquery config, as JSON. osquery merges it with the config from the server",
+		)
+		flOsqueryExtensionName = flag.String(
+			"osquery_extension_name",
+			env.String("KOLIDE_LAUNCHER_OSQUERY_EXTENSION_NAME", ""),
+			"File name of a custom osquery extension to autoload, in the same directory as launcher, or comma separated names to autoload several (default: osquery-extension.ext)",
+		)
+		flWatchdogMemoryLimit = flag.Int(
+			"watchdog_memory_limit",
+			intEnv("KOLIDE_LAUNCHER_WATCHDOG_MEMORY_LIMIT", 0),
+			"Restart osquery if it uses more than this many MB of memory. Enables osquery's watchdog (default: disabled)",
+		)
+		flWatchdogUtilizationLimit = flag.Int(
+			"watchdog_utilization_limit",
+			intEnv("KOLIDE_LAUNCHER_WATCHDOG_UTILIZATION_LIMIT", 0),
+			"Restart osquery if it uses more than this percentage of CPU. Enables osquery's watchdog (default: disabled)",
+		)
 		flCertPins = flag.String(
 			"cert_pins",
 			env.String("KOLIDE_LAUNCHER_CERT_PINS", ""),
@@ -112,6 +154,16 @@ func parseOptions() (*options, error) {
 			env.Duration("KOLIDE_LAUNCHER_LOGGING_INTERVAL", 60*time.Second),
 			"The interval at which logs should be flushed to the server",
 		)
+		flConnectTimeout = flag.Duration(
+			"connect_timeout",
+			env.Duration("KOLIDE_LAUNCHER_CONNECT_TIMEOUT", time.Second),
+			"How long to wait at startup to connect to the gRPC server, before carrying on and connecting in the background",
+		)
+		flRequestTimeout = flag.Duration(
+			"request_timeout",
+			env.Duration("KOLIDE_LAUNCHER_REQUEST_TIMEOUT", 60*time.Second),
+			"How long each request to the gRPC server has, before it's cancelled",
+		)
 
 		// Autoupdate options
 		flAutoupdate = flag.Bool(
@@ -146,6 +198,11 @@ func parseOptions() (*options, error) {
 			env.Bool("KOLIDE_LAUNCHER_DEBUG", false),
 			"Whether or not debug logging is enabled (default: false)",
 		)
+		flLogLevel = flag.String(
+			"log_level",
+			env.String("KOLIDE_LAUNCHER_LOG_LEVEL", ""),
+			"The minimum level to log (options: debug, info, warn, error). Overrides --debug",
+		)
 		flDisableControlTLS = flag.Bool(
 			"disable_control_tls",
 			env.Bool("KOLIDE_LAUNCHER_DISABLE_CONTROL_TLS", false),
@@ -218,6 +275,39 @@ func parseOptions() (*options, error) {
 		return nil, fmt.Errorf("unknown update channel %s", *flUpdateChannel)
 	}
 
+	switch *flLogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("unknown log level %s", *flLogLevel)
+	}
+
+	var osqueryExtensions []string
+	if *flOsqueryExtensionName != "" {
+		for _, name := range strings.Split(*flOsqueryExtensionName, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || strings.ContainsAny(name, `/\`) {
+				return nil, fmt.Errorf("osquery_extension_name must be file names, not paths, got %s", *flOsqueryExtensionName)
+			}
+			osqueryExtensions = append(osqueryExtensions, name)
+		}
+	}
+
+	if *flWatchdogMemoryLimit < 0 {
+		return nil, fmt.Errorf("watchdog_memory_limit can't be negative, got %d", *flWatchdogMemoryLimit)
+	}
+
+	if *flWatchdogUtilizationLimit < 0 {
+		return nil, fmt.Errorf("watchdog_utilization_limit can't be negative, got %d", *flWatchdogUtilizationLimit)
+	}
+
+	if *flConnectTimeout <= 0 {
+		return nil, fmt.Errorf("connect_timeout must be positive, got %s", *flConnectTimeout)
+	}
+
+	if *flRequestTimeout <= 0 {
+		return nil, fmt.Errorf("request_timeout must be positive, got %s", *flRequestTimeout)
+	}
+
 	certPins, err := parseCertPins(*flCertPins)
 	if err != nil {
 		return nil, err
@@ -227,19 +317,28 @@ func parseOptions() (*options, error) {
 		kolideServerURL:     *flKolideServerURL,
 		control:             *flControl,
 		controlServerURL:    *flControlServerURL,
+		controlRootPEM:      *flControlRootPEM,
 		getShellsInterval:   *flGetShellsInterval,
 		enrollSecret:        *flEnrollSecret,
 		enrollSecretPath:    *flEnrollSecretPath,
 		rootDirectory:       *flRootDirectory,
 		osquerydPath:        osquerydPath,
+		osqueryFlagfile:     *flOsqueryFlagfile,
+		osqueryConfigPath:   *flOsqueryConfigPath,
+		osqueryExtensions:   osqueryExtensions,
+		watchdogMemoryLimit: *flWatchdogMemoryLimit,
+		watchdogUtilization: *flWatchdogUtilizationLimit,
 		certPins:            certPins,
 		rootPEM:             *flRootPEM,
 		loggingInterval:     *flLoggingInterval,
+		connectTimeout:      *flConnectTimeout,
+		requestTimeout:      *flRequestTimeout,
 		enableInitialRunner: *flInitialRunner,
 		autoupdate:          *flAutoupdate,
 		printVersion:        *flVersion,
 		developerUsage:      *flDeveloperUsage,
 		debug:               *flDebug,
+		logLevel:            *flLogLevel,
 		disableControlTLS:   *flDisableControlTLS,
 		insecureTLS:         *flInsecureTLS,
 		insecureGRPC:        *flInsecureGRPC,
@@ -279,6 +378,9 @@ func shortUsage() {
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("root_directory")
 	printOpt("osqueryd_path")
+	printOpt("osquery_flagfile")
+	printOpt("osquery_config_path")
+	printOpt("osquery_extension_name")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("autoupdate")
 	fmt.Fprintf(os.Stderr, "\n")
@@ -319,11 +421,17 @@ func developerUsage() {
 	fmt.Fprintf(os.Stderr, "Development Options:\n")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("debug")
+	printOpt("log_level")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("insecure")
 	printOpt("insecure_grpc")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("logging_interval")
+	printOpt("connect_timeout")
+	printOpt("request_timeout")
+	fmt.Fprintf(os.Stderr, "\n")
+	printOpt("watchdog_memory_limit")
+	printOpt("watchdog_utilization_limit")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("notary_url")
 	printOpt("mirror_url")
@@ -331,6 +439,7 @@ func developerUsage() {
 	printOpt("update_channel")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("control_get_shells_interval")
+	printOpt("control_root_pem")
 	printOpt("disable_control_tls")
 	fmt.Fprintf(os.Stderr, "\n")
 	usageFooter()
@@ -354,6 +463,20 @@ func duration(key string, def time.Duration) time.Duration {
 	return def
 }
 
+// intEnv returns the int value of the environment variable key, or
+// def if it's unset. kit's env doesn't have ints.
+func intEnv(key string, def int) int {
+	if env, ok := os.LookupEnv(key); ok {
+		i, err := strconv.Atoi(env)
+		if err != nil {
+			fmt.Println("env: parse int flag: ", err)
+			os.Exit(1)
+		}
+		return i
+	}
+	return def
+}
+
 func parseCertPins(pins string) ([][]byte, error) {
 	var certPins [][]byte
 	if pins != "" {
@@ -367,3 +490,19 @@ func parseCertPins(pins string) ([][]byte, error) {
 	}
 	return certPins, nil
 }
+
+// filterLogLevel restricts logger to the level set with --log_level. An
+// empty level leaves logger unchanged.
+func filterLogLevel(logger log.Logger, logLevel string) log.Logger {
+	switch logLevel {
+	case "debug":
+		return level.NewFilter(logger, level.AllowDebug())
+	case "info":
+		return level.NewFilter(logger, level.AllowInfo())
+	case "warn":
+		return level.NewFilter(logger, level.AllowWarn())
+	case "error":
+		return level.NewFilter(logger, level.AllowError())
+	}
+	return logger
+}
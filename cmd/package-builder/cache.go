@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/kit/env"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/kolide/launcher/pkg/packaging"
+	"github.com/pkg/errors"
+)
+
+func runCache(args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintf(os.Stderr, "USAGE\n  package-builder cache verify [flags]\n\n")
+		return errors.New("cache needs a command: verify")
+	}
+
+	flagset := flag.NewFlagSet("cache verify", flag.ExitOnError)
+	var (
+		flDebug = flagset.Bool(
+			"debug",
+			false,
+			"enable debug logging",
+		)
+		flCacheDir = flagset.String(
+			"cache_dir",
+			env.String("CACHE_DIR", ""),
+			"The download cache to verify, as passed to make",
+		)
+		flDelete = flagset.Bool(
+			"delete",
+			false,
+			"Remove downloads that don't match their TUF metadata, so the next build downloads them again",
+		)
+		flPrune = flagset.Duration(
+			"prune",
+			0,
+			"If set, remove downloads made longer ago than this, eg 720h",
+		)
+	)
+
+	flagset.Usage = usageFor(flagset, "package-builder cache verify [flags]")
+	if err := flagset.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	logger := log.NewJSONLogger(os.Stderr)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	logger = log.With(logger, "caller", log.DefaultCaller)
+
+	if *flDebug {
+		logger = level.NewFilter(logger, level.AllowDebug())
+	} else {
+		logger = level.NewFilter(logger, level.AllowInfo())
+	}
+
+	ctx := context.Background()
+	ctx = ctxlog.NewContext(ctx, logger)
+
+	if *flCacheDir == "" {
+		flagset.Usage()
+		return errors.New("cache_dir is required")
+	}
+	if *flPrune < 0 {
+		return errors.New("prune can't be negative")
+	}
+
+	opts := []packaging.CacheOpt{}
+	if *flDelete {
+		opts = append(opts, packaging.WithRemoveCorrupt())
+	}
+	if *flPrune > 0 {
+		opts = append(opts, packaging.WithPrune(*flPrune))
+	}
+
+	entries, err := packaging.VerifyCache(ctx, *flCacheDir, opts...)
+	if err != nil {
+		return err
+	}
+
+	corrupt := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "PATH\tTARGET\tAGE\tSTATUS\n")
+	for _, entry := range entries {
+		status := "ok"
+		switch {
+		case entry.Err != nil && entry.Removed:
+			status = fmt.Sprintf("removed, %v", entry.Err)
+		case entry.Err != nil:
+			status = entry.Err.Error()
+			corrupt++
+		case entry.Pruned:
+			status = "pruned"
+		}
+		age := time.Since(entry.ModTime).Truncate(time.Minute)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Path, entry.TargetName, age, status)
+	}
+	w.Flush()
+
+	if corrupt > 0 {
+		return errors.Errorf("%d cached downloads don't match their TUF metadata. Remove them with --delete", corrupt)
+	}
+	return nil
+}
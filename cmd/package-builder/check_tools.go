@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kolide/launcher/pkg/packaging"
+)
+
+// checkToolsTargets returns the targets check_tools reports on. That's
+// every target package-builder knows, unless targets narrows it down,
+// less any excluded.
+func checkToolsTargets(input, exclude string, includeWindows bool, arch string, universal bool) ([]packaging.Target, error) {
+	var targets []packaging.Target
+	if strings.TrimSpace(input) == "" {
+		seen := make(map[packaging.Target]bool)
+		all := packaging.DefaultTargets(true)
+		for _, keyword := range packaging.TargetKeywords() {
+			all = append(all, keyword.Targets...)
+		}
+		for _, target := range all {
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	} else {
+		var err error
+		if targets, err = packaging.ParseTargets(input, includeWindows); err != nil {
+			return nil, err
+		}
+	}
+
+	targets, err := packaging.ExcludeTargets(targets, exclude, includeWindows)
+	if err != nil {
+		return nil, err
+	}
+
+	if targets, err = packaging.ExpandArches(targets, arch); err != nil {
+		return nil, err
+	}
+	if universal {
+		return packaging.UniversalTargets(targets)
+	}
+	return targets, nil
+}
+
+// printTools prints the tools each target needs, and whether they're
+// in the PATH, followed by where each tool was found.
+func printTools(w io.Writer, packageOptions packaging.PackageOptions, targets []packaging.Target) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "TARGET\tTOOLS\tSTATUS\n")
+
+	tools := []string{}
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		required := packageOptions.RequiredTools(target)
+		for _, tool := range required {
+			if !seen[tool] {
+				seen[tool] = true
+				tools = append(tools, tool)
+			}
+		}
+
+		toolNames, status := strings.Join(required, ", "), "ok"
+		if len(required) == 0 {
+			toolNames = "none"
+		}
+		if missing := packageOptions.MissingTools(target); len(missing) > 0 {
+			status = fmt.Sprintf("missing %s", strings.Join(missing, ", "))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", target.String(), toolNames, status)
+	}
+
+	fmt.Fprintf(tw, "\nTOOL\tPATH\n")
+	for _, tool := range tools {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			path = "not found"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", tool, path)
+	}
+	return tw.Flush()
+}
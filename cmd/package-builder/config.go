@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// applyConfigFile sets flags from a YAML, or JSON, file. Keys are flag
+// names, eg: hostname, or targets. Lists are joined with commas, like
+// the flags expect. Flags set on the command line take precedence over
+// the file. Unknown keys are an error, so typos don't go unnoticed.
+func applyConfigFile(flagset *flag.FlagSet, path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading config file %s", path)
+	}
+
+	// JSON is valid YAML, so this handles both.
+	jsonContents, err := yaml.YAMLToJSON(contents)
+	if err != nil {
+		return errors.Wrapf(err, "parsing config file %s", path)
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(jsonContents, &config); err != nil {
+		return errors.Wrapf(err, "parsing config file %s", path)
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	flagset.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	keys := []string{}
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	unknown := []string{}
+	for _, key := range keys {
+		if key == "config_file" || flagset.Lookup(key) == nil {
+			unknown = append(unknown, key)
+			continue
+		}
+
+		if setOnCommandLine[key] {
+			continue
+		}
+
+		value, err := configValue(config[key])
+		if err != nil {
+			return errors.Wrapf(err, "config file key %s", key)
+		}
+
+		if err := flagset.Set(key, value); err != nil {
+			return errors.Wrapf(err, "config file key %s", key)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return errors.Errorf("Unknown keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// configValue converts a decoded config value into a flag value.
+func configValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := []string{}
+		for _, item := range value {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.Errorf("unsupported value %s", fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "package-builder-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`
+hostname: config.example.com:443
+identifier: config-app
+targets:
+  - deb
+  - rpm
+autoupdate: true
+restart_sec: 5
+`), 0644))
+
+	// Environment variables are the flags' defaults, as with env.String
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	hostname := flagset.String("hostname", "env.example.com:443", "")
+	identifier := flagset.String("identifier", "env-app", "")
+	targets := flagset.String("targets", "", "")
+	autoupdate := flagset.Bool("autoupdate", false, "")
+	restartSec := flagset.Int("restart_sec", 0, "")
+	updateChannel := flagset.String("update_channel", "env-channel", "")
+	flagset.String("config_file", "", "")
+
+	require.NoError(t, flagset.Parse([]string{"--hostname", "cli.example.com:443", "--config_file", configPath}))
+	require.NoError(t, applyConfigFile(flagset, configPath))
+
+	// Command line flags take precedence over the file, which takes
+	// precedence over the environment
+	require.Equal(t, "cli.example.com:443", *hostname)
+	require.Equal(t, "config-app", *identifier)
+	require.Equal(t, "deb,rpm", *targets)
+	require.True(t, *autoupdate)
+	require.Equal(t, 5, *restartSec)
+	require.Equal(t, "env-channel", *updateChannel)
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "package-builder-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		name     string
+		contents string
+		contains string
+	}{
+		{name: "unknown keys", contents: "hostnme: a.example.com\nidentifer: app\n", contains: "Unknown keys in config file"},
+		{name: "config_file isn't a setting", contents: "config_file: other.yaml\n", contains: "config_file"},
+		{name: "bad value", contents: "restart_sec: soon\n", contains: "restart_sec"},
+		{name: "nested value", contents: "hostname:\n  name: a.example.com\n", contains: "hostname"},
+		{name: "not yaml", contents: "hostname: [a\n", contains: "parsing config file"},
+	}
+
+	for _, tt := range tests {
+		configPath := filepath.Join(dir, "config.yaml")
+		require.NoError(t, ioutil.WriteFile(configPath, []byte(tt.contents), 0644))
+
+		flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+		flagset.String("hostname", "", "")
+		flagset.String("identifier", "", "")
+		flagset.Int("restart_sec", 0, "")
+		flagset.String("config_file", "", "")
+
+		err := applyConfigFile(flagset, configPath)
+		require.Error(t, err, tt.name)
+		require.Contains(t, err.Error(), tt.contains, tt.name)
+	}
+
+	err = applyConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), filepath.Join(dir, "missing.yaml"))
+	require.Error(t, err)
+}
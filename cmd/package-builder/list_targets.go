@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kolide/launcher/pkg/packaging"
+)
+
+func runListTargets(args []string) error {
+	flagset := flag.NewFlagSet("list-targets", flag.ExitOnError)
+	flagset.Usage = usageFor(flagset, "package-builder list-targets")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "TARGET\tPLATFORM\tINIT\tPACKAGE\tEXTENSION\n")
+
+	printTargets := func(name string, targets []packaging.Target) {
+		for _, target := range targets {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, target.Platform, target.Init, target.Package, target.PkgExtension())
+		}
+	}
+
+	printTargets("all", packaging.DefaultTargets(false))
+	for _, keyword := range packaging.TargetKeywords() {
+		printTargets(keyword.Name, keyword.Targets)
+	}
+	w.Flush()
+
+	fmt.Fprintf(os.Stdout, "\nall includes windows when --include_windows is set. Use --arch to build for other architectures.\n")
+	fmt.Fprintf(os.Stdout, "Targets may choose their init system as package:init, eg deb:upstart. Inits: %s\n", initFlavorNames())
+	fmt.Fprintf(os.Stdout, "Package formats: %s\n", packageFlavorNames())
+	return nil
+}
+
+func initFlavorNames() string {
+	names := []string{}
+	for _, flavor := range packaging.InitFlavors() {
+		names = append(names, string(flavor))
+	}
+	return strings.Join(names, ", ")
+}
+
+func packageFlavorNames() string {
+	names := []string{}
+	for _, flavor := range packaging.PackageFlavors() {
+		names = append(names, string(flavor))
+	}
+	return strings.Join(names, ", ")
+}
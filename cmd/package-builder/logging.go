@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// newLogger returns the JSON logger builds log to. It writes to
+// stderr, and, if logFile is set, to that too, so CI can archive the
+// full build log. With a log file, debug logs only go to the file, so
+// they don't clutter the console.
+//
+// The returned func closes the log file, first logging runErr to it,
+// as that's otherwise only printed to stderr.
+func newLogger(debug bool, logFile string) (log.Logger, func(runErr error), error) {
+	if logFile == "" {
+		logger := level.NewFilter(log.NewJSONLogger(os.Stderr), allowLevel(debug))
+		return withContext(logger), func(error) {}, nil
+	}
+
+	fh, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "opening log_file")
+	}
+
+	console := level.NewFilter(log.NewJSONLogger(os.Stderr), allowLevel(false))
+	file := level.NewFilter(log.NewJSONLogger(log.NewSyncWriter(fh)), allowLevel(debug))
+	logger := withContext(log.LoggerFunc(func(keyvals ...interface{}) error {
+		consoleErr := console.Log(keyvals...)
+		if err := file.Log(keyvals...); err != nil {
+			return err
+		}
+		return consoleErr
+	}))
+
+	closeLog := func(runErr error) {
+		if runErr != nil {
+			level.Error(withContext(file)).Log("msg", "package-builder failed", "err", runErr)
+		}
+		if err := fh.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "closing log_file: %v\n", err)
+		}
+	}
+	return logger, closeLog, nil
+}
+
+func allowLevel(debug bool) level.Option {
+	if debug {
+		return level.AllowDebug()
+	}
+	return level.AllowInfo()
+}
+
+// withContext adds the timestamp and caller to each log line.
+func withContext(logger log.Logger) log.Logger {
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	return log.With(logger, "caller", log.DefaultCaller)
+}
@@ -2,41 +2,93 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
 
-	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/env"
 	"github.com/kolide/kit/version"
 	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/kolide/launcher/pkg/packagekit"
 	"github.com/kolide/launcher/pkg/packaging"
 	"github.com/pkg/errors"
 )
 
 func runVersion(args []string) error {
+	flagset := flag.NewFlagSet("version", flag.ExitOnError)
+	flJSON := flagset.Bool(
+		"json",
+		false,
+		"Print the version information as JSON",
+	)
+	flagset.Usage = usageFor(flagset, "package-builder version [flags]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	if *flJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(version.Version())
+	}
+
 	version.PrintFull()
 	return nil
 }
 
 func runMake(args []string) error {
-	flagset := flag.NewFlagSet("macos", flag.ExitOnError)
+	return runPackaging("make", args)
+}
+
+func runScripts(args []string) error {
+	return runPackaging("scripts", args)
+}
+
+// runPackaging implements both make, and scripts. They take the same
+// flags, so a scripts run renders exactly what make would package.
+// scripts takes its targets as arguments, rather than --targets.
+func runPackaging(mode string, args []string) (err error) {
+	flagset := flag.NewFlagSet(mode, flag.ExitOnError)
 	var (
 		flDebug = flagset.Bool(
 			"debug",
 			false,
 			"enable debug logging",
 		)
+		flLogFile = flagset.String(
+			"log_file",
+			env.String("LOG_FILE", ""),
+			"Also write the build logs to this file, truncating it. With --debug, debug logs only go to the file",
+		)
+		flVerboseBuild = flagset.Bool(
+			"verbose_build",
+			env.Bool("VERBOSE_BUILD", false),
+			"Log the output of the packaging tools, such as fpm and pkgbuild, as they run. Implies --debug",
+		)
+		flConfigFile = flagset.String(
+			"config_file",
+			env.String("CONFIG_FILE", ""),
+			"YAML or JSON file of flag values. Flags on the command line take precedence",
+		)
 		flHostname = flagset.String(
 			"hostname",
 			env.String("HOSTNAME", ""),
-			"the hostname of the gRPC server",
+			"the hostname of the gRPC server. Comma separate multiple servers, in priority order, for failover",
 		)
 		flPackageVersion = flagset.String(
 			"package_version",
@@ -46,27 +98,117 @@ func runMake(args []string) error {
 		flOsqueryVersion = flagset.String(
 			"osquery_version",
 			env.String("OSQUERY_VERSION", "stable"),
-			"What TUF channel to download osquery from. Supports filesystem paths",
+			"What TUF channel, or exact version, to download osquery from. Supports sha256:<hash> pins, and filesystem paths",
+		)
+		flBundleOsquery = flagset.Bool(
+			"bundle_osquery",
+			env.Bool("BUNDLE_OSQUERY", true),
+			"Bundle osqueryd in the package. Set to false where osquery is managed separately, with osqueryd_path",
+		)
+		flOsquerydPath = flagset.String(
+			"osqueryd_path",
+			env.String("OSQUERYD_PATH", ""),
+			"With bundle_osquery=false, the absolute path of the host's osqueryd, for launcher to run",
 		)
 		flLauncherVersion = flagset.String(
 			"launcher_version",
 			env.String("LAUNCHER_VERSION", "stable"),
-			"What TUF channel to download launcher from. Supports filesystem paths",
+			"What TUF channel, or exact version, to download launcher from. Supports sha256:<hash> pins, sha:<commit> dev builds, and filesystem paths",
+		)
+		flMinLauncherVersion = flagset.String(
+			"min_launcher_version",
+			env.String("MIN_LAUNCHER_VERSION", ""),
+			"If set, fail the build if launcher, with its channel resolved to a version, is older than this",
 		)
 		flExtensionVersion = flagset.String(
 			"extension_version",
 			env.String("EXTENSION_VERSION", "stable"),
-			"What TUF channel to download the osquery extension from. Supports filesystem paths",
+			"What TUF channel, or exact version, to download the osquery extension from. Supports sha256:<hash> pins, sha:<commit> dev builds, and filesystem paths",
+		)
+		flExtensionName = flagset.String(
+			"extension_name",
+			env.String("EXTENSION_NAME", ""),
+			"File name of a custom osquery extension to package, and have launcher autoload, instead of the standard one (default: osquery-extension.ext, or .exe on windows)",
+		)
+		flLocalBuildDir = flagset.String(
+			"local_build_dir",
+			env.String("LOCAL_BUILD_DIR", ""),
+			"Directory of locally built osqueryd, launcher, and osquery-extension.ext binaries to package. Overrides the version flags",
+		)
+		flFetchAtInstall = flagset.Bool(
+			"fetch_at_install",
+			env.Bool("FETCH_AT_INSTALL", false),
+			"Package only launcher. The postinstall downloads osqueryd, and the extension, from install_mirror_url, and checks them against their TUF hashes. Not for tar, msi, chocolatey, or snap packages",
+		)
+		flInstallMirrorURL = flagset.String(
+			"install_mirror_url",
+			env.String("INSTALL_MIRROR_URL", ""),
+			"With fetch_at_install, the mirror installing hosts download binaries from. It's laid out like tuf_mirror_url",
+		)
+		flStripBinaries = flagset.Bool(
+			"strip_binaries",
+			env.Bool("STRIP_BINARIES", true),
+			"Strip symbols from the packaged linux and darwin binaries, with the build host's strip, to make them smaller. Only binaries for the host's platform, or, with use_docker, linux binaries, are stripped. Signed darwin binaries are left as they are. Set false to package binaries with their symbols, for debugging",
+		)
+		flUseDocker = flagset.Bool(
+			"use_docker",
+			env.Bool("USE_DOCKER", false),
+			"Run linux targets' build tools, like strip and deb and rpm signing, in a linux container, so any host with docker can build them. Not for snap packages",
+		)
+		flOCIBase = flagset.String(
+			"oci_base",
+			env.String("OCI_BASE", ""),
+			"Path to an OCI layout tarball, eg from skopeo copy docker://debian:stable-slim oci-archive:base.tar, to build oci targets' images on. If unset, they're built from scratch",
 		)
 		flEnrollSecret = flagset.String(
 			"enroll_secret",
 			env.String("ENROLL_SECRET", ""),
 			"the string to be used as the server enrollment secret",
 		)
+		flEnrollSecretPath = flagset.String(
+			"enroll_secret_path",
+			env.String("ENROLL_SECRET_PATH", ""),
+			"Path to a file containing the server enrollment secret. Mutually exclusive with --enroll_secret",
+		)
+		flSecretsFile = flagset.String(
+			"secrets_file",
+			env.String("SECRETS_FILE", ""),
+			"Path to a YAML, or JSON, file mapping tenant ids to enroll secrets. A package is built for each tenant, with the tenant in its file name",
+		)
 		flSigningKey = flagset.String(
 			"mac_package_signing_key",
 			env.String("SIGNING_KEY", ""),
-			"The name of the key that should be used to packages. Behavior is platform and packaging specific",
+			"The name of the key that should be used to packages. Behavior is platform and packaging specific. The build fails if the package isn't signed",
+		)
+		flLinuxSigningKey = flagset.String(
+			"linux_signing_key",
+			env.String("LINUX_SIGNING_KEY", ""),
+			"GPG key ID to sign deb and rpm packages with. The key must be in the default gpg keyring (or $GNUPGHOME), and rpm and dpkg-sig must be installed. The build fails if signing does",
+		)
+		flNotarize = flagset.Bool(
+			"notarize",
+			env.Bool("NOTARIZE", false),
+			"Submit signed macOS pkgs to Apple's notary service, and staple the ticket. Requires mac_package_signing_key, and either apple_keychain_profile, or apple_id, apple_team_id, and apple_app_password",
+		)
+		flAppleID = flagset.String(
+			"apple_id",
+			env.String("APPLE_ID", ""),
+			"Apple ID to notarize with",
+		)
+		flAppleTeamID = flagset.String(
+			"apple_team_id",
+			env.String("APPLE_TEAM_ID", ""),
+			"Apple developer team ID to notarize with",
+		)
+		flAppleAppPassword = flagset.String(
+			"apple_app_password",
+			env.String("APPLE_APP_PASSWORD", ""),
+			"App specific password for apple_id. Prefer the APPLE_APP_PASSWORD environment variable, to keep it out of the process list",
+		)
+		flAppleKeychainProfile = flagset.String(
+			"apple_keychain_profile",
+			env.String("APPLE_KEYCHAIN_PROFILE", ""),
+			"Keychain profile with notarization credentials, as stored by `xcrun notarytool store-credentials`",
 		)
 		flInsecure = flagset.Bool(
 			"insecure",
@@ -78,6 +220,16 @@ func runMake(args []string) error {
 			env.Bool("INSECURE_GRPC", false),
 			"whether or not the launcher packages should invoke the launcher's --insecure_grpc flag",
 		)
+		flIUnderstandInsecure = flagset.Bool(
+			"i_understand_insecure",
+			env.Bool("I_UNDERSTAND_INSECURE", false),
+			"Acknowledge that insecure and insecure_grpc packages don't verify, or encrypt, launcher's connection to the server. Required to build them",
+		)
+		flAutoupdateMirrorURL = flagset.String(
+			"autoupdate_mirror_url",
+			env.String("AUTOUPDATE_MIRROR_URL", ""),
+			"The mirror the installed launcher downloads updates from, for self-hosted autoupdates. Sets launcher's --mirror_url (default: launcher's default, https://dl.kolide.co)",
+		)
 		flAutoupdate = flagset.Bool(
 			"autoupdate",
 			env.Bool("AUTOUPDATE", false),
@@ -88,6 +240,61 @@ func runMake(args []string) error {
 			env.String("UPDATE_CHANNEL", ""),
 			"the value that should be used when invoking the launcher's --update_channel flag",
 		)
+		flLauncherLogLevel = flagset.String(
+			"launcher_log_level",
+			env.String("LAUNCHER_LOG_LEVEL", ""),
+			"the value that should be used when invoking the launcher's --log_level flag (options: debug, info, warn, error)",
+		)
+		flLauncherConnectTimeout = flagset.Duration(
+			"launcher_connect_timeout",
+			env.Duration("LAUNCHER_CONNECT_TIMEOUT", 0),
+			"How long the installed launcher waits to connect to the gRPC server, eg 10s. Sets launcher's --connect_timeout (default: launcher's default, 1s)",
+		)
+		flLauncherRequestTimeout = flagset.Duration(
+			"launcher_request_timeout",
+			env.Duration("LAUNCHER_REQUEST_TIMEOUT", 0),
+			"How long each of the installed launcher's gRPC requests has, eg 2m. Sets launcher's --request_timeout (default: launcher's default, 60s)",
+		)
+		flWatchdogMemoryLimit = flagset.Int(
+			"watchdog_memory_limit",
+			0,
+			"the value that should be used when invoking the launcher's --watchdog_memory_limit flag, in MB (default: no limit)",
+		)
+		flWatchdogUtilizationLimit = flagset.Int(
+			"watchdog_utilization_limit",
+			0,
+			"the value that should be used when invoking the launcher's --watchdog_utilization_limit flag, as a CPU percentage (default: no limit)",
+		)
+		flNoStart = flagset.Bool(
+			"no_start",
+			env.Bool("NO_START", false),
+			"Install the launcher service, but don't enable or start it. It can be started manually, or by later orchestration",
+		)
+		flRunAsUser = flagset.String(
+			"run_as_user",
+			env.String("RUN_AS_USER", ""),
+			"Run launcher as this user, rather than root, on linux. It's created at install if missing",
+		)
+		flRunAsGroup = flagset.String(
+			"run_as_group",
+			env.String("RUN_AS_GROUP", ""),
+			"Run launcher as this group, with run_as_user. It's created at install if missing (default: the user's own group)",
+		)
+		flRestartPolicy = flagset.String(
+			"restart_policy",
+			env.String("RESTART_POLICY", ""),
+			"When the init system restarts launcher: a systemd Restart= value, such as always, on-failure, or no. Upstart supports only those three (default: on-failure)",
+		)
+		flRestartSec = flagset.Int(
+			"restart_sec",
+			0,
+			"Seconds the init system waits before restarting launcher, on systemd and upstart (default: 3 on systemd, none on upstart)",
+		)
+		flLaunchdPlistTemplate = flagset.String(
+			"launchd_plist_template",
+			env.String("LAUNCHD_PLIST_TEMPLATE", ""),
+			"Path to a Go text/template for the launchd plist, such as to change its KeepAlive, rather than the default. Fields: .Identifier .Label .Program .ProgramArguments .Environment .SecretPath .StandardErrorPath .StandardOutPath .ThrottleInterval .Disabled",
+		)
 		flControl = flagset.Bool(
 			"control",
 			env.Bool("CONTROL", false),
@@ -98,6 +305,16 @@ func runMake(args []string) error {
 			env.String("CONTROL_HOSTNAME", ""),
 			"the value that should be used when invoking the launcher's --control_hostname flag",
 		)
+		flControlRequestInterval = flagset.Duration(
+			"control_request_interval",
+			env.Duration("CONTROL_REQUEST_INTERVAL", 0),
+			"How often the installed launcher polls the control server, eg 30s. Sets launcher's --control_get_shells_interval (default: launcher's default)",
+		)
+		flControlRootPEM = flagset.String(
+			"control_root_pem",
+			env.String("CONTROL_ROOT_PEM", ""),
+			"Path to a PEM file including root certificates to verify the control server against, rather than the system roots",
+		)
 		flDisableControlTLS = flagset.Bool(
 			"disable_control_tls",
 			env.Bool("DISABLE_CONTROL_TLS", false),
@@ -108,11 +325,56 @@ func runMake(args []string) error {
 			env.String("IDENTIFIER", "launcher"),
 			"the name of the directory that the launcher installation will shard into",
 		)
+		flInstallPrefix = flagset.String(
+			"install_prefix",
+			env.String("INSTALL_PREFIX", ""),
+			"Absolute path to install binaries, in <prefix>/<identifier>/bin, and config, in <prefix>/etc/<identifier>, under. Not supported for windows (default: /usr/local, with config in /etc on linux and macOS)",
+		)
+		flVendor = flagset.String(
+			"vendor",
+			env.String("VENDOR", ""),
+			"Vendor in the package metadata. Also the manufacturer of msi packages (default: Kolide, and the identifier for msi)",
+		)
+		flMaintainer = flagset.String(
+			"maintainer",
+			env.String("MAINTAINER", ""),
+			"Maintainer in the package metadata, eg: IT <it@example.com> (default: the vendor)",
+		)
+		flDescription = flagset.String(
+			"description",
+			env.String("DESCRIPTION", ""),
+			"Description in the package metadata (default: The Kolide Launcher, packaged for <identifier>)",
+		)
+		flLicense = flagset.String(
+			"license",
+			env.String("LICENSE", packagekit.DefaultLicense),
+			"License in the package metadata",
+		)
+		flHomepage = flagset.String(
+			"homepage",
+			env.String("HOMEPAGE", packagekit.DefaultHomepage),
+			"Homepage url in the package metadata",
+		)
 		flOmitSecret = flagset.Bool(
 			"omit_secret",
 			env.Bool("OMIT_SECRET", false),
 			"omit the enroll secret in the resultant package (default: false)",
 		)
+		flSecretFromEnv = flagset.String(
+			"secret_from_env",
+			env.String("SECRET_FROM_ENV", ""),
+			"Rather than packaging the enroll secret, read it from this environment variable when the package is installed. An existing secret on the host is kept if it's unset",
+		)
+		flUpgradeOnly = flagset.Bool(
+			"upgrade_only",
+			env.Bool("UPGRADE_ONLY", false),
+			"Build packages that only upgrade an existing install's binaries and init file, keeping its secret and config. Installing fails without an existing install. Only deb, pkg, and tar",
+		)
+		flSecretFileMode = flagset.String(
+			"secret_file_mode",
+			env.String("SECRET_FILE_MODE", "0600"),
+			"Octal permissions of the installed enroll secret file. It must be readable by its owner, and not writable by anyone else",
+		)
 		flCertPins = flagset.String(
 			"cert_pins",
 			env.String("CERT_PINS", ""),
@@ -121,17 +383,92 @@ func runMake(args []string) error {
 		flRootPEM = flagset.String(
 			"root_pem",
 			env.String("ROOT_PEM", ""),
-			"Path to PEM file including root certificates to verify against",
+			"Comma separated paths to PEM files including root certificates to verify against. They are merged into a single bundle",
 		)
 		flOutputDir = flagset.String(
 			"output_dir",
 			env.String("OUTPUT_DIR", ""),
-			"Directory to output package files to (default: random)",
+			"Directory to output package files to, or - to write a single package to stdout (default: random)",
+		)
+		flOutputNameTemplate = flagset.String(
+			"output_name_template",
+			env.String("OUTPUT_NAME_TEMPLATE", ""),
+			"Go text/template for package file names. Fields: .Target .Platform .Init .Package .Arch .PackageVersion .Ext .Tenant (default: launcher.{{.Target}}.{{.Ext}}, or launcher.{{.Tenant}}.{{.Target}}.{{.Ext}} with secrets_file)",
+		)
+		flOsqueryFlagfile = flagset.String(
+			"osquery_flagfile",
+			env.String("OSQUERY_FLAGFILE", ""),
+			"Path to an osquery flagfile to include in the package, for additional osqueryd flags",
+		)
+		flOsqueryConfigPath = flagset.String(
+			"osquery_config_path",
+			env.String("OSQUERY_CONFIG_PATH", ""),
+			"Path to a static osquery config, as JSON, to include in the package. osquery merges it with the config from the server",
+		)
+		flPreinstallScript = flagset.String(
+			"preinstall_script",
+			env.String("PREINSTALL_SCRIPT", ""),
+			"Path to a shell script to run before install. Not supported for tar or windows packages",
+		)
+		flPostinstallScript = flagset.String(
+			"postinstall_script",
+			env.String("POSTINSTALL_SCRIPT", ""),
+			"Path to a shell script to run after install, once the generated postinstall has run. Not supported for tar or windows packages",
+		)
+		flPreremoveScript = flagset.String(
+			"preremove_script",
+			env.String("PREREMOVE_SCRIPT", ""),
+			"Path to a shell script to run before removal. Not supported for tar, windows, or macOS packages",
 		)
 		flCacheDir = flagset.String(
 			"cache_dir",
 			env.String("CACHE_DIR", ""),
-			"Directory to cache downloads in (default: random)",
+			"Directory to cache downloads in. Reused across runs when set (default: random)",
+		)
+		flMirrorURL = flagset.String(
+			"tuf_mirror_url",
+			env.String("TUF_MIRROR_URL", ""),
+			"Mirror to download binaries from (default: https://dl.kolide.co)",
+		)
+		flNotaryURL = flagset.String(
+			"notary_url",
+			env.String("NOTARY_URL", ""),
+			"Notary server to fetch TUF metadata from (default: https://notary.kolide.co)",
+		)
+		flTUFRootJSON = flagset.String(
+			"tuf_root_json",
+			env.String("TUF_ROOT_JSON", ""),
+			"Path to trusted TUF root metadata, eg a self-hosted notary's root.json. If set, the TUF metadata is verified against it",
+		)
+		flProxy = flagset.String(
+			"proxy",
+			env.String("PROXY", ""),
+			"Proxy URL to download through (default: HTTP_PROXY, HTTPS_PROXY, and NO_PROXY from the environment)",
+		)
+		flDownloadRetries = flagset.Int(
+			"download_retries",
+			3,
+			"How many times to retry transient download failures",
+		)
+		flDownloadRetryBackoff = flagset.Duration(
+			"download_retry_backoff",
+			env.Duration("DOWNLOAD_RETRY_BACKOFF", 1*time.Second),
+			"How long to wait before the first download retry. Doubles with each attempt",
+		)
+		flDownloadTimeout = flagset.Duration(
+			"download_timeout",
+			env.Duration("DOWNLOAD_TIMEOUT", 0),
+			"How long each download attempt may take before it's retried, or the next mirror is tried (default: no limit)",
+		)
+		flRefreshCache = flagset.Bool(
+			"refresh_cache",
+			env.Bool("REFRESH_CACHE", false),
+			"Ignore cached downloads, and fetch fresh copies",
+		)
+		flNoNetwork = flagset.Bool(
+			"no_network",
+			env.Bool("NO_NETWORK", false),
+			"Forbid network access. Binaries must be filesystem paths, or in --cache_dir from a previous build, and docker images must already be pulled",
 		)
 		flInitialRunner = flagset.Bool(
 			"with_initial_runner",
@@ -141,75 +478,802 @@ func runMake(args []string) error {
 		flTargets = flagset.String(
 			"targets",
 			env.String("TARGETS", ""),
-			"Target platforms to build",
+			fmt.Sprintf("Comma separated target platforms to build. Choose the init system with package:init, eg deb:sysvinit (options: %s)", strings.Join(packaging.TargetKeywordNames(), ", ")),
+		)
+		flExcludeTargets = flagset.String(
+			"exclude_targets",
+			env.String("EXCLUDE_TARGETS", ""),
+			"Comma separated target platforms to leave out of --targets, or the default set, eg darwin. It's an error if they aren't in it",
+		)
+		flArch = flagset.String(
+			"arch",
+			env.String("ARCH", ""),
+			"Comma separated architectures to build each target for (options: amd64, arm64. default: amd64)",
+		)
+		flUniversal = flagset.Bool(
+			"universal",
+			env.Bool("UNIVERSAL", false),
+			"Build darwin targets as a single universal package, with amd64 and arm64 binaries combined by lipo",
+		)
+		flIncludeWindows = flagset.Bool(
+			"include_windows",
+			env.Bool("INCLUDE_WINDOWS", false),
+			"Include the windows msi in the default set of targets",
+		)
+		flOutputFormat = flagset.String(
+			"output_format",
+			env.String("OUTPUT_FORMAT", "human"),
+			"How to report the built packages (options: human, json)",
+		)
+		flChecksums = flagset.Bool(
+			"checksums",
+			env.Bool("CHECKSUMS", false),
+			"Write a sha256sum compatible <package>.sha256 file next to each package",
+		)
+		flSBOM = flagset.String(
+			"sbom",
+			env.String("SBOM", ""),
+			"Write a software bill of materials, of the packaged binaries, next to each package (options: cyclonedx, spdx)",
+		)
+		flTimingReport = flagset.Bool(
+			"timing_report",
+			env.Bool("TIMING_REPORT", false),
+			"Report how long each target's build spent fetching binaries, generating scripts, and packaging, at the end of the run",
+		)
+		flWriteLockfile = flagset.String(
+			"write_lockfile",
+			env.String("WRITE_LOCKFILE", ""),
+			"Path to write a lockfile, recording the sha256 of every package, and the binaries and files that went into it, to after a successful build",
 		)
+		flVerifyLockfile = flagset.String(
+			"verify_lockfile",
+			env.String("VERIFY_LOCKFILE", ""),
+			"Path to a lockfile, from --write_lockfile, that the build's packages and inputs must exactly match. Needs a reproducible build, see --source_date_epoch",
+		)
+		flCompression = flagset.String(
+			"compression",
+			env.String("COMPRESSION", ""),
+			"Compression for deb, rpm, and pacman packages (options: none, gzip, xz, zstd). xz is smallest, but slowest to build and install. none is fastest, and largest. zstd is pacman only (default: gzip, zstd for pacman)",
+		)
+		flMaxPackageSize = flagset.String(
+			"max_package_size",
+			env.String("MAX_PACKAGE_SIZE", ""),
+			"Fail any package larger than this, eg: 50MB (units: KB, MB, GB. default: no limit)",
+		)
+		flSkipUnbuildable = flagset.Bool(
+			"skip_unbuildable",
+			env.Bool("SKIP_UNBUILDABLE", false),
+			"Skip, with a warning, targets whose build tools (eg: pkgbuild, docker) aren't installed, rather than failing",
+		)
+		flCheckTools = flagset.Bool(
+			"check_tools",
+			env.Bool("CHECK_TOOLS", false),
+			"Print which build tools (eg: pkgbuild, docker) each target needs, and whether they're installed, without building anything. Checks every target, unless targets is set",
+		)
+		flDryRun = flagset.Bool(
+			"dry_run",
+			env.Bool("DRY_RUN", false),
+			"Print the build plan, without downloading or building anything",
+		)
+		flValidateOnly = flagset.Bool(
+			"validate_only",
+			env.Bool("VALIDATE_ONLY", false),
+			"Check the flags, build tools, and that the versions and channels exist, without downloading or building anything",
+		)
+		flPrintFlags = flagset.Bool(
+			"print_flags",
+			env.Bool("PRINT_FLAGS", false),
+			"Print the command line and environment the installed launcher will run with, per target, without downloading or building anything",
+		)
+		flTimeout = flagset.Duration(
+			"timeout",
+			env.Duration("TIMEOUT", 0),
+			"Abort if building all the packages takes longer than this (default: no timeout)",
+		)
+		flSourceDateEpoch = flagset.String(
+			"source_date_epoch",
+			env.String("SOURCE_DATE_EPOCH", ""),
+			"Unix timestamp to use for file mtimes and embedded timestamps, for reproducible deb, rpm, and tar builds",
+		)
+		flKeepTemp = flagset.Bool(
+			"keep_temp",
+			env.Bool("KEEP_TEMP", false),
+			"Keep temporary build directories, and partial output from failed builds, for debugging",
+		)
+		flEmbedBuildMetadata = flagset.Bool(
+			"embed_build_metadata",
+			env.Bool("EMBED_BUILD_METADATA", true),
+			"Include a version.json, recording the binary versions and build time, in the config directory of each package",
+		)
+		flMaxParallel = flagset.Int(
+			"max_parallel",
+			runtime.NumCPU(),
+			"Maximum number of targets to build concurrently",
+		)
+	)
+
+	flExtraFiles := newStringsFlag(env.String("EXTRA_FILES", ""))
+	flagset.Var(
+		flExtraFiles,
+		"extra_file",
+		"Additional file to include in the package, as src:dest, with dest relative to the package root. Repeatable",
+	)
+
+	flExtensions := newStringsFlag(env.String("EXTENSIONS", ""))
+	flagset.Var(
+		flExtensions,
+		"extension",
+		"Additional osquery extension to package, and have launcher autoload, as a path, or name=version, where version is a channel, version, or path, eg acme-extension.ext=stable. Repeatable",
+	)
+
+	flLauncherFlags := newStringsFlag(env.String("LAUNCHER_FLAGS", ""))
+	flagset.Var(
+		flLauncherFlags,
+		"launcher_flag",
+		"Additional launcher flag, as name=value, or name for a boolean flag, for flags without an option here. Repeatable",
+	)
+
+	flDownloadMirrors := newStringsFlag(env.String("DOWNLOAD_MIRRORS", ""))
+	flagset.Var(
+		flDownloadMirrors,
+		"download_mirrors",
+		"Comma separated mirrors to download binaries from, tried in order until one succeeds. Exclusive with tuf_mirror_url",
+	)
+
+	flChannelAliases := newStringsFlag(env.String("CHANNEL_ALIASES", ""))
+	flagset.Var(
+		flChannelAliases,
+		"channel_aliases",
+		"Comma separated alias=channel names, such as prod=stable, that osquery_version, launcher_version, and extension_version may use",
 	)
 
-	flagset.Usage = usageFor(flagset, "package-builder make [flags]")
+	if mode == "scripts" {
+		flagset.Usage = usageFor(flagset, "package-builder scripts [flags] <target>")
+	} else {
+		flagset.Usage = usageFor(flagset, "package-builder make [flags]")
+	}
+	if err := setIntEnvDefaults(flagset, map[string]string{
+		"watchdog_memory_limit":      "WATCHDOG_MEMORY_LIMIT",
+		"watchdog_utilization_limit": "WATCHDOG_UTILIZATION_LIMIT",
+		"restart_sec":                "RESTART_SEC",
+		"download_retries":           "DOWNLOAD_RETRIES",
+		"max_parallel":               "MAX_PARALLEL",
+	}); err != nil {
+		return err
+	}
+
 	if err := flagset.Parse(args); err != nil {
 		return err
 	}
 
-	logger := log.NewJSONLogger(os.Stderr)
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
-	logger = log.With(logger, "caller", log.DefaultCaller)
+	// Apply the config file first, so the checks below see its
+	// settings too.
+	if *flConfigFile != "" {
+		if err := applyConfigFile(flagset, *flConfigFile); err != nil {
+			return err
+		}
+	}
 
-	if *flDebug {
-		logger = level.NewFilter(logger, level.AllowDebug())
-	} else {
-		logger = level.NewFilter(logger, level.AllowInfo())
+	scriptsMode := mode == "scripts"
+	if scriptsMode {
+		if flagset.NArg() != 1 {
+			return errors.New("scripts requires a single target argument, eg: deb, or rpm:upstart")
+		}
+		if *flDryRun || *flValidateOnly || *flPrintFlags || *flCheckTools {
+			return errors.New("scripts doesn't support dry_run, validate_only, print_flags, or check_tools")
+		}
+	} else if flagset.NArg() > 0 {
+		return errors.Errorf("unexpected arguments %s", strings.Join(flagset.Args(), " "))
+	}
+
+	logger, closeLog, err := newLogger(*flDebug || *flVerboseBuild, *flLogFile)
+	if err != nil {
+		return err
 	}
+	defer func() { closeLog(err) }()
 
 	ctx := context.Background()
 	ctx = ctxlog.NewContext(ctx, logger)
 
+	if *flTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *flTimeout)
+		defer cancel()
+	}
+
+	// Deferred first, so it's still handling signals while the other
+	// deferred cleanups run.
+	ctx, stopSignals := cancelOnSignal(ctx, logger)
+	defer stopSignals()
+
+	// Checking tools doesn't need a hostname, or secret, so it works
+	// before anything else is set up.
+	if *flCheckTools {
+		checkOptions := packaging.PackageOptions{
+			OsqueryVersion:   *flOsqueryVersion,
+			OmitOsquery:      !*flBundleOsquery,
+			LauncherVersion:  *flLauncherVersion,
+			ExtensionVersion: *flExtensionVersion,
+			LocalBuildDir:    *flLocalBuildDir,
+			StripBinaries:    *flStripBinaries,
+			UseDocker:        *flUseDocker,
+			SigningKey:       *flSigningKey,
+			LinuxSigningKey:  *flLinuxSigningKey,
+		}
+		if *flNotarize {
+			checkOptions.Notarize = &packagekit.NotarizeOptions{}
+		}
+		targets, err := checkToolsTargets(*flTargets, *flExcludeTargets, *flIncludeWindows, *flArch, *flUniversal)
+		if err != nil {
+			return err
+		}
+		return printTools(os.Stdout, checkOptions, targets)
+	}
+
 	if *flHostname == "" {
 		return errors.New("Hostname undefined")
 	}
 
-	// Validate that pinned certs are valid hex
-	for _, pin := range strings.Split(*flCertPins, ",") {
-		if _, err := hex.DecodeString(pin); err != nil {
-			return errors.Wrap(err, "unable to parse cert pins")
+	if err := packaging.ValidateIdentifier(*flIdentifier); err != nil {
+		return err
+	}
+
+	hostnames, err := packaging.ParseHostnames(*flHostname)
+	if err != nil {
+		return err
+	}
+
+	enrollSecret := *flEnrollSecret
+	if *flEnrollSecretPath != "" {
+		if enrollSecret != "" {
+			return errors.New("Only one of enroll_secret and enroll_secret_path may be specified")
+		}
+
+		var err error
+		if enrollSecret, err = readSecretFile(*flEnrollSecretPath); err != nil {
+			return err
+		}
+	}
+
+	// Exactly one way of provisioning the secret
+	secretModes := []string{}
+	if enrollSecret != "" {
+		secretModes = append(secretModes, "enroll_secret")
+	}
+	if *flSecretsFile != "" {
+		secretModes = append(secretModes, "secrets_file")
+	}
+	if *flOmitSecret {
+		secretModes = append(secretModes, "omit_secret")
+	}
+	if *flSecretFromEnv != "" {
+		secretModes = append(secretModes, "secret_from_env")
+	}
+	if *flUpgradeOnly {
+		secretModes = append(secretModes, "upgrade_only")
+	}
+	switch len(secretModes) {
+	case 0:
+		return errors.New("No enroll secret. Set one of enroll_secret, enroll_secret_path, secrets_file, secret_from_env, omit_secret, or upgrade_only")
+	case 1:
+	default:
+		return errors.Errorf("Only one of enroll_secret, secrets_file, secret_from_env, omit_secret, and upgrade_only may be specified, got %s", strings.Join(secretModes, ", "))
+	}
+
+	// Each tenant gets its own packages, built with the same binaries
+	var tenants []string
+	var tenantSecrets map[string]string
+	if *flSecretsFile != "" {
+		if scriptsMode {
+			return errors.New("scripts renders a single package's scripts, so can't be used with secrets_file")
+		}
+		if tenantSecrets, err = packaging.ParseSecretsFile(*flSecretsFile); err != nil {
+			return err
+		}
+		for tenant := range tenantSecrets {
+			tenants = append(tenants, tenant)
+		}
+		sort.Strings(tenants)
+	}
+
+	secretFileMode, err := strconv.ParseUint(*flSecretFileMode, 8, 32)
+	if err != nil {
+		return errors.Errorf("secret_file_mode %s is not an octal mode, eg: 0600", *flSecretFileMode)
+	}
+
+	if *flOutputFormat != "human" && *flOutputFormat != "json" {
+		return errors.Errorf("Unknown output_format %s", *flOutputFormat)
+	}
+
+	// Insecure packages are for development. They're easy to leave on
+	// by mistake, and a production fleet running them can be
+	// impersonated by anyone on the network.
+	if *flInsecure || *flInsecureGrpc {
+		if !*flIUnderstandInsecure {
+			return errors.New("insecure and insecure_grpc build packages whose launcher doesn't verify the server's certificate, or doesn't use TLS at all, so anyone on the network can impersonate the server. Only use them for development, and set i_understand_insecure to build them")
+		}
+		level.Warn(logger).Log("msg", "building insecure packages, these are for development only", "insecure", *flInsecure, "insecure_grpc", *flInsecureGrpc)
+	}
+
+	if *flUpdateChannel != "" && !*flAutoupdate {
+		return errors.New("update_channel requires autoupdate")
+	}
+
+	if *flAutoupdateMirrorURL != "" && !*flAutoupdate {
+		return errors.New("autoupdate_mirror_url requires autoupdate")
+	}
+
+	if *flFetchAtInstall && *flInstallMirrorURL == "" {
+		return errors.New("fetch_at_install requires install_mirror_url")
+	}
+
+	if *flInstallMirrorURL != "" && !*flFetchAtInstall {
+		return errors.New("install_mirror_url requires fetch_at_install")
+	}
+
+	if *flControl && *flControlHostname == "" {
+		return errors.New("control requires control_hostname")
+	}
+
+	if !*flControl && (*flControlHostname != "" || *flDisableControlTLS || *flControlRequestInterval != 0 || *flControlRootPEM != "") {
+		return errors.New("control_hostname, control_request_interval, control_root_pem, and disable_control_tls require control")
+	}
+
+	if *flControlRequestInterval < 0 {
+		return errors.New("control_request_interval can't be negative")
+	}
+
+	if !*flBundleOsquery && *flOsquerydPath == "" {
+		return errors.New("bundle_osquery=false requires osqueryd_path")
+	}
+
+	if *flBundleOsquery && *flOsquerydPath != "" {
+		return errors.New("osqueryd_path requires bundle_osquery=false")
+	}
+
+	if *flDryRun && *flValidateOnly {
+		return errors.New("Only one of dry_run and validate_only may be specified")
+	}
+
+	if *flPrintFlags && (*flDryRun || *flValidateOnly) {
+		return errors.New("print_flags can't be used with dry_run or validate_only")
+	}
+
+	if *flAutoupdate && *flUpdateChannel == "" {
+		level.Warn(logger).Log("msg", "autoupdate is set without update_channel, launcher will use its default channel (stable)")
+	}
+
+	if err := packaging.ValidateLauncherLogLevel(*flLauncherLogLevel); err != nil {
+		return err
+	}
+
+	extraLauncherFlags, err := packaging.ParseLauncherFlags(flLauncherFlags.values)
+	if err != nil {
+		return err
+	}
+	if managed := packaging.ManagedLauncherFlags(extraLauncherFlags); len(managed) > 0 {
+		level.Warn(logger).Log("msg", "launcher_flag sets flags package-builder manages, they override its settings", "flags", strings.Join(managed, ","))
+	}
+
+	if *flOsqueryFlagfile != "" {
+		if err := packaging.ValidateOsqueryFlagfile(*flOsqueryFlagfile); err != nil {
+			return err
 		}
 	}
 
+	if *flOsqueryConfigPath != "" {
+		if err := packaging.ValidateOsqueryConfig(*flOsqueryConfigPath); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range []string{*flPreinstallScript, *flPostinstallScript, *flPreremoveScript} {
+		if path != "" {
+			if err := packaging.ValidateHookScript(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, value := range map[string]string{"tuf_mirror_url": *flMirrorURL, "notary_url": *flNotaryURL, "autoupdate_mirror_url": *flAutoupdateMirrorURL, "install_mirror_url": *flInstallMirrorURL} {
+		if err := validateServerURL(value, *flInsecure); err != nil {
+			return errors.Wrapf(err, "invalid %s", name)
+		}
+	}
+
+	if *flTUFRootJSON != "" {
+		if _, err := packaging.ReadTUFRoot(*flTUFRootJSON); err != nil {
+			return err
+		}
+	}
+
+	if len(flDownloadMirrors.values) > 0 && *flMirrorURL != "" {
+		return errors.New("only one of download_mirrors and tuf_mirror_url can be set")
+	}
+	for _, mirror := range flDownloadMirrors.values {
+		if err := validateServerURL(mirror, *flInsecure); err != nil {
+			return errors.Wrap(err, "invalid download_mirrors")
+		}
+	}
+
+	channelAliases, err := packaging.ParseChannelAliases(flChannelAliases.values)
+	if err != nil {
+		return errors.Wrap(err, "invalid channel_aliases")
+	}
+	for name, version := range map[string]*string{"osquery_version": flOsqueryVersion, "launcher_version": flLauncherVersion, "extension_version": flExtensionVersion} {
+		resolved, err := channelAliases.Resolve(*version)
+		if err != nil {
+			return errors.Wrapf(err, "resolving %s", name)
+		}
+		if resolved != *version {
+			level.Info(logger).Log("msg", "resolved channel alias", "flag", name, "alias", *version, "channel", resolved)
+			*version = resolved
+		}
+	}
+
+	var sourceDateEpoch time.Time
+	if *flSourceDateEpoch != "" {
+		epoch, err := strconv.ParseInt(*flSourceDateEpoch, 10, 64)
+		if err != nil || epoch < 0 {
+			return errors.Errorf("source_date_epoch must be a unix timestamp, got %s", *flSourceDateEpoch)
+		}
+		sourceDateEpoch = time.Unix(epoch, 0)
+	}
+
+	if err := validateProxyURL(*flProxy); err != nil {
+		return errors.Wrap(err, "invalid proxy")
+	}
+
+	if *flDownloadRetries < 0 {
+		return errors.Errorf("download_retries can't be negative, got %d", *flDownloadRetries)
+	}
+	if *flDownloadTimeout < 0 {
+		return errors.Errorf("download_timeout can't be negative, got %s", *flDownloadTimeout)
+	}
+
+	for name, value := range map[string]int{"watchdog_memory_limit": *flWatchdogMemoryLimit, "watchdog_utilization_limit": *flWatchdogUtilizationLimit} {
+		if value < 0 {
+			return errors.Errorf("%s can't be negative, got %d", name, value)
+		}
+	}
+
+	// Read the lockfile first, so a bad one fails before building
+	var lockfile *packaging.Lockfile
+	if *flVerifyLockfile != "" {
+		if scriptsMode {
+			return errors.New("scripts doesn't build packages, so can't verify a lockfile")
+		}
+		if lockfile, err = packaging.ReadLockfile(*flVerifyLockfile); err != nil {
+			return err
+		}
+		if sourceDateEpoch.IsZero() {
+			level.Warn(logger).Log("msg", "verify_lockfile without source_date_epoch will fail, as builds aren't reproducible")
+		}
+	}
+
+	if *flMaxParallel < 1 {
+		return errors.Errorf("max_parallel must be at least 1, got %d", *flMaxParallel)
+	}
+
+	var maxPackageSize int64
+	if *flMaxPackageSize != "" {
+		if maxPackageSize, err = packaging.ParseSize(*flMaxPackageSize); err != nil {
+			return errors.Wrap(err, "invalid max_package_size")
+		}
+	}
+
+	var sbomFormat packaging.SBOMFormat
+	if *flSBOM != "" {
+		if sbomFormat, err = packaging.ParseSBOMFormat(*flSBOM); err != nil {
+			return errors.Wrap(err, "invalid sbom")
+		}
+	}
+
+	rootPEMs, err := packaging.ParseRootPEMs(*flRootPEM)
+	if err != nil {
+		return err
+	}
+
+	certPins, err := normalizeCertPins(*flCertPins)
+	if err != nil {
+		return err
+	}
+
+	targetsInput := *flTargets
+	if scriptsMode {
+		targetsInput = flagset.Arg(0)
+	}
+	targets, err := packaging.ParseTargets(targetsInput, *flIncludeWindows)
+	if err != nil {
+		return err
+	}
+
+	if targets, err = packaging.ExcludeTargets(targets, *flExcludeTargets, *flIncludeWindows); err != nil {
+		return err
+	}
+
+	if targets, err = packaging.ExpandArches(targets, *flArch); err != nil {
+		return err
+	}
+
+	if *flUniversal {
+		if targets, err = packaging.UniversalTargets(targets); err != nil {
+			return err
+		}
+	}
+
+	if err := validateSigningKeys(targets, *flSigningKey, *flLinuxSigningKey); err != nil {
+		return err
+	}
+
+	// With output_dir -, the package is streamed to stdout, so there
+	// can only be one, and nothing else can be printed there.
+	toStdout := *flOutputDir == "-"
+	if toStdout {
+		switch {
+		case scriptsMode:
+			return errors.New("scripts can't write to stdout, set output_dir to a directory")
+		case len(targets) != 1:
+			return errors.Errorf("output_dir - writes a single package to stdout, but %d targets are selected", len(targets))
+		case len(tenants) > 0:
+			return errors.New("output_dir - writes a single package to stdout, so can't be used with secrets_file")
+		case *flChecksums:
+			return errors.New("output_dir - can't be used with checksums, as they're written next to the package")
+		case *flSBOM != "":
+			return errors.New("output_dir - can't be used with sbom, as it's written next to the package")
+		case *flOutputFormat == "json":
+			return errors.New("output_dir - can't be used with output_format json, as stdout is the package")
+		}
+	}
+
+	extraFiles, err := packaging.ParseExtraFiles(flExtraFiles.values)
+	if err != nil {
+		return err
+	}
+
+	extensions, err := packaging.ParseExtensionSpecs(flExtensions.values)
+	if err != nil {
+		return err
+	}
+
+	var notarize *packagekit.NotarizeOptions
+	if *flNotarize {
+		if *flSigningKey == "" {
+			return errors.New("notarize requires mac_package_signing_key")
+		}
+		notarize = &packagekit.NotarizeOptions{
+			AppleID:         *flAppleID,
+			TeamID:          *flAppleTeamID,
+			AppPassword:     *flAppleAppPassword,
+			KeychainProfile: *flAppleKeychainProfile,
+		}
+		if err := notarize.Validate(); err != nil {
+			return err
+		}
+	}
+
+	outputName, err := packaging.ParseOutputNameTemplate(*flOutputNameTemplate)
+	if err != nil {
+		return err
+	}
+	if len(tenants) > 0 {
+		if err := packaging.ValidateTenantOutputName(outputName); err != nil {
+			return err
+		}
+	}
+
+	if !*flDryRun {
+		for _, target := range targets {
+			if err := target.Validate(); err != nil {
+				return errors.Wrapf(err, "invalid target %s", target.String())
+			}
+		}
+	}
+
+	if *flDryRun {
+		osqueryVersion, launcherVersion, extensionVersion := *flOsqueryVersion, *flLauncherVersion, *flExtensionVersion
+		if *flLocalBuildDir != "" {
+			osqueryVersion, launcherVersion, extensionVersion = *flLocalBuildDir, *flLocalBuildDir, *flLocalBuildDir
+		}
+		if !*flBundleOsquery {
+			osqueryVersion = fmt.Sprintf("not bundled, using %s", *flOsquerydPath)
+		}
+		return printPlan(os.Stdout, osqueryVersion, launcherVersion, extensionVersion, *flPackageVersion, *flOutputDir, outputName, targets, tenants)
+	}
+
+	packageOptions := packaging.PackageOptions{
+		PackageVersion:     *flPackageVersion,
+		OsqueryVersion:     *flOsqueryVersion,
+		OmitOsquery:        !*flBundleOsquery,
+		OsquerydPath:       *flOsquerydPath,
+		LauncherVersion:    *flLauncherVersion,
+		MinLauncherVersion: *flMinLauncherVersion,
+		ExtensionVersion:   *flExtensionVersion,
+		ExtensionName:      *flExtensionName,
+		Extensions:         extensions,
+		InstallPrefix:      *flInstallPrefix,
+		LocalBuildDir:      *flLocalBuildDir,
+		FetchAtInstall:     *flFetchAtInstall,
+		InstallMirrorURL:   *flInstallMirrorURL,
+		StripBinaries:      *flStripBinaries,
+		UseDocker:          *flUseDocker,
+		OCIBase:            *flOCIBase,
+		Compression:        *flCompression,
+		Hostname:           hostnames[0],
+		Hostnames:          hostnames,
+		Secret:             enrollSecret,
+		SigningKey:         *flSigningKey,
+		LinuxSigningKey:    *flLinuxSigningKey,
+		Insecure:           *flInsecure,
+		InsecureGrpc:       *flInsecureGrpc,
+		Autoupdate:         *flAutoupdate,
+		UpdateChannel:      *flUpdateChannel,
+		AutoupdateMirror:   *flAutoupdateMirrorURL,
+		LauncherLogLevel:   *flLauncherLogLevel,
+		ExtraLauncherFlags: extraLauncherFlags,
+		Control:            *flControl,
+		InitialRunner:      *flInitialRunner,
+		NoStart:            *flNoStart,
+		RunAsUser:          *flRunAsUser,
+		RunAsGroup:         *flRunAsGroup,
+		RestartPolicy:      *flRestartPolicy,
+		RestartSec:         *flRestartSec,
+		LaunchdTemplate:    *flLaunchdPlistTemplate,
+		ControlHostname:    *flControlHostname,
+		DisableControlTLS:  *flDisableControlTLS,
+		Identifier:         *flIdentifier,
+		Vendor:             *flVendor,
+		Maintainer:         *flMaintainer,
+		Description:        *flDescription,
+		License:            *flLicense,
+		Homepage:           *flHomepage,
+		OmitSecret:         *flOmitSecret,
+		SecretFromEnv:      *flSecretFromEnv,
+		UpgradeOnly:        *flUpgradeOnly,
+		SecretFileMode:     os.FileMode(secretFileMode),
+		CertPins:           certPins,
+		RootPEMs:           rootPEMs,
+		OsqueryFlagfile:    *flOsqueryFlagfile,
+		OsqueryConfigPath:  *flOsqueryConfigPath,
+		PreinstallScript:   *flPreinstallScript,
+		PostinstallScript:  *flPostinstallScript,
+		PreremoveScript:    *flPreremoveScript,
+		ExtraFiles:         extraFiles,
+		CacheDir:           *flCacheDir,
+		RefreshCache:       *flRefreshCache,
+		NoNetwork:          *flNoNetwork,
+		MirrorURL:          *flMirrorURL,
+		MirrorURLs:         flDownloadMirrors.values,
+		NotaryURL:          *flNotaryURL,
+		TUFRootJSON:        *flTUFRootJSON,
+		Proxy:              *flProxy,
+		KeepTemp:           *flKeepTemp,
+		VerboseBuild:       *flVerboseBuild,
+		OmitBuildMetadata:  !*flEmbedBuildMetadata,
+		SourceDateEpoch:    sourceDateEpoch,
+		Notarize:           notarize,
+
+		WatchdogMemoryLimitMB:    *flWatchdogMemoryLimit,
+		WatchdogUtilizationLimit: *flWatchdogUtilizationLimit,
+
+		ControlRequestInterval: *flControlRequestInterval,
+		ControlRootPEM:         *flControlRootPEM,
+
+		LauncherConnectTimeout: *flLauncherConnectTimeout,
+		LauncherRequestTimeout: *flLauncherRequestTimeout,
+
+		DownloadRetries:      *flDownloadRetries,
+		DownloadRetryBackoff: *flDownloadRetryBackoff,
+		DownloadTimeout:      *flDownloadTimeout,
+	}
+
+	// Fail before building anything, rather than part way through
+	for _, target := range targets {
+		if err := packageOptions.Validate(target); err != nil {
+			return errors.Wrapf(err, "invalid options for %s", target.String())
+		}
+	}
+	if len(tenants) > 0 {
+		// Tenants' options only differ by the secret, so checking one
+		// checks them all.
+		tenantOptions := packageOptions
+		tenantOptions.Tenant, tenantOptions.Secret = tenants[0], tenantSecrets[tenants[0]]
+		for _, target := range targets {
+			if err := tenantOptions.Validate(target); err != nil {
+				return errors.Wrapf(err, "invalid options for %s", target.String())
+			}
+		}
+	}
+
+	if scriptsMode {
+		return renderScripts(ctx, packageOptions, targets, *flOutputDir)
+	}
+
+	if *flPrintFlags {
+		return printLauncherFlags(os.Stdout, packageOptions, targets)
+	}
+
+	// Skip targets this host can't build, eg: macOS pkgs on linux.
+	skipped := []string{}
+	if *flSkipUnbuildable {
+		buildable := []packaging.Target{}
+		for _, target := range targets {
+			missing := packageOptions.MissingTools(target)
+			if len(missing) == 0 {
+				buildable = append(buildable, target)
+				continue
+			}
+			level.Warn(logger).Log("msg", "skipping target, required tools are missing", "target", target.String(), "missing", strings.Join(missing, ","))
+			skipped = append(skipped, fmt.Sprintf("%s (missing %s)", target.String(), strings.Join(missing, ", ")))
+		}
+		if len(buildable) == 0 {
+			return errors.Errorf("No buildable targets. Skipped %s", strings.Join(skipped, ", "))
+		}
+		targets = buildable
+	}
+
+	// Fail before downloading or building anything if a build tool is
+	// missing, rather than with an exec error part way through.
+	for _, target := range targets {
+		if err := packageOptions.CheckTools(target); err != nil {
+			return errors.Wrapf(err, "can't build %s", target.String())
+		}
+	}
+
+	if *flValidateOnly {
+		if err := packageOptions.CheckVersions(ctx, targets); err != nil {
+			return err
+		}
+		names := make([]string, len(targets))
+		for i, target := range targets {
+			names[i] = target.String()
+		}
+		fmt.Printf("Options are valid for %s\n", strings.Join(names, ", "))
+		for _, s := range skipped {
+			fmt.Printf("Skipped %s\n", s)
+		}
+		return nil
+	}
+
 	// If we have a cacheDir, use it. Otherwise. set something random.
-	cacheDir := *flCacheDir
-	var err error
-	if cacheDir == "" {
-		cacheDir, err = ioutil.TempDir("", "download_cache")
+	if packageOptions.CacheDir == "" {
+		packageOptions.CacheDir, err = ioutil.TempDir("", "download_cache")
 		if err != nil {
 			return errors.Wrap(err, "could not create temp dir for caching files")
 		}
-		defer os.RemoveAll(cacheDir)
+		if !*flKeepTemp {
+			defer os.RemoveAll(packageOptions.CacheDir)
+		}
 	}
 
-	packageOptions := packaging.PackageOptions{
-		PackageVersion:    *flPackageVersion,
-		OsqueryVersion:    *flOsqueryVersion,
-		LauncherVersion:   *flLauncherVersion,
-		ExtensionVersion:  *flExtensionVersion,
-		Hostname:          *flHostname,
-		Secret:            *flEnrollSecret,
-		SigningKey:        *flSigningKey,
-		Insecure:          *flInsecure,
-		InsecureGrpc:      *flInsecureGrpc,
-		Autoupdate:        *flAutoupdate,
-		UpdateChannel:     *flUpdateChannel,
-		Control:           *flControl,
-		InitialRunner:     *flInitialRunner,
-		ControlHostname:   *flControlHostname,
-		DisableControlTLS: *flDisableControlTLS,
-		Identifier:        *flIdentifier,
-		OmitSecret:        *flOmitSecret,
-		CertPins:          *flCertPins,
-		RootPEM:           *flRootPEM,
-		CacheDir:          cacheDir,
+	// Downloads are written to the cache, and packages to the output
+	// dir. Check they're writable before spending time downloading.
+	if err := os.MkdirAll(packageOptions.CacheDir, 0755); err != nil {
+		return errors.Wrap(err, "making cache dir")
+	}
+	if err := checkWritable(packageOptions.CacheDir); err != nil {
+		return errors.Wrap(err, "cache dir")
 	}
 
 	outputDir := *flOutputDir
 
+	// Packages for stdout are built in a random dir, which is always
+	// removed, unless --keep_temp is set.
+	if toStdout {
+		var err error
+		outputDir, err = ioutil.TempDir("", "launcher-package")
+		if err != nil {
+			return errors.Wrap(err, "making output dir")
+		}
+		if !*flKeepTemp {
+			defer os.RemoveAll(outputDir)
+		}
+	}
+
+	// Without an output dir, packages are written to a random one. It's
+	// kept if anything was built, as that's where the packages are. If
+	// nothing was, it's removed, unless --keep_temp is set.
+	//
 	// NOTE: if you;re using docker-for-mac, you probably need to set the TMPDIR env to /tmp
 	if outputDir == "" {
 		var err error
@@ -217,33 +1281,483 @@ func runMake(args []string) error {
 		if err != nil {
 			return errors.Wrap(err, "making output dir")
 		}
+		fmt.Fprintf(os.Stderr, "Writing packages to temporary directory %s\n", outputDir)
 	}
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return errors.Wrap(err, "mkdir")
 	}
+	if err := checkWritable(outputDir); err != nil {
+		return errors.Wrap(err, "output dir")
+	}
+
+	buildOpts := []packaging.BuildOpt{
+		packaging.WithOutputName(outputName),
+		packaging.WithMaxParallel(*flMaxParallel),
+	}
+	if *flChecksums {
+		buildOpts = append(buildOpts, packaging.WithChecksums())
+	}
+	if maxPackageSize > 0 {
+		buildOpts = append(buildOpts, packaging.WithMaxPackageSize(maxPackageSize))
+	}
+	if sbomFormat != "" {
+		buildOpts = append(buildOpts, packaging.WithSBOM(sbomFormat))
+	}
+	if *flTimingReport {
+		buildOpts = append(buildOpts, packaging.WithTimings())
+	}
 
-	targets, err := getTargets(*flTargets)
+	results, err := buildTenants(ctx, packageOptions, targets, outputDir, tenants, tenantSecrets, buildOpts)
 	if err != nil {
+		if *flOutputDir == "" && len(results) == 0 && !*flKeepTemp {
+			os.RemoveAll(outputDir)
+		}
+		if ctx.Err() == context.Canceled {
+			return errors.Wrap(err, "interrupted")
+		}
 		return err
 	}
 
+	if lockfile != nil {
+		if err := lockfile.Verify(results); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Packages match lockfile %s\n", *flVerifyLockfile)
+	}
+
+	if *flWriteLockfile != "" {
+		if err := packaging.WriteLockfile(*flWriteLockfile, results); err != nil {
+			return err
+		}
+	}
+
+	// JSON output is just the results, so report skips on stderr
+	summary := os.Stdout
+	if *flOutputFormat == "json" || toStdout {
+		summary = os.Stderr
+	}
+
+	switch {
+	case toStdout:
+		if err := copyToStdout(results[0].Path); err != nil {
+			return err
+		}
+	case *flOutputFormat == "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return errors.Wrap(err, "encoding results")
+		}
+	default:
+		fmt.Printf("Built you packages in %s\n", outputDir)
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		for _, result := range results {
+			target := result.Target
+			if result.Tenant != "" {
+				target = fmt.Sprintf("%s (%s)", result.Target, result.Tenant)
+			}
+			fmt.Fprintf(w, "  %s\t%s\n", target, filepath.Base(result.Path))
+		}
+		w.Flush()
+	}
+
+	for _, s := range skipped {
+		fmt.Fprintf(summary, "Skipped %s\n", s)
+	}
+
+	if *flTimingReport {
+		fmt.Fprintf(summary, "\nBuild timings:\n")
+		if err := packaging.WriteTimingReport(summary, results); err != nil {
+			return errors.Wrap(err, "writing timing report")
+		}
+	}
+
+	return nil
+}
+
+// checkWritable checks that files can be written to dir, by creating,
+// and removing, one.
+func checkWritable(dir string) error {
+	fh, err := ioutil.TempFile(dir, ".package-builder-check")
+	if err != nil {
+		return errors.Wrapf(err, "%s isn't writable", dir)
+	}
+	fh.Close()
+	if err := os.Remove(fh.Name()); err != nil {
+		return errors.Wrapf(err, "removing %s", fh.Name())
+	}
+	return nil
+}
+
+// copyToStdout writes the package at path to stdout, for output_dir -.
+func copyToStdout(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening package")
+	}
+	defer fh.Close()
+
+	if _, err := io.Copy(os.Stdout, fh); err != nil {
+		return errors.Wrap(err, "writing package to stdout")
+	}
+	return nil
+}
+
+// normalizeCertPins validates a comma separated list of cert
+// pins. Each must be a hex encoded SHA256 hash, so 32 bytes once
+// decoded. Whitespace around each pin is trimmed, and the cleaned up
+// list is returned.
+func normalizeCertPins(input string) (string, error) {
+	if strings.TrimSpace(input) == "" {
+		return "", nil
+	}
+
+	pins := []string{}
+	for _, pin := range strings.Split(input, ",") {
+		pin = strings.TrimSpace(pin)
+
+		decoded, err := hex.DecodeString(pin)
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to parse cert pin %q", pin)
+		}
+
+		if len(decoded) != sha256.Size {
+			return "", errors.Errorf("cert pin %q is %d bytes, expected a %d byte SHA256 hash", pin, len(decoded), sha256.Size)
+		}
+
+		pins = append(pins, pin)
+	}
+
+	return strings.Join(pins, ","), nil
+}
+
+// validateServerURL checks an optional server URL. They must be
+// https, unless insecure is set.
+func validateServerURL(raw string, insecure bool) error {
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", raw)
+	}
+
+	if u.Host == "" {
+		return errors.Errorf("%s has no host", raw)
+	}
+
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && insecure:
+	case u.Scheme == "http":
+		return errors.Errorf("%s is not https. Use --insecure to allow it", raw)
+	default:
+		return errors.Errorf("%s has unsupported scheme %s", raw, u.Scheme)
+	}
+
+	return nil
+}
+
+// validateSigningKeys rejects signing keys that don't apply to any of
+// the targets, rather than silently building unsigned packages. This
+// mirrors PackageOptions.signingKey.
+func validateSigningKeys(targets []packaging.Target, macKey, linuxKey string) error {
+	macUsed, linuxUsed := false, false
+	for _, target := range targets {
+		switch {
+		case target.Platform == packaging.Darwin && target.Package == packaging.Pkg:
+			macUsed = true
+		case target.Platform == packaging.Linux && (target.Package == packaging.Deb || target.Package == packaging.Rpm):
+			linuxUsed = true
+		}
+	}
+
+	if macKey != "" && !macUsed {
+		return errors.New("mac_package_signing_key is set, but no targets are macOS pkgs")
+	}
+
+	if linuxKey != "" && !linuxUsed {
+		return errors.New("linux_signing_key is set, but no targets are debs or rpms")
+	}
+
+	return nil
+}
+
+// validateProxyURL checks an optional proxy URL.
+func validateProxyURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", raw)
+	}
+
+	if u.Host == "" {
+		return errors.Errorf("%s has no host", raw)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return errors.Errorf("%s has unsupported scheme %s", raw, u.Scheme)
+	}
+
+	return nil
+}
+
+// readSecretFile reads an enroll secret from a file. Trailing
+// newlines are trimmed, as most editors add one. Empty secrets are
+// rejected, as they are most likely a mistake.
+func readSecretFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "reading enroll secret file")
+	}
+
+	secret := strings.TrimRight(string(contents), "\r\n")
+	if secret == "" {
+		return "", errors.Errorf("enroll secret file %s is empty", path)
+	}
+
+	return secret, nil
+}
+
+// printPlan writes out what would be built, without downloading or
+// building anything. It returns an error if any target is invalid.
+func printPlan(w io.Writer, osqueryVersion, launcherVersion, extensionVersion, packageVersion, outputDir string, outputName *template.Template, targets []packaging.Target, tenants []string) error {
+	if packageVersion == "" {
+		packageVersion = "(autodetect)"
+	}
+	switch outputDir {
+	case "":
+		outputDir = "(random)"
+	case "-":
+		outputDir = "(stdout)"
+	}
+
+	fmt.Fprintf(w, "osquery:          %s\n", osqueryVersion)
+	fmt.Fprintf(w, "launcher:         %s\n", launcherVersion)
+	fmt.Fprintf(w, "extension:        %s\n", extensionVersion)
+	fmt.Fprintf(w, "package version:  %s\n", packageVersion)
+	if len(tenants) > 0 {
+		fmt.Fprintf(w, "tenants:          %s\n", strings.Join(tenants, ", "))
+	}
+	fmt.Fprintf(w, "\n")
+
+	// Without tenants, there's one package per target
+	if len(tenants) == 0 {
+		tenants = []string{""}
+	}
+
+	invalid := []string{}
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "PLATFORM\tINIT\tPACKAGE\tOUTPUT\tSTATUS\n")
 	for _, target := range targets {
-		outputFileName := fmt.Sprintf("launcher.%s.%s", target.String(), target.PkgExtension())
-		outputFile, err := os.Create(filepath.Join(outputDir, outputFileName))
+		status := "ok"
+		if err := target.Validate(); err != nil {
+			status = err.Error()
+			invalid = append(invalid, target.String())
+		}
+
+		for _, tenant := range tenants {
+			tenantStatus := status
+			outputFileName, err := packaging.RenderTenantOutputName(outputName, target, packageVersion, tenant)
+			if err != nil {
+				tenantStatus = err.Error()
+				invalid = append(invalid, target.String())
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				target.Platform, target.Init, target.Package,
+				filepath.Join(outputDir, outputFileName),
+				tenantStatus,
+			)
+		}
+	}
+	tw.Flush()
+
+	if len(invalid) > 0 {
+		return errors.Errorf("invalid targets: %s", strings.Join(invalid, ", "))
+	}
+
+	return nil
+}
+
+// setIntEnvDefaults sets int flags' defaults from their environment
+// variables, flag name to variable, as kolide/kit/env doesn't have
+// ints. A bad value is reported as the flag's parsing reports it.
+func setIntEnvDefaults(flagset *flag.FlagSet, envs map[string]string) error {
+	names := []string{}
+	for name := range envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := os.LookupEnv(envs[name])
+		if !ok {
+			continue
+		}
+		f := flagset.Lookup(name)
+		if err := f.Value.Set(value); err != nil {
+			return errors.Wrapf(err, "invalid value %q for %s", value, envs[name])
+		}
+		f.DefValue = value
+	}
+	return nil
+}
+
+// stringsFlag is a repeatable flag. Each value may also be a comma
+// separated list, which is how the environment and config files set
+// it. Values from the command line replace the default from the
+// environment.
+type stringsFlag struct {
+	values []string
+	set    bool
+}
+
+func newStringsFlag(def string) *stringsFlag {
+	s := &stringsFlag{}
+	s.Set(def)
+	s.set = false
+	return s
+}
+
+func (s *stringsFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(s.values, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	if !s.set {
+		s.values = nil
+		s.set = true
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			s.values = append(s.values, v)
+		}
+	}
+	return nil
+}
+
+// renderScripts writes the init files, and install scripts, for each
+// target into its own directory under outputDir, and prints their paths.
+func renderScripts(ctx context.Context, packageOptions packaging.PackageOptions, targets []packaging.Target, outputDir string) error {
+	if outputDir == "" {
+		var err error
+		outputDir, err = ioutil.TempDir("", "launcher-scripts")
 		if err != nil {
-			return errors.Wrap(err, "Failed to make package output file")
+			return errors.Wrap(err, "making output dir")
 		}
-		defer outputFile.Close()
+		fmt.Fprintf(os.Stderr, "Writing scripts to temporary directory %s\n", outputDir)
+	}
 
-		if err := packageOptions.Build(ctx, outputFile, target); err != nil {
-			return errors.Wrap(err, "could not generate packages")
+	for _, target := range targets {
+		// Each target gets a copy, RenderScripts sets per target state
+		po := packageOptions
+		paths, err := po.RenderScripts(ctx, target, filepath.Join(outputDir, target.String()))
+		if err != nil {
+			return errors.Wrapf(err, "rendering scripts for %s", target.String())
+		}
+		for _, path := range paths {
+			fmt.Println(path)
 		}
 	}
+	return nil
+}
+
+// printLauncherFlags prints, for each target, how the installed
+// launcher runs. The secret itself isn't printed, only how it's
+// installed.
+func printLauncherFlags(w io.Writer, packageOptions packaging.PackageOptions, targets []packaging.Target) error {
+	for i, target := range targets {
+		// Each target gets a copy, LauncherConfig sets per target state
+		po := packageOptions
+		config, err := po.LauncherConfig(target)
+		if err != nil {
+			return errors.Wrapf(err, "launcher config for %s", target.String())
+		}
+
+		if i > 0 {
+			fmt.Fprintf(w, "\n")
+		}
+		fmt.Fprintf(w, "%s:\n", target.String())
+		fmt.Fprintf(w, "  command:        %s\n", config.Path)
+		for _, flag := range config.Flags {
+			fmt.Fprintf(w, "                    %s\n", flag)
+		}
 
-	fmt.Printf("Built you packages in %s\n", outputDir)
+		user := config.User
+		switch {
+		case user != "":
+		case target.Platform == packaging.Windows:
+			user = "LocalSystem"
+		default:
+			user = "root"
+		}
+		if config.Group != "" {
+			user = fmt.Sprintf("%s:%s", user, config.Group)
+		}
+		fmt.Fprintf(w, "  user:           %s\n", user)
+		fmt.Fprintf(w, "  enroll secret:  %s\n", config.Secret)
+
+		fmt.Fprintf(w, "  environment:\n")
+		keys := make([]string, 0, len(config.Environment))
+		for key := range config.Environment {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "    %s=%s\n", key, config.Environment[key])
+		}
+	}
 	return nil
 }
 
+// buildTenants builds the targets once per tenant, with the tenant's
+// secret. They share the download cache, so binaries are only fetched
+// once. Without tenants, it's BuildAll. Errors from every tenant are
+// returned together, as a BuildAllError.
+func buildTenants(ctx context.Context, packageOptions packaging.PackageOptions, targets []packaging.Target, outputDir string, tenants []string, secrets map[string]string, buildOpts []packaging.BuildOpt) ([]packaging.BuildResult, error) {
+	if len(tenants) == 0 {
+		return packaging.BuildAll(ctx, packageOptions, targets, outputDir, buildOpts...)
+	}
+
+	// Tenants are built in sorted order, and BuildAll keeps each one's
+	// results in target order, so runs always report in the same order.
+	var results []packaging.BuildResult
+	var errs []error
+	for _, tenant := range tenants {
+		po := packageOptions
+		po.Tenant, po.Secret = tenant, secrets[tenant]
+
+		tenantResults, err := packaging.BuildAll(ctx, po, targets, outputDir, buildOpts...)
+		results = append(results, tenantResults...)
+		if err == nil {
+			continue
+		}
+
+		if buildErr, ok := err.(*packaging.BuildAllError); ok {
+			for _, e := range buildErr.Errors {
+				errs = append(errs, errors.Wrapf(e, "tenant %s", tenant))
+			}
+		} else {
+			errs = append(errs, errors.Wrapf(err, "tenant %s", tenant))
+		}
+	}
+
+	if len(errs) > 0 {
+		return results, &packaging.BuildAllError{Errors: errs}
+	}
+	return results, nil
+}
+
 func usageFor(fs *flag.FlagSet, short string) func() {
 	return func() {
 		fmt.Fprintf(os.Stderr, "USAGE\n")
@@ -265,6 +1779,10 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "\n")
 	fmt.Fprintf(os.Stderr, "MODES\n")
 	fmt.Fprintf(os.Stderr, "  make         Generate a single launcher package for each platform\n")
+	fmt.Fprintf(os.Stderr, "  scripts      Render the init files and install scripts for a target\n")
+	fmt.Fprintf(os.Stderr, "  verify       Print the launcher configuration inside built packages\n")
+	fmt.Fprintf(os.Stderr, "  list-targets Print the supported --targets\n")
+	fmt.Fprintf(os.Stderr, "  cache        Verify, and prune, the download cache\n")
 	fmt.Fprintf(os.Stderr, "  version      Print full version information\n")
 	fmt.Fprintf(os.Stderr, "\n")
 	fmt.Fprintf(os.Stderr, "VERSION\n")
@@ -284,6 +1802,14 @@ func main() {
 		run = runVersion
 	case "make":
 		run = runMake
+	case "scripts":
+		run = runScripts
+	case "verify":
+		run = runVerify
+	case "list-targets":
+		run = runListTargets
+	case "cache":
+		run = runCache
 	default:
 		usage()
 		os.Exit(1)
@@ -294,65 +1820,3 @@ func main() {
 		os.Exit(1)
 	}
 }
-
-// getTargets takes a string, and parses targets out of it. This
-// encodes what the default mapping between human names and build
-// targets is.
-func getTargets(input string) ([]packaging.Target, error) {
-
-	defaultTargets := []packaging.Target{
-		{
-			Platform: packaging.Darwin,
-			Init:     packaging.LaunchD,
-			Package:  packaging.Pkg,
-		},
-		{
-			Platform: packaging.Linux,
-			Init:     packaging.SystemD,
-			Package:  packaging.Rpm,
-		},
-		{
-			Platform: packaging.Linux,
-			Init:     packaging.SystemD,
-			Package:  packaging.Deb,
-		},
-		{
-			Platform: packaging.Linux,
-			Init:     packaging.Upstart,
-			Package:  packaging.Deb,
-		},
-	}
-
-	// Nothing specified, return a default set
-	if input == "" {
-		return defaultTargets, nil
-	}
-
-	// split the input, and iterate
-	targets := []packaging.Target{}
-	for _, target := range strings.Split(input, ",") {
-		switch target {
-		case "rpm":
-			targets = append(targets, packaging.Target{
-				Platform: packaging.Linux,
-				Init:     packaging.SystemD,
-				Package:  packaging.Rpm,
-			})
-		case "deb":
-			targets = append(targets, packaging.Target{
-				Platform: packaging.Linux,
-				Init:     packaging.SystemD,
-				Package:  packaging.Deb,
-			})
-		case "darwin":
-			targets = append(targets, packaging.Target{
-				Platform: packaging.Darwin,
-				Init:     packaging.LaunchD,
-				Package:  packaging.Pkg,
-			})
-		default:
-			return nil, errors.Errorf("Unknown target: %s", target)
-		}
-	}
-	return targets, nil
-}
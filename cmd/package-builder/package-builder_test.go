@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeCertPins(t *testing.T) {
+	t.Parallel()
+
+	pin := strings.Repeat("ab", 32)
+	otherPin := strings.Repeat("01", 32)
+
+	var tests = []struct {
+		name  string
+		in    string
+		out   string
+		valid bool
+	}{
+		{name: "empty", in: "", out: "", valid: true},
+		{name: "blank", in: "  ", out: "", valid: true},
+		{name: "good pin", in: pin, out: pin, valid: true},
+		{name: "multiple pins", in: pin + "," + otherPin, out: pin + "," + otherPin, valid: true},
+		{name: "whitespace is trimmed", in: " " + pin + " ,\n" + otherPin + "\n", out: pin + "," + otherPin, valid: true},
+		{name: "short hex", in: strings.Repeat("ab", 31)},
+		{name: "long hex", in: strings.Repeat("ab", 33)},
+		{name: "one bad pin", in: pin + "," + strings.Repeat("ab", 20)},
+		{name: "not hex", in: strings.Repeat("zz", 32)},
+		{name: "odd length", in: pin + "a"},
+		{name: "empty pin", in: pin + ","},
+	}
+
+	for _, tt := range tests {
+		out, err := normalizeCertPins(tt.in)
+		if !tt.valid {
+			require.Error(t, err, tt.name)
+			continue
+		}
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.out, out, tt.name)
+	}
+}
+
+func TestReadSecretFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "package-builder-secret")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		name     string
+		contents string
+		out      string
+		valid    bool
+	}{
+		{name: "no newline", contents: "secret", out: "secret", valid: true},
+		{name: "trailing newline", contents: "secret\n", out: "secret", valid: true},
+		{name: "trailing crlf", contents: "secret\r\n\r\n", out: "secret", valid: true},
+		{name: "other whitespace is kept", contents: " secret \n", out: " secret ", valid: true},
+		{name: "empty", contents: ""},
+		{name: "only newlines", contents: "\n\r\n"},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("secret-%d", i))
+		require.NoError(t, ioutil.WriteFile(path, []byte(tt.contents), 0600))
+
+		secret, err := readSecretFile(path)
+		if !tt.valid {
+			require.Error(t, err, tt.name)
+			require.Contains(t, err.Error(), "is empty", tt.name)
+			continue
+		}
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.out, secret, tt.name)
+	}
+
+	_, err = readSecretFile(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestRunPackagingOsquerydPath(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "package-builder-osqueryd-path")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	args := []string{"--hostname", "device.example.com:443", "--enroll_secret", "secret", "--output_dir", dir}
+
+	// The flags reach the installed launcher's environment
+	require.NoError(t, runPackaging("scripts", append(args, "--bundle_osquery=false", "--osqueryd_path", "/opt/osquery/bin/osqueryd", "deb")))
+	unit, err := ioutil.ReadFile(filepath.Join(dir, "linux-systemd-deb", "root", "etc", "systemd", "system", "launcher.launcher.service"))
+	require.NoError(t, err)
+	require.Contains(t, string(unit), "KOLIDE_LAUNCHER_OSQUERYD_PATH=/opt/osquery/bin/osqueryd\n")
+
+	var tests = []struct {
+		name     string
+		args     []string
+		contains string
+	}{
+		{name: "relative path", args: []string{"--bundle_osquery=false", "--osqueryd_path", "bin/osqueryd", "deb"}, contains: "must be an absolute path"},
+		{name: "posix path on windows", args: []string{"--bundle_osquery=false", "--osqueryd_path", "/opt/osquery/bin/osqueryd", "windows"}, contains: "must be an absolute path"},
+		{name: "no path", args: []string{"--bundle_osquery=false", "deb"}, contains: "bundle_osquery=false requires osqueryd_path"},
+		{name: "path with bundled osquery", args: []string{"--osqueryd_path", "/opt/osquery/bin/osqueryd", "deb"}, contains: "osqueryd_path requires bundle_osquery=false"},
+	}
+
+	for _, tt := range tests {
+		err := runPackaging("scripts", append(args, tt.args...))
+		require.Error(t, err, tt.name)
+		require.Contains(t, err.Error(), tt.contains, tt.name)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// signalGracePeriod is how long cancelled builds get to stop, and
+// clean up, before package-builder exits without them.
+const signalGracePeriod = 30 * time.Second
+
+// cancelOnSignal returns a context that's cancelled on SIGINT or
+// SIGTERM. Cancelled builds kill their packaging tools, and remove
+// their temp dirs and partial output, as they return. If they haven't
+// within signalGracePeriod, or there's a second signal, it exits
+// without waiting. The returned func stops handling signals.
+func cancelOnSignal(ctx context.Context, logger log.Logger) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.Signal(15))
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case s := <-sig:
+			level.Info(logger).Log("msg", "interrupted, stopping builds and cleaning up. Interrupt again to exit now", "signal", s.String())
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-sig:
+			fmt.Fprintln(os.Stderr, "Interrupted again, exiting without cleaning up")
+		case <-time.After(signalGracePeriod):
+			fmt.Fprintf(os.Stderr, "Builds didn't stop within %s, exiting without cleaning up\n", signalGracePeriod)
+		case <-done:
+			return
+		}
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(sig)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/kit/env"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/kolide/launcher/pkg/packaging"
+	"github.com/pkg/errors"
+)
+
+// verifyResult is the json output of verify mode
+type verifyResult struct {
+	Path           string            `json:"path"`
+	Platform       string            `json:"platform"`
+	InitFile       string            `json:"init_file"`
+	Hostname       string            `json:"hostname"`
+	UpdateChannel  string            `json:"update_channel,omitempty"`
+	CertPins       string            `json:"cert_pins,omitempty"`
+	SecretIncluded bool              `json:"enroll_secret_included"`
+	Flags          []string          `json:"flags"`
+	Environment    map[string]string `json:"environment"`
+	Binaries       map[string]string `json:"binaries"`
+}
+
+func runVerify(args []string) error {
+	flagset := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		flDebug = flagset.Bool(
+			"debug",
+			false,
+			"enable debug logging",
+		)
+		flOutputFormat = flagset.String(
+			"output_format",
+			env.String("OUTPUT_FORMAT", "human"),
+			"How to report the package contents (options: human, json)",
+		)
+	)
+
+	flagset.Usage = usageFor(flagset, "package-builder verify [flags] <package>...")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	logger := log.NewJSONLogger(os.Stderr)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	logger = log.With(logger, "caller", log.DefaultCaller)
+
+	if *flDebug {
+		logger = level.NewFilter(logger, level.AllowDebug())
+	} else {
+		logger = level.NewFilter(logger, level.AllowInfo())
+	}
+
+	ctx := context.Background()
+	ctx = ctxlog.NewContext(ctx, logger)
+
+	if *flOutputFormat != "human" && *flOutputFormat != "json" {
+		return errors.Errorf("Unknown output_format %s", *flOutputFormat)
+	}
+
+	if flagset.NArg() == 0 {
+		flagset.Usage()
+		return errors.New("No packages to verify")
+	}
+
+	results := []verifyResult{}
+	for _, packagePath := range flagset.Args() {
+		info, err := packaging.InspectPackage(ctx, packagePath)
+		if err != nil {
+			return errors.Wrapf(err, "verifying %s", packagePath)
+		}
+
+		results = append(results, verifyResult{
+			Path:           info.Path,
+			Platform:       string(info.Platform),
+			InitFile:       info.InitFile,
+			Hostname:       info.Hostname(),
+			UpdateChannel:  info.UpdateChannel(),
+			CertPins:       info.CertPins(),
+			SecretIncluded: info.SecretIncluded,
+			Flags:          info.Flags,
+			Environment:    info.Environment,
+			Binaries:       info.Binaries,
+		})
+	}
+
+	if *flOutputFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, result := range results {
+		printVerifyResult(os.Stdout, result)
+	}
+	return nil
+}
+
+func printVerifyResult(w io.Writer, result verifyResult) {
+	secret := "included"
+	if !result.SecretIncluded {
+		secret = "omitted"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "package:\t%s\n", result.Path)
+	fmt.Fprintf(tw, "platform:\t%s\n", result.Platform)
+	fmt.Fprintf(tw, "init:\t%s\n", result.InitFile)
+	fmt.Fprintf(tw, "hostname:\t%s\n", result.Hostname)
+	fmt.Fprintf(tw, "enroll secret:\t%s\n", secret)
+	fmt.Fprintf(tw, "cert pins:\t%s\n", result.CertPins)
+	fmt.Fprintf(tw, "update channel:\t%s\n", result.UpdateChannel)
+	fmt.Fprintf(tw, "flags:\t%s\n", strings.Join(result.Flags, " "))
+
+	binaries := []string{}
+	for name := range result.Binaries {
+		binaries = append(binaries, name)
+	}
+	sort.Strings(binaries)
+	for _, name := range binaries {
+		version := result.Binaries[name]
+		if version == "" {
+			version = "(unknown)"
+		}
+		fmt.Fprintf(tw, "binary:\t%s %s\n", name, version)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n")
+}
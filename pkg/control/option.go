@@ -2,6 +2,7 @@ package control
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"time"
 
@@ -27,6 +28,18 @@ func WithInsecureSkipVerify() Option {
 	}
 }
 
+// WithRootCAs verifies the control server against pool, rather than
+// the system roots.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		c.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+}
+
 func WithGetShellsInterval(i time.Duration) Option {
 	return func(c *Client) {
 		c.getShellsInterval = i
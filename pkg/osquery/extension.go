@@ -110,6 +110,10 @@ type ExtensionOpts struct {
 	// RunDifferentialQueriesImmediately allows the client to execute a new query the first time it sees it,
 	// bypassing the scheduler.
 	RunDifferentialQueriesImmediately bool
+	// StaticConfig is an osquery config, as JSON, that's returned
+	// alongside the server's config. osquery merges them, so it can
+	// carry packs or schedules that don't depend on the server.
+	StaticConfig string
 }
 
 // NewExtension creates a new Extension from the provided service.KolideService
@@ -396,7 +400,11 @@ func (e *Extension) GenerateConfigs(ctx context.Context) (map[string]string, err
 		// this case.
 	}
 
-	return map[string]string{"config": config}, nil
+	configs := map[string]string{"config": config}
+	if e.Opts.StaticConfig != "" {
+		configs["static"] = e.Opts.StaticConfig
+	}
+	return configs, nil
 }
 
 // TODO: https://github.com/kolide/launcher/issues/366
@@ -280,6 +280,32 @@ func TestExtensionGenerateConfigsCaching(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestExtensionGenerateConfigsStatic(t *testing.T) {
+	configVal := `{"foo": "bar"}`
+	staticVal := `{"packs": {"fixed": "/etc/osquery/fixed.conf"}}`
+	m := &mock.KolideService{
+		RequestConfigFunc: func(ctx context.Context, nodeKey string) (string, bool, error) {
+			return configVal, false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	e, err := NewExtension(m, db, ExtensionOpts{EnrollSecret: "enroll_secret", StaticConfig: staticVal})
+	require.Nil(t, err)
+
+	configs, err := e.GenerateConfigs(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"config": configVal, "static": staticVal}, configs)
+
+	// The static config is returned with the cached one, too
+	m.RequestConfigFunc = func(ctx context.Context, nodeKey string) (string, bool, error) {
+		return "", false, errors.New("foobar")
+	}
+	configs, err = e.GenerateConfigs(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"config": configVal, "static": staticVal}, configs)
+}
+
 func TestExtensionGenerateConfigsEnrollmentInvalid(t *testing.T) {
 	expectedNodeKey := "good_node_key"
 	var gotNodeKey string
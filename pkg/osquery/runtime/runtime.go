@@ -41,9 +41,13 @@ type osqueryOptions struct {
 	binaryPath            string
 	rootDirectory         string
 	extensionSocketPath   string
+	extensionNames        []string // If unset, the standard extension
 	configPluginFlag      string
 	loggerPluginFlag      string
 	distributedPluginFlag string
+	flagfilePath          string
+	watchdogMemoryLimit   int // MB
+	watchdogUtilization   int // CPU percent
 	extensionPlugins      []osquery.OsqueryPlugin
 	stdout                io.Writer
 	stderr                io.Writer
@@ -74,7 +78,7 @@ type OsqueryInstance struct {
 type osqueryFilePaths struct {
 	pidfilePath           string
 	databasePath          string
-	extensionPath         string
+	extensionPath         string // The first of the autoloaded extensions
 	extensionAutoloadPath string
 	extensionSocketPath   string
 }
@@ -83,20 +87,34 @@ type osqueryFilePaths struct {
 // directory where all of the osquery filesystem artifacts should be stored.
 // In return, a structure of paths is returned that can be used to launch an
 // osqueryd instance. An error may be returned if the supplied parameters are
-// unacceptable.
-func calculateOsqueryPaths(rootDir, extensionSocketPath string) (*osqueryFilePaths, error) {
-	// Determine the path to the extension
+// unacceptable. The extensions, named extNames, or the standard
+// extension if there are none, must be next to the launcher
+// executable. They're all autoloaded. An empty name is the standard
+// extension.
+func calculateOsqueryPaths(rootDir, extensionSocketPath string, extNames ...string) (*osqueryFilePaths, error) {
+	if len(extNames) == 0 {
+		extNames = []string{extensionName}
+	}
+
+	// Determine the paths to the extensions
 	exPath, err := os.Executable()
 	if err != nil {
 		return nil, errors.Wrap(err, "finding path of launcher executable")
 	}
-	extensionPath := filepath.Join(filepath.Dir(exPath), extensionName)
-	if _, err := os.Stat(extensionPath); err != nil {
-		if os.IsNotExist(err) {
-			return nil, errors.Wrapf(err, "extension path does not exist: %s", extensionPath)
-		} else {
-			return nil, errors.Wrapf(err, "could not stat extension path")
+	extensionPaths := []string{}
+	for _, extName := range extNames {
+		if extName == "" {
+			extName = extensionName
+		}
+		extensionPath := filepath.Join(filepath.Dir(exPath), extName)
+		if _, err := os.Stat(extensionPath); err != nil {
+			if os.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "extension path does not exist: %s", extensionPath)
+			} else {
+				return nil, errors.Wrapf(err, "could not stat extension path")
+			}
 		}
+		extensionPaths = append(extensionPaths, extensionPath)
 	}
 
 	// Determine the path to the extension socket
@@ -104,16 +122,16 @@ func calculateOsqueryPaths(rootDir, extensionSocketPath string) (*osqueryFilePat
 		extensionSocketPath = socketPath(rootDir)
 	}
 
-	// Write the autoload file
+	// Write the autoload file. osquery reads one extension per line.
 	extensionAutoloadPath := filepath.Join(rootDir, "osquery.autoload")
-	if err := ioutil.WriteFile(extensionAutoloadPath, []byte(extensionPath), 0644); err != nil {
+	if err := ioutil.WriteFile(extensionAutoloadPath, []byte(strings.Join(extensionPaths, "\n")), 0644); err != nil {
 		return nil, errors.Wrap(err, "could not write osquery extension autoload file")
 	}
 
 	return &osqueryFilePaths{
 		pidfilePath:           filepath.Join(rootDir, "osquery.pid"),
 		databasePath:          filepath.Join(rootDir, "osquery.db"),
-		extensionPath:         extensionPath,
+		extensionPath:         extensionPaths[0],
 		extensionAutoloadPath: extensionAutoloadPath,
 		extensionSocketPath:   extensionSocketPath,
 	}, nil
@@ -153,6 +171,24 @@ func createOsquerydCommand(osquerydBinary string, paths *osqueryFilePaths, confi
 	return cmd, nil
 }
 
+// watchdogArgs replaces --disable_watchdog in args with the watchdog
+// limits. Zero limits are left at osquery's defaults.
+func watchdogArgs(args []string, memoryMB, utilization int) []string {
+	watchdog := []string{}
+	for _, arg := range args {
+		if arg != "--disable_watchdog" {
+			watchdog = append(watchdog, arg)
+		}
+	}
+	if memoryMB > 0 {
+		watchdog = append(watchdog, fmt.Sprintf("--watchdog_memory_limit=%d", memoryMB))
+	}
+	if utilization > 0 {
+		watchdog = append(watchdog, fmt.Sprintf("--watchdog_utilization_limit=%d", utilization))
+	}
+	return watchdog
+}
+
 func osqueryTempDir() (string, func(), error) {
 	tempPath, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -243,6 +279,42 @@ func WithDistributedPluginFlag(plugin string) OsqueryInstanceOption {
 	}
 }
 
+// WithFlagfile is a functional option which allows the user to define an
+// osquery flagfile, to supplement the flags the runtime sets. The runtime's
+// flags take precedence over those in the flagfile.
+func WithFlagfile(path string) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		i.opts.flagfilePath = path
+	}
+}
+
+// WithExtensionName is a functional option which allows the user to
+// autoload a custom osquery extension, instead of the standard one. The
+// extension must be in the same directory as the launcher executable.
+func WithExtensionName(name string) OsqueryInstanceOption {
+	return WithExtensionNames(name)
+}
+
+// WithExtensionNames is a functional option which allows the user to
+// autoload several osquery extensions, instead of the standard one.
+// Like WithExtensionName, they must be next to the launcher executable.
+func WithExtensionNames(names ...string) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		i.opts.extensionNames = names
+	}
+}
+
+// WithWatchdogLimits is a functional option which enables osquery's
+// watchdog, which restarts its worker when it uses more than memoryMB of
+// memory, or utilization percent of CPU. A zero limit leaves that limit
+// at osquery's default. If both are zero, the watchdog stays disabled.
+func WithWatchdogLimits(memoryMB, utilization int) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		i.opts.watchdogMemoryLimit = memoryMB
+		i.opts.watchdogUtilization = utilization
+	}
+}
+
 // WithStdout is a functional option which allows the user to define where the
 // stdout of the osquery process should be directed. By default, the output will
 // be discarded. This should only be configured once.
@@ -428,7 +500,7 @@ func (r *Runner) launchOsqueryInstance() error {
 
 	// Based on the root directory, calculate the file names of all of the
 	// required osquery artifact files.
-	paths, err := calculateOsqueryPaths(o.opts.rootDirectory, o.opts.extensionSocketPath)
+	paths, err := calculateOsqueryPaths(o.opts.rootDirectory, o.opts.extensionSocketPath, o.opts.extensionNames...)
 	if err != nil {
 		return errors.Wrap(err, "could not calculate osquery file paths")
 	}
@@ -475,6 +547,16 @@ func (r *Runner) launchOsqueryInstance() error {
 		return errors.Wrap(err, "couldn't create osqueryd command")
 	}
 
+	// osquery applies flags in order, so the flagfile goes first, leaving
+	// the flags we depend on authoritative.
+	if o.opts.flagfilePath != "" {
+		o.cmd.Args = append([]string{o.cmd.Args[0], fmt.Sprintf("--flagfile=%s", o.opts.flagfilePath)}, o.cmd.Args[1:]...)
+	}
+
+	if o.opts.watchdogMemoryLimit > 0 || o.opts.watchdogUtilization > 0 {
+		o.cmd.Args = watchdogArgs(o.cmd.Args, o.opts.watchdogMemoryLimit, o.opts.watchdogUtilization)
+	}
+
 	// Assign a PGID that matches the PID. This lets us kill the entire process group later.
 	o.cmd.SysProcAttr = setpgid()
 
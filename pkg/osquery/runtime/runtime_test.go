@@ -49,6 +49,55 @@ func TestCalculateOsqueryPaths(t *testing.T) {
 	require.Equal(t, binDir, filepath.Dir(paths.extensionAutoloadPath))
 }
 
+func TestCalculateOsqueryPathsExtensionName(t *testing.T) {
+	t.Parallel()
+	binDir := getBinDir(t)
+	fakeExtensionPath := filepath.Join(binDir, "acme-extension.ext")
+	require.NoError(t, ioutil.WriteFile(fakeExtensionPath, []byte("#!/bin/bash\nsleep infinity"), 0755))
+
+	// A root directory of its own, so the autoload file isn't shared
+	rootDir, err := ioutil.TempDir("", "osquery-paths")
+	require.NoError(t, err)
+	defer os.RemoveAll(rootDir)
+
+	paths, err := calculateOsqueryPaths(rootDir, "", "acme-extension.ext")
+	require.NoError(t, err)
+	require.Equal(t, fakeExtensionPath, paths.extensionPath)
+
+	autoload, err := ioutil.ReadFile(paths.extensionAutoloadPath)
+	require.NoError(t, err)
+	require.Equal(t, fakeExtensionPath, string(autoload))
+
+	_, err = calculateOsqueryPaths(rootDir, "", "missing-extension.ext")
+	require.Error(t, err)
+}
+
+func TestCalculateOsqueryPathsExtensionNames(t *testing.T) {
+	t.Parallel()
+	binDir := getBinDir(t)
+	standardPath := filepath.Join(binDir, "osquery-extension.ext")
+	require.NoError(t, ioutil.WriteFile(standardPath, []byte("#!/bin/bash\nsleep infinity"), 0755))
+	acmePath := filepath.Join(binDir, "acme-secondary.ext")
+	require.NoError(t, ioutil.WriteFile(acmePath, []byte("#!/bin/bash\nsleep infinity"), 0755))
+
+	rootDir, err := ioutil.TempDir("", "osquery-paths")
+	require.NoError(t, err)
+	defer os.RemoveAll(rootDir)
+
+	// Every extension is autoloaded, one per line
+	paths, err := calculateOsqueryPaths(rootDir, "", "osquery-extension.ext", "acme-secondary.ext")
+	require.NoError(t, err)
+	require.Equal(t, standardPath, paths.extensionPath)
+
+	autoload, err := ioutil.ReadFile(paths.extensionAutoloadPath)
+	require.NoError(t, err)
+	require.Equal(t, standardPath+"\n"+acmePath, string(autoload))
+
+	// Any missing one is an error
+	_, err = calculateOsqueryPaths(rootDir, "", "acme-secondary.ext", "missing-extension.ext")
+	require.Error(t, err)
+}
+
 func TestCreateOsqueryCommand(t *testing.T) {
 	t.Parallel()
 	paths := &osqueryFilePaths{
@@ -67,6 +116,21 @@ func TestCreateOsqueryCommand(t *testing.T) {
 	require.Equal(t, os.Stdout, cmd.Stdout)
 }
 
+func TestWatchdogArgs(t *testing.T) {
+	t.Parallel()
+
+	args := []string{"osqueryd", "--force=true", "--disable_watchdog", "--utc"}
+
+	require.Equal(t,
+		[]string{"osqueryd", "--force=true", "--utc", "--watchdog_memory_limit=250", "--watchdog_utilization_limit=20"},
+		watchdogArgs(args, 250, 20),
+	)
+	require.Equal(t,
+		[]string{"osqueryd", "--force=true", "--utc", "--watchdog_memory_limit=250"},
+		watchdogArgs(args, 250, 0),
+	)
+}
+
 // buildOsqueryExtensionInBinDir compiles the osquery extension and places it
 // on disk in the same directory as the currently running executable (as
 // expected when running an osquery process)
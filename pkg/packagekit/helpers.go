@@ -1,8 +1,17 @@
 package packagekit
 
 import (
+	"bytes"
+	"context"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
 	"github.com/pkg/errors"
 )
 
@@ -19,3 +28,94 @@ func isDirectory(d string) error {
 
 	return nil
 }
+
+// pinMtimes sets the mtime of everything under the given directories
+// to t. Symlinks are skipped, as changing their times would change
+// their targets.
+func pinMtimes(t time.Time, dirs ...string) error {
+	for _, dir := range dirs {
+		if err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			return os.Chtimes(path, t, t)
+		}); err != nil {
+			return errors.Wrapf(err, "pinning mtimes in %s", dir)
+		}
+	}
+	return nil
+}
+
+// dockerNetworkArgs are the docker run arguments that keep a build
+// container off the network, and stop docker pulling its image, if
+// po.NoNetwork is set. The image must already be present.
+func dockerNetworkArgs(po *PackageOptions) []string {
+	if !po.NoNetwork {
+		return nil
+	}
+	return []string{"--network", "none", "--pull", "never"}
+}
+
+// toolOutput collects the combined output of a packaging tool. If it
+// has a logger, each line is also logged, at debug level, as it's
+// written.
+type toolOutput struct {
+	tool   string
+	logger log.Logger
+	buf    bytes.Buffer
+	line   []byte
+}
+
+func (o *toolOutput) Write(p []byte) (int, error) {
+	o.buf.Write(p)
+	if o.logger == nil {
+		return len(p), nil
+	}
+
+	o.line = append(o.line, p...)
+	for {
+		i := bytes.IndexByte(o.line, '\n')
+		if i < 0 {
+			break
+		}
+		o.log(o.line[:i])
+		o.line = o.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs any final, unterminated, line.
+func (o *toolOutput) flush() {
+	if o.logger != nil && len(o.line) > 0 {
+		o.log(o.line)
+	}
+	o.line = nil
+}
+
+func (o *toolOutput) log(line []byte) {
+	level.Debug(o.logger).Log("msg", "tool output", "tool", o.tool, "line", string(line))
+}
+
+// runTool runs cmd, a packaging tool such as fpm, capturing its
+// stdout and stderr. With po.Verbose, the output is streamed to the
+// logger. On failure, it's included in the returned error.
+func runTool(ctx context.Context, po *PackageOptions, tool string, cmd *exec.Cmd) error {
+	// The same writer for both means exec copies them in one
+	// goroutine, so toolOutput needn't lock.
+	output := &toolOutput{tool: tool}
+	if po.Verbose {
+		output.logger = ctxlog.FromContext(ctx)
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+	output.flush()
+	if err != nil {
+		return errors.Wrapf(err, "running %s: %s", tool, strings.TrimSpace(output.buf.String()))
+	}
+	return nil
+}
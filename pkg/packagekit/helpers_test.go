@@ -0,0 +1,31 @@
+package packagekit
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTool(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	ctx := ctxlog.NewContext(context.TODO(), log.NewLogfmtLogger(&logs))
+
+	// Without verbose, nothing is logged, but failures include the output
+	err := runTool(ctx, &PackageOptions{}, "fpm", exec.Command("sh", "-c", "echo building; echo bad input >&2; exit 3"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "running fpm: building\nbad input")
+	require.Empty(t, logs.String())
+
+	// With verbose, each line is logged, including an unterminated last one
+	err = runTool(ctx, &PackageOptions{Verbose: true}, "fpm", exec.Command("sh", "-c", "echo one; printf two"))
+	require.NoError(t, err)
+	require.Contains(t, logs.String(), `msg="tool output" tool=fpm line=one`)
+	require.Contains(t, logs.String(), `msg="tool output" tool=fpm line=two`)
+}
@@ -0,0 +1,116 @@
+package packagekit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// NotarizeOptions are the credentials for Apple's notary service. Set
+// either KeychainProfile, as stored by `xcrun notarytool
+// store-credentials`, or all of AppleID, TeamID, and AppPassword.
+type NotarizeOptions struct {
+	AppleID         string
+	TeamID          string
+	AppPassword     string // an app specific password, not the account password
+	KeychainProfile string
+}
+
+// Validate checks that one complete set of credentials is present.
+func (n *NotarizeOptions) Validate() error {
+	if n.KeychainProfile != "" {
+		if n.AppleID != "" || n.TeamID != "" || n.AppPassword != "" {
+			return errors.New("notarization takes either a keychain profile, or an apple id, team id, and password. Not both")
+		}
+		return nil
+	}
+
+	if n.AppleID == "" || n.TeamID == "" || n.AppPassword == "" {
+		return errors.New("notarization requires a keychain profile, or all of an apple id, team id, and password")
+	}
+	return nil
+}
+
+func (n *NotarizeOptions) credentialArgs() []string {
+	if n.KeychainProfile != "" {
+		return []string{"--keychain-profile", n.KeychainProfile}
+	}
+	return []string{"--apple-id", n.AppleID, "--team-id", n.TeamID, "--password", n.AppPassword}
+}
+
+// notarySubmission is the json output of `notarytool submit --wait`
+type notarySubmission struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// notarizePkg submits a signed pkg to Apple's notary service, waits
+// for the result, and staples the ticket to the pkg.
+func notarizePkg(ctx context.Context, path string, n *NotarizeOptions) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.notarizePkg")
+	defer span.End()
+
+	logger := ctxlog.FromContext(ctx)
+
+	if err := n.Validate(); err != nil {
+		return err
+	}
+
+	// Don't log the arguments, they may include the password.
+	args := append([]string{"notarytool", "submit", path, "--wait", "--output-format", "json"}, n.credentialArgs()...)
+	level.Info(logger).Log("msg", "submitting pkg for notarization", "path", path)
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, "xcrun", args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// notarytool exits non-zero for rejected submissions, but its
+	// output still has the submission id, which is what's needed to
+	// look up why.
+	runErr := cmd.Run()
+
+	submission, err := parseNotarySubmission(stdout.Bytes())
+	if err != nil {
+		if runErr != nil {
+			return errors.Wrapf(runErr, "running notarytool: %s", strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+
+	level.Info(logger).Log("msg", "notarization finished", "id", submission.ID, "status", submission.Status)
+
+	if submission.Status != "Accepted" {
+		return errors.Errorf(
+			"notarization %s was %s: %s. See `xcrun notarytool log %s` for details",
+			submission.ID, submission.Status, submission.Message, submission.ID,
+		)
+	}
+
+	staple := exec.CommandContext(ctx, "xcrun", "stapler", "staple", path)
+	if output, err := staple.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "stapling notarization %s: %s", submission.ID, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func parseNotarySubmission(output []byte) (*notarySubmission, error) {
+	var submission notarySubmission
+	if err := json.Unmarshal(output, &submission); err != nil {
+		return nil, errors.Wrap(err, "parsing notarytool output")
+	}
+	if submission.ID == "" || submission.Status == "" {
+		return nil, errors.Errorf("notarytool output is missing an id or status: %s", strings.TrimSpace(string(output)))
+	}
+	return &submission, nil
+}
@@ -1,5 +1,17 @@
 package packagekit
 
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults for the package metadata.
+const (
+	DefaultVendor   = "Kolide"
+	DefaultLicense  = "MIT"
+	DefaultHomepage = "https://github.com/kolide/launcher"
+)
+
 // PackageOptions is the superset of all packaging options. Not all
 // packages will support all options.
 type PackageOptions struct {
@@ -9,4 +21,69 @@ type PackageOptions struct {
 	Scripts    string // directory of packaging scripts (postinst, prerm, etc)
 	SigningKey string // key to sign packages with (platform specific behaviors)
 	Version    string // package version
+	Arch       string // package architecture, in go's naming (eg: amd64, arm64)
+	NoNetwork  bool   // run build containers without network access, using only local images
+	Verbose    bool   // log the output of the packaging tools, at debug level, as they run
+
+	// SignInDocker signs linux packages in the fpm container, with the
+	// host's gpg keyring mounted, rather than with the host's tools.
+	SignInDocker bool
+
+	// Package metadata. These are optional, unset ones use the
+	// defaults below. Not all formats have all of them.
+	Vendor      string // eg: Kolide
+	Maintainer  string // eg: Kolide <help@example.com>. Defaults to the vendor
+	Description string // Defaults to a description of launcher for Identifier
+	License     string // eg: MIT
+	Homepage    string // eg: https://github.com/kolide/launcher
+
+	// SourceDateEpoch, if set, is used for all file mtimes and
+	// embedded timestamps, so builds are reproducible. See
+	// https://reproducible-builds.org/specs/source-date-epoch/
+	SourceDateEpoch time.Time
+}
+
+func (po *PackageOptions) vendor() string {
+	if po.Vendor != "" {
+		return po.Vendor
+	}
+	return DefaultVendor
+}
+
+func (po *PackageOptions) maintainer() string {
+	if po.Maintainer != "" {
+		return po.Maintainer
+	}
+	return po.vendor()
+}
+
+func (po *PackageOptions) description() string {
+	if po.Description != "" {
+		return po.Description
+	}
+	return fmt.Sprintf("The Kolide Launcher, packaged for %s", po.Identifier)
+}
+
+func (po *PackageOptions) license() string {
+	if po.License != "" {
+		return po.License
+	}
+	return DefaultLicense
+}
+
+func (po *PackageOptions) homepage() string {
+	if po.Homepage != "" {
+		return po.Homepage
+	}
+	return DefaultHomepage
+}
+
+// SigningError marks failures to sign, or verify the signature of, a
+// package. Check for it with errors.Cause.
+type SigningError struct {
+	Err error
+}
+
+func (e *SigningError) Error() string {
+	return e.Err.Error()
 }
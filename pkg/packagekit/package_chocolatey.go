@@ -0,0 +1,161 @@
+package packagekit
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// PackageChocolatey creates a chocolatey package. The launcher
+// install logic already lives in the MSI, so this builds one with
+// PackageWixMSI, and wraps it in a nupkg that installs it.
+func PackageChocolatey(ctx context.Context, w io.Writer, po *PackageOptions, wixOpts ...WixOpt) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.PackageChocolatey")
+	defer span.End()
+
+	msiFH, err := ioutil.TempFile("", "packaging-chocolatey-msi")
+	if err != nil {
+		return errors.Wrap(err, "making msi tempfile")
+	}
+	defer os.Remove(msiFH.Name())
+	defer msiFH.Close()
+
+	if err := PackageWixMSI(ctx, msiFH, po, wixOpts...); err != nil {
+		return errors.Wrap(err, "building msi")
+	}
+
+	if _, err := msiFH.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "rewinding msi")
+	}
+
+	return writeNupkg(w, po, msiFH)
+}
+
+// writeNupkg writes a nupkg containing the msi. nupkgs are zip files
+// in the Open Packaging Conventions format, which is why they need
+// the content types and relationships parts.
+func writeNupkg(w io.Writer, po *PackageOptions, msi io.Reader) error {
+	packageId := fmt.Sprintf("%s-%s", po.Name, po.Identifier)
+	msiName := fmt.Sprintf("%s.msi", po.Name)
+
+	zw := zip.NewWriter(w)
+
+	nuspecFH, err := zw.Create(packageId + ".nuspec")
+	if err != nil {
+		return errors.Wrap(err, "creating nuspec")
+	}
+	if err := renderNuspec(nuspecFH, packageId, po); err != nil {
+		return errors.Wrap(err, "rendering nuspec")
+	}
+
+	installFH, err := zw.Create("tools/chocolateyInstall.ps1")
+	if err != nil {
+		return errors.Wrap(err, "creating chocolateyInstall.ps1")
+	}
+	if err := chocolateyInstallTemplate.Execute(installFH, msiName); err != nil {
+		return errors.Wrap(err, "rendering chocolateyInstall.ps1")
+	}
+
+	msiEntry, err := zw.Create("tools/" + msiName)
+	if err != nil {
+		return errors.Wrap(err, "creating msi entry")
+	}
+	if _, err := io.Copy(msiEntry, msi); err != nil {
+		return errors.Wrap(err, "copying msi")
+	}
+
+	contentTypesFH, err := zw.Create("[Content_Types].xml")
+	if err != nil {
+		return errors.Wrap(err, "creating content types")
+	}
+	if _, err := io.WriteString(contentTypesFH, nupkgContentTypes); err != nil {
+		return errors.Wrap(err, "writing content types")
+	}
+
+	relsFH, err := zw.Create("_rels/.rels")
+	if err != nil {
+		return errors.Wrap(err, "creating relationships")
+	}
+	if _, err := fmt.Fprintf(relsFH, nupkgRels, packageId); err != nil {
+		return errors.Wrap(err, "writing relationships")
+	}
+
+	if err := zw.Close(); err != nil {
+		return errors.Wrap(err, "closing nupkg")
+	}
+
+	return nil
+}
+
+type nuspecPackage struct {
+	XMLName  xml.Name       `xml:"package"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Metadata nuspecMetadata `xml:"metadata"`
+}
+
+type nuspecMetadata struct {
+	Id          string `xml:"id"`
+	Version     string `xml:"version"`
+	Title       string `xml:"title"`
+	Authors     string `xml:"authors"`
+	ProjectUrl  string `xml:"projectUrl"`
+	Description string `xml:"description"`
+	Tags        string `xml:"tags"`
+}
+
+func renderNuspec(w io.Writer, packageId string, po *PackageOptions) error {
+	doc := nuspecPackage{
+		Xmlns: "http://schemas.microsoft.com/packaging/2015/06/nuspec.xsd",
+		Metadata: nuspecMetadata{
+			Id: packageId,
+			// Chocolatey versions are numeric, the same as MSIs
+			Version:     wixVersion(po.Version),
+			Title:       fmt.Sprintf("%s (%s)", po.Name, po.Identifier),
+			Authors:     po.vendor(),
+			ProjectUrl:  po.homepage(),
+			Description: po.description(),
+			Tags:        "kolide launcher osquery",
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return errors.Wrap(err, "writing xml header")
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+var chocolateyInstallTemplate = template.Must(template.New("chocolateyInstall").Parse(
+	`$ErrorActionPreference = 'Stop'
+$toolsDir = "$(Split-Path -parent $MyInvocation.MyCommand.Definition)"
+
+Install-ChocolateyInstallPackage -PackageName $env:ChocolateyPackageName ` + "`" + `
+  -FileType 'msi' ` + "`" + `
+  -SilentArgs '/qn /norestart' ` + "`" + `
+  -File "$toolsDir\{{.}}"
+`))
+
+const nupkgContentTypes = `<?xml version="1.0" encoding="utf-8"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+  <Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml" />
+  <Default Extension="nuspec" ContentType="application/octet" />
+  <Default Extension="ps1" ContentType="application/octet" />
+  <Default Extension="msi" ContentType="application/octet" />
+</Types>
+`
+
+const nupkgRels = `<?xml version="1.0" encoding="utf-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Type="http://schemas.microsoft.com/packaging/2010/07/manifest" Target="/%s.nuspec" Id="manifest" />
+</Relationships>
+`
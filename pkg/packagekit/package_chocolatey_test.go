@@ -0,0 +1,47 @@
+package packagekit
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteNupkg(t *testing.T) {
+	t.Parallel()
+
+	po := &PackageOptions{
+		Name:       "launcher",
+		Identifier: "kolide-app",
+		Version:    "0.5.6-19-g17c8589",
+		Homepage:   "https://example.com",
+	}
+
+	var output bytes.Buffer
+	require.NoError(t, writeNupkg(&output, po, strings.NewReader("fake msi")))
+
+	zr, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	require.NoError(t, err)
+
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		fh, err := f.Open()
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(fh)
+		require.NoError(t, err)
+		fh.Close()
+		contents[f.Name] = string(b)
+	}
+
+	require.Equal(t, "fake msi", contents["tools/launcher.msi"])
+	require.Contains(t, contents["launcher-kolide-app.nuspec"], "<id>launcher-kolide-app</id>")
+	require.Contains(t, contents["launcher-kolide-app.nuspec"], "<version>0.5.6</version>")
+	require.Contains(t, contents["launcher-kolide-app.nuspec"], "<authors>Kolide</authors>")
+	require.Contains(t, contents["launcher-kolide-app.nuspec"], "<projectUrl>https://example.com</projectUrl>")
+	require.Contains(t, contents["tools/chocolateyInstall.ps1"], `-File "$toolsDir\launcher.msi"`)
+	require.Contains(t, contents["_rels/.rels"], `Target="/launcher-kolide-app.nuspec"`)
+	require.Contains(t, contents, "[Content_Types].xml")
+}
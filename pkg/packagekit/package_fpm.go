@@ -1,7 +1,6 @@
 package packagekit
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -17,14 +16,38 @@ import (
 type outputType string
 
 const (
-	Deb outputType = "deb"
-	RPM            = "rpm"
-	Tar            = "tar"
+	Deb    outputType = "deb"
+	RPM               = "rpm"
+	Tar               = "tar"
+	Pacman            = "pacman"
+)
+
+// Compression is the algorithm a package's contents are compressed
+// with. Not every package type supports every algorithm.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip             = "gzip"
+	CompressionXz               = "xz"
+	CompressionZstd             = "zstd"
 )
 
 type fpmOptions struct {
-	outputType outputType
-	replaces   []string
+	outputType  outputType
+	replaces    []string
+	compression Compression
+	owners      []FileOwner
+}
+
+// FileOwner is the owner of a packaged file, or directory, declared
+// in the package's metadata, so it's installed owned by them, rather
+// than by root. The user, and group, must exist when it's installed.
+type FileOwner struct {
+	Path  string // the installed path, eg: /etc/kolide-app
+	User  string
+	Group string
+	Dir   bool // Path is a directory, which the package owns
 }
 
 type FpmOpt func(*fpmOptions)
@@ -41,6 +64,12 @@ func AsDeb() FpmOpt {
 	}
 }
 
+func AsPacman() FpmOpt {
+	return func(f *fpmOptions) {
+		f.outputType = Pacman
+	}
+}
+
 func AsTar() FpmOpt {
 	return func(f *fpmOptions) {
 		f.outputType = Tar
@@ -56,6 +85,22 @@ func WithReplaces(r []string) FpmOpt {
 	}
 }
 
+// WithCompression sets the compression algorithm. If unset, each
+// package type uses its default.
+func WithCompression(c Compression) FpmOpt {
+	return func(f *fpmOptions) {
+		f.compression = c
+	}
+}
+
+// WithOwners declares the owners of packaged files, and directories,
+// in the package metadata. Only rpm packages support it.
+func WithOwners(owners []FileOwner) FpmOpt {
+	return func(f *fpmOptions) {
+		f.owners = owners
+	}
+}
+
 func PackageFPM(ctx context.Context, w io.Writer, po *PackageOptions, fpmOpts ...FpmOpt) error {
 	ctx, span := trace.StartSpan(ctx, "packagekit.PackageRPM")
 	defer span.End()
@@ -91,11 +136,51 @@ func PackageFPM(ctx context.Context, w io.Writer, po *PackageOptions, fpmOpts ..
 		"-C", "/pkgsrc",
 	}
 
+	fpmCommand = append(fpmCommand, fpmMetadataArgs(po)...)
+
+	if po.Arch != "" {
+		fpmCommand = append(fpmCommand, "-a", fpmArch(f.outputType, po.Arch))
+	}
+
+	compressionArgs, err := fpmCompressionArgs(f.outputType, f.compression)
+	if err != nil {
+		return err
+	}
+	fpmCommand = append(fpmCommand, compressionArgs...)
+
+	ownerArgs, err := fpmOwnerArgs(f.outputType, f.owners)
+	if err != nil {
+		return err
+	}
+	fpmCommand = append(fpmCommand, ownerArgs...)
+
 	// Pass each replaces in. Set it as a conflict and a replace.
 	for _, r := range f.replaces {
 		fpmCommand = append(fpmCommand, "--replaces", r, "--conflicts", r)
 	}
 
+	// fpm, and rpmbuild, honor SOURCE_DATE_EPOCH for the timestamps
+	// they embed. The file mtimes come from the package root.
+	var dockerEnv []string
+	if !po.SourceDateEpoch.IsZero() {
+		if err := pinMtimes(po.SourceDateEpoch, po.Root, po.Scripts); err != nil {
+			return err
+		}
+		dockerEnv = append(dockerEnv, "-e", fmt.Sprintf("SOURCE_DATE_EPOCH=%d", po.SourceDateEpoch.Unix()))
+		if f.outputType == RPM {
+			fpmCommand = append(fpmCommand,
+				"--rpm-rpmbuild-define", "use_source_date_epoch_as_buildtime 1",
+				"--rpm-rpmbuild-define", "clamp_mtime_to_source_date_epoch 1",
+				"--rpm-rpmbuild-define", "_buildhost reproducible",
+			)
+		}
+	}
+
+	// If preinstall exists, pass it to fpm
+	if _, err := os.Stat(filepath.Join(po.Scripts, "preinstall")); !os.IsNotExist(err) {
+		fpmCommand = append(fpmCommand, "--before-install", filepath.Join("/pkgscripts", "preinstall"))
+	}
+
 	// If postinstall exists, pass it to fpm
 	if _, err := os.Stat(filepath.Join(po.Scripts, "postinstall")); !os.IsNotExist(err) {
 		fpmCommand = append(fpmCommand, "--after-install", filepath.Join("/pkgscripts", "postinstall"))
@@ -106,20 +191,30 @@ func PackageFPM(ctx context.Context, w io.Writer, po *PackageOptions, fpmOpts ..
 		fpmCommand = append(fpmCommand, "--before-remove", filepath.Join("/pkgscripts", "prerm"))
 	}
 
+	// If postremove exists, pass it to fpm
+	if _, err := os.Stat(filepath.Join(po.Scripts, "postremove")); !os.IsNotExist(err) {
+		fpmCommand = append(fpmCommand, "--after-remove", filepath.Join("/pkgscripts", "postremove"))
+	}
+
 	dockerArgs := []string{
 		"run", "--rm",
 		"-v", fmt.Sprintf("%s:/pkgsrc", po.Root),
 		"-v", fmt.Sprintf("%s:/pkgscripts", po.Scripts),
 		"-v", fmt.Sprintf("%s:/out", outputPathDir),
-		"kolide/fpm",
 	}
+	dockerArgs = append(dockerArgs, dockerEnv...)
+	dockerArgs = append(dockerArgs, dockerNetworkArgs(po)...)
+	dockerArgs = append(dockerArgs, "kolide/fpm")
 
 	cmd := exec.CommandContext(ctx, "docker", append(dockerArgs, fpmCommand...)...)
+	if err := runTool(ctx, po, "fpm", cmd); err != nil {
+		return errors.Wrap(err, "creating fpm package")
+	}
 
-	stderr := new(bytes.Buffer)
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return errors.Wrapf(err, "creating fpm package: %s", stderr)
+	if po.SigningKey != "" {
+		if err := signFPMPackage(ctx, po, f.outputType, filepath.Join(outputPathDir, outputFilename)); err != nil {
+			return &SigningError{errors.Wrap(err, "signing package")}
+		}
 	}
 
 	outputFH, err := os.Open(filepath.Join(outputPathDir, outputFilename))
@@ -133,3 +228,137 @@ func PackageFPM(ctx context.Context, w io.Writer, po *PackageOptions, fpmOpts ..
 
 	return nil
 }
+
+// fpmMetadataArgs are the fpm arguments for the package metadata
+// shown by `dpkg -I`, `rpm -qi`, and friends.
+func fpmMetadataArgs(po *PackageOptions) []string {
+	return []string{
+		"--vendor", po.vendor(),
+		"--maintainer", po.maintainer(),
+		"--description", po.description(),
+		"--license", po.license(),
+		"--url", po.homepage(),
+	}
+}
+
+// signFPMPackage signs a package with gpg. This runs on the host,
+// not in the fpm container, so it can use the host's gpg keyring,
+// unless po.SignInDocker is set.
+func signFPMPackage(ctx context.Context, po *PackageOptions, t outputType, path string) error {
+	signCommand, err := fpmSigningCommand(po, t, path)
+	if err != nil {
+		return err
+	}
+
+	if !po.SignInDocker {
+		cmd := exec.CommandContext(ctx, signCommand[0], signCommand[1:]...)
+		return runTool(ctx, po, signCommand[0], cmd)
+	}
+
+	gnupgHome, err := gnupgHome()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	dockerArgs := []string{
+		"run", "--rm",
+		"-v", fmt.Sprintf("%s:%s", dir, dir),
+		"-v", fmt.Sprintf("%s:/root/.gnupg", gnupgHome),
+	}
+	dockerArgs = append(dockerArgs, dockerNetworkArgs(po)...)
+	dockerArgs = append(dockerArgs, "kolide/fpm")
+
+	cmd := exec.CommandContext(ctx, "docker", append(dockerArgs, signCommand...)...)
+	return runTool(ctx, po, signCommand[0], cmd)
+}
+
+// fpmSigningCommand is the command to sign the package at path.
+func fpmSigningCommand(po *PackageOptions, t outputType, path string) ([]string, error) {
+	switch t {
+	case RPM:
+		return []string{"rpm", "--addsign", "--define", fmt.Sprintf("_gpg_name %s", po.SigningKey), path}, nil
+	case Deb:
+		return []string{"dpkg-sig", "--sign", "builder", "-k", po.SigningKey, path}, nil
+	default:
+		return nil, errors.Errorf("Don't know how to sign %s packages", t)
+	}
+}
+
+// gnupgHome is the host's gpg keyring directory, per GNUPGHOME, or
+// ~/.gnupg, as gpg finds it.
+func gnupgHome() (string, error) {
+	if dir := os.Getenv("GNUPGHOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "finding gpg keyring")
+	}
+	return filepath.Join(home, ".gnupg"), nil
+}
+
+// fpmCompressions are the fpm names of the compressions each output
+// type supports.
+var fpmCompressions = map[outputType]map[Compression]string{
+	Deb:    {CompressionNone: "none", CompressionGzip: "gz", CompressionXz: "xz"},
+	RPM:    {CompressionNone: "none", CompressionGzip: "gzip", CompressionXz: "xz"},
+	Pacman: {CompressionNone: "none", CompressionGzip: "gz", CompressionXz: "xz", CompressionZstd: "zstd"},
+}
+
+// fpmCompressionArgs returns the fpm arguments to compress with c. An
+// empty c is fpm's default, except for pacman, as Arch has moved to
+// zstd compressed packages.
+func fpmCompressionArgs(t outputType, c Compression) ([]string, error) {
+	if c == "" {
+		if t == Pacman {
+			return []string{"--pacman-compression", "zstd"}, nil
+		}
+		return nil, nil
+	}
+
+	name, ok := fpmCompressions[t][c]
+	if !ok {
+		return nil, errors.Errorf("%s packages can't be compressed with %s", t, c)
+	}
+	return []string{fmt.Sprintf("--%s-compression", t), name}, nil
+}
+
+// fpmOwnerArgs are the fpm arguments to declare owners, as rpm %attr
+// entries. Their modes are left as packaged. Directories are listed
+// as the package's, so they have an entry to set the owner of.
+func fpmOwnerArgs(t outputType, owners []FileOwner) ([]string, error) {
+	if len(owners) == 0 {
+		return nil, nil
+	}
+	if t != RPM {
+		return nil, errors.Errorf("%s packages can't declare file owners", t)
+	}
+
+	args := []string{}
+	for _, owner := range owners {
+		group := owner.Group
+		if group == "" {
+			group = "-"
+		}
+		if owner.Dir {
+			args = append(args, "--directories", owner.Path)
+		}
+		args = append(args, "--rpm-attr", fmt.Sprintf("-,%s,%s:%s", owner.User, group, owner.Path))
+	}
+	return args, nil
+}
+
+// fpmArch converts go's architecture names into the ones each package
+// format expects.
+func fpmArch(t outputType, arch string) string {
+	switch {
+	case t == RPM && arch == "amd64":
+		return "x86_64"
+	case (t == RPM || t == Pacman) && arch == "arm64":
+		return "aarch64"
+	case t == Pacman && arch == "amd64":
+		return "x86_64"
+	}
+	return arch
+}
@@ -0,0 +1,97 @@
+package packagekit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerNetworkArgs(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, dockerNetworkArgs(&PackageOptions{}))
+	require.Equal(t, []string{"--network", "none", "--pull", "never"}, dockerNetworkArgs(&PackageOptions{NoNetwork: true}))
+}
+
+func TestFpmMetadataArgs(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, []string{
+		"--vendor", "Kolide",
+		"--maintainer", "Kolide",
+		"--description", "The Kolide Launcher, packaged for kolide-app",
+		"--license", "MIT",
+		"--url", "https://github.com/kolide/launcher",
+	}, fpmMetadataArgs(&PackageOptions{Identifier: "kolide-app"}))
+
+	require.Equal(t, []string{
+		"--vendor", "Example Co",
+		"--maintainer", "IT <it@example.com>",
+		"--description", "Example endpoint agent",
+		"--license", "Proprietary",
+		"--url", "https://example.com",
+	}, fpmMetadataArgs(&PackageOptions{
+		Identifier:  "kolide-app",
+		Vendor:      "Example Co",
+		Maintainer:  "IT <it@example.com>",
+		Description: "Example endpoint agent",
+		License:     "Proprietary",
+		Homepage:    "https://example.com",
+	}))
+
+	// The maintainer defaults to the vendor
+	require.Equal(t, "Example Co", (&PackageOptions{Vendor: "Example Co"}).maintainer())
+}
+
+func TestFpmCompressionArgs(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		outputType  outputType
+		compression Compression
+		expected    []string
+	}{
+		{outputType: Deb},
+		{outputType: RPM},
+		{outputType: Pacman, expected: []string{"--pacman-compression", "zstd"}},
+		{outputType: Deb, compression: CompressionGzip, expected: []string{"--deb-compression", "gz"}},
+		{outputType: Deb, compression: CompressionXz, expected: []string{"--deb-compression", "xz"}},
+		{outputType: RPM, compression: CompressionGzip, expected: []string{"--rpm-compression", "gzip"}},
+		{outputType: RPM, compression: CompressionNone, expected: []string{"--rpm-compression", "none"}},
+		{outputType: Pacman, compression: CompressionXz, expected: []string{"--pacman-compression", "xz"}},
+	}
+
+	for _, tt := range tests {
+		args, err := fpmCompressionArgs(tt.outputType, tt.compression)
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, args)
+	}
+
+	_, err := fpmCompressionArgs(Deb, CompressionZstd)
+	require.Error(t, err)
+
+	_, err = fpmCompressionArgs(Tar, CompressionXz)
+	require.Error(t, err)
+}
+
+func TestFpmOwnerArgs(t *testing.T) {
+	t.Parallel()
+
+	args, err := fpmOwnerArgs(RPM, nil)
+	require.NoError(t, err)
+	require.Empty(t, args)
+
+	args, err = fpmOwnerArgs(RPM, []FileOwner{
+		{Path: "/etc/kolide-app", User: "kolide", Group: "kolide", Dir: true},
+		{Path: "/etc/kolide-app/secret", User: "kolide"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"--directories", "/etc/kolide-app",
+		"--rpm-attr", "-,kolide,kolide:/etc/kolide-app",
+		"--rpm-attr", "-,kolide,-:/etc/kolide-app/secret",
+	}, args)
+
+	_, err = fpmOwnerArgs(Deb, []FileOwner{{Path: "/etc/kolide-app", User: "kolide"}})
+	require.Error(t, err)
+}
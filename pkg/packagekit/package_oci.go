@@ -0,0 +1,520 @@
+package packagekit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// OCI media types. See https://github.com/opencontainers/image-spec
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociHistory struct {
+	Created   string `json:"created,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+type ociImageConfig struct {
+	Created      string `json:"created,omitempty"`
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		User         string              `json:"User,omitempty"`
+		Env          []string            `json:"Env,omitempty"`
+		Entrypoint   []string            `json:"Entrypoint,omitempty"`
+		Cmd          []string            `json:"Cmd,omitempty"`
+		WorkingDir   string              `json:"WorkingDir,omitempty"`
+		Labels       map[string]string   `json:"Labels,omitempty"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+		Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+		StopSignal   string              `json:"StopSignal,omitempty"`
+	} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []ociHistory `json:"history,omitempty"`
+}
+
+type ociOptions struct {
+	base    string
+	service *InitOptions
+}
+
+type OCIOpt func(*ociOptions)
+
+// WithOCIBase builds the image on top of the image in the OCI layout
+// tarball at path, eg: from skopeo copy docker://debian:stable-slim
+// oci-archive:base.tar. Without one, the image is built from scratch.
+func WithOCIBase(path string) OCIOpt {
+	return func(o *ociOptions) {
+		o.base = path
+	}
+}
+
+// WithOCIService makes the file at initOptions.Path (relative to the
+// package root) the image's entrypoint, run with the environment and
+// flags.
+func WithOCIService(initOptions *InitOptions) OCIOpt {
+	return func(o *ociOptions) {
+		o.service = initOptions
+	}
+}
+
+// PackageOCI creates an OCI image, as an OCI layout tarball, from the
+// package root. The root is a single layer, on top of the base image
+// layers, if there's one. The image can be loaded with docker load,
+// or podman load, or pushed with skopeo copy oci-archive:<path>.
+// Packaging scripts are not included.
+func PackageOCI(ctx context.Context, w io.Writer, po *PackageOptions, ociOpts ...OCIOpt) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.PackageOCI")
+	defer span.End()
+
+	options := &ociOptions{}
+	for _, opt := range ociOpts {
+		opt(options)
+	}
+
+	if err := isDirectory(po.Root); err != nil {
+		return err
+	}
+
+	if options.service == nil {
+		return errors.New("oci images need a service to run")
+	}
+
+	workDir, err := ioutil.TempDir("", "packaging-oci")
+	if err != nil {
+		return errors.Wrap(err, "making TempDir")
+	}
+	defer os.RemoveAll(workDir)
+
+	created := po.SourceDateEpoch
+	if created.IsZero() {
+		created = time.Now()
+	}
+	created = created.UTC()
+
+	manifest := &ociManifest{SchemaVersion: 2, MediaType: ociManifestMediaType}
+	config := &ociImageConfig{}
+	config.RootFS.Type = "layers"
+
+	// blobs are the paths of each blob, by digest, in the order
+	// they're written.
+	type blob struct {
+		digest string
+		path   string
+	}
+	blobs := []blob{}
+
+	baseDir := filepath.Join(workDir, "base")
+	if options.base != "" {
+		if manifest, config, err = readOCIBase(options.base, baseDir); err != nil {
+			return errors.Wrapf(err, "reading base image %s", options.base)
+		}
+		for _, layer := range manifest.Layers {
+			blobs = append(blobs, blob{digest: layer.Digest, path: ociBlobPath(baseDir, layer.Digest)})
+		}
+	}
+
+	// The package root, tarred as it is for tar packages, is the layer
+	layerPath := filepath.Join(workDir, "layer.tar.gz")
+	layer, diffID, err := writeOCILayer(ctx, layerPath, po)
+	if err != nil {
+		return err
+	}
+	manifest.Layers = append(manifest.Layers, layer)
+	blobs = append(blobs, blob{digest: layer.Digest, path: layerPath})
+
+	name := fmt.Sprintf("%s-%s", po.Name, po.Identifier)
+	config.Created = created.Format(time.RFC3339)
+	config.Architecture = po.Arch
+	if config.Architecture == "" {
+		config.Architecture = "amd64"
+	}
+	config.OS = "linux"
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, ociHistory{
+		Created:   config.Created,
+		CreatedBy: "package-builder",
+		Comment:   fmt.Sprintf("%s %s", name, po.Version),
+	})
+
+	// The service runs as launcher's init would run it. The base
+	// image's settings are kept, but ours win.
+	config.Config.Entrypoint = []string{options.service.Path}
+	config.Config.Cmd = options.service.Flags
+	config.Config.Env = mergeOCIEnv(config.Config.Env, options.service.Environment)
+	if config.Config.Labels == nil {
+		config.Config.Labels = make(map[string]string)
+	}
+	for k, v := range map[string]string{
+		"org.opencontainers.image.title":       name,
+		"org.opencontainers.image.version":     po.Version,
+		"org.opencontainers.image.created":     config.Created,
+		"org.opencontainers.image.vendor":      po.vendor(),
+		"org.opencontainers.image.licenses":    po.license(),
+		"org.opencontainers.image.description": po.description(),
+		"org.opencontainers.image.url":         po.homepage(),
+	} {
+		config.Config.Labels[k] = v
+	}
+
+	configPath := filepath.Join(workDir, "config.json")
+	if manifest.Config, err = writeOCIJSON(configPath, ociConfigMediaType, config); err != nil {
+		return errors.Wrap(err, "writing image config")
+	}
+	blobs = append(blobs, blob{digest: manifest.Config.Digest, path: configPath})
+
+	manifestPath := filepath.Join(workDir, "manifest.json")
+	manifestDescriptor, err := writeOCIJSON(manifestPath, ociManifestMediaType, manifest)
+	if err != nil {
+		return errors.Wrap(err, "writing image manifest")
+	}
+	blobs = append(blobs, blob{digest: manifestDescriptor.Digest, path: manifestPath})
+
+	manifestDescriptor.Annotations = map[string]string{
+		"org.opencontainers.image.ref.name": ociTag(po.Version),
+	}
+	index, err := json.Marshal(&ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{manifestDescriptor}})
+	if err != nil {
+		return errors.Wrap(err, "encoding image index")
+	}
+
+	tw := tar.NewWriter(w)
+
+	writeFile := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     size,
+			ModTime:  created,
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return errors.Wrapf(err, "writing tar header for %s", name)
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return errors.Wrapf(err, "copying %s into tar", name)
+		}
+		return nil
+	}
+
+	layout := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	if err := writeFile("oci-layout", int64(len(layout)), bytes.NewReader(layout)); err != nil {
+		return err
+	}
+	if err := writeFile("index.json", int64(len(index)), bytes.NewReader(index)); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: "blobs/", Mode: 0755, ModTime: created, Typeflag: tar.TypeDir}); err != nil {
+		return errors.Wrap(err, "writing tar header for blobs")
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "blobs/sha256/", Mode: 0755, ModTime: created, Typeflag: tar.TypeDir}); err != nil {
+		return errors.Wrap(err, "writing tar header for blobs")
+	}
+
+	// Images can repeat a layer, but each blob is only stored once
+	written := make(map[string]bool)
+	for _, b := range blobs {
+		if written[b.digest] {
+			continue
+		}
+		written[b.digest] = true
+		if err := copyOCIBlob(b.path, b.digest, writeFile); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "closing tar")
+	}
+
+	return nil
+}
+
+// writeOCILayer writes the package root, as a gzipped tar, to path,
+// and returns its descriptor, and the digest of the uncompressed tar,
+// which is its diff id.
+func writeOCILayer(ctx context.Context, path string, po *PackageOptions) (ociDescriptor, string, error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		return ociDescriptor{}, "", errors.Wrap(err, "creating layer")
+	}
+	defer fh.Close()
+
+	if err := PackageTar(ctx, fh, po); err != nil {
+		return ociDescriptor{}, "", errors.Wrap(err, "creating layer")
+	}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return ociDescriptor{}, "", errors.Wrap(err, "seeking layer")
+	}
+
+	compressed := sha256.New()
+	gzr, err := gzip.NewReader(io.TeeReader(fh, compressed))
+	if err != nil {
+		return ociDescriptor{}, "", errors.Wrap(err, "reading layer")
+	}
+	uncompressed := sha256.New()
+	if _, err := io.Copy(uncompressed, gzr); err != nil {
+		return ociDescriptor{}, "", errors.Wrap(err, "hashing layer")
+	}
+	// Drain any trailing bytes, so the compressed hash is of it all
+	if _, err := io.Copy(compressed, fh); err != nil {
+		return ociDescriptor{}, "", errors.Wrap(err, "hashing layer")
+	}
+
+	info, err := fh.Stat()
+	if err != nil {
+		return ociDescriptor{}, "", errors.Wrap(err, "stat layer")
+	}
+
+	return ociDescriptor{
+		MediaType: ociLayerMediaType,
+		Digest:    "sha256:" + hex.EncodeToString(compressed.Sum(nil)),
+		Size:      info.Size(),
+	}, "sha256:" + hex.EncodeToString(uncompressed.Sum(nil)), nil
+}
+
+// writeOCIJSON writes v, as JSON, to path, and returns its descriptor.
+func writeOCIJSON(path, mediaType string, v interface{}) (ociDescriptor, error) {
+	contents, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, errors.Wrap(err, "encoding")
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return ociDescriptor{}, errors.Wrap(err, "writing")
+	}
+	sum := sha256.Sum256(contents)
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(contents)),
+	}, nil
+}
+
+// copyOCIBlob writes the blob at path into the image, checking it
+// matches its digest as it goes.
+func copyOCIBlob(path, digest string, writeFile func(string, int64, io.Reader) error) error {
+	hexDigest, err := ociDigestHex(digest)
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "opening blob %s", digest)
+	}
+	defer fh.Close()
+
+	info, err := fh.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat blob %s", digest)
+	}
+
+	h := sha256.New()
+	if err := writeFile("blobs/sha256/"+hexDigest, info.Size(), io.TeeReader(fh, h)); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != hexDigest {
+		return errors.Errorf("blob %s doesn't match its digest, got sha256:%s", digest, actual)
+	}
+	return nil
+}
+
+// readOCIBase extracts the OCI layout tarball at path into dir, and
+// returns its image's manifest, and config. Layouts with several
+// images, eg: for several platforms, use the first.
+func readOCIBase(path, dir string) (*ociManifest, *ociImageConfig, error) {
+	if err := extractOCILayout(path, dir); err != nil {
+		return nil, nil, err
+	}
+
+	var index ociIndex
+	if err := readOCIJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, nil, errors.Wrap(err, "reading index.json. Is it an OCI layout tarball?")
+	}
+	if len(index.Manifests) == 0 {
+		return nil, nil, errors.New("index.json has no images")
+	}
+	if mediaType := index.Manifests[0].MediaType; mediaType != ociManifestMediaType {
+		return nil, nil, errors.Errorf("unsupported image media type %s, expected %s", mediaType, ociManifestMediaType)
+	}
+
+	var manifest ociManifest
+	if err := readOCIJSON(ociBlobPath(dir, index.Manifests[0].Digest), &manifest); err != nil {
+		return nil, nil, errors.Wrap(err, "reading manifest")
+	}
+
+	var config ociImageConfig
+	if err := readOCIJSON(ociBlobPath(dir, manifest.Config.Digest), &config); err != nil {
+		return nil, nil, errors.Wrap(err, "reading config")
+	}
+	if config.OS != "" && config.OS != "linux" {
+		return nil, nil, errors.Errorf("base image is for %s, not linux", config.OS)
+	}
+
+	for _, layer := range manifest.Layers {
+		if _, err := os.Stat(ociBlobPath(dir, layer.Digest)); err != nil {
+			return nil, nil, errors.Wrapf(err, "missing layer %s", layer.Digest)
+		}
+	}
+
+	manifest.MediaType = ociManifestMediaType
+	return &manifest, &config, nil
+}
+
+// extractOCILayout extracts the index, and blobs, of the OCI layout
+// tarball at path into dir.
+func extractOCILayout(path, dir string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening")
+	}
+	defer fh.Close()
+
+	tr := tar.NewReader(fh)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading tar")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(header.Name)), "./")
+		if name != "index.json" && !ociBlobNameRegexp.MatchString(name) {
+			continue
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return errors.Wrapf(err, "making dir for %s", name)
+		}
+		out, err := os.Create(dest)
+		if err != nil {
+			return errors.Wrapf(err, "creating %s", name)
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return errors.Wrapf(err, "extracting %s", name)
+		}
+		if err := out.Close(); err != nil {
+			return errors.Wrapf(err, "closing %s", name)
+		}
+	}
+}
+
+func readOCIJSON(path string, v interface{}) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
+
+var (
+	ociBlobNameRegexp = regexp.MustCompile(`^blobs/sha256/[a-f0-9]{64}$`)
+	ociDigestRegexp   = regexp.MustCompile(`^sha256:([a-f0-9]{64})$`)
+	ociTagRegexp      = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+)
+
+func ociDigestHex(digest string) (string, error) {
+	m := ociDigestRegexp.FindStringSubmatch(digest)
+	if m == nil {
+		return "", errors.Errorf("unsupported digest %s, expected sha256", digest)
+	}
+	return m[1], nil
+}
+
+// ociBlobPath is where the blob with digest is in the layout at dir.
+// Invalid digests get a path that doesn't exist.
+func ociBlobPath(dir, digest string) string {
+	hexDigest, err := ociDigestHex(digest)
+	if err != nil {
+		return filepath.Join(dir, "blobs", "invalid")
+	}
+	return filepath.Join(dir, "blobs", "sha256", hexDigest)
+}
+
+// ociTag makes version a valid tag, which allows letters, numbers,
+// and _.-, up to 128 characters, not starting with . or -.
+func ociTag(version string) string {
+	tag := ociTagRegexp.ReplaceAllString(version, "_")
+	if tag == "" || tag[0] == '.' || tag[0] == '-' {
+		tag = "v" + tag
+	}
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
+
+// mergeOCIEnv sets env's variables in the image's environment, of
+// KEY=value entries. Existing ones are replaced, and new ones are
+// added in order.
+func mergeOCIEnv(imageEnv []string, env map[string]string) []string {
+	merged := []string{}
+	for _, kv := range imageEnv {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if _, ok := env[key]; ok {
+			continue
+		}
+		merged = append(merged, kv)
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		merged = append(merged, fmt.Sprintf("%s=%s", k, env[k]))
+	}
+	return merged
+}
@@ -0,0 +1,194 @@
+package packagekit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readOCILayoutTar returns the files in an OCI layout tarball, by name.
+func readOCILayoutTar(t *testing.T, r io.Reader) map[string][]byte {
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = contents
+	}
+}
+
+// ociImage reads the image in layout, checking each blob matches its
+// digest.
+func ociImage(t *testing.T, files map[string][]byte) (ociIndex, ociManifest, ociImageConfig) {
+	for name, contents := range files {
+		if !strings.HasPrefix(name, "blobs/sha256/") {
+			continue
+		}
+		sum := sha256.Sum256(contents)
+		require.Equal(t, strings.TrimPrefix(name, "blobs/sha256/"), hex.EncodeToString(sum[:]), name)
+	}
+
+	blob := func(digest string) []byte {
+		hexDigest, err := ociDigestHex(digest)
+		require.NoError(t, err)
+		contents, ok := files["blobs/sha256/"+hexDigest]
+		require.True(t, ok, "missing blob %s", digest)
+		return contents
+	}
+
+	var index ociIndex
+	require.NoError(t, json.Unmarshal(files["index.json"], &index))
+	require.Len(t, index.Manifests, 1)
+
+	var manifest ociManifest
+	require.NoError(t, json.Unmarshal(blob(index.Manifests[0].Digest), &manifest))
+
+	var config ociImageConfig
+	require.NoError(t, json.Unmarshal(blob(manifest.Config.Digest), &config))
+
+	return index, manifest, config
+}
+
+func TestPackageOCI(t *testing.T) {
+	t.Parallel()
+
+	packageRoot, err := ioutil.TempDir("", "packaging-oci-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(packageRoot)
+
+	binDir := filepath.Join(packageRoot, "usr", "local", "kolide-app", "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "launcher"), []byte("launcher"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(packageRoot, "etc", "kolide-app"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(packageRoot, "etc", "kolide-app", "secret"), []byte("secret"), 0600))
+
+	po := &PackageOptions{
+		Name:            "launcher",
+		Identifier:      "kolide-app",
+		Root:            packageRoot,
+		Version:         "0.11.2+git",
+		Arch:            "arm64",
+		SourceDateEpoch: time.Unix(1600000000, 0),
+	}
+	service := &InitOptions{
+		Path:        "/usr/local/kolide-app/bin/launcher",
+		Flags:       []string{"--with_initial_runner"},
+		Environment: map[string]string{"KOLIDE_LAUNCHER_HOSTNAME": "device.example.com:443", "KOLIDE_LAUNCHER_ROOT_DIRECTORY": "/var/kolide-app"},
+	}
+
+	// Images need something to run
+	require.Error(t, PackageOCI(context.TODO(), ioutil.Discard, po))
+
+	var output bytes.Buffer
+	require.NoError(t, PackageOCI(context.TODO(), &output, po, WithOCIService(service)))
+
+	// Pinned timestamps make images reproducible
+	var again bytes.Buffer
+	require.NoError(t, PackageOCI(context.TODO(), &again, po, WithOCIService(service)))
+	require.Equal(t, output.Bytes(), again.Bytes())
+
+	files := readOCILayoutTar(t, bytes.NewReader(output.Bytes()))
+	require.JSONEq(t, `{"imageLayoutVersion":"1.0.0"}`, string(files["oci-layout"]))
+
+	index, manifest, config := ociImage(t, files)
+	require.Equal(t, "0.11.2_git", index.Manifests[0].Annotations["org.opencontainers.image.ref.name"])
+
+	require.Equal(t, "arm64", config.Architecture)
+	require.Equal(t, "linux", config.OS)
+	require.Equal(t, "2020-09-13T12:26:40Z", config.Created)
+	require.Equal(t, []string{"/usr/local/kolide-app/bin/launcher"}, config.Config.Entrypoint)
+	require.Equal(t, []string{"--with_initial_runner"}, config.Config.Cmd)
+	require.Equal(t, []string{"KOLIDE_LAUNCHER_HOSTNAME=device.example.com:443", "KOLIDE_LAUNCHER_ROOT_DIRECTORY=/var/kolide-app"}, config.Config.Env)
+	require.Equal(t, "launcher-kolide-app", config.Config.Labels["org.opencontainers.image.title"])
+
+	// From scratch, the package root is the only layer
+	require.Len(t, manifest.Layers, 1)
+	require.Len(t, config.RootFS.DiffIDs, 1)
+
+	layer, err := ociDigestHex(manifest.Layers[0].Digest)
+	require.NoError(t, err)
+	gzr, err := gzip.NewReader(bytes.NewReader(files["blobs/sha256/"+layer]))
+	require.NoError(t, err)
+	uncompressed, err := ioutil.ReadAll(gzr)
+	require.NoError(t, err)
+	sum := sha256.Sum256(uncompressed)
+	require.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), config.RootFS.DiffIDs[0])
+
+	layerFiles := readOCILayoutTar(t, bytes.NewReader(uncompressed))
+	require.Equal(t, "launcher", string(layerFiles["usr/local/kolide-app/bin/launcher"]))
+	require.Equal(t, "secret", string(layerFiles["etc/kolide-app/secret"]))
+}
+
+func TestPackageOCIBase(t *testing.T) {
+	t.Parallel()
+
+	baseRoot, err := ioutil.TempDir("", "packaging-oci-base")
+	require.NoError(t, err)
+	defer os.RemoveAll(baseRoot)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(baseRoot, "os-release"), []byte("base"), 0644))
+
+	packageRoot, err := ioutil.TempDir("", "packaging-oci-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(packageRoot)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(packageRoot, "launcher"), []byte("launcher"), 0755))
+
+	// The base is an image like any other
+	baseFH, err := ioutil.TempFile("", "packaging-oci-base")
+	require.NoError(t, err)
+	defer os.Remove(baseFH.Name())
+	require.NoError(t, PackageOCI(context.TODO(), baseFH, &PackageOptions{Name: "base", Identifier: "os", Root: baseRoot, Version: "1"}, WithOCIService(&InitOptions{
+		Path:        "/bin/sh",
+		Environment: map[string]string{"PATH": "/usr/bin:/bin", "KOLIDE_LAUNCHER_HOSTNAME": "base.example.com"},
+	})))
+	require.NoError(t, baseFH.Close())
+
+	po := &PackageOptions{Name: "launcher", Identifier: "kolide-app", Root: packageRoot, Version: "1.2.3"}
+	service := &InitOptions{Path: "/launcher", Environment: map[string]string{"KOLIDE_LAUNCHER_HOSTNAME": "device.example.com"}}
+
+	var output bytes.Buffer
+	require.NoError(t, PackageOCI(context.TODO(), &output, po, WithOCIService(service), WithOCIBase(baseFH.Name())))
+
+	_, manifest, config := ociImage(t, readOCILayoutTar(t, &output))
+	require.Len(t, manifest.Layers, 2)
+	require.Len(t, config.RootFS.DiffIDs, 2)
+	require.Len(t, config.History, 2)
+
+	// The base's environment is kept, but ours wins
+	require.Equal(t, []string{"PATH=/usr/bin:/bin", "KOLIDE_LAUNCHER_HOSTNAME=device.example.com"}, config.Config.Env)
+	require.Equal(t, []string{"/launcher"}, config.Config.Entrypoint)
+	require.Equal(t, "launcher-kolide-app", config.Config.Labels["org.opencontainers.image.title"])
+
+	// Bases have to be OCI layouts
+	notLayout := filepath.Join(packageRoot, "launcher")
+	require.Error(t, PackageOCI(context.TODO(), ioutil.Discard, po, WithOCIService(service), WithOCIBase(notLayout)))
+}
+
+func TestOCITag(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "1.2.3", ociTag("1.2.3"))
+	require.Equal(t, "0.11.2-3-gabc_dirty", ociTag("0.11.2-3-gabc+dirty"))
+	require.Equal(t, "v.1", ociTag(".1"))
+	require.Len(t, ociTag(strings.Repeat("1", 200)), 128)
+}
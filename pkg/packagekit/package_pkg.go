@@ -1,7 +1,6 @@
 package packagekit
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -9,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/launcher/pkg/contexts/ctxlog"
@@ -16,16 +16,41 @@ import (
 	"go.opencensus.io/trace"
 )
 
-func PackagePkg(ctx context.Context, w io.Writer, po *PackageOptions) error {
+type pkgOptions struct {
+	notarize *NotarizeOptions
+}
+
+type PkgOpt func(*pkgOptions)
+
+// WithNotarization submits the signed pkg to Apple's notary service,
+// and staples the ticket to it. The build fails if notarization is
+// rejected.
+func WithNotarization(n *NotarizeOptions) PkgOpt {
+	return func(p *pkgOptions) {
+		p.notarize = n
+	}
+}
+
+func PackagePkg(ctx context.Context, w io.Writer, po *PackageOptions, pkgOpts ...PkgOpt) error {
 	ctx, span := trace.StartSpan(ctx, "packagekit.PackagePkg")
 	defer span.End()
 
+	options := &pkgOptions{}
+	for _, opt := range pkgOpts {
+		opt(options)
+	}
+
 	logger := ctxlog.FromContext(ctx)
 
 	if err := isDirectory(po.Root); err != nil {
 		return err
 	}
 
+	// Apple only notarizes signed packages
+	if options.notarize != nil && po.SigningKey == "" {
+		return errors.New("notarization requires a signing key")
+	}
+
 	outputFilename := fmt.Sprintf("%s-%s.pkg", po.Name, po.Version)
 
 	outputPathDir, err := ioutil.TempDir("", "packaging-pkg-output")
@@ -58,14 +83,26 @@ func PackagePkg(ctx context.Context, w io.Writer, po *PackageOptions) error {
 	)
 
 	cmd := exec.CommandContext(ctx, "pkgbuild", args...)
+	if err := runTool(ctx, po, "pkgbuild", cmd); err != nil {
+		return errors.Wrap(err, "creating pkg package")
+	}
 
-	stderr := new(bytes.Buffer)
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return errors.Wrapf(err, "creating pkg package: %s", stderr)
+	// pkgbuild errors out on a bad key, but make sure. Shipping an
+	// unsigned package when a signed one was asked for is worse than
+	// failing.
+	if po.SigningKey != "" {
+		if err := verifyPkgSignature(ctx, outputPath); err != nil {
+			return &SigningError{errors.Wrap(err, "verifying package signature")}
+		}
+	}
+
+	if options.notarize != nil {
+		if err := notarizePkg(ctx, outputPath, options.notarize); err != nil {
+			return &SigningError{errors.Wrap(err, "notarizing package")}
+		}
 	}
 
-	outputFH, err := os.Open(filepath.Join(outputPathDir, outputFilename))
+	outputFH, err := os.Open(outputPath)
 	if err != nil {
 		return errors.Wrap(err, "opening resultant output file")
 	}
@@ -78,3 +115,34 @@ func PackagePkg(ctx context.Context, w io.Writer, po *PackageOptions) error {
 	return nil
 
 }
+
+// verifyPkgSignature checks that a package is signed, using pkgutil.
+func verifyPkgSignature(ctx context.Context, path string) error {
+	cmd := exec.CommandContext(ctx, "pkgutil", "--check-signature", path)
+
+	// pkgutil exits non-zero for unsigned packages, but the output
+	// is more informative, so check it regardless.
+	output, _ := cmd.CombinedOutput()
+	return checkPkgSignatureOutput(string(output))
+}
+
+// checkPkgSignatureOutput parses the output of pkgutil
+// --check-signature. Signed packages report a status of "signed",
+// possibly with further details (eg: "signed by a developer
+// certificate issued by Apple for distribution").
+func checkPkgSignatureOutput(output string) error {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "Status:") {
+			continue
+		}
+
+		status := strings.TrimSpace(strings.TrimPrefix(line, "Status:"))
+		if strings.HasPrefix(status, "signed") {
+			return nil
+		}
+		return errors.Errorf("package is not signed: %s", status)
+	}
+
+	return errors.Errorf("could not determine package signature status: %s", strings.TrimSpace(output))
+}
@@ -0,0 +1,54 @@
+package packagekit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPkgSignatureOutput(t *testing.T) {
+	t.Parallel()
+
+	signed := `Package "launcher.pkg":
+   Status: signed by a developer certificate issued by Apple for distribution
+   Signed with a trusted timestamp on: 2018-12-10 18:39:44 +0000
+   Certificate Chain:
+    1. Developer ID Installer: Kolide Inc (YZ3EM74M78)
+`
+	require.NoError(t, checkPkgSignatureOutput(signed))
+
+	unsigned := `Package "launcher.pkg":
+   Status: no signature
+`
+	err := checkPkgSignatureOutput(unsigned)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no signature")
+
+	require.Error(t, checkPkgSignatureOutput("pkgutil: command not found"))
+}
+
+func TestParseNotarySubmission(t *testing.T) {
+	t.Parallel()
+
+	submission, err := parseNotarySubmission([]byte(`{"id":"2efe2717-52ef-43a5-96dc-0797e4ca1041","status":"Invalid","message":"Processing complete"}`))
+	require.NoError(t, err)
+	require.Equal(t, "2efe2717-52ef-43a5-96dc-0797e4ca1041", submission.ID)
+	require.Equal(t, "Invalid", submission.Status)
+
+	_, err = parseNotarySubmission([]byte(`Error: HTTP status code: 401. Unable to authenticate.`))
+	require.Error(t, err)
+
+	_, err = parseNotarySubmission([]byte(`{"message":"Processing complete"}`))
+	require.Error(t, err)
+}
+
+func TestNotarizeOptionsValidate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, (&NotarizeOptions{KeychainProfile: "launcher"}).Validate())
+	require.NoError(t, (&NotarizeOptions{AppleID: "dev@example.com", TeamID: "YZ3EM74M78", AppPassword: "abcd-efgh"}).Validate())
+
+	require.Error(t, (&NotarizeOptions{}).Validate())
+	require.Error(t, (&NotarizeOptions{AppleID: "dev@example.com", TeamID: "YZ3EM74M78"}).Validate())
+	require.Error(t, (&NotarizeOptions{KeychainProfile: "launcher", AppleID: "dev@example.com"}).Validate())
+}
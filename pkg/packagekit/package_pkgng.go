@@ -0,0 +1,253 @@
+package packagekit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// freebsdManifest is the subset of the pkgng manifest we
+// need. Manifests are UCL, which is a superset of json. See
+// https://github.com/freebsd/pkg/blob/master/docs/pkg-create.8
+type freebsdManifest struct {
+	Name        string            `json:"name"`
+	Origin      string            `json:"origin"`
+	Version     string            `json:"version"`
+	Comment     string            `json:"comment"`
+	Desc        string            `json:"desc"`
+	Maintainer  string            `json:"maintainer"`
+	WWW         string            `json:"www"`
+	Licenses    []string          `json:"licenses"`
+	ABI         string            `json:"abi"`
+	Prefix      string            `json:"prefix"`
+	FlatSize    int64             `json:"flatsize"`
+	Files       map[string]string `json:"files,omitempty"`
+	Directories map[string]string `json:"directories,omitempty"`
+	Scripts     map[string]string `json:"scripts,omitempty"`
+}
+
+// PackageFreeBSD creates a FreeBSD pkgng package. This is a
+// compressed tarball, with the manifest as the first entries, followed
+// by the package root. The preinstall, postinstall, and prerm scripts
+// are embedded in the manifest.
+func PackageFreeBSD(ctx context.Context, w io.Writer, po *PackageOptions) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.PackageFreeBSD")
+	defer span.End()
+
+	if err := isDirectory(po.Root); err != nil {
+		return err
+	}
+
+	manifest := freebsdManifest{
+		Name:        fmt.Sprintf("%s-%s", po.Name, po.Identifier),
+		Origin:      fmt.Sprintf("sysutils/%s-%s", po.Name, po.Identifier),
+		Version:     freebsdVersion(po.Version),
+		Comment:     po.description(),
+		Desc:        po.description(),
+		Maintainer:  po.maintainer(),
+		WWW:         po.homepage(),
+		Licenses:    []string{po.license()},
+		ABI:         fmt.Sprintf("FreeBSD:*:%s", freebsdArch(po.Arch)),
+		Prefix:      "/",
+		Files:       make(map[string]string),
+		Directories: make(map[string]string),
+		Scripts:     make(map[string]string),
+	}
+
+	// Everything in the package root is listed, with its sha256. Only
+	// the directories specific to this package are owned by it, so
+	// shared ones like /usr/local aren't removed on deinstall.
+	if err := filepath.Walk(po.Root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		installPath, err := freebsdInstallPath(po.Root, path)
+		if err != nil || installPath == "/" {
+			return err
+		}
+
+		switch {
+		case fi.IsDir():
+			if strings.Contains(installPath, po.Identifier) {
+				manifest.Directories[installPath+"/"] = "n"
+			}
+		case fi.Mode().IsRegular():
+			sum, err := sha256File(path)
+			if err != nil {
+				return err
+			}
+			manifest.Files[installPath] = "1$" + sum
+			manifest.FlatSize += fi.Size()
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "building manifest")
+	}
+
+	for script, name := range map[string]string{"preinstall": "pre-install", "postinstall": "post-install", "prerm": "pre-deinstall"} {
+		contents, err := ioutil.ReadFile(filepath.Join(po.Scripts, script))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "reading %s script", script)
+		}
+		manifest.Scripts[name] = string(contents)
+	}
+
+	return writeFreeBSDPkg(w, po, manifest)
+}
+
+func writeFreeBSDPkg(w io.Writer, po *PackageOptions, manifest freebsdManifest) error {
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+
+	// The compact manifest is the same, without the file lists
+	compact := manifest
+	compact.Files, compact.Directories, compact.Scripts = nil, nil, nil
+
+	for _, entry := range []struct {
+		name     string
+		manifest freebsdManifest
+	}{
+		{name: "+COMPACT_MANIFEST", manifest: compact},
+		{name: "+MANIFEST", manifest: manifest},
+	} {
+		contents, err := json.Marshal(entry.manifest)
+		if err != nil {
+			return errors.Wrapf(err, "encoding %s", entry.name)
+		}
+
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    0644,
+			Size:    int64(len(contents)),
+			ModTime: po.SourceDateEpoch,
+			Uname:   "root",
+			Gname:   "wheel",
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "writing %s header", entry.name)
+		}
+		if _, err := io.Copy(tw, bytes.NewReader(contents)); err != nil {
+			return errors.Wrapf(err, "writing %s", entry.name)
+		}
+	}
+
+	if err := filepath.Walk(po.Root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		installPath, err := freebsdInstallPath(po.Root, path)
+		if err != nil || installPath == "/" {
+			return err
+		}
+
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return errors.Errorf("unsupported file type for %s", path)
+		}
+
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return errors.Wrapf(err, "tar header for %s", path)
+		}
+		header.Name = installPath
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "root", "wheel"
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+		if !po.SourceDateEpoch.IsZero() {
+			header.ModTime = po.SourceDateEpoch
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "writing tar header for %s", path)
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		fh, err := os.Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "opening %s", path)
+		}
+		defer fh.Close()
+
+		if _, err := io.Copy(tw, fh); err != nil {
+			return errors.Wrapf(err, "copying %s into package", path)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "creating freebsd package")
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "closing tar")
+	}
+
+	if err := gzw.Close(); err != nil {
+		return errors.Wrap(err, "closing gzip")
+	}
+
+	return nil
+}
+
+// freebsdInstallPath returns the absolute path a file in the package
+// root is installed to.
+func freebsdInstallPath(root, path string) (string, error) {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "relative path for %s", path)
+	}
+	if relPath == "." {
+		return "/", nil
+	}
+	return "/" + filepath.ToSlash(relPath), nil
+}
+
+func sha256File(path string) (string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %s", path)
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return "", errors.Wrapf(err, "hashing %s", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// freebsdVersion converts a version into one pkg accepts. Hyphens
+// separate the name and version, so they can't appear in it.
+func freebsdVersion(version string) string {
+	return strings.Replace(version, "-", ".", -1)
+}
+
+// freebsdArch converts go's architecture names into FreeBSD's.
+func freebsdArch(arch string) string {
+	switch arch {
+	case "", "amd64":
+		return "amd64"
+	case "arm64":
+		return "aarch64"
+	}
+	return arch
+}
@@ -0,0 +1,89 @@
+package packagekit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageFreeBSD(t *testing.T) {
+	t.Parallel()
+
+	packageRoot, err := ioutil.TempDir("", "packaging-freebsd-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(packageRoot)
+
+	scriptRoot, err := ioutil.TempDir("", "packaging-freebsd-scripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(scriptRoot)
+
+	binDir := filepath.Join(packageRoot, "usr", "local", "kolide-app", "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "launcher"), []byte("launcher"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(scriptRoot, "postinstall"), []byte("#!/bin/sh\necho hi"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(scriptRoot, "preinstall"), []byte("#!/bin/sh\necho before"), 0755))
+
+	po := &PackageOptions{
+		Name:       "launcher",
+		Identifier: "kolide-app",
+		Root:       packageRoot,
+		Scripts:    scriptRoot,
+		Version:    "0.5.6-19-g17c8589",
+		Arch:       "arm64",
+		Vendor:     "Example Co",
+	}
+
+	var output bytes.Buffer
+	require.NoError(t, PackageFreeBSD(context.TODO(), &output, po))
+
+	gzr, err := gzip.NewReader(&output)
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+
+	names := []string{}
+	contents := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+
+		b, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		contents[header.Name] = b
+	}
+
+	// pkg expects the manifests first
+	require.Equal(t, []string{"+COMPACT_MANIFEST", "+MANIFEST"}, names[:2])
+	require.Equal(t, "launcher", string(contents["/usr/local/kolide-app/bin/launcher"]))
+
+	var manifest freebsdManifest
+	require.NoError(t, json.Unmarshal(contents["+MANIFEST"], &manifest))
+	require.Equal(t, "launcher-kolide-app", manifest.Name)
+	require.Equal(t, "0.5.6.19.g17c8589", manifest.Version)
+	require.Equal(t, "FreeBSD:*:aarch64", manifest.ABI)
+	require.Equal(t, "Example Co", manifest.Maintainer)
+	require.Equal(t, "The Kolide Launcher, packaged for kolide-app", manifest.Desc)
+	require.Equal(t, []string{"MIT"}, manifest.Licenses)
+	require.Equal(t, int64(len("launcher")), manifest.FlatSize)
+	require.Contains(t, manifest.Files, "/usr/local/kolide-app/bin/launcher")
+	require.Contains(t, manifest.Directories, "/usr/local/kolide-app/")
+	require.NotContains(t, manifest.Directories, "/usr/local/")
+	require.Equal(t, "#!/bin/sh\necho hi", manifest.Scripts["post-install"])
+	require.Equal(t, "#!/bin/sh\necho before", manifest.Scripts["pre-install"])
+
+	var compact freebsdManifest
+	require.NoError(t, json.Unmarshal(contents["+COMPACT_MANIFEST"], &compact))
+	require.Empty(t, compact.Files)
+}
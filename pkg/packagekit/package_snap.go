@@ -0,0 +1,274 @@
+package packagekit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// snapcraftYAML is the subset of snapcraft.yaml we need. See
+// https://snapcraft.io/docs/snapcraft-yaml-reference
+type snapcraftYAML struct {
+	Name          string              `json:"name"`
+	Base          string              `json:"base"`
+	Version       string              `json:"version"`
+	Summary       string              `json:"summary"`
+	Description   string              `json:"description"`
+	License       string              `json:"license,omitempty"`
+	Grade         string              `json:"grade"`
+	Confinement   string              `json:"confinement"`
+	Architectures []snapArchitecture  `json:"architectures"`
+	Parts         map[string]snapPart `json:"parts"`
+	Apps          map[string]snapApp  `json:"apps"`
+}
+
+type snapArchitecture struct {
+	BuildOn  []string `json:"build-on"`
+	BuildFor []string `json:"build-for"`
+}
+
+type snapPart struct {
+	Plugin string `json:"plugin"`
+	Source string `json:"source"`
+}
+
+type snapApp struct {
+	Command          string            `json:"command"`
+	Daemon           string            `json:"daemon"`
+	RestartCondition string            `json:"restart-condition,omitempty"`
+	RestartDelay     string            `json:"restart-delay,omitempty"`
+	InstallMode      string            `json:"install-mode,omitempty"`
+	Environment      map[string]string `json:"environment,omitempty"`
+}
+
+// snapBase is the runtime snaps are built against. The package root
+// holds prebuilt binaries, so it only matters to snapcraft.
+const snapBase = "core22"
+
+type snapOptions struct {
+	service      *InitOptions
+	restart      string
+	restartDelay int
+	noStart      bool
+}
+
+type SnapOpt func(*snapOptions)
+
+// WithSnapService makes the file at initOptions.Path (relative to the
+// package root) the snap's daemon. snapd runs it as a systemd
+// service, with the environment and flags.
+func WithSnapService(initOptions *InitOptions) SnapOpt {
+	return func(s *snapOptions) {
+		s.service = initOptions
+	}
+}
+
+// WithSnapRestart sets when snapd restarts the daemon, as a systemd
+// Restart= policy, and how many seconds it waits first. snapd's
+// defaults are used for empty, or zero, values.
+func WithSnapRestart(policy string, delaySec int) SnapOpt {
+	return func(s *snapOptions) {
+		s.restart = policy
+		s.restartDelay = delaySec
+	}
+}
+
+// WithoutSnapStart installs the daemon disabled, so it doesn't start
+// until it's enabled with snap start --enable.
+func WithoutSnapStart() SnapOpt {
+	return func(s *snapOptions) {
+		s.noStart = true
+	}
+}
+
+// snapNameRegexp matches valid snap names: lowercase letters, numbers,
+// and single hyphens between them.
+var snapNameRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidateSnapName checks name can be used as a snap's name.
+func ValidateSnapName(name string) error {
+	if len(name) > 40 || !snapNameRegexp.MatchString(name) || !strings.ContainsAny(name, "abcdefghijklmnopqrstuvwxyz") {
+		return errors.Errorf("invalid snap name %q. Snap names are up to 40 lowercase letters, numbers, and single hyphens, with at least one letter", name)
+	}
+	return nil
+}
+
+// snapVersionRegexp matches valid snap versions.
+var snapVersionRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9:.+~-]{0,30}[a-zA-Z0-9+~])?$`)
+
+// PackageSnap creates a snap from the package root, using snapcraft
+// on the build host. The root is dumped into the snap as is, and the
+// service, from WithSnapService, becomes its daemon. launcher needs to
+// see the whole host, so the snap uses classic confinement.
+func PackageSnap(ctx context.Context, w io.Writer, po *PackageOptions, snapOpts ...SnapOpt) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.PackageSnap")
+	defer span.End()
+
+	options := &snapOptions{}
+	for _, opt := range snapOpts {
+		opt(options)
+	}
+
+	logger := ctxlog.FromContext(ctx)
+
+	if err := isDirectory(po.Root); err != nil {
+		return err
+	}
+
+	if options.service == nil {
+		return errors.New("snaps need a service to run")
+	}
+
+	if _, err := exec.LookPath("snapcraft"); err != nil {
+		return errors.New("snapcraft is needed to build snaps, but isn't in the PATH. See https://snapcraft.io/docs/snapcraft-overview")
+	}
+
+	workDir, err := ioutil.TempDir("", "packaging-snap")
+	if err != nil {
+		return errors.Wrap(err, "making TempDir")
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := os.MkdirAll(filepath.Join(workDir, "snap"), 0755); err != nil {
+		return errors.Wrap(err, "making snap dir")
+	}
+
+	snapcraftFH, err := os.Create(filepath.Join(workDir, "snap", "snapcraft.yaml"))
+	if err != nil {
+		return errors.Wrap(err, "create snapcraft.yaml")
+	}
+	defer snapcraftFH.Close()
+
+	if err := renderSnapcraft(snapcraftFH, po, options); err != nil {
+		return errors.Wrap(err, "rendering snapcraft.yaml")
+	}
+	snapcraftFH.Close()
+
+	outputPath := filepath.Join(workDir, fmt.Sprintf("%s-%s.snap", po.Name, po.Version))
+
+	// Destructive mode builds on this host, rather than in a VM. The
+	// root is all prebuilt, so there's nothing to isolate.
+	args := []string{"pack", "--destructive-mode", "--output", outputPath}
+
+	level.Debug(logger).Log(
+		"msg", "Running snapcraft",
+		"args", fmt.Sprintf("%v", args),
+	)
+
+	cmd := exec.CommandContext(ctx, "snapcraft", args...)
+	cmd.Dir = workDir
+	if !po.SourceDateEpoch.IsZero() {
+		if err := pinMtimes(po.SourceDateEpoch, po.Root); err != nil {
+			return errors.Wrap(err, "pinning mtimes")
+		}
+		cmd.Env = append(os.Environ(), fmt.Sprintf("SOURCE_DATE_EPOCH=%d", po.SourceDateEpoch.Unix()))
+	}
+	if err := runTool(ctx, po, "snapcraft", cmd); err != nil {
+		return errors.Wrap(err, "creating snap package")
+	}
+
+	outputFH, err := os.Open(outputPath)
+	if err != nil {
+		return errors.Wrap(err, "opening resultant output file")
+	}
+	defer outputFH.Close()
+
+	if _, err := io.Copy(w, outputFH); err != nil {
+		return errors.Wrap(err, "copying output")
+	}
+
+	return nil
+}
+
+// renderSnapcraft writes the snapcraft.yaml for the package root.
+func renderSnapcraft(w io.Writer, po *PackageOptions, options *snapOptions) error {
+	name := fmt.Sprintf("%s-%s", po.Name, po.Identifier)
+	if err := ValidateSnapName(name); err != nil {
+		return err
+	}
+	if !snapVersionRegexp.MatchString(po.Version) {
+		return errors.Errorf("invalid snap version %q. Snap versions are up to 32 letters, numbers, and :.+~-", po.Version)
+	}
+
+	root, err := filepath.Abs(po.Root)
+	if err != nil {
+		return errors.Wrap(err, "resolving package root")
+	}
+
+	// The command is split on whitespace, and run relative to $SNAP
+	service := options.service
+	command := append([]string{strings.TrimPrefix(filepath.ToSlash(service.Path), "/")}, service.Flags...)
+
+	app := snapApp{
+		Command:     strings.Join(command, " "),
+		Daemon:      "simple",
+		Environment: service.Environment,
+	}
+	switch options.restart {
+	case "":
+	case "no":
+		app.RestartCondition = "never"
+	default:
+		app.RestartCondition = options.restart
+	}
+	if options.restartDelay > 0 {
+		app.RestartDelay = fmt.Sprintf("%ds", options.restartDelay)
+	}
+	if options.noStart {
+		app.InstallMode = "disable"
+	}
+
+	// Summaries are limited to 78 characters
+	summary := po.description()
+	if len(summary) > 78 {
+		summary = summary[:75] + "..."
+	}
+
+	snapcraft := snapcraftYAML{
+		Name:        name,
+		Base:        snapBase,
+		Version:     po.Version,
+		Summary:     summary,
+		Description: po.description(),
+		License:     po.license(),
+		Grade:       "stable",
+		Confinement: "classic",
+		Architectures: []snapArchitecture{
+			{BuildOn: []string{"amd64", "arm64"}, BuildFor: []string{snapArch(po.Arch)}},
+		},
+		Parts: map[string]snapPart{
+			"launcher": {Plugin: "dump", Source: root},
+		},
+		Apps: map[string]snapApp{
+			po.Name: app,
+		},
+	}
+
+	contents, err := yaml.Marshal(snapcraft)
+	if err != nil {
+		return errors.Wrap(err, "encoding snapcraft.yaml")
+	}
+	_, err = w.Write(contents)
+	return err
+}
+
+// snapArch returns the snap architecture for a go one. They're the
+// same for the architectures we build, and amd64 is the default.
+func snapArch(arch string) string {
+	if arch == "" {
+		return "amd64"
+	}
+	return arch
+}
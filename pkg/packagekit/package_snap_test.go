@@ -0,0 +1,65 @@
+package packagekit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderSnapcraft(t *testing.T) {
+	t.Parallel()
+
+	po := &PackageOptions{
+		Name:       "launcher",
+		Identifier: "kolide-app",
+		Root:       "/tmp/package-root",
+		Version:    "0.11.4",
+		Arch:       "arm64",
+	}
+	service := &InitOptions{
+		Path:        "/usr/local/kolide-app/bin/launcher",
+		Flags:       []string{"--debug", "--log_level=info"},
+		Environment: map[string]string{"KOLIDE_LAUNCHER_ROOT_DIRECTORY": "$SNAP_COMMON/device.example.com-443"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, renderSnapcraft(&buf, po, &snapOptions{service: service, restart: "no", restartDelay: 5, noStart: true}))
+
+	var snapcraft snapcraftYAML
+	require.NoError(t, yaml.Unmarshal(buf.Bytes(), &snapcraft))
+	require.Equal(t, "launcher-kolide-app", snapcraft.Name)
+	require.Equal(t, "0.11.4", snapcraft.Version)
+	require.Equal(t, "classic", snapcraft.Confinement)
+	require.Equal(t, []string{"arm64"}, snapcraft.Architectures[0].BuildFor)
+	require.Equal(t, snapPart{Plugin: "dump", Source: "/tmp/package-root"}, snapcraft.Parts["launcher"])
+
+	app := snapcraft.Apps["launcher"]
+	require.Equal(t, "usr/local/kolide-app/bin/launcher --debug --log_level=info", app.Command)
+	require.Equal(t, "simple", app.Daemon)
+	require.Equal(t, "never", app.RestartCondition)
+	require.Equal(t, "5s", app.RestartDelay)
+	require.Equal(t, "disable", app.InstallMode)
+	require.Equal(t, service.Environment, app.Environment)
+
+	// snapd's defaults are left alone
+	buf.Reset()
+	require.NoError(t, renderSnapcraft(&buf, po, &snapOptions{service: service}))
+	require.NotContains(t, buf.String(), "restart-")
+	require.NotContains(t, buf.String(), "install-mode")
+
+	po.Version = "0.11.4-3-gabcdef0-dirty-with-a-very-long-suffix"
+	require.Error(t, renderSnapcraft(&buf, po, &snapOptions{service: service}))
+}
+
+func TestValidateSnapName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"launcher-kolide-app", "launcher-acme2"} {
+		require.NoError(t, ValidateSnapName(name), name)
+	}
+	for _, name := range []string{"launcher-kolide.app", "launcher-kolide_app", "launcher--app", "Launcher", "launcher-", "123", "launcher-a-very-long-identifier-for-a-snap"} {
+		require.Error(t, ValidateSnapName(name), name)
+	}
+}
@@ -0,0 +1,100 @@
+package packagekit
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// PackageTar creates a gzipped tarball of the package root. It's
+// meant to be extracted into /, for hosts without a package
+// manager. Packaging scripts are not included.
+func PackageTar(ctx context.Context, w io.Writer, po *PackageOptions) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.PackageTar")
+	defer span.End()
+
+	if err := isDirectory(po.Root); err != nil {
+		return err
+	}
+
+	// gzip headers only have a timestamp if one is set, and Walk is in
+	// lexical order. So the mtimes are all that vary between builds.
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+
+	if err := filepath.Walk(po.Root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(po.Root, path)
+		if err != nil {
+			return errors.Wrapf(err, "relative path for %s", path)
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return errors.Wrapf(err, "reading link %s", path)
+			}
+		}
+
+		header, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return errors.Wrapf(err, "tar header for %s", path)
+		}
+
+		// Everything is installed as root, regardless of who built it
+		header.Name = filepath.ToSlash(relPath)
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "root", "root"
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+		if !po.SourceDateEpoch.IsZero() {
+			header.ModTime = po.SourceDateEpoch
+			header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "writing tar header for %s", path)
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		fh, err := os.Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "opening %s", path)
+		}
+		defer fh.Close()
+
+		if _, err := io.Copy(tw, fh); err != nil {
+			return errors.Wrapf(err, "copying %s into tar", path)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "creating tar package")
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "closing tar")
+	}
+
+	if err := gzw.Close(); err != nil {
+		return errors.Wrap(err, "closing gzip")
+	}
+
+	return nil
+}
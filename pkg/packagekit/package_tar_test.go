@@ -0,0 +1,110 @@
+package packagekit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageTar(t *testing.T) {
+	t.Parallel()
+
+	packageRoot, err := ioutil.TempDir("", "packaging-tar-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(packageRoot)
+
+	binDir := filepath.Join(packageRoot, "usr", "local", "kolide-app", "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "launcher"), []byte("launcher"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(packageRoot, "etc", "kolide-app"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(packageRoot, "etc", "kolide-app", "secret"), []byte("secret"), 0600))
+
+	po := &PackageOptions{
+		Name:       "launcher",
+		Identifier: "kolide-app",
+		Root:       packageRoot,
+		Version:    "0.0.0",
+	}
+
+	var output bytes.Buffer
+	require.NoError(t, PackageTar(context.TODO(), &output, po))
+
+	gzr, err := gzip.NewReader(&output)
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+
+	files := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		files[header.Name] = header
+
+		b, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		contents[header.Name] = string(b)
+	}
+
+	require.Contains(t, files, "usr/")
+	require.Contains(t, files, "usr/local/kolide-app/bin/")
+	require.Equal(t, "launcher", contents["usr/local/kolide-app/bin/launcher"])
+	require.Equal(t, int64(0755), files["usr/local/kolide-app/bin/launcher"].Mode&0777)
+	require.Equal(t, "secret", contents["etc/kolide-app/secret"])
+	require.Equal(t, int64(0600), files["etc/kolide-app/secret"].Mode&0777)
+	require.Equal(t, 0, files["etc/kolide-app/secret"].Uid)
+}
+
+func TestPackageTarSourceDateEpoch(t *testing.T) {
+	t.Parallel()
+
+	epoch := time.Unix(1500000000, 0)
+
+	build := func(mtime time.Time) []byte {
+		packageRoot, err := ioutil.TempDir("", "packaging-tar-epoch")
+		require.NoError(t, err)
+		defer os.RemoveAll(packageRoot)
+
+		for _, name := range []string{"b", "a", "c/d"} {
+			path := filepath.Join(packageRoot, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, ioutil.WriteFile(path, []byte(name), 0644))
+			require.NoError(t, os.Chtimes(path, mtime, mtime))
+		}
+
+		po := &PackageOptions{
+			Name:            "launcher",
+			Identifier:      "kolide-app",
+			Root:            packageRoot,
+			Version:         "0.0.0",
+			SourceDateEpoch: epoch,
+		}
+
+		var output bytes.Buffer
+		require.NoError(t, PackageTar(context.TODO(), &output, po))
+		return output.Bytes()
+	}
+
+	first := build(time.Now())
+	second := build(time.Now().Add(-time.Hour))
+	require.Equal(t, first, second)
+
+	gzr, err := gzip.NewReader(bytes.NewReader(first))
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+	header, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "a", header.Name)
+	require.True(t, epoch.Equal(header.ModTime))
+}
@@ -3,6 +3,10 @@ package packagekit
 import (
 	"context"
 	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/kolide/kit/env"
@@ -41,3 +45,52 @@ func TestPackageTrivial(t *testing.T) {
 	require.NoError(t, err)
 
 }
+
+func TestPackageFPMOwners(t *testing.T) {
+	t.Parallel()
+	// Like TestPackageTrivial, this needs docker, and rpm to query the
+	// package.
+	if !env.Bool("CI_TEST_PACKAGING", false) {
+		t.Skip("No packaging tools")
+	}
+
+	inputDir, err := ioutil.TempDir("", "packaging-input")
+	require.NoError(t, err)
+	defer os.RemoveAll(inputDir)
+
+	confDir := filepath.Join(inputDir, "etc", "test-owners")
+	require.NoError(t, os.MkdirAll(confDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(confDir, "secret"), []byte("secret"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(confDir, "launcher.flags"), []byte(""), 0644))
+
+	po := &PackageOptions{
+		Name:       "test-owners",
+		Identifier: "test",
+		Version:    "0.0.0",
+		Root:       inputDir,
+	}
+
+	rpmFile, err := ioutil.TempFile("", "packaging-owners-*.rpm")
+	require.NoError(t, err)
+	defer os.Remove(rpmFile.Name())
+
+	owners := []FileOwner{
+		{Path: "/etc/test-owners", User: "kolide", Group: "kolide", Dir: true},
+		{Path: "/etc/test-owners/secret", User: "kolide", Group: "kolide"},
+	}
+	require.NoError(t, PackageFPM(context.TODO(), rpmFile, po, AsRPM(), WithOwners(owners)))
+	require.NoError(t, rpmFile.Close())
+
+	out, err := exec.Command("rpm", "-qp", "--queryformat", "[%{FILENAMES} %{FILEUSERNAME}:%{FILEGROUPNAME}\n]", rpmFile.Name()).Output()
+	require.NoError(t, err)
+
+	declared := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		require.Len(t, fields, 2, line)
+		declared[fields[0]] = fields[1]
+	}
+	require.Equal(t, "kolide:kolide", declared["/etc/test-owners"])
+	require.Equal(t, "kolide:kolide", declared["/etc/test-owners/secret"])
+	require.Equal(t, "root:root", declared["/etc/test-owners/launcher.flags"])
+}
@@ -0,0 +1,398 @@
+package packagekit
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// wixNamespace is used to generate stable UpgradeCodes. It's an
+// arbitrary, but fixed, uuid.
+var wixNamespace = uuid.MustParse("2e3c1ca4-cf93-44a7-a6ab-c36ae677bf1b")
+
+type wixOptions struct {
+	services []*InitOptions
+	noStart  bool
+}
+
+type WixOpt func(*wixOptions)
+
+// WithService registers the file at initOptions.Path (relative to
+// the package root) as a windows service. The environment is set via
+// the service's registry key, and the flags are passed as arguments.
+func WithService(initOptions *InitOptions) WixOpt {
+	return func(w *wixOptions) {
+		w.services = append(w.services, initOptions)
+	}
+}
+
+// WithoutServiceStart installs services as manual start, and doesn't
+// start them on install.
+func WithoutServiceStart() WixOpt {
+	return func(w *wixOptions) {
+		w.noStart = true
+	}
+}
+
+// PackageWixMSI creates an MSI from the package root using the WiX
+// toolset. As WiX is windows software, this runs it under wine
+// inside a docker container.
+func PackageWixMSI(ctx context.Context, w io.Writer, po *PackageOptions, wixOpts ...WixOpt) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.PackageWixMSI")
+	defer span.End()
+
+	if err := isDirectory(po.Root); err != nil {
+		return err
+	}
+
+	outputFilename := fmt.Sprintf("%s-%s.msi", po.Name, po.Version)
+
+	outputPathDir, err := ioutil.TempDir("/tmp", "packaging-msi-output")
+	if err != nil {
+		return errors.Wrap(err, "making TempDir")
+	}
+	defer os.RemoveAll(outputPathDir)
+
+	wxsFH, err := os.Create(filepath.Join(outputPathDir, "launcher.wxs"))
+	if err != nil {
+		return errors.Wrap(err, "create wxs file")
+	}
+	defer wxsFH.Close()
+
+	if err := renderWixProduct(ctx, wxsFH, po, wixOpts...); err != nil {
+		return errors.Wrap(err, "rendering wxs")
+	}
+	wxsFH.Close()
+
+	dockerArgs := []string{
+		"run", "--rm",
+		"-v", fmt.Sprintf("%s:/pkgsrc", po.Root),
+		"-v", fmt.Sprintf("%s:/out", outputPathDir),
+		"-w", "/out",
+	}
+	dockerArgs = append(dockerArgs, dockerNetworkArgs(po)...)
+	dockerArgs = append(dockerArgs, "felfert/wix")
+
+	wixCommands := [][]string{
+		{"candle", "-nologo", "-arch", "x64", "-out", "/out/launcher.wixobj", "/out/launcher.wxs"},
+		{"light", "-nologo", "-b", "/pkgsrc", "-out", filepath.Join("/out", outputFilename), "/out/launcher.wixobj"},
+	}
+
+	for _, wixCommand := range wixCommands {
+		cmd := exec.CommandContext(ctx, "docker", append(dockerArgs, wixCommand...)...)
+		if err := runTool(ctx, po, wixCommand[0], cmd); err != nil {
+			return err
+		}
+	}
+
+	outputFH, err := os.Open(filepath.Join(outputPathDir, outputFilename))
+	if err != nil {
+		return errors.Wrap(err, "opening resultant output file")
+	}
+	defer outputFH.Close()
+
+	if _, err := io.Copy(w, outputFH); err != nil {
+		return errors.Wrap(err, "copying output")
+	}
+
+	return nil
+}
+
+// The following structs model the subset of the WiX schema we
+// need. See http://wixtoolset.org/documentation/manual/v3/xsd/wix/
+type wixDocument struct {
+	XMLName xml.Name   `xml:"Wix"`
+	XMLNS   string     `xml:"xmlns,attr"`
+	Product wixProduct `xml:"Product"`
+}
+
+type wixProduct struct {
+	Id            string           `xml:"Id,attr"`
+	Name          string           `xml:"Name,attr"`
+	Language      string           `xml:"Language,attr"`
+	Version       string           `xml:"Version,attr"`
+	Manufacturer  string           `xml:"Manufacturer,attr"`
+	UpgradeCode   string           `xml:"UpgradeCode,attr"`
+	Package       wixPackage       `xml:"Package"`
+	MajorUpgrade  wixMajorUpgrade  `xml:"MajorUpgrade"`
+	MediaTemplate wixMediaTemplate `xml:"MediaTemplate"`
+	Directory     wixDirectory     `xml:"Directory"`
+	Feature       wixFeature       `xml:"Feature"`
+}
+
+type wixPackage struct {
+	InstallerVersion string `xml:"InstallerVersion,attr"`
+	Compressed       string `xml:"Compressed,attr"`
+	InstallScope     string `xml:"InstallScope,attr"`
+}
+
+type wixMajorUpgrade struct {
+	DowngradeErrorMessage string `xml:"DowngradeErrorMessage,attr"`
+}
+
+type wixMediaTemplate struct {
+	EmbedCab string `xml:"EmbedCab,attr"`
+}
+
+type wixDirectory struct {
+	Id          string          `xml:"Id,attr"`
+	Name        string          `xml:"Name,attr,omitempty"`
+	Directories []*wixDirectory `xml:"Directory"`
+	Components  []*wixComponent `xml:"Component"`
+}
+
+type wixComponent struct {
+	Id             string             `xml:"Id,attr"`
+	Guid           string             `xml:"Guid,attr"`
+	Win64          string             `xml:"Win64,attr"`
+	File           wixFile            `xml:"File"`
+	ServiceInstall *wixServiceInstall `xml:"ServiceInstall,omitempty"`
+	ServiceControl *wixServiceControl `xml:"ServiceControl,omitempty"`
+	RegistryValue  *wixRegistryValue  `xml:"RegistryValue,omitempty"`
+}
+
+type wixFile struct {
+	Id      string `xml:"Id,attr"`
+	Source  string `xml:"Source,attr"`
+	KeyPath string `xml:"KeyPath,attr"`
+}
+
+type wixServiceInstall struct {
+	Id           string `xml:"Id,attr"`
+	Name         string `xml:"Name,attr"`
+	DisplayName  string `xml:"DisplayName,attr"`
+	Description  string `xml:"Description,attr"`
+	Type         string `xml:"Type,attr"`
+	Start        string `xml:"Start,attr"`
+	ErrorControl string `xml:"ErrorControl,attr"`
+	Arguments    string `xml:"Arguments,attr,omitempty"`
+}
+
+type wixServiceControl struct {
+	Id     string `xml:"Id,attr"`
+	Name   string `xml:"Name,attr"`
+	Start  string `xml:"Start,attr,omitempty"`
+	Stop   string `xml:"Stop,attr"`
+	Remove string `xml:"Remove,attr"`
+	Wait   string `xml:"Wait,attr"`
+}
+
+type wixRegistryValue struct {
+	Root        string   `xml:"Root,attr"`
+	Key         string   `xml:"Key,attr"`
+	Name        string   `xml:"Name,attr"`
+	Type        string   `xml:"Type,attr"`
+	Action      string   `xml:"Action,attr"`
+	MultiString []string `xml:"MultiStringValue"`
+}
+
+type wixFeature struct {
+	Id            string            `xml:"Id,attr"`
+	Level         string            `xml:"Level,attr"`
+	ComponentRefs []wixComponentRef `xml:"ComponentRef"`
+}
+
+type wixComponentRef struct {
+	Id string `xml:"Id,attr"`
+}
+
+// renderWixProduct walks the package root, and renders a wxs
+// document installing everything in it under ProgramFiles.
+func renderWixProduct(ctx context.Context, w io.Writer, po *PackageOptions, wixOpts ...WixOpt) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.renderWixProduct")
+	defer span.End()
+
+	wo := &wixOptions{}
+	for _, opt := range wixOpts {
+		opt(wo)
+	}
+
+	services := make(map[string]*InitOptions)
+	for _, s := range wo.services {
+		services[filepath.ToSlash(strings.TrimPrefix(s.Path, "/"))] = s
+	}
+
+	programFiles := &wixDirectory{Id: "ProgramFiles64Folder"}
+	dirs := map[string]*wixDirectory{".": programFiles}
+	feature := wixFeature{Id: "Launcher", Level: "1"}
+
+	err := filepath.Walk(po.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(po.Root, path)
+		if err != nil {
+			return errors.Wrapf(err, "relative path of %s", path)
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if relPath == "." {
+			return nil
+		}
+
+		parent, ok := dirs[filepath.ToSlash(filepath.Dir(relPath))]
+		if !ok {
+			return errors.Errorf("missing parent directory for %s", relPath)
+		}
+
+		if info.IsDir() {
+			d := &wixDirectory{Id: wixId("dir", relPath), Name: info.Name()}
+			parent.Directories = append(parent.Directories, d)
+			dirs[relPath] = d
+			return nil
+		}
+
+		component := &wixComponent{
+			Id:    wixId("comp", relPath),
+			Guid:  "*",
+			Win64: "yes",
+			File: wixFile{
+				Id:      wixId("file", relPath),
+				Source:  relPath,
+				KeyPath: "yes",
+			},
+		}
+
+		if s, ok := services[relPath]; ok {
+			addWixService(component, s, wo.noStart)
+		}
+
+		parent.Components = append(parent.Components, component)
+		feature.ComponentRefs = append(feature.ComponentRefs, wixComponentRef{Id: component.Id})
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "walking package root")
+	}
+
+	doc := wixDocument{
+		XMLNS: "http://schemas.microsoft.com/wix/2006/wi",
+		Product: wixProduct{
+			Id:           "*",
+			Name:         fmt.Sprintf("%s %s", po.Name, po.Identifier),
+			Language:     "1033",
+			Version:      wixVersion(po.Version),
+			Manufacturer: wixManufacturer(po),
+			UpgradeCode:  uuid.NewSHA1(wixNamespace, []byte(po.Name+po.Identifier)).String(),
+			Package: wixPackage{
+				InstallerVersion: "500",
+				Compressed:       "yes",
+				InstallScope:     "perMachine",
+			},
+			MajorUpgrade: wixMajorUpgrade{
+				DowngradeErrorMessage: "A newer version is already installed.",
+			},
+			MediaTemplate: wixMediaTemplate{EmbedCab: "yes"},
+			Directory: wixDirectory{
+				Id:          "TARGETDIR",
+				Name:        "SourceDir",
+				Directories: []*wixDirectory{programFiles},
+			},
+			Feature: feature,
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return errors.Wrap(err, "writing xml header")
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return errors.Wrap(err, "xml encode")
+	}
+	return nil
+}
+
+// addWixService adds the windows service bits to a component.
+func addWixService(component *wixComponent, initOptions *InitOptions, noStart bool) {
+	serviceName := fmt.Sprintf("%s%sSvc", strings.Title(initOptions.Name), strings.Title(initOptions.Identifier))
+
+	component.ServiceInstall = &wixServiceInstall{
+		Id:           wixId("svc", serviceName),
+		Name:         serviceName,
+		DisplayName:  fmt.Sprintf("%s %s", initOptions.Name, initOptions.Identifier),
+		Description:  initOptions.Description,
+		Type:         "ownProcess",
+		Start:        "auto",
+		ErrorControl: "normal",
+		Arguments:    strings.Join(initOptions.Flags, " "),
+	}
+	if noStart {
+		component.ServiceInstall.Start = "demand"
+	}
+
+	component.ServiceControl = &wixServiceControl{
+		Id:     wixId("svcctl", serviceName),
+		Name:   serviceName,
+		Start:  "install",
+		Stop:   "both",
+		Remove: "uninstall",
+		Wait:   "no",
+	}
+	if noStart {
+		component.ServiceControl.Start = ""
+	}
+
+	if len(initOptions.Environment) == 0 {
+		return
+	}
+
+	env := []string{}
+	for k, v := range initOptions.Environment {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(env)
+
+	component.RegistryValue = &wixRegistryValue{
+		Root:        "HKLM",
+		Key:         fmt.Sprintf(`SYSTEM\CurrentControlSet\Services\%s`, serviceName),
+		Name:        "Environment",
+		Type:        "multiString",
+		Action:      "write",
+		MultiString: env,
+	}
+}
+
+// wixId returns a valid, and stable, WiX identifier for a
+// path. Identifiers are limited to 72 characters, so hash the path
+// instead of trying to sanitize it.
+func wixId(prefix, path string) string {
+	return fmt.Sprintf("%s_%x", prefix, sha1.Sum([]byte(path)))
+}
+
+var wixVersionRegexp = regexp.MustCompile(`^\d+(\.\d+){0,3}`)
+
+// wixVersion returns an MSI compatible version string. MSI versions
+// must be purely numeric, so strip any git describe style suffix
+// (eg: 0.5.6-19-g17c8589 becomes 0.5.6)
+func wixVersion(version string) string {
+	if v := wixVersionRegexp.FindString(version); v != "" {
+		return v
+	}
+	return "0.0.0"
+}
+
+// wixManufacturer is the MSI's Manufacturer, shown in Add/Remove
+// Programs. It has historically been the identifier, so that's kept
+// unless a vendor is set.
+func wixManufacturer(po *PackageOptions) string {
+	if po.Vendor != "" {
+		return po.Vendor
+	}
+	return po.Identifier
+}
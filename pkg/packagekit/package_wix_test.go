@@ -0,0 +1,102 @@
+package packagekit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderWixProduct(t *testing.T) {
+	t.Parallel()
+
+	packageRoot, err := ioutil.TempDir("", "packaging-wix-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(packageRoot)
+
+	binDir := filepath.Join(packageRoot, "Launcher-kolide-app", "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "launcher.exe"), []byte("launcher"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "osqueryd.exe"), []byte("osqueryd"), 0755))
+
+	po := &PackageOptions{
+		Name:       "launcher",
+		Identifier: "kolide-app",
+		Root:       packageRoot,
+		Version:    "0.5.6-19-g17c8589",
+	}
+
+	initOptions := &InitOptions{
+		Name:        "launcher",
+		Description: "The Kolide Launcher",
+		Identifier:  "kolide-app",
+		Path:        "/Launcher-kolide-app/bin/launcher.exe",
+		Flags:       []string{"--autoupdate"},
+		Environment: map[string]string{"KOLIDE_LAUNCHER_HOSTNAME": "device.kolide.com:443"},
+	}
+
+	var output bytes.Buffer
+	err = renderWixProduct(context.TODO(), &output, po, WithService(initOptions))
+	require.NoError(t, err)
+
+	expectedOutputStrings := []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`Version="0.5.6"`,
+		`Manufacturer="kolide-app"`,
+		`Source="Launcher-kolide-app/bin/launcher.exe"`,
+		`Source="Launcher-kolide-app/bin/osqueryd.exe"`,
+		`<ServiceInstall Id="svc_`,
+		`Name="LauncherKolide-AppSvc"`,
+		`Arguments="--autoupdate"`,
+		`<MultiStringValue>KOLIDE_LAUNCHER_HOSTNAME=device.kolide.com:443</MultiStringValue>`,
+	}
+
+	for _, s := range expectedOutputStrings {
+		require.Contains(t, output.String(), s)
+	}
+
+	// The upgrade code must be stable, or upgrades won't replace
+	// previous installs.
+	var secondOutput bytes.Buffer
+	err = renderWixProduct(context.TODO(), &secondOutput, po, WithService(initOptions))
+	require.NoError(t, err)
+	require.Equal(t, output.String(), secondOutput.String())
+
+	// Without starting, the service is manual, and not started on install
+	var noStartOutput bytes.Buffer
+	err = renderWixProduct(context.TODO(), &noStartOutput, po, WithService(initOptions), WithoutServiceStart())
+	require.NoError(t, err)
+	require.Contains(t, noStartOutput.String(), `Start="demand"`)
+	require.NotContains(t, noStartOutput.String(), `Start="install"`)
+	require.NotContains(t, noStartOutput.String(), `Start="auto"`)
+
+	// A vendor replaces the identifier as the manufacturer
+	var vendorOutput bytes.Buffer
+	vendorPo := *po
+	vendorPo.Vendor = "Example Co"
+	err = renderWixProduct(context.TODO(), &vendorOutput, &vendorPo, WithService(initOptions))
+	require.NoError(t, err)
+	require.Contains(t, vendorOutput.String(), `Manufacturer="Example Co"`)
+}
+
+func TestWixVersion(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{in: "0.5.6", out: "0.5.6"},
+		{in: "0.5.6-19-g17c8589", out: "0.5.6"},
+		{in: "1.2.3.4", out: "1.2.3.4"},
+		{in: "nightly", out: "0.0.0"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.out, wixVersion(tt.in))
+	}
+}
@@ -1,5 +1,10 @@
 package packagekit
 
+import (
+	"regexp"
+	"strings"
+)
+
 type InitOptions struct {
 	Name        string
 	Description string
@@ -7,4 +12,35 @@ type InitOptions struct {
 	Path        string
 	Environment map[string]string `plist:"EnvironmentVariables"`
 	Flags       []string          `plist:"ProgramArguments"`
+	User        string            // If set, run as this user, rather than root. Only used by the linux init systems.
+	Group       string            // If set, with User, run as this group
+}
+
+// shellSafe matches strings that need no quoting in sh.
+var shellSafe = regexp.MustCompile(`^[a-zA-Z0-9_./:,=@%+-]+$`)
+
+// shellQuote quotes s as a single sh word. Strings that are already
+// safe are left as they are, so the rendered scripts stay readable.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// ShellEscape escapes s for use inside double quotes in sh, so that
+// it's taken literally, rather than expanded.
+func ShellEscape(s string) string {
+	return shellEscaper.Replace(s)
+}
+
+var shellEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
+// shellEscapeAll is ShellEscape for each of ss.
+func shellEscapeAll(ss []string) []string {
+	escaped := make([]string, len(ss))
+	for i, s := range ss {
+		escaped[i] = ShellEscape(s)
+	}
+	return escaped
 }
@@ -15,13 +15,22 @@ func RenderInit(ctx context.Context, w io.Writer, initOptions *InitOptions) erro
 	defer span.End()
 
 	initdTemplate := `#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.Common.Identifier}}
+# Required-Start:    $network $remote_fs $syslog
+# Required-Stop:     $network $remote_fs $syslog
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: {{.Common.Description}}
+### END INIT INFO
 set -e
-NAME="{{.Common.Identifier}}"
-DAEMON="{{.Common.Path}}"
-DAEMON_OPTS="{{ StringsJoin .Common.Flags " \\\n" }}"
+NAME="{{ShellEscape .Common.Identifier}}"
+DAEMON="{{ShellEscape .Common.Path}}"
+DAEMON_OPTS="{{ StringsJoin (ShellEscapeAll .Common.Flags) " \\\n" }}"
+DAEMON_USER="{{ if .Common.User }}--chuid {{ .Common.User }}{{ if .Common.Group }}:{{ .Common.Group }}{{ end }}{{ end }}"
 
 {{- range $key, $value := .Common.Environment }}
-{{$key}}={{$value}}
+{{$key}}={{ShellQuote $value}}
 export {{$key}}
 {{- end }}
 
@@ -34,7 +43,7 @@ is_running() {
 case "$1" in
   start)
         echo "Starting daemon: "$NAME
-        start-stop-daemon --start --quiet --background --exec $DAEMON -- $DAEMON_OPTS
+        start-stop-daemon --start --quiet --background $DAEMON_USER --exec $DAEMON -- $DAEMON_OPTS
         ;;
   stop)
         echo "Stopping daemon: "$NAME
@@ -43,7 +52,7 @@ case "$1" in
   restart)
         echo "Restarting daemon: "$NAME
         start-stop-daemon --stop --quiet --oknodo --retry 30 --exec $DAEMON
-        start-stop-daemon --start --quiet --background --exec $DAEMON -- $DAEMON_OPTS
+        start-stop-daemon --start --quiet --background $DAEMON_USER --exec $DAEMON -- $DAEMON_OPTS
         ;;
   status)
     if is_running; then
@@ -68,7 +77,10 @@ exit 0
 	}
 
 	funcsMap := template.FuncMap{
-		"StringsJoin": strings.Join,
+		"StringsJoin":    strings.Join,
+		"ShellEscape":    ShellEscape,
+		"ShellEscapeAll": shellEscapeAll,
+		"ShellQuote":     shellQuote,
 	}
 
 	t, err := template.New("initd").Funcs(funcsMap).Parse(initdTemplate)
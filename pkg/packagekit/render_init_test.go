@@ -3,6 +3,10 @@ package packagekit
 import (
 	"bytes"
 	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -31,6 +35,7 @@ func TestRenderInitComplex(t *testing.T) {
 
 	expectedOutputStrings := []string{
 		`NAME="kolide-app"`,
+		`# Provides:          kolide-app`,
 		`KOLIDE_LAUNCHER_OSQUERYD_PATH=/usr/local/kolide-app/bin/osqueryd`,
 		`export KOLIDE_LAUNCHER_OSQUERYD_PATH`,
 		`--with_initial_runner`,
@@ -46,3 +51,53 @@ func TestRenderInitComplex(t *testing.T) {
 	}
 
 }
+
+func TestRenderInitUser(t *testing.T) {
+	t.Parallel()
+
+	initOptions := complexInitOptions()
+	initOptions.User = "kolide"
+	initOptions.Group = "kolide-group"
+
+	var output bytes.Buffer
+	err := RenderInit(context.TODO(), &output, initOptions)
+	require.NoError(t, err)
+	require.Contains(t, output.String(), `DAEMON_USER="--chuid kolide:kolide-group"`)
+	require.Contains(t, output.String(), `--start --quiet --background $DAEMON_USER --exec $DAEMON`)
+
+	output.Reset()
+	err = RenderInit(context.TODO(), &output, complexInitOptions())
+	require.NoError(t, err)
+	require.Contains(t, output.String(), `DAEMON_USER=""`)
+}
+
+func TestRenderInitEscaping(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packagekit-init-escaping")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "injected")
+
+	initOptions := complexInitOptions()
+	initOptions.Environment["KOLIDE_LAUNCHER_HOSTNAME"] = "a'b\"c$(touch " + marker + ")`touch " + marker + "`\nd"
+	initOptions.Path = "/usr/local/$(touch " + marker + ")/launcher"
+
+	var output bytes.Buffer
+	require.NoError(t, RenderInit(context.TODO(), &output, initOptions))
+	script := filepath.Join(dir, "launcher")
+	require.NoError(t, ioutil.WriteFile(script, output.Bytes(), 0755))
+
+	// It's well formed, and running it, up to the usage error, doesn't
+	// run the injected commands
+	require.NoError(t, exec.Command("/bin/sh", "-n", script).Run())
+	require.Error(t, exec.Command("/bin/sh", script, "usage").Run())
+	_, err = os.Stat(marker)
+	require.True(t, os.IsNotExist(err), "injected command ran")
+
+	// The values are taken literally
+	cmd := exec.Command("/bin/sh", "-c", `eval "$(sed -n '/^KOLIDE_LAUNCHER_HOSTNAME=/,/^export/p; /^DAEMON=/p' "$0")"; printf '%s|%s' "$KOLIDE_LAUNCHER_HOSTNAME" "$DAEMON"`, script)
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	require.Equal(t, initOptions.Environment["KOLIDE_LAUNCHER_HOSTNAME"]+"|"+initOptions.Path, string(out))
+}
@@ -1,10 +1,14 @@
 package packagekit
 
 import (
+	"bytes"
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"path/filepath"
+	"text/template"
 
 	"github.com/groob/plist"
 	"github.com/pkg/errors"
@@ -22,12 +26,60 @@ type launchdOptions struct {
 	StandardErrorPath string                 `plist:"StandardErrorPath"`
 	StandardOutPath   string                 `plist:"StandardOutPath"`
 	KeepAlive         map[string]interface{} `plist:"KeepAlive"`
+	Disabled          bool                   `plist:"Disabled,omitempty"`
 }
 
-func RenderLaunchd(ctx context.Context, w io.Writer, initOptions *InitOptions) error {
+type launchdRenderOptions struct {
+	template string
+	disabled bool
+}
+
+type LaunchdOption func(*launchdRenderOptions)
+
+// WithLaunchdTemplate renders the plist from a text/template, rather
+// than the default. It's executed with a LaunchdTemplateData, and must
+// produce an XML plist with the service's Label. See
+// ValidateLaunchdTemplate.
+func WithLaunchdTemplate(tmpl string) LaunchdOption {
+	return func(lo *launchdRenderOptions) {
+		lo.template = tmpl
+	}
+}
+
+// WithLaunchdDisabled renders the plist as Disabled, so launchd
+// doesn't load it at boot. It's loaded, and enabled, with
+// launchctl load -w.
+func WithLaunchdDisabled() LaunchdOption {
+	return func(lo *launchdRenderOptions) {
+		lo.disabled = true
+	}
+}
+
+// LaunchdTemplateData is what launchd plist templates are executed
+// with. Values aren't escaped, so templates should pipe them to xml,
+// eg: {{.Label | xml}}.
+type LaunchdTemplateData struct {
+	Identifier        string
+	Label             string
+	Program           string            // launcher's path
+	ProgramArguments  []string          // Program, followed by its flags
+	Environment       map[string]string // launcher's environment, including its config
+	SecretPath        string
+	StandardErrorPath string
+	StandardOutPath   string
+	ThrottleInterval  int  // The default plist's, in seconds
+	Disabled          bool // Whether the plist must be Disabled. See WithLaunchdDisabled.
+}
+
+func RenderLaunchd(ctx context.Context, w io.Writer, initOptions *InitOptions, opts ...LaunchdOption) error {
 	ctx, span := trace.StartSpan(ctx, "packagekit.RenderLaunchd")
 	defer span.End()
 
+	lo := &launchdRenderOptions{}
+	for _, opt := range opts {
+		opt(lo)
+	}
+
 	if initOptions.Identifier == "" {
 		return errors.New("Identifier must not be empty")
 	}
@@ -36,22 +88,27 @@ func RenderLaunchd(ctx context.Context, w io.Writer, initOptions *InitOptions) e
 		return errors.New("Path must not be empty")
 	}
 
-	pathState := map[string]bool{
-		fmt.Sprintf("/etc/%s/secret", initOptions.Identifier): true,
+	data := launchdTemplateData(initOptions)
+	data.Disabled = lo.disabled
+	if lo.template != "" {
+		return renderLaunchdTemplate(w, lo.template, data)
 	}
 
 	keepAlive := map[string]interface{}{
-		"PathState": pathState,
+		"PathState": map[string]bool{
+			data.SecretPath: true,
+		},
 	}
 
 	lOpts := &launchdOptions{
-		Environment:       initOptions.Environment,
-		Args:              append([]string{initOptions.Path}, initOptions.Flags...),
-		Label:             fmt.Sprintf("com.%s.launcher", initOptions.Identifier),
-		ThrottleInterval:  60,
-		StandardErrorPath: filepath.Join("/var/log", initOptions.Identifier, "launcher-stderr.log"),
-		StandardOutPath:   filepath.Join("/var/log", initOptions.Identifier, "launcher-stdout.log"),
+		Environment:       data.Environment,
+		Args:              data.ProgramArguments,
+		Label:             data.Label,
+		ThrottleInterval:  data.ThrottleInterval,
+		StandardErrorPath: data.StandardErrorPath,
+		StandardOutPath:   data.StandardOutPath,
 		KeepAlive:         keepAlive,
+		Disabled:          data.Disabled,
 	}
 
 	enc := plist.NewEncoder(w)
@@ -61,3 +118,87 @@ func RenderLaunchd(ctx context.Context, w io.Writer, initOptions *InitOptions) e
 	}
 	return nil
 }
+
+func launchdTemplateData(initOptions *InitOptions) LaunchdTemplateData {
+	return LaunchdTemplateData{
+		Identifier:        initOptions.Identifier,
+		Label:             fmt.Sprintf("com.%s.launcher", initOptions.Identifier),
+		Program:           initOptions.Path,
+		ProgramArguments:  append([]string{initOptions.Path}, initOptions.Flags...),
+		Environment:       initOptions.Environment,
+		SecretPath:        fmt.Sprintf("/etc/%s/secret", initOptions.Identifier),
+		StandardErrorPath: filepath.Join("/var/log", initOptions.Identifier, "launcher-stderr.log"),
+		StandardOutPath:   filepath.Join("/var/log", initOptions.Identifier, "launcher-stdout.log"),
+		ThrottleInterval:  60,
+	}
+}
+
+// launchdTemplateFuncs are the functions available to plist templates.
+var launchdTemplateFuncs = template.FuncMap{
+	"xml": func(s string) (string, error) {
+		var buf bytes.Buffer
+		if err := xml.EscapeText(&buf, []byte(s)); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	},
+}
+
+// ValidateLaunchdTemplate checks that tmpl parses, and renders a valid
+// plist, as WithLaunchdTemplate requires, for some example options,
+// and opts.
+func ValidateLaunchdTemplate(tmpl string, opts ...LaunchdOption) error {
+	lo := &launchdRenderOptions{}
+	for _, opt := range opts {
+		opt(lo)
+	}
+
+	data := launchdTemplateData(&InitOptions{
+		Identifier: "example",
+		Path:       "/usr/local/example/bin/launcher",
+		Flags:      []string{"--config", "/etc/example/launcher.flags"},
+		Environment: map[string]string{
+			"KOLIDE_LAUNCHER_HOSTNAME": "example.com:443",
+		},
+	})
+	data.Disabled = lo.disabled
+	return renderLaunchdTemplate(ioutil.Discard, tmpl, data)
+}
+
+// renderLaunchdTemplate executes tmpl with data, and writes the
+// result to w if it's a valid plist for the service. launchd ignores
+// plists it can't parse, so a mistake would leave launcher not
+// running.
+func renderLaunchdTemplate(w io.Writer, tmpl string, data LaunchdTemplateData) error {
+	t, err := template.New("launchd").Funcs(launchdTemplateFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return errors.Wrap(err, "parsing launchd plist template")
+	}
+
+	var rendered bytes.Buffer
+	if err := t.Execute(&rendered, data); err != nil {
+		return errors.Wrap(err, "executing launchd plist template")
+	}
+
+	var service struct {
+		Label            string   `plist:"Label"`
+		Program          string   `plist:"Program"`
+		ProgramArguments []string `plist:"ProgramArguments"`
+		Disabled         bool     `plist:"Disabled"`
+	}
+	if err := plist.NewXMLDecoder(bytes.NewReader(rendered.Bytes())).Decode(&service); err != nil {
+		return errors.Wrap(err, "launchd plist template doesn't produce a valid plist")
+	}
+	if service.Label != data.Label {
+		return errors.Errorf("launchd plist template's Label is %q, it must be %s", service.Label, data.Label)
+	}
+	if service.Program == "" && len(service.ProgramArguments) == 0 {
+		return errors.New("launchd plist template has no Program, or ProgramArguments")
+	}
+	if data.Disabled && !service.Disabled {
+		return errors.New("launchd plist template must set Disabled, when .Disabled is set")
+	}
+
+	_, err = w.Write(rendered.Bytes())
+	return err
+}
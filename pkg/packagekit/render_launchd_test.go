@@ -3,6 +3,7 @@ package packagekit
 import (
 	"bytes"
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -45,6 +46,122 @@ func TestRenderLaunchdComplex(t *testing.T) {
 	require.Equal(t, expectedData, generatedData)
 }
 
+func TestRenderLaunchdDisabled(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	require.NoError(t, RenderLaunchd(context.TODO(), &output, complexInitOptions()))
+	require.NotContains(t, output.String(), "Disabled")
+
+	output.Reset()
+	require.NoError(t, RenderLaunchd(context.TODO(), &output, complexInitOptions(), WithLaunchdDisabled()))
+
+	var generated struct {
+		Label    string `plist:"Label"`
+		Disabled bool   `plist:"Disabled"`
+	}
+	_, err := plist.Unmarshal(output.Bytes(), &generated)
+	require.NoError(t, err)
+	require.Equal(t, "com.kolide-app.launcher", generated.Label)
+	require.True(t, generated.Disabled)
+
+	// Templates have to honor it
+	require.Error(t, ValidateLaunchdTemplate(testLaunchdTemplate, WithLaunchdDisabled()))
+	disabledTemplate := strings.Replace(testLaunchdTemplate, "<key>KeepAlive</key>", "{{- if .Disabled }}\n    <key>Disabled</key>\n    <true/>\n    {{- end }}\n    <key>KeepAlive</key>", 1)
+	require.NoError(t, ValidateLaunchdTemplate(disabledTemplate, WithLaunchdDisabled()))
+	require.NoError(t, ValidateLaunchdTemplate(disabledTemplate))
+}
+
+// testLaunchdTemplate is a plist template that changes the KeepAlive
+// to always, and adds an environment variable.
+const testLaunchdTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+  <dict>
+    <key>Label</key>
+    <string>{{.Label | xml}}</string>
+    <key>EnvironmentVariables</key>
+    <dict>
+      {{- range $name, $value := .Environment }}
+      <key>{{$name | xml}}</key>
+      <string>{{$value | xml}}</string>
+      {{- end }}
+      <key>ACME_PROXY</key>
+      <string>http://proxy.acme.biz:3128</string>
+    </dict>
+    <key>KeepAlive</key>
+    <true/>
+    <key>ThrottleInterval</key>
+    <integer>10</integer>
+    <key>ProgramArguments</key>
+    <array>
+      {{- range .ProgramArguments }}
+      <string>{{. | xml}}</string>
+      {{- end }}
+    </array>
+    <key>StandardErrorPath</key>
+    <string>{{.StandardErrorPath | xml}}</string>
+  </dict>
+</plist>
+`
+
+func TestRenderLaunchdTemplate(t *testing.T) {
+	t.Parallel()
+
+	initOptions := complexInitOptions()
+	initOptions.Flags = append(initOptions.Flags, "--hostname=<&>")
+
+	var output bytes.Buffer
+	err := RenderLaunchd(context.TODO(), &output, initOptions, WithLaunchdTemplate(testLaunchdTemplate))
+	require.NoError(t, err)
+
+	var generated struct {
+		Label                string            `plist:"Label"`
+		EnvironmentVariables map[string]string `plist:"EnvironmentVariables"`
+		KeepAlive            bool              `plist:"KeepAlive"`
+		ThrottleInterval     int               `plist:"ThrottleInterval"`
+		ProgramArguments     []string          `plist:"ProgramArguments"`
+		StandardErrorPath    string            `plist:"StandardErrorPath"`
+	}
+	_, err = plist.Unmarshal(output.Bytes(), &generated)
+	require.NoError(t, err)
+
+	require.Equal(t, "com.kolide-app.launcher", generated.Label)
+	require.True(t, generated.KeepAlive)
+	require.Equal(t, 10, generated.ThrottleInterval)
+	require.Equal(t, "http://proxy.acme.biz:3128", generated.EnvironmentVariables["ACME_PROXY"])
+	require.Equal(t, "nightly", generated.EnvironmentVariables["KOLIDE_LAUNCHER_UPDATE_CHANNEL"])
+	require.Equal(t, append([]string{initOptions.Path}, initOptions.Flags...), generated.ProgramArguments)
+	require.Equal(t, "/var/log/kolide-app/launcher-stderr.log", generated.StandardErrorPath)
+}
+
+func TestValidateLaunchdTemplate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, ValidateLaunchdTemplate(testLaunchdTemplate))
+
+	var tests = []struct {
+		name string
+		tmpl string
+	}{
+		{name: "unparseable", tmpl: "{{.Label"},
+		{name: "unknown field", tmpl: "{{.Bogus}}"},
+		{name: "not a plist", tmpl: "Label={{.Label}}"},
+		{name: "unclosed", tmpl: `<plist version="1.0"><dict><key>Label</key><string>{{.Label}}</string>`},
+		{name: "wrong label", tmpl: `<plist version="1.0"><dict><key>Label</key><string>com.acme.launcher</string><key>Program</key><string>{{.Program}}</string></dict></plist>`},
+		{name: "no program", tmpl: `<plist version="1.0"><dict><key>Label</key><string>{{.Label}}</string></dict></plist>`},
+	}
+
+	for _, tt := range tests {
+		require.Error(t, ValidateLaunchdTemplate(tt.tmpl), tt.name)
+	}
+
+	var output bytes.Buffer
+	err := RenderLaunchd(context.TODO(), &output, complexInitOptions(), WithLaunchdTemplate(tests[4].tmpl))
+	require.Error(t, err)
+	require.Empty(t, output.String(), "invalid plists shouldn't be written")
+}
+
 // expectedComplex returns the expected data. It uses
 // `DHowett/go-plist` so we can cross-check our encoder.
 func expectedComplex() (launchdOptions, error) {
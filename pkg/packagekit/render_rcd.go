@@ -0,0 +1,76 @@
+package packagekit
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+var rcNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// RCName returns the name of the FreeBSD rc.d service. It's also used
+// for the rc.conf variables, so it must be a valid shell identifier.
+func RCName(initOptions *InitOptions) string {
+	return rcNameUnsafe.ReplaceAllString(initOptions.Name+"_"+initOptions.Identifier, "_")
+}
+
+// RenderRCD renders a FreeBSD rc.d script. The daemon is supervised
+// by daemon(8), so it's restarted if it exits, and logs to
+// /var/log/<name>.log. Enable it with `sysrc <name>_enable=YES`.
+func RenderRCD(ctx context.Context, w io.Writer, initOptions *InitOptions) error {
+	ctx, span := trace.StartSpan(ctx, "packagekit.RenderRCD")
+	defer span.End()
+
+	rcdTemplate := `#!/bin/sh
+#
+# PROVIDE: {{.Name}}
+# REQUIRE: LOGIN NETWORKING
+# KEYWORD: shutdown
+#
+# {{.Common.Description}}
+
+. /etc/rc.subr
+
+name="{{.Name}}"
+rcvar="{{.Name}}_enable"
+
+load_rc_config $name
+: ${ {{- .Name}}_enable:="NO"}
+
+{{- range $key, $value := .Common.Environment }}
+{{$key}}="{{ShellEscape $value}}"
+export {{$key}}
+{{- end }}
+
+pidfile="/var/run/${name}.pid"
+command="/usr/sbin/daemon"
+command_args="-r -o /var/log/${name}.log -P ${pidfile} {{ShellWord .Common.Path}}{{ range .Common.Flags }} {{ShellWord .}}{{ end }}"
+
+run_rc_command "$1"
+`
+
+	var data = struct {
+		Name   string
+		Common InitOptions
+	}{
+		Name:   RCName(initOptions),
+		Common: *initOptions,
+	}
+
+	funcsMap := template.FuncMap{
+		"ShellEscape": ShellEscape,
+		// rc.subr evals command_args, so each word is quoted for
+		// that, then escaped for the assignment
+		"ShellWord": func(s string) string { return ShellEscape(shellQuote(s)) },
+	}
+
+	t, err := template.New("rcd").Funcs(funcsMap).Parse(rcdTemplate)
+	if err != nil {
+		return errors.Wrap(err, "not able to parse rc.d template")
+	}
+	return t.ExecuteTemplate(w, "rcd", data)
+}
@@ -0,0 +1,66 @@
+package packagekit
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderRCDEmpty(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	err := RenderRCD(context.TODO(), &output, emptyInitOptions())
+	require.NoError(t, err)
+	require.Contains(t, output.String(), `rcvar="empty_empty_enable"`)
+}
+
+func TestRenderRCDComplex(t *testing.T) {
+	t.Parallel()
+
+	expectedOutputStrings := []string{
+		`# PROVIDE: launcher_kolide_app`,
+		`name="launcher_kolide_app"`,
+		`: ${launcher_kolide_app_enable:="NO"}`,
+		`KOLIDE_LAUNCHER_OSQUERYD_PATH="/usr/local/kolide-app/bin/osqueryd"`,
+		`export KOLIDE_LAUNCHER_OSQUERYD_PATH`,
+		`command_args="-r -o /var/log/${name}.log -P ${pidfile} /usr/local/kolide-app/bin/launcher --autoupdate --with_initial_runner"`,
+	}
+
+	var output bytes.Buffer
+	err := RenderRCD(context.TODO(), &output, complexInitOptions())
+	require.NoError(t, err)
+
+	for _, s := range expectedOutputStrings {
+		require.Contains(t, output.String(), s)
+	}
+}
+
+func TestRCName(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "launcher_kolide_app", RCName(&InitOptions{Name: "launcher", Identifier: "kolide-app"}))
+	require.Equal(t, "launcher_acme_corp", RCName(&InitOptions{Name: "launcher", Identifier: "acme.corp"}))
+}
+
+func TestRenderRCDEscaping(t *testing.T) {
+	t.Parallel()
+
+	initOptions := complexInitOptions()
+	initOptions.Environment["KOLIDE_LAUNCHER_HOSTNAME"] = "a'b\"c$(exit 1)`exit 1`"
+	initOptions.Path = "/usr/local/kolide app/$(exit 1)/launcher"
+
+	var output bytes.Buffer
+	require.NoError(t, RenderRCD(context.TODO(), &output, initOptions))
+
+	// rc.subr evals command_args, so check the values survive that, as
+	// well as the assignments
+	cmd := exec.Command("/bin/sh", "-c", `pidfile=/var/run/launcher.pid; eval "$(grep -e '^KOLIDE_LAUNCHER_HOSTNAME=' -e '^command_args=')"; eval "set -- $command_args"; printf '%s|%s' "$KOLIDE_LAUNCHER_HOSTNAME" "$6"`)
+	cmd.Stdin = &output
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	require.Equal(t, initOptions.Environment["KOLIDE_LAUNCHER_HOSTNAME"]+"|"+initOptions.Path, string(out))
+}
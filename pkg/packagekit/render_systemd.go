@@ -10,12 +10,33 @@ import (
 	"go.opencensus.io/trace"
 )
 
+// SystemdRestartPolicies are the values systemd allows for Restart=
+var SystemdRestartPolicies = []string{"no", "on-success", "on-failure", "on-abnormal", "on-watchdog", "on-abort", "always"}
+
 type systemdOptions struct {
 	Restart    string
 	RestartSec int
 }
 
-func RenderSystemd(ctx context.Context, w io.Writer, initOptions *InitOptions) error {
+type SystemdOption func(*systemdOptions)
+
+// WithRestart sets when systemd restarts the service. It should be
+// one of SystemdRestartPolicies. The default is on-failure.
+func WithRestart(policy string) SystemdOption {
+	return func(so *systemdOptions) {
+		so.Restart = policy
+	}
+}
+
+// WithRestartSec sets how many seconds systemd waits before restarting
+// the service. The default is 3.
+func WithRestartSec(sec int) SystemdOption {
+	return func(so *systemdOptions) {
+		so.RestartSec = sec
+	}
+}
+
+func RenderSystemd(ctx context.Context, w io.Writer, initOptions *InitOptions, opts ...SystemdOption) error {
 	ctx, span := trace.StartSpan(ctx, "packagekit.Systemd")
 	defer span.End()
 
@@ -23,6 +44,9 @@ func RenderSystemd(ctx context.Context, w io.Writer, initOptions *InitOptions) e
 		Restart:    "on-failure",
 		RestartSec: 3,
 	}
+	for _, opt := range opts {
+		opt(sOpts)
+	}
 
 	// Prepend a "" so that the merged output looks a bit cleaner in the systemd file
 	if len(initOptions.Flags) > 0 {
@@ -38,6 +62,12 @@ After=network.service syslog.service
 {{- if .Common.Environment}}{{- range $key, $value := .Common.Environment }}
 Environment={{$key}}={{$value}}
 {{- end }}{{- end }}
+{{- if .Common.User }}
+User={{.Common.User}}
+{{- end }}
+{{- if .Common.Group }}
+Group={{.Common.Group}}
+{{- end }}
 ExecStart={{.Common.Path}}{{ StringsJoin .Common.Flags " \\\n" }}
 Restart={{.Opts.Restart}}
 RestartSec={{.Opts.RestartSec}}
@@ -45,6 +45,28 @@ func TestRenderSystemdComplex(t *testing.T) {
 	require.Equal(t, expectedComplexUnit(), output.String())
 }
 
+func TestRenderSystemdUser(t *testing.T) {
+	t.Parallel()
+
+	initOptions := complexInitOptions()
+	initOptions.User = "kolide"
+	initOptions.Group = "kolide-group"
+
+	var output bytes.Buffer
+	err := RenderSystemd(context.TODO(), &output, initOptions)
+	require.NoError(t, err)
+	require.Contains(t, output.String(), "nightly\nUser=kolide\nGroup=kolide-group\nExecStart=")
+}
+
+func TestRenderSystemdRestart(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	err := RenderSystemd(context.TODO(), &output, complexInitOptions(), WithRestart("always"), WithRestartSec(30))
+	require.NoError(t, err)
+	require.Contains(t, output.String(), "\nRestart=always\nRestartSec=30\n")
+}
+
 func expectedComplexUnit() string {
 
 	return `[Unit]
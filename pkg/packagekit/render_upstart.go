@@ -17,6 +17,9 @@ type upstartOptions struct {
 	PostStartScript []string
 	PreStopScript   []string
 	Expect          string
+	NoRespawn       bool
+	RespawnDelay    int
+	Manual          bool
 }
 
 type UpstartOption func(*upstartOptions)
@@ -47,6 +50,31 @@ func WithPreStopScript(s []string) UpstartOption {
 	}
 }
 
+// WithRespawn sets whether upstart respawns the daemon when it exits
+// unexpectedly. The default is to respawn.
+func WithRespawn(respawn bool) UpstartOption {
+	return func(uo *upstartOptions) {
+		uo.NoRespawn = !respawn
+	}
+}
+
+// WithRespawnDelay sets how many seconds upstart waits before
+// respawning the daemon. Upstart has no native delay, so this sleeps
+// in post-stop.
+func WithRespawnDelay(sec int) UpstartOption {
+	return func(uo *upstartOptions) {
+		uo.RespawnDelay = sec
+	}
+}
+
+// WithManualStart renders the job as manual, rather than started on
+// boot, so it only runs once something starts it.
+func WithManualStart() UpstartOption {
+	return func(uo *upstartOptions) {
+		uo.Manual = true
+	}
+}
+
 func RenderUpstart(ctx context.Context, w io.Writer, initOptions *InitOptions, uOpts ...UpstartOption) error {
 	ctx, span := trace.StartSpan(ctx, "packagekit.Upstart")
 	defer span.End()
@@ -70,18 +98,38 @@ func RenderUpstart(ctx context.Context, w io.Writer, initOptions *InitOptions, u
 expect {{ .Opts.Expect }}
 {{- end }}
 
+{{ if .Opts.Manual -}}
+# Only start when asked, and stop on shutdown
+manual
+{{ else -}}
 # Start and stop on boot events
 start on net-device-up
+{{ end -}}
 stop on shutdown
+{{- if not .Opts.NoRespawn }}
 
 # Respawn upto 15 times within 5 seconds.
 # Exceeding that will be considered a failure
 respawn
 respawn limit 15 5
+{{- if .Opts.RespawnDelay }}
+
+# Wait before respawning
+post-stop exec sleep {{ .Opts.RespawnDelay }}
+{{- end }}
+{{- end }}
 
 # Send logs to the default upstart location, /var/log/upstart/
 # (This should be rotated by the upstart managed logrotate)
 console log
+{{- if .Common.User }}
+
+# Drop privileges before running the daemon
+setuid {{ .Common.User }}
+{{- end }}
+{{- if .Common.Group }}
+setgid {{ .Common.Group }}
+{{- end }}
 
 # Environment Variables
 {{- if .Common.Environment}}{{- range $key, $value := .Common.Environment }}
@@ -90,6 +90,46 @@ func TestRenderUpstartOptions(t *testing.T) {
 				"expect fork",
 			},
 		},
+		{
+			expectedStrings: []string{
+				"stop on shutdown\n\n# Respawn upto 15 times within 5 seconds.",
+				"\nrespawn\nrespawn limit 15 5\n",
+			},
+			unexpectedStrings: []string{
+				"post-stop",
+			},
+		},
+		{
+			uOpts: []UpstartOption{WithRespawnDelay(10)},
+			expectedStrings: []string{
+				"\nrespawn\n",
+				"\npost-stop exec sleep 10\n",
+			},
+		},
+		{
+			uOpts: []UpstartOption{WithRespawn(false), WithRespawnDelay(10)},
+			unexpectedStrings: []string{
+				"respawn",
+				"post-stop",
+			},
+		},
+		{
+			expectedStrings: []string{
+				"\nstart on net-device-up\nstop on shutdown\n",
+			},
+			unexpectedStrings: []string{
+				"manual",
+			},
+		},
+		{
+			uOpts: []UpstartOption{WithManualStart()},
+			expectedStrings: []string{
+				"\nmanual\nstop on shutdown\n",
+			},
+			unexpectedStrings: []string{
+				"start on",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -107,3 +147,22 @@ func TestRenderUpstartOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestRenderUpstartUser(t *testing.T) {
+	t.Parallel()
+
+	initOptions := complexInitOptions()
+	initOptions.User = "kolide"
+	initOptions.Group = "kolide-group"
+
+	var output bytes.Buffer
+	err := RenderUpstart(context.TODO(), &output, initOptions)
+	require.NoError(t, err)
+	require.Contains(t, output.String(), "\nsetuid kolide\nsetgid kolide-group\n")
+
+	output.Reset()
+	err = RenderUpstart(context.TODO(), &output, complexInitOptions())
+	require.NoError(t, err)
+	require.NotContains(t, output.String(), "setuid")
+	require.NotContains(t, output.String(), "setgid")
+}
@@ -0,0 +1,478 @@
+package packaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/pkg/errors"
+)
+
+// BuildResult describes a successfully built package.
+type BuildResult struct {
+	Target           string `json:"target"`
+	Tenant           string `json:"tenant,omitempty"`
+	Path             string `json:"path"`
+	Size             int64  `json:"size"`
+	SHA256           string `json:"sha256"`
+	PackageVersion   string `json:"package_version"`
+	LauncherVersion  string `json:"launcher_version"`
+	OsqueryVersion   string `json:"osquery_version"`
+	ExtensionVersion string `json:"extension_version"`
+
+	// Inputs are the sha256 hashes of the binaries, and other files, that
+	// went into the package, by their path in it. See Lockfile.
+	Inputs map[string]string `json:"inputs,omitempty"`
+
+	// SBOM is the path of the package's software bill of materials, if
+	// it was built WithSBOM.
+	SBOM string `json:"sbom,omitempty"`
+
+	// Duration, and Timings, are how long the build took, and where it
+	// spent that time. They're only set WithTimings.
+	Duration time.Duration `json:"duration,omitempty"`
+	Timings  []PhaseTiming `json:"timings,omitempty"`
+}
+
+// buildOptions control how targets are built, as opposed to what
+// goes into the packages.
+type buildOptions struct {
+	outputDir   string
+	outputName  *template.Template // see ParseOutputNameTemplate
+	maxParallel int
+	checksums   bool       // write a sha256sum style file next to each package
+	maxSize     int64      // fail packages larger than this many bytes. Zero is unlimited.
+	timings     bool       // report how long each build, and its phases, took
+	sbom        SBOMFormat // write an SBOM, in this format, next to each package
+}
+
+type BuildOpt func(*buildOptions)
+
+// WithOutputName sets the template used to name packages. See
+// ParseOutputNameTemplate.
+func WithOutputName(tmpl *template.Template) BuildOpt {
+	return func(bo *buildOptions) {
+		bo.outputName = tmpl
+	}
+}
+
+// WithMaxParallel sets how many targets are built at once. The
+// default is one at a time.
+func WithMaxParallel(n int) BuildOpt {
+	return func(bo *buildOptions) {
+		bo.maxParallel = n
+	}
+}
+
+// WithChecksums writes a sha256sum compatible <package>.sha256 file
+// next to each package.
+func WithChecksums() BuildOpt {
+	return func(bo *buildOptions) {
+		bo.checksums = true
+	}
+}
+
+// WithMaxPackageSize fails the build of any package larger than
+// maxSize bytes, for deployment tools with size limits. See
+// ParseSize.
+func WithMaxPackageSize(maxSize int64) BuildOpt {
+	return func(bo *buildOptions) {
+		bo.maxSize = maxSize
+	}
+}
+
+// WithTimings reports how long each build took, overall and by phase,
+// in its result. See WriteTimingReport.
+func WithTimings() BuildOpt {
+	return func(bo *buildOptions) {
+		bo.timings = true
+	}
+}
+
+// BuildAll builds a package for each target into outputDir, and
+// returns a result for each one that was built. Errors are collected,
+// and returned together, so a single run reports every failing
+// target. Results are returned alongside the error, for the targets
+// that did build. Both are in the order of targets, whatever order the
+// builds finished in.
+//
+// packageOptions is copied for each target, so it's safe to reuse.
+func BuildAll(ctx context.Context, packageOptions PackageOptions, targets []Target, outputDir string, buildOpts ...BuildOpt) ([]BuildResult, error) {
+	logger := ctxlog.FromContext(ctx)
+
+	cfg := buildOptions{
+		outputDir:   outputDir,
+		maxParallel: 1,
+	}
+	for _, opt := range buildOpts {
+		opt(&cfg)
+	}
+
+	if cfg.outputName == nil {
+		tmpl, err := ParseOutputNameTemplate("")
+		if err != nil {
+			return nil, err
+		}
+		cfg.outputName = tmpl
+	}
+
+	if cfg.maxParallel < 1 {
+		cfg.maxParallel = 1
+	}
+
+	if err := validateOutputNames(cfg.outputName, targets, packageOptions.PackageVersion, packageOptions.Tenant); err != nil {
+		return nil, err
+	}
+
+	// Each build is kept with its target's index, so the results can be
+	// put back in order once the parallel builds are done.
+	type targetBuild struct {
+		index  int
+		result BuildResult
+		err    error
+	}
+
+	var (
+		mu     sync.Mutex
+		builds []targetBuild
+	)
+
+	addResult := func(index int, result BuildResult, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			level.Info(logger).Log("msg", "build failed", "target", targets[index].String(), "err", err)
+		}
+		builds = append(builds, targetBuild{index: index, result: result, err: err})
+	}
+
+	// When the package version is unset, it's detected from the
+	// launcher binary during the build. Build the first target by
+	// itself, so every package shares the detected version.
+	first := 0
+	if packageOptions.PackageVersion == "" && len(targets) > 0 {
+		result, err := buildTarget(ctx, packageOptions, targets[0], cfg)
+		addResult(0, result, err)
+		packageOptions.PackageVersion = result.PackageVersion
+		first = 1
+	}
+
+	indexCh := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.maxParallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexCh {
+				result, err := buildTarget(ctx, packageOptions, targets[index], cfg)
+				addResult(index, result, err)
+			}
+		}()
+	}
+
+	for index := first; index < len(targets); index++ {
+		indexCh <- index
+	}
+	close(indexCh)
+	wg.Wait()
+
+	sort.Slice(builds, func(i, j int) bool { return builds[i].index < builds[j].index })
+
+	var (
+		errs    []error
+		results []BuildResult
+	)
+	for _, build := range builds {
+		if build.err != nil {
+			errs = append(errs, build.err)
+			continue
+		}
+		results = append(results, build.result)
+	}
+
+	if len(errs) > 0 {
+		return results, &BuildAllError{Errors: errs}
+	}
+
+	return results, nil
+}
+
+// buildTarget builds a single target into its own output file. It
+// takes packageOptions by value, as Build isn't safe to call
+// concurrently on a shared struct.
+//
+// As the output name may depend on the autodetected package version,
+// the package is built into a temporary file, and renamed once it's
+// complete. This also means failed, or cancelled, builds don't leave
+// partial packages behind, unless KeepTemp is set.
+func buildTarget(ctx context.Context, packageOptions PackageOptions, target Target, cfg buildOptions) (BuildResult, error) {
+	// Don't start new builds once the context is done, eg: by a timeout
+	if err := ctx.Err(); err != nil {
+		return BuildResult{}, errors.Wrapf(err, "skipped %s", target.String())
+	}
+
+	logger := ctxlog.FromContext(ctx)
+	level.Info(logger).Log("msg", "starting build", "target", target.String())
+	start := time.Now()
+
+	outputFile, err := ioutil.TempFile(cfg.outputDir, fmt.Sprintf(".launcher.%s.", target.String()))
+	if err != nil {
+		return BuildResult{}, errors.Wrapf(err, "Failed to make package output file for %s", target.String())
+	}
+	defer outputFile.Close()
+
+	if err := packageOptions.Build(ctx, outputFile, target); err != nil {
+		removeOutput(ctx, packageOptions, target, outputFile.Name())
+		return BuildResult{}, errors.Wrapf(err, "building %s", target.String())
+	}
+
+	if cfg.maxSize > 0 {
+		info, err := outputFile.Stat()
+		if err != nil {
+			return BuildResult{}, errors.Wrapf(err, "stat output file for %s", target.String())
+		}
+		if info.Size() > cfg.maxSize {
+			removeOutput(ctx, packageOptions, target, outputFile.Name())
+			return BuildResult{}, errors.Errorf(
+				"package for %s is %s (%d bytes), over the %s (%d bytes) limit",
+				target.String(), FormatSize(info.Size()), info.Size(), FormatSize(cfg.maxSize), cfg.maxSize,
+			)
+		}
+	}
+	// After this, the package is complete. If it doesn't make it into
+	// place, don't leave it lying around.
+	defer os.Remove(outputFile.Name())
+
+	if err := outputFile.Close(); err != nil {
+		return BuildResult{}, errors.Wrapf(err, "closing output file for %s", target.String())
+	}
+
+	outputName, err := RenderTenantOutputName(cfg.outputName, target, packageOptions.PackageVersion, packageOptions.Tenant)
+	if err != nil {
+		return BuildResult{}, errors.Wrapf(err, "naming output file for %s", target.String())
+	}
+
+	// TempFile creates files as 0600, which isn't what anyone expects
+	// of a package.
+	if err := os.Chmod(outputFile.Name(), 0644); err != nil {
+		return BuildResult{}, errors.Wrapf(err, "chmod output file for %s", target.String())
+	}
+
+	outputPath := filepath.Join(cfg.outputDir, outputName)
+	if err := os.Rename(outputFile.Name(), outputPath); err != nil {
+		return BuildResult{}, errors.Wrapf(err, "moving output file into place for %s", target.String())
+	}
+
+	size, sum, err := hashFile(outputPath)
+	if err != nil {
+		return BuildResult{}, errors.Wrapf(err, "hashing output file for %s", target.String())
+	}
+
+	if cfg.checksums {
+		if err := writeChecksumFile(outputPath, sum); err != nil {
+			return BuildResult{}, errors.Wrapf(err, "writing checksum file for %s", target.String())
+		}
+	}
+
+	var sbomPath string
+	if cfg.sbom != "" {
+		pkg, err := packageOptions.sbomPackage(sum)
+		if err != nil {
+			return BuildResult{}, errors.Wrapf(err, "describing sbom for %s", target.String())
+		}
+		if sbomPath, err = writeSBOM(outputPath, cfg.sbom, pkg); err != nil {
+			return BuildResult{}, errors.Wrapf(err, "writing sbom for %s", target.String())
+		}
+	}
+
+	level.Info(logger).Log(
+		"msg", "finished build",
+		"target", target.String(),
+		"path", outputPath,
+		"bytes", size,
+		"duration", time.Since(start).String(),
+	)
+
+	// Without bundled osquery, there's no osquery version to report
+	osqueryVersion := packageOptions.fetchedVersion(target.PlatformBinaryName("osqueryd"), packageOptions.OsqueryVersion)
+	if packageOptions.OmitOsquery {
+		osqueryVersion = ""
+	}
+
+	result := BuildResult{
+		Target:           target.String(),
+		Tenant:           packageOptions.Tenant,
+		Path:             outputPath,
+		Size:             size,
+		SHA256:           sum,
+		PackageVersion:   packageOptions.PackageVersion,
+		LauncherVersion:  packageOptions.fetchedVersion(target.PlatformBinaryName("launcher"), packageOptions.LauncherVersion),
+		OsqueryVersion:   osqueryVersion,
+		ExtensionVersion: packageOptions.fetchedVersion(packageOptions.extensionName(target), packageOptions.ExtensionVersion),
+		Inputs:           packageOptions.inputs,
+		SBOM:             sbomPath,
+	}
+	if cfg.timings {
+		result.Duration = time.Since(start)
+		result.Timings = packageOptions.timings
+	}
+
+	return result, nil
+}
+
+// removeOutput removes the output of a failed build, unless KeepTemp
+// is set.
+func removeOutput(ctx context.Context, packageOptions PackageOptions, target Target, path string) {
+	if packageOptions.KeepTemp {
+		level.Info(ctxlog.FromContext(ctx)).Log("msg", "keeping partial output", "target", target.String(), "path", path)
+		return
+	}
+	os.Remove(path)
+}
+
+// hashFile streams a file through sha256, returning its size and hex
+// encoded digest.
+func hashFile(path string) (int64, string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "opening file")
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, fh)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "reading file")
+	}
+
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksumFile writes <path>.sha256, in the format used by
+// sha256sum, so it can be checked with `sha256sum -c`.
+func writeChecksumFile(path, sum string) error {
+	contents := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	return ioutil.WriteFile(path+".sha256", []byte(contents), 0644)
+}
+
+// defaultOutputNameTemplate names packages as launcher.<target>.<ext>,
+// or launcher.<tenant>.<target>.<ext> when building for a tenant.
+const defaultOutputNameTemplate = "launcher.{{if .Tenant}}{{.Tenant}}.{{end}}{{.Target}}.{{.Ext}}"
+
+// outputNameData is the data available to output name templates
+type outputNameData struct {
+	Target         string
+	Platform       string
+	Init           string
+	Package        string
+	Arch           string
+	PackageVersion string
+	Ext            string
+	Tenant         string
+}
+
+// ParseOutputNameTemplate parses a text/template for naming output
+// files. An empty input uses the default naming. The template is
+// test rendered, so mistakes are caught before anything is built.
+func ParseOutputNameTemplate(input string) (*template.Template, error) {
+	if input == "" {
+		input = defaultOutputNameTemplate
+	}
+
+	tmpl, err := template.New("output_name").Option("missingkey=error").Parse(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing output name template")
+	}
+
+	testTarget := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	if _, err := RenderOutputName(tmpl, testTarget, "0.0.0"); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// RenderOutputName renders the output file name for a target.
+func RenderOutputName(tmpl *template.Template, target Target, packageVersion string) (string, error) {
+	return RenderTenantOutputName(tmpl, target, packageVersion, "")
+}
+
+// RenderTenantOutputName renders the output file name for a target,
+// built for tenant. See ParseSecretsFile.
+func RenderTenantOutputName(tmpl *template.Template, target Target, packageVersion, tenant string) (string, error) {
+	data := outputNameData{
+		Target:         target.String(),
+		Platform:       string(target.Platform),
+		Init:           string(target.Init),
+		Package:        string(target.Package),
+		Arch:           string(target.GetArch()),
+		PackageVersion: packageVersion,
+		Ext:            target.PkgExtension(),
+		Tenant:         tenant,
+	}
+
+	var name strings.Builder
+	if err := tmpl.Execute(&name, data); err != nil {
+		return "", errors.Wrap(err, "executing output name template")
+	}
+
+	if name.Len() == 0 || filepath.Base(name.String()) != name.String() {
+		return "", errors.Errorf("output name template produced an invalid file name %q", name.String())
+	}
+
+	return name.String(), nil
+}
+
+// validateOutputNames checks that tmpl names each of the targets'
+// packages differently, so they don't overwrite each other. The
+// package version is the same for every target, so if it's still to
+// be detected, a placeholder stands in for it.
+func validateOutputNames(tmpl *template.Template, targets []Target, packageVersion, tenant string) error {
+	if packageVersion == "" {
+		packageVersion = "0.0.0"
+	}
+
+	names := make(map[string]Target)
+	for _, target := range targets {
+		name, err := RenderTenantOutputName(tmpl, target, packageVersion, tenant)
+		if err != nil {
+			return errors.Wrapf(err, "naming output file for %s", target.String())
+		}
+		if other, ok := names[name]; ok {
+			return errors.Errorf("output name template names both %s and %s %s. Include more of the target, eg {{.Target}}", other.String(), target.String(), name)
+		}
+		names[name] = target
+	}
+	return nil
+}
+
+// ValidateTenantOutputName checks that tmpl names each tenant's
+// packages differently, so they don't overwrite each other.
+func ValidateTenantOutputName(tmpl *template.Template) error {
+	testTarget := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	names := make(map[string]bool)
+	for _, tenant := range []string{"tenant-a", "tenant-b"} {
+		name, err := RenderTenantOutputName(tmpl, testTarget, "0.0.0", tenant)
+		if err != nil {
+			return err
+		}
+		names[name] = true
+	}
+	if len(names) != 2 {
+		return errors.New("output name template must include {{.Tenant}} to build for multiple tenants")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package packaging
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/kolide/kit/version"
+	"github.com/pkg/errors"
+)
+
+// buildMetadataName is the file, in the config directory, that
+// records how a package was built.
+const buildMetadataName = "version.json"
+
+// BuildMetadata records how a package was built. It's embedded in each
+// package, as version.json in the config directory, so fleet
+// inventory can tell what's installed. See
+// PackageOptions.OmitBuildMetadata.
+type BuildMetadata struct {
+	PackageVersion        string            `json:"package_version"`
+	LauncherVersion       string            `json:"launcher_version"`
+	OsqueryVersion        string            `json:"osquery_version"`
+	ExtensionVersion      string            `json:"extension_version"`
+	Extensions            map[string]string `json:"extensions,omitempty"` // version of each additional extension, by name
+	Binaries              map[string]string `json:"binaries"`             // sha256 of each packaged binary, by name
+	Target                string            `json:"target"`
+	BuildTime             string            `json:"build_time"` // RFC3339. SourceDateEpoch, for reproducible builds.
+	PackageBuilderVersion string            `json:"package_builder_version"`
+}
+
+// buildMetadata describes the package being built. It's called once
+// the binaries are in place, and the package version is known.
+func (p *PackageOptions) buildMetadata() (*BuildMetadata, error) {
+	buildTime := p.SourceDateEpoch
+	if buildTime.IsZero() {
+		buildTime = time.Now()
+	}
+
+	metadata := &BuildMetadata{
+		PackageVersion:        p.PackageVersion,
+		LauncherVersion:       p.metadataVersion(p.target.PlatformBinaryName("launcher"), p.LauncherVersion),
+		OsqueryVersion:        p.metadataVersion(p.target.PlatformBinaryName("osqueryd"), p.OsqueryVersion),
+		ExtensionVersion:      p.metadataVersion(p.extensionName(p.target), p.ExtensionVersion),
+		Binaries:              make(map[string]string),
+		Target:                p.target.String(),
+		BuildTime:             buildTime.UTC().Format(time.RFC3339),
+		PackageBuilderVersion: version.Version().Version,
+	}
+
+	if p.OmitOsquery {
+		metadata.OsqueryVersion = "system"
+	}
+
+	if len(p.Extensions) > 0 {
+		metadata.Extensions = make(map[string]string)
+		for _, extension := range p.Extensions {
+			metadata.Extensions[extension.Name] = p.metadataVersion(extension.Name, extension.Version)
+		}
+	}
+
+	for _, name := range p.binaryNames(p.target) {
+		_, sum, err := hashFile(filepath.Join(p.packageRoot, p.binDir, name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "hashing binary %s", name)
+		}
+		metadata.Binaries[name] = sum
+	}
+
+	return metadata, nil
+}
+
+// metadataVersion is how a binary version is recorded in the build
+// metadata. Fetched binaries are recorded by their exact version, with
+// channels resolved, so inventory can tell hosts apart. Local binaries
+// are recorded as local, rather than leaking the build host's paths.
+func (p *PackageOptions) metadataVersion(binaryName, binaryVersion string) string {
+	if p.LocalBuildDir != "" || isLocalVersion(binaryVersion) {
+		return "local"
+	}
+	return p.fetchedVersion(binaryName, binaryVersion)
+}
+
+// writeBuildMetadata embeds the build metadata in the package, unless
+// OmitBuildMetadata is set. It's kept either way, for the SBOM.
+func (p *PackageOptions) writeBuildMetadata() error {
+	metadata, err := p.buildMetadata()
+	if err != nil {
+		return err
+	}
+	p.metadata = metadata
+
+	if p.OmitBuildMetadata {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding build metadata")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(p.packageRoot, p.confDir, buildMetadataName), append(contents, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing build metadata")
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package packaging
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMetadata(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion:  "1.2.3",
+		LauncherVersion: "stable",
+		LocalBuildDir:   binDir,
+		Hostname:        "device.example.com:443",
+		Identifier:      "kolide-app",
+		Secret:          "secret",
+		SourceDateEpoch: time.Unix(1600000000, 0),
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	var metadata BuildMetadata
+	require.NoError(t, json.Unmarshal(tarFile(t, results[0].Path, "etc/kolide-app/version.json"), &metadata))
+	require.Equal(t, "1.2.3", metadata.PackageVersion)
+	require.Equal(t, "local", metadata.LauncherVersion)
+	require.Equal(t, "linux-systemd-tar", metadata.Target)
+	require.Equal(t, "2020-09-13T12:26:40Z", metadata.BuildTime)
+	launcherSum := sha256.Sum256([]byte("launcher"))
+	require.Equal(t, hex.EncodeToString(launcherSum[:]), metadata.Binaries["launcher"])
+	require.Len(t, metadata.Binaries, 3)
+
+	// Pinned to the epoch, rebuilds are identical
+	rebuilt, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Equal(t, results[0].SHA256, rebuilt[0].SHA256)
+
+	po.OmitBuildMetadata = true
+	results, err = BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.NotContains(t, tarModes(t, results[0].Path), "etc/kolide-app/version.json")
+}
+
+func TestBuildMetadataResolvedVersions(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   "stable",
+		LauncherVersion:  filepath.Join(binDir, "launcher"),
+		ExtensionVersion: filepath.Join(binDir, "osquery-extension.ext"),
+		Hostname:         "device.example.com:443",
+		Identifier:       "kolide-app",
+		Secret:           "secret",
+		CacheDir:         outputDir,
+		NotaryURL:        notary.URL,
+		MirrorURL:        mirror.URL,
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// The channel is recorded as the version it pointed at
+	var metadata BuildMetadata
+	require.NoError(t, json.Unmarshal(tarFile(t, results[0].Path, "etc/kolide-app/version.json"), &metadata))
+	require.Equal(t, "1.2.3", metadata.OsqueryVersion)
+	require.Equal(t, "local", metadata.LauncherVersion)
+	require.Equal(t, "local", metadata.ExtensionVersion)
+}
+
+// tarFile returns the contents of name in a tar.gz package.
+func tarFile(t *testing.T, path, name string) []byte {
+	fh, err := os.Open(path)
+	require.NoError(t, err)
+	defer fh.Close()
+	gzr, err := gzip.NewReader(fh)
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Name == name {
+			contents, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			return contents
+		}
+	}
+	require.FailNow(t, "not in package", name)
+	return nil
+}
@@ -0,0 +1,751 @@
+package packaging
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAll(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   filepath.Join(binDir, "osqueryd"),
+		LauncherVersion:  filepath.Join(binDir, "launcher"),
+		ExtensionVersion: filepath.Join(binDir, "osquery-extension.ext"),
+		Hostname:         "device.example.com:443",
+		Identifier:       "kolide-app",
+		Secret:           "secret",
+	}
+
+	targets := []Target{
+		{Platform: Linux, Init: SystemD, Package: Tar},
+		{Platform: Darwin, Init: LaunchD, Package: Tar},
+		{Platform: Linux, Init: SystemD, Package: Msi}, // invalid
+	}
+
+	outputName, err := ParseOutputNameTemplate("{{.Platform}}-{{.PackageVersion}}.{{.Ext}}")
+	require.NoError(t, err)
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir, WithOutputName(outputName), WithMaxParallel(2), WithChecksums())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "linux-systemd-msi")
+	require.Len(t, results, 2)
+
+	// In the order of targets, whichever finished first
+	require.Equal(t, "linux-systemd-tar", results[0].Target)
+	require.Equal(t, "darwin-launchd-tar", results[1].Target)
+
+	for _, result := range results {
+		require.Equal(t, "1.2.3", result.PackageVersion)
+		require.FileExists(t, result.Path)
+		require.FileExists(t, result.Path+".sha256")
+	}
+
+	// Only the packages, and their checksums, are left behind
+	files, err := ioutil.ReadDir(outputDir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	require.ElementsMatch(t, []string{"linux-1.2.3.tar.gz", "linux-1.2.3.tar.gz.sha256", "darwin-1.2.3.tar.gz", "darwin-1.2.3.tar.gz.sha256"}, names)
+}
+
+func TestBuildAllMaxPackageSize(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   filepath.Join(binDir, "osqueryd"),
+		LauncherVersion:  filepath.Join(binDir, "launcher"),
+		ExtensionVersion: filepath.Join(binDir, "osquery-extension.ext"),
+		Hostname:         "device.example.com:443",
+		Identifier:       "kolide-app",
+		Secret:           "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir, WithMaxPackageSize(100))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "over the 100B (100 bytes) limit")
+	require.Empty(t, results)
+
+	files, err := ioutil.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	results, err = BuildAll(context.TODO(), po, targets, outputDir, WithMaxPackageSize(50*1000*1000))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+}
+
+func TestBuildAllLocalBuildDir(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	// The versions are ignored, so nothing is downloaded
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   "stable",
+		LauncherVersion:  "stable",
+		ExtensionVersion: "stable",
+		LocalBuildDir:    binDir,
+		NotaryURL:        "http://127.0.0.1:1",
+		Hostname:         "device.example.com:443",
+		Identifier:       "kolide-app",
+		Secret:           "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	require.NoError(t, po.CheckVersions(context.TODO(), targets))
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// Windows binaries have their own names
+	err = po.Validate(Target{Platform: Windows, Init: WindowsService, Package: Msi})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "osqueryd.exe")
+
+	require.NoError(t, os.Chmod(filepath.Join(binDir, "launcher"), 0644))
+	err = po.Validate(targets[0])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not executable")
+}
+
+func TestBuildWatchdogLimits(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion:           "1.2.3",
+		OsqueryVersion:           filepath.Join(binDir, "osqueryd"),
+		LauncherVersion:          filepath.Join(binDir, "launcher"),
+		ExtensionVersion:         filepath.Join(binDir, "osquery-extension.ext"),
+		Hostname:                 "device.example.com:443",
+		Identifier:               "kolide-app",
+		Secret:                   "secret",
+		WatchdogMemoryLimitMB:    250,
+		WatchdogUtilizationLimit: 20,
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// The flags end up in the systemd unit
+	fh, err := os.Open(results[0].Path)
+	require.NoError(t, err)
+	defer fh.Close()
+	gzr, err := gzip.NewReader(fh)
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+	var unit []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if strings.HasSuffix(hdr.Name, ".service") {
+			unit, err = ioutil.ReadAll(tr)
+			require.NoError(t, err)
+		}
+	}
+	require.Contains(t, string(unit), "--watchdog_memory_limit=250")
+	require.Contains(t, string(unit), "--watchdog_utilization_limit=20")
+
+	po.WatchdogMemoryLimitMB = -1
+	_, err = BuildAll(context.TODO(), po, targets, outputDir)
+	require.Error(t, err)
+}
+
+func TestBuildControl(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+	controlPEM := filepath.Join(binDir, "control.pem")
+	require.NoError(t, ioutil.WriteFile(controlPEM, testCertPEM(t, "control"), 0644))
+
+	po := PackageOptions{
+		PackageVersion:         "1.2.3",
+		LocalBuildDir:          binDir,
+		Hostname:               "device.example.com:443",
+		Identifier:             "kolide-app",
+		Secret:                 "secret",
+		Control:                true,
+		ControlHostname:        "control.example.com:443",
+		ControlRequestInterval: 30 * time.Second,
+		ControlRootPEM:         controlPEM,
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Contains(t, tarModes(t, results[0].Path), "etc/kolide-app/control-roots.pem")
+
+	config, err := po.LauncherConfig(targets[0])
+	require.NoError(t, err)
+	require.Equal(t, "30s", config.Environment["KOLIDE_CONTROL_GET_SHELLS_INTERVAL"])
+	require.Equal(t, "/etc/kolide-app/control-roots.pem", config.Environment["KOLIDE_LAUNCHER_CONTROL_ROOT_PEM"])
+
+	var tests = []func(*PackageOptions){
+		func(p *PackageOptions) { p.Control = false },
+		func(p *PackageOptions) { p.ControlRequestInterval = -time.Second },
+		func(p *PackageOptions) { p.DisableControlTLS = true },
+		func(p *PackageOptions) { p.ControlRootPEM = filepath.Join(binDir, "launcher") },
+	}
+	for i, mutate := range tests {
+		bad := po
+		mutate(&bad)
+		require.Error(t, bad.Validate(targets[0]), i)
+	}
+}
+
+func TestBuildFilePermissions(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	// Source modes aren't carried into the package
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0700))
+	}
+
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   filepath.Join(binDir, "osqueryd"),
+		LauncherVersion:  filepath.Join(binDir, "launcher"),
+		ExtensionVersion: filepath.Join(binDir, "osquery-extension.ext"),
+		Hostname:         "device.example.com:443",
+		Identifier:       "kolide-app",
+		Secret:           "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	modes := tarModes(t, results[0].Path)
+	require.Equal(t, int64(0600), modes["etc/kolide-app/secret"])
+	require.Equal(t, int64(0700), modes["etc/kolide-app/"])
+	require.Equal(t, int64(0700), modes["var/kolide-app/device.example.com-443/"])
+	require.Equal(t, int64(0755), modes["usr/local/kolide-app/bin/"])
+	require.Equal(t, int64(0755), modes["usr/local/kolide-app/bin/launcher"])
+	require.Equal(t, int64(0755), modes["usr/local/kolide-app/bin/osqueryd"])
+
+	po.SecretFileMode = 0440
+	results, err = BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Equal(t, int64(0440), tarModes(t, results[0].Path)["etc/kolide-app/secret"])
+
+	for _, bad := range []os.FileMode{0644 | os.ModeSetuid, 0200, 0620, 0666} {
+		po.SecretFileMode = bad
+		_, err = BuildAll(context.TODO(), po, targets, outputDir)
+		require.Error(t, err, bad.String())
+	}
+}
+
+// tarModes returns the permissions of each entry in a tar.gz package.
+func tarModes(t *testing.T, path string) map[string]int64 {
+	fh, err := os.Open(path)
+	require.NoError(t, err)
+	defer fh.Close()
+	gzr, err := gzip.NewReader(fh)
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+
+	modes := make(map[string]int64)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		modes[hdr.Name] = hdr.Mode & 0777
+	}
+	return modes
+}
+
+func TestBuildExtensionName(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "acme-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion: "1.2.3",
+		LocalBuildDir:  binDir,
+		ExtensionName:  "acme-extension.ext",
+		Hostname:       "device.example.com:443",
+		Identifier:     "kolide-app",
+		Secret:         "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	modes := tarModes(t, results[0].Path)
+	require.Contains(t, modes, "usr/local/kolide-app/bin/acme-extension.ext")
+	require.NotContains(t, modes, "usr/local/kolide-app/bin/osquery-extension.ext")
+
+	env, _ := (&PackageOptions{ExtensionName: "acme-extension.ext"}).launcherConfig()
+	require.Equal(t, "acme-extension.ext", env["KOLIDE_LAUNCHER_OSQUERY_EXTENSION_NAME"])
+
+	for _, bad := range []string{"bin/acme.ext", `..\acme.ext`, "..", "launcher"} {
+		po.ExtensionName = bad
+		require.Error(t, po.Validate(targets[0]), bad)
+	}
+}
+
+func TestBuildExtensions(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext", "acme-extension.ext", "other.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   filepath.Join(binDir, "osqueryd"),
+		LauncherVersion:  filepath.Join(binDir, "launcher"),
+		ExtensionVersion: filepath.Join(binDir, "osquery-extension.ext"),
+		Extensions: []ExtensionSpec{
+			{Name: "acme-extension.ext", Version: filepath.Join(binDir, "acme-extension.ext")},
+			{Name: "renamed.ext", Version: filepath.Join(binDir, "other.ext")},
+		},
+		Hostname:   "device.example.com:443",
+		Identifier: "kolide-app",
+		Secret:     "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	modes := tarModes(t, results[0].Path)
+	for _, name := range []string{"osquery-extension.ext", "acme-extension.ext", "renamed.ext"} {
+		require.Equal(t, int64(0755), modes["usr/local/kolide-app/bin/"+name], name)
+	}
+
+	// launcher autoloads them all, the standard extension first
+	po.target = targets[0]
+	env, _ := po.launcherConfig()
+	require.Equal(t, "osquery-extension.ext,acme-extension.ext,renamed.ext", env["KOLIDE_LAUNCHER_OSQUERY_EXTENSION_NAME"])
+}
+
+func TestBuildInstallPrefix(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion: "1.2.3",
+		LocalBuildDir:  binDir,
+		InstallPrefix:  "/opt/acme/",
+		Hostname:       "device.example.com:443",
+		Identifier:     "kolide-app",
+		Secret:         "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	modes := tarModes(t, results[0].Path)
+	require.Contains(t, modes, "opt/acme/kolide-app/bin/launcher")
+	require.Contains(t, modes, "opt/acme/etc/kolide-app/secret")
+	require.Contains(t, modes, "var/kolide-app/device.example.com-443/")
+	require.NotContains(t, modes, "usr/local/kolide-app/bin/launcher")
+
+	for _, bad := range []string{"opt/acme", "/opt/my acme"} {
+		po.InstallPrefix = bad
+		require.Error(t, po.Validate(targets[0]), bad)
+	}
+
+	po.InstallPrefix = "/opt/acme"
+	require.Error(t, po.Validate(Target{Platform: Windows, Init: WindowsService, Package: Msi}))
+}
+
+func TestBuildUpgradeOnly(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+	flagfile := filepath.Join(binDir, "osquery.flags")
+	require.NoError(t, ioutil.WriteFile(flagfile, []byte("--verbose\n"), 0644))
+
+	po := PackageOptions{
+		PackageVersion:  "1.2.3",
+		LocalBuildDir:   binDir,
+		OsqueryFlagfile: flagfile,
+		Hostname:        "device.example.com:443",
+		Identifier:      "kolide-app",
+		UpgradeOnly:     true,
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// Binaries and the init file are upgraded, config is left alone
+	modes := tarModes(t, results[0].Path)
+	require.Contains(t, modes, "usr/local/kolide-app/bin/launcher")
+	require.Contains(t, modes, "etc/systemd/system/launcher.kolide-app.service")
+	require.NotContains(t, modes, "etc/kolide-app/secret")
+	require.NotContains(t, modes, "etc/kolide-app/osquery.flags")
+
+	unit := tarFile(t, results[0].Path, "etc/systemd/system/launcher.kolide-app.service")
+	require.Contains(t, string(unit), "KOLIDE_LAUNCHER_OSQUERY_FLAGFILE=/etc/kolide-app/osquery.flags")
+}
+
+func TestBuildOmitOsquery(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	// There's no osqueryd to package
+	for _, name := range []string{"launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion: "1.2.3",
+		LocalBuildDir:  binDir,
+		OmitOsquery:    true,
+		OsquerydPath:   "/opt/osquery/bin/osqueryd",
+		Hostname:       "device.example.com:443",
+		Identifier:     "kolide-app",
+		Secret:         "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Empty(t, results[0].OsqueryVersion)
+
+	modes := tarModes(t, results[0].Path)
+	require.Contains(t, modes, "usr/local/kolide-app/bin/launcher")
+	require.NotContains(t, modes, "usr/local/kolide-app/bin/osqueryd")
+
+	unit := tarFile(t, results[0].Path, "etc/systemd/system/launcher.kolide-app.service")
+	require.Contains(t, string(unit), "KOLIDE_LAUNCHER_OSQUERYD_PATH=/opt/osquery/bin/osqueryd\n")
+
+	var tests = []struct {
+		omit   bool
+		path   string
+		target Target
+		valid  bool
+	}{
+		{omit: true, path: `C:\Program Files\osquery\osqueryd\osqueryd.exe`, target: Target{Platform: Windows, Init: WindowsService, Package: Msi}, valid: true},
+		{omit: true, target: targets[0]},
+		{omit: true, path: "osqueryd", target: targets[0]},
+		{omit: true, path: "/opt/osquery/bin/osqueryd", target: Target{Platform: Windows, Init: WindowsService, Package: Msi}},
+		{path: "/opt/osquery/bin/osqueryd", target: targets[0]},
+	}
+	for _, tt := range tests {
+		err := (&PackageOptions{OmitOsquery: tt.omit, OsquerydPath: tt.path}).validateOsquerydPath(tt.target)
+		if tt.valid {
+			require.NoError(t, err, tt.path)
+		} else {
+			require.Error(t, err, tt.path)
+		}
+	}
+}
+
+func TestBuildAllErrors(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	notary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer notary.Close()
+
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   "stable",
+		LauncherVersion:  filepath.Join(binDir, "launcher"),
+		ExtensionVersion: filepath.Join(binDir, "osquery-extension.ext"),
+		Hostname:         "device.example.com:443",
+		Identifier:       "kolide-app",
+		Secret:           "secret",
+		CacheDir:         outputDir,
+		NotaryURL:        notary.URL,
+	}
+
+	targets := []Target{
+		{Platform: Linux, Init: SystemD, Package: Tar},
+		{Platform: Linux, Init: SystemD, Package: Msi},
+	}
+
+	_, err = BuildAll(context.TODO(), po, targets, outputDir)
+	require.Error(t, err)
+
+	buildErr, ok := err.(*BuildAllError)
+	require.True(t, ok, "BuildAll returns a BuildAllError")
+	require.Len(t, buildErr.Errors, 2)
+
+	downloadErr, ok := errors.Cause(buildErr.Errors[0]).(*DownloadError)
+	require.True(t, ok, "failed download is a DownloadError: %v", buildErr.Errors[0])
+	require.Equal(t, "linux-systemd-tar", downloadErr.Target)
+	require.Equal(t, "osqueryd", downloadErr.Component)
+	require.True(t, downloadErr.Transient)
+
+	targetErr, ok := errors.Cause(buildErr.Errors[1]).(*UnsupportedTargetError)
+	require.True(t, ok, "invalid target is an UnsupportedTargetError: %v", buildErr.Errors[1])
+	require.Equal(t, targets[1], targetErr.Target)
+}
+
+func TestBuildAllResolvedVersions(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	cacheDir, err := ioutil.TempDir("", "packaging-build-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	for _, name := range []string{"launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	po := PackageOptions{
+		PackageVersion:   "1.2.3",
+		OsqueryVersion:   "stable",
+		LauncherVersion:  filepath.Join(binDir, "launcher"),
+		ExtensionVersion: filepath.Join(binDir, "osquery-extension.ext"),
+		Hostname:         "device.example.com:443",
+		Identifier:       "kolide-app",
+		Secret:           "secret",
+		CacheDir:         cacheDir,
+		NotaryURL:        notary.URL,
+		MirrorURL:        mirror.URL,
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// The channel is reported as the version it resolved to. Local
+	// binaries are reported as they were given.
+	require.Equal(t, "1.2.3", results[0].OsqueryVersion)
+	require.Equal(t, po.LauncherVersion, results[0].LauncherVersion)
+}
+
+func TestBuildAllOutputNameCollision(t *testing.T) {
+	t.Parallel()
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	// deb systemd and deb upstart would both be named the same, and
+	// the second to finish would overwrite the first
+	outputName, err := ParseOutputNameTemplate("launcher-{{.PackageVersion}}-{{.Platform}}-{{.Arch}}.{{.Ext}}")
+	require.NoError(t, err)
+
+	_, err = BuildAll(context.TODO(), PackageOptions{}, DefaultTargets(false), outputDir, WithOutputName(outputName))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "linux-systemd-deb and linux-upstart-deb")
+
+	// Nothing is built
+	files, err := ioutil.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	outputName, err = ParseOutputNameTemplate("launcher-{{.PackageVersion}}-{{.Target}}.{{.Ext}}")
+	require.NoError(t, err)
+	require.NoError(t, validateOutputNames(outputName, DefaultTargets(true), "", ""))
+}
+
+func TestOutputName(t *testing.T) {
+	t.Parallel()
+
+	target := Target{Platform: Linux, Init: SystemD, Package: Deb, Arch: Arm64}
+
+	tmpl, err := ParseOutputNameTemplate("")
+	require.NoError(t, err)
+	name, err := RenderOutputName(tmpl, target, "1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, "launcher.linux-systemd-deb-arm64.deb", name)
+
+	tmpl, err = ParseOutputNameTemplate("launcher_{{.PackageVersion}}_{{.Arch}}.{{.Ext}}")
+	require.NoError(t, err)
+	name, err = RenderOutputName(tmpl, target, "1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, "launcher_1.2.3_arm64.deb", name)
+
+	for _, bad := range []string{"{{.Nope}}", "{{", "../{{.Ext}}", "dir/launcher.{{.Ext}}"} {
+		_, err := ParseOutputNameTemplate(bad)
+		require.Error(t, err, bad)
+	}
+
+	// Tenants are in the default name, but custom ones have to ask
+	tmpl, err = ParseOutputNameTemplate("")
+	require.NoError(t, err)
+	name, err = RenderTenantOutputName(tmpl, target, "1.2.3", "acme")
+	require.NoError(t, err)
+	require.Equal(t, "launcher.acme.linux-systemd-deb-arm64.deb", name)
+	require.NoError(t, ValidateTenantOutputName(tmpl))
+
+	tmpl, err = ParseOutputNameTemplate("launcher_{{.PackageVersion}}_{{.Arch}}.{{.Ext}}")
+	require.NoError(t, err)
+	require.Error(t, ValidateTenantOutputName(tmpl))
+
+	tmpl, err = ParseOutputNameTemplate("{{.Tenant}}_launcher_{{.Arch}}.{{.Ext}}")
+	require.NoError(t, err)
+	require.NoError(t, ValidateTenantOutputName(tmpl))
+}
@@ -0,0 +1,148 @@
+package packaging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/pkg/errors"
+)
+
+// Builder makes packages of one format, from the package root, and
+// scripts, that Build prepares. The formats package-builder knows are
+// registered by their PackageFlavor. Others can be added with
+// RegisterBuilder, and built by using their flavor in a Target.
+type Builder interface {
+	// Build writes target's package to w. p.PackagekitOptions() has
+	// the package root, scripts, and metadata.
+	Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error
+
+	// Extension is the package file's extension, eg: deb
+	Extension() string
+
+	// Supports reports whether target can be built in this format.
+	// Its platform, init, and arch are already known to be valid.
+	Supports(target Target) bool
+
+	// Tools returns the external commands needed to build target
+	// with p. See RequiredTools.
+	Tools(p *PackageOptions, target Target) []string
+}
+
+// builders are the Builders of each package format. See
+// RegisterBuilder.
+var (
+	buildersMu sync.RWMutex
+	builders   = map[PackageFlavor]Builder{
+		Deb:        &fpmBuilder{extension: "deb", outputType: packagekit.AsDeb(), signingTool: "dpkg-sig", sysVInit: true},
+		Rpm:        &fpmBuilder{extension: "rpm", outputType: packagekit.AsRPM(), signingTool: "rpm"},
+		Pacman:     &fpmBuilder{extension: "pkg.tar.zst", outputType: packagekit.AsPacman()},
+		Pkg:        pkgBuilder{},
+		Tar:        tarBuilder{},
+		Msi:        &wixBuilder{extension: "msi", packageWix: packagekit.PackageWixMSI},
+		Chocolatey: &wixBuilder{extension: "nupkg", packageWix: packagekit.PackageChocolatey},
+		FreeBSDPkg: freeBSDBuilder{},
+		Snap:       snapBuilder{},
+		OCI:        ociBuilder{},
+	}
+)
+
+// RegisterBuilder makes builder the Builder of flavor packages. It
+// replaces any existing one, so can override the built in formats too.
+// It's usually called from an init function.
+func RegisterBuilder(flavor PackageFlavor, builder Builder) {
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+	builders[flavor] = builder
+}
+
+// LookupBuilder returns the Builder of flavor packages, if there is
+// one.
+func LookupBuilder(flavor PackageFlavor) (Builder, bool) {
+	buildersMu.RLock()
+	defer buildersMu.RUnlock()
+	builder, ok := builders[flavor]
+	return builder, ok
+}
+
+// PackageFlavors returns the package formats there are Builders for,
+// sorted.
+func PackageFlavors() []PackageFlavor {
+	buildersMu.RLock()
+	defer buildersMu.RUnlock()
+	flavors := []PackageFlavor{}
+	for flavor := range builders {
+		flavors = append(flavors, flavor)
+	}
+	sort.Slice(flavors, func(i, j int) bool { return flavors[i] < flavors[j] })
+	return flavors
+}
+
+// RequiredTools returns the external commands needed to build target
+// with these options.
+func (p *PackageOptions) RequiredTools(target Target) []string {
+	tools := p.packageTools(target)
+	if target.Arch == Universal && p.fetchesBinaries() {
+		tools = append(tools, "lipo")
+	}
+	if p.stripsBinaries(target) && !p.usesDocker(target) {
+		tools = append(tools, "strip")
+	}
+	return tools
+}
+
+// packageTools returns the external commands needed to make target's
+// package, per its Builder.
+func (p *PackageOptions) packageTools(target Target) []string {
+	builder, ok := LookupBuilder(target.Package)
+	if !ok {
+		return nil
+	}
+	return builder.Tools(p, target)
+}
+
+// MissingTools returns the commands needed to build target that
+// aren't in the PATH. An empty result means the target should be
+// buildable on this host.
+func (p *PackageOptions) MissingTools(target Target) []string {
+	return p.missingTools(target, exec.LookPath)
+}
+
+func (p *PackageOptions) missingTools(target Target, lookPath func(string) (string, error)) []string {
+	missing := []string{}
+	for _, tool := range p.RequiredTools(target) {
+		if _, err := lookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	return missing
+}
+
+// CheckTools returns an error naming the commands needed to build
+// target that aren't in the PATH. Build checks this first, so a
+// missing tool fails clearly, rather than as an exec error part way
+// through the build.
+func (p *PackageOptions) CheckTools(target Target) error {
+	return p.checkTools(target, exec.LookPath)
+}
+
+func (p *PackageOptions) checkTools(target Target, lookPath func(string) (string, error)) error {
+	missing := p.missingTools(target, lookPath)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(missing))
+	for i, tool := range missing {
+		quoted[i] = fmt.Sprintf("'%s'", tool)
+	}
+	if len(missing) == 1 {
+		return errors.Errorf("%s target requires %s which was not found in the PATH", target.Package, quoted[0])
+	}
+	return errors.Errorf("%s target requires %s which were not found in the PATH", target.Package, strings.Join(quoted, ", "))
+}
@@ -0,0 +1,138 @@
+package packaging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingTools(t *testing.T) {
+	t.Parallel()
+
+	// A linux host with docker, but none of the macOS tools
+	lookPath := func(file string) (string, error) {
+		if file == "docker" || file == "rpm" {
+			return "/usr/bin/" + file, nil
+		}
+		return "", exec.ErrNotFound
+	}
+
+	var tests = []struct {
+		target  Target
+		po      PackageOptions
+		missing []string
+	}{
+		{target: Target{Platform: Linux, Init: SystemD, Package: Tar}, missing: []string{}},
+		{target: Target{Platform: FreeBSD, Init: RCD, Package: FreeBSDPkg}, missing: []string{}},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Deb}, missing: []string{}},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Rpm}, po: PackageOptions{LinuxSigningKey: "ABCD"}, missing: []string{}},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Deb}, po: PackageOptions{LinuxSigningKey: "ABCD"}, missing: []string{"dpkg-sig"}},
+		{target: Target{Platform: Windows, Init: WindowsService, Package: Msi}, missing: []string{}},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Snap}, missing: []string{"snapcraft"}},
+		{target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, missing: []string{"pkgbuild"}},
+		{target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, po: PackageOptions{SigningKey: "Developer ID"}, missing: []string{"pkgbuild", "pkgutil"}},
+		{target: Target{Platform: Darwin, Init: LaunchD, Package: Tar, Arch: Universal}, po: PackageOptions{OsqueryVersion: "stable"}, missing: []string{"lipo"}},
+		{target: Target{Platform: Darwin, Init: LaunchD, Package: Tar, Arch: Universal}, po: PackageOptions{LocalBuildDir: "./build"}, missing: []string{}},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Deb}, po: PackageOptions{LinuxSigningKey: "ABCD", StripBinaries: true, UseDocker: true}, missing: []string{}},
+		{target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, po: PackageOptions{UseDocker: true}, missing: []string{"pkgbuild"}},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.missing, tt.po.missingTools(tt.target, lookPath), tt.target.String())
+	}
+}
+
+func TestCheckTools(t *testing.T) {
+	t.Parallel()
+
+	lookPath := func(file string) (string, error) {
+		return "", exec.ErrNotFound
+	}
+
+	po := PackageOptions{}
+	require.NoError(t, po.checkTools(Target{Platform: Linux, Init: SystemD, Package: Tar}, lookPath))
+
+	err := po.checkTools(Target{Platform: Linux, Init: SystemD, Package: Rpm}, lookPath)
+	require.EqualError(t, err, "rpm target requires 'docker' which was not found in the PATH")
+
+	po.SigningKey = "Developer ID"
+	err = po.checkTools(Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, lookPath)
+	require.EqualError(t, err, "pkg target requires 'pkgbuild', 'pkgutil' which were not found in the PATH")
+}
+
+// listBuilder is a custom package format, of the files in the package
+// root, one per line.
+type listBuilder struct{}
+
+func (listBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	root := p.PackagekitOptions().Root
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, filepath.ToSlash(rel))
+		return err
+	})
+}
+
+func (listBuilder) Extension() string { return "list" }
+
+func (listBuilder) Supports(target Target) bool { return target.Platform == Linux }
+
+func (listBuilder) Tools(p *PackageOptions, target Target) []string { return []string{"true"} }
+
+func TestRegisterBuilder(t *testing.T) {
+	t.Parallel()
+
+	const listPackage PackageFlavor = "test-list"
+	RegisterBuilder(listPackage, listBuilder{})
+	require.Contains(t, PackageFlavors(), listPackage)
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion: "1.2.3",
+		LocalBuildDir:  binDir,
+		Hostname:       "device.example.com:443",
+		Identifier:     "kolide-app",
+		Secret:         "secret",
+	}
+	target := Target{Platform: Linux, Init: SystemD, Package: listPackage}
+	require.Equal(t, "list", target.PkgExtension())
+	require.Equal(t, []string{"true"}, po.RequiredTools(target))
+
+	results, err := BuildAll(context.TODO(), po, []Target{target}, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "launcher.linux-systemd-test-list.list", filepath.Base(results[0].Path))
+
+	contents, err := ioutil.ReadFile(results[0].Path)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "usr/local/kolide-app/bin/launcher\n")
+	require.Contains(t, string(contents), "etc/systemd/system/launcher.kolide-app.service\n")
+
+	// The builder decides what it supports
+	require.Error(t, (&Target{Platform: Darwin, Init: LaunchD, Package: listPackage}).Validate())
+	require.Error(t, (&Target{Platform: Linux, Init: SystemD, Package: "test-missing"}).Validate())
+}
@@ -0,0 +1,209 @@
+package packaging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/pkg/errors"
+)
+
+// CacheEntry is a download in the cache, as checked by VerifyCache.
+type CacheEntry struct {
+	Path       string    // The cached package
+	TargetName string    // The TUF target it was downloaded as, eg: linux/osqueryd-stable.tar.gz
+	ModTime    time.Time // When it was downloaded
+	Err        error     // If set, the package doesn't match its TUF metadata
+	Pruned     bool      // Removed for being older than the prune age
+	Removed    bool      // Removed, for failing verification, or being pruned
+}
+
+type cacheOptions struct {
+	removeCorrupt bool
+	pruneAge      time.Duration
+}
+
+type CacheOpt func(*cacheOptions)
+
+// WithRemoveCorrupt removes downloads that don't match their TUF
+// metadata, so the next build downloads them afresh.
+func WithRemoveCorrupt() CacheOpt {
+	return func(co *cacheOptions) {
+		co.removeCorrupt = true
+	}
+}
+
+// WithPrune removes downloads made longer ago than age.
+func WithPrune(age time.Duration) CacheOpt {
+	return func(co *cacheOptions) {
+		co.pruneAge = age
+	}
+}
+
+// VerifyCache checks each download in localCacheDir, as cached by
+// FetchBinary, against the TUF metadata it was downloaded with. A
+// corrupt download is re-downloaded by FetchBinary anyway, but with
+// WithNoNetwork it fails the build, so this finds them beforehand.
+// Metadata that can't be read is an entry of its own. Entries are
+// returned sorted by path. Removing an entry removes its package, the
+// binary extracted from it, and its metadata.
+//
+// FetchBinary only locks the cache against fetches in the same
+// process, so other builds shouldn't use the cache at the same time.
+func VerifyCache(ctx context.Context, localCacheDir string, opts ...CacheOpt) ([]CacheEntry, error) {
+	logger := ctxlog.FromContext(ctx)
+	co := &cacheOptions{}
+	for _, opt := range opts {
+		opt(co)
+	}
+
+	if _, err := os.Stat(localCacheDir); err != nil {
+		return nil, errors.Wrap(err, "cache dir")
+	}
+
+	metaPaths, err := filepath.Glob(filepath.Join(localCacheDir, "*.tuf.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing cached TUF metadata")
+	}
+
+	// Packages may have more than one metadata file, as a pinned hash
+	// shares the package of the version it resolves to.
+	type cachedPackage struct {
+		key       string
+		metaPaths []string
+		metas     []*cachedTarget
+	}
+	packages := make(map[string]*cachedPackage)
+	entries := []CacheEntry{}
+	for _, metaPath := range metaPaths {
+		cached, err := loadCachedTarget(metaPath)
+		var key string
+		if err == nil {
+			key, err = cachedPackageKey(filepath.Base(metaPath), cached)
+		}
+		if err != nil {
+			// Unreadable metadata is corrupt too. FetchBinary
+			// overwrites it, but WithNoNetwork builds can't.
+			entry := CacheEntry{Path: metaPath, Err: err}
+			if info, statErr := os.Stat(metaPath); statErr == nil {
+				entry.ModTime = info.ModTime()
+			}
+			entry.Pruned = co.pruneAge > 0 && time.Since(entry.ModTime) > co.pruneAge
+			if entry.Pruned || co.removeCorrupt {
+				if err := os.Remove(metaPath); err != nil {
+					return nil, errors.Wrapf(err, "removing %s from the cache", metaPath)
+				}
+				entry.Removed = true
+			}
+			entries = append(entries, entry)
+			continue
+		}
+
+		pkg, ok := packages[key]
+		if !ok {
+			pkg = &cachedPackage{key: key}
+			packages[key] = pkg
+		}
+		pkg.metaPaths = append(pkg.metaPaths, metaPath)
+		pkg.metas = append(pkg.metas, cached)
+	}
+
+	for _, pkg := range packages {
+		packagePath := filepath.Join(localCacheDir, fmt.Sprintf("%s.tar.gz", pkg.key))
+		unlock := lockFetch(packagePath)
+
+		info, err := os.Stat(packagePath)
+		if os.IsNotExist(err) {
+			// Only the metadata was cached, such as when a download
+			// failed. Its partial download is pruned like a package.
+			if info, err := os.Stat(packagePath + ".partial"); err == nil && co.pruneAge > 0 && time.Since(info.ModTime()) > co.pruneAge {
+				os.Remove(packagePath + ".partial")
+			}
+			unlock()
+			continue
+		} else if err != nil {
+			unlock()
+			return nil, errors.Wrapf(err, "checking cached package %s", packagePath)
+		}
+
+		entry := CacheEntry{
+			Path:       packagePath,
+			TargetName: pkg.metas[0].TargetName,
+			ModTime:    info.ModTime(),
+		}
+
+		// It's fine if it matches any of its metadata, as fetches
+		// check it against the metadata they looked up.
+		for _, meta := range pkg.metas {
+			if entry.Err = meta.Meta.verify(packagePath); entry.Err == nil {
+				break
+			}
+		}
+
+		entry.Pruned = co.pruneAge > 0 && time.Since(entry.ModTime) > co.pruneAge
+		if entry.Pruned || (entry.Err != nil && co.removeCorrupt) {
+			removePaths := append([]string{packagePath, packagePath + ".partial", filepath.Join(localCacheDir, pkg.key)}, pkg.metaPaths...)
+			for _, removePath := range removePaths {
+				if err := os.RemoveAll(removePath); err != nil {
+					unlock()
+					return nil, errors.Wrapf(err, "removing %s from the cache", removePath)
+				}
+			}
+			entry.Removed = true
+		}
+		unlock()
+
+		level.Debug(logger).Log("msg", "checked cached download", "path", packagePath, "target", entry.TargetName, "err", entry.Err, "removed", entry.Removed)
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// cachedPackageKey returns the cacheKey of the package that the TUF
+// metadata, cached as metaName, was fetched for. That's named for the
+// resolved version, which, for pinned hashes, isn't the one in
+// metaName. See metaCacheName.
+func cachedPackageKey(metaName string, cached *cachedTarget) (string, error) {
+	invalid := errors.Errorf("can't tell which package the cached TUF metadata %s is for", metaName)
+
+	// Target names are <platform>/<baseName>-<version>.tar.gz, with
+	// an /<arch> after the platform, unless it's amd64.
+	dir, file := path.Split(cached.TargetName)
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	platform, arch := parts[0], string(Amd64)
+	switch {
+	case len(parts) == 2:
+		arch = parts[1]
+	case len(parts) > 2 || platform == "":
+		return "", invalid
+	}
+
+	baseName := strings.TrimSuffix(file, fmt.Sprintf("-%s.tar.gz", cached.Version))
+	if baseName == file {
+		return "", invalid
+	}
+
+	// metaName is <name>-<version>-<platform>-<arch>.tuf.json. name
+	// is baseName, and an extension, if it has one.
+	stem := strings.TrimSuffix(metaName, fmt.Sprintf("-%s-%s.tuf.json", platform, arch))
+	if stem == metaName || !strings.HasPrefix(stem, baseName+".") && !strings.HasPrefix(stem, baseName+"-") {
+		return "", invalid
+	}
+	ext := strings.TrimPrefix(stem, baseName)
+	i := strings.Index(ext, "-")
+	if i < 0 {
+		return "", invalid
+	}
+	ext = ext[:i]
+
+	return cacheKey(baseName+ext, cached.Version, platform, arch), nil
+}
@@ -0,0 +1,144 @@
+package packaging
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCache(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-verify-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	for _, version := range []string{"stable", "1.2.3", "sha256:" + hex.EncodeToString(release.publishedSum())} {
+		_, err := FetchBinary(context.TODO(), cacheDir, "osqueryd", version, "linux", "", WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+		require.NoError(t, err)
+	}
+
+	stablePath := filepath.Join(cacheDir, "osqueryd-stable-linux-amd64.tar.gz")
+	versionPath := filepath.Join(cacheDir, "osqueryd-1.2.3-linux-amd64.tar.gz")
+
+	// The pinned hash shares the version's package
+	entries, err := VerifyCache(context.TODO(), cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, versionPath, entries[0].Path)
+	require.Equal(t, "linux/osqueryd-1.2.3.tar.gz", entries[0].TargetName)
+	require.Equal(t, stablePath, entries[1].Path)
+	for _, entry := range entries {
+		require.NoError(t, entry.Err)
+		require.False(t, entry.Removed)
+	}
+
+	// Corrupt packages are reported, and only removed when asked
+	require.NoError(t, ioutil.WriteFile(stablePath, []byte("corrupt"), 0644))
+	entries, err = VerifyCache(context.TODO(), cacheDir)
+	require.NoError(t, err)
+	require.Error(t, entries[1].Err)
+	require.False(t, entries[1].Removed)
+	require.FileExists(t, stablePath)
+
+	entries, err = VerifyCache(context.TODO(), cacheDir, WithRemoveCorrupt())
+	require.NoError(t, err)
+	require.True(t, entries[1].Removed)
+	require.NoError(t, entries[0].Err)
+	require.False(t, entries[0].Removed)
+	for _, name := range []string{"osqueryd-stable-linux-amd64.tar.gz", "osqueryd-stable-linux-amd64", "osqueryd-stable-linux-amd64.tuf.json"} {
+		_, err := os.Stat(filepath.Join(cacheDir, name))
+		require.True(t, os.IsNotExist(err), name)
+	}
+
+	// Unreadable metadata is corrupt too
+	badMetaPath := filepath.Join(cacheDir, "launcher-stable-linux-amd64.tuf.json")
+	require.NoError(t, ioutil.WriteFile(badMetaPath, []byte("{"), 0644))
+	entries, err = VerifyCache(context.TODO(), cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, badMetaPath, entries[0].Path)
+	require.Error(t, entries[0].Err)
+	require.NoError(t, entries[1].Err)
+
+	// Pruning removes old downloads, along with all their metadata
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(versionPath, old, old))
+	entries, err = VerifyCache(context.TODO(), cacheDir, WithPrune(24*time.Hour))
+	require.NoError(t, err)
+	require.False(t, entries[0].Pruned)
+	require.True(t, entries[1].Pruned)
+	require.True(t, entries[1].Removed)
+	metaPaths, err := filepath.Glob(filepath.Join(cacheDir, "osqueryd-*"))
+	require.NoError(t, err)
+	require.Empty(t, metaPaths)
+
+	_, err = VerifyCache(context.TODO(), filepath.Join(cacheDir, "missing"))
+	require.Error(t, err)
+}
+
+func TestCachedPackageKey(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		metaName string
+		target   cachedTarget
+		key      string
+	}{
+		{
+			metaName: "osqueryd-stable-linux-amd64.tuf.json",
+			target:   cachedTarget{TargetName: "linux/osqueryd-stable.tar.gz", Version: "stable"},
+			key:      "osqueryd-stable-linux-amd64",
+		},
+		{
+			metaName: "osqueryd-sha256-abcdef-linux-amd64.tuf.json",
+			target:   cachedTarget{TargetName: "linux/osqueryd-1.2.3.tar.gz", Version: "1.2.3"},
+			key:      "osqueryd-1.2.3-linux-amd64",
+		},
+		{
+			metaName: "osquery-extension.ext-beta-darwin-arm64.tuf.json",
+			target:   cachedTarget{TargetName: "darwin/arm64/osquery-extension-beta.tar.gz", Version: "beta"},
+			key:      "osquery-extension.ext-beta-darwin-arm64",
+		},
+		{
+			metaName: "launcher.exe-0.11.4-windows-amd64.tuf.json",
+			target:   cachedTarget{TargetName: "windows/launcher-0.11.4.tar.gz", Version: "0.11.4"},
+			key:      "launcher.exe-0.11.4-windows-amd64",
+		},
+	}
+	for _, tt := range tests {
+		key, err := cachedPackageKey(tt.metaName, &tt.target)
+		require.NoError(t, err, tt.metaName)
+		require.Equal(t, tt.key, key, tt.metaName)
+	}
+
+	var invalid = []struct {
+		metaName string
+		target   cachedTarget
+	}{
+		{"osqueryd-stable-linux-amd64.tuf.json", cachedTarget{TargetName: "osqueryd-stable.tar.gz", Version: "stable"}},
+		{"osqueryd-stable-linux-amd64.tuf.json", cachedTarget{TargetName: "linux/osqueryd-stable.tar.gz", Version: "beta"}},
+		{"osqueryd-stable-darwin-amd64.tuf.json", cachedTarget{TargetName: "linux/osqueryd-stable.tar.gz", Version: "stable"}},
+		{"launcher-stable-linux-amd64.tuf.json", cachedTarget{TargetName: "linux/osqueryd-stable.tar.gz", Version: "stable"}},
+	}
+	for _, tt := range invalid {
+		_, err := cachedPackageKey(tt.metaName, &tt.target)
+		require.Error(t, err, tt.metaName)
+	}
+}
@@ -0,0 +1,69 @@
+package packaging
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// channelRegexp is what TUF channel, and version, names look like.
+var channelRegexp = regexp.MustCompile(`^[a-zA-Z0-9._+-]+$`)
+
+// ChannelAliases maps an organization's names for channels, like prod
+// or canary, to the TUF channels, or versions, they stand for. An
+// alias may point at another alias. See ParseChannelAliases.
+type ChannelAliases map[string]string
+
+// ParseChannelAliases parses alias=channel pairs. Each alias must
+// resolve, possibly through other aliases, to a TUF channel or exact
+// version. Aliases can't form cycles, and can't resolve to local paths
+// or sha256 pins, as they're shared by every binary. Whether the
+// channel exists is left to the download, or CheckVersions.
+func ParseChannelAliases(specs []string) (ChannelAliases, error) {
+	aliases := make(ChannelAliases)
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i < 1 || i == len(spec)-1 {
+			return nil, errors.Errorf("invalid channel alias %q. Expected alias=channel", spec)
+		}
+		alias, channel := spec[:i], spec[i+1:]
+
+		for _, name := range []string{alias, channel} {
+			if !channelRegexp.MatchString(name) {
+				return nil, errors.Errorf("invalid channel alias %q. %q isn't a channel name", spec, name)
+			}
+		}
+		if existing, ok := aliases[alias]; ok {
+			return nil, errors.Errorf("channel alias %s is set to both %s and %s", alias, existing, channel)
+		}
+		aliases[alias] = channel
+	}
+
+	for alias := range aliases {
+		if _, err := aliases.Resolve(alias); err != nil {
+			return nil, err
+		}
+	}
+	return aliases, nil
+}
+
+// Resolve follows version through the aliases, to the channel or
+// version it stands for. Versions that aren't aliases are returned as
+// they are.
+func (a ChannelAliases) Resolve(version string) (string, error) {
+	seen := []string{version}
+	for {
+		next, ok := a[version]
+		if !ok {
+			return version, nil
+		}
+		for _, s := range seen {
+			if s == next {
+				return "", errors.Errorf("channel aliases form a cycle: %s -> %s", strings.Join(seen, " -> "), next)
+			}
+		}
+		seen = append(seen, next)
+		version = next
+	}
+}
@@ -0,0 +1,48 @@
+package packaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChannelAliases(t *testing.T) {
+	t.Parallel()
+
+	aliases, err := ParseChannelAliases([]string{"prod=stable", "canary=beta", "qa=canary", "pinned=0.11.4"})
+	require.NoError(t, err)
+
+	var resolveTests = []struct {
+		in, out string
+	}{
+		{in: "prod", out: "stable"},
+		{in: "qa", out: "beta"},
+		{in: "pinned", out: "0.11.4"},
+		{in: "stable", out: "stable"},
+		{in: "./build/launcher", out: "./build/launcher"},
+	}
+	for _, tt := range resolveTests {
+		resolved, err := aliases.Resolve(tt.in)
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.out, resolved, tt.in)
+	}
+
+	var tests = [][]string{
+		{"prod"},
+		{"prod="},
+		{"=stable"},
+		{"prod=./build/launcher"},
+		{"prod=sha256:abcdef"},
+		{"prod=stable", "prod=beta"},
+		{"prod=prod"},
+		{"prod=canary", "canary=qa", "qa=prod"},
+	}
+	for _, specs := range tests {
+		_, err := ParseChannelAliases(specs)
+		require.Error(t, err, specs)
+	}
+
+	_, err = ParseChannelAliases([]string{"prod=canary", "canary=prod"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cycle")
+}
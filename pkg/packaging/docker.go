@@ -0,0 +1,58 @@
+package packaging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// dockerImage is the linux image that, with UseDocker, linux targets'
+// build tools run in. It's the one packagekit builds linux packages
+// with, so the tools match the packaging.
+const dockerImage = "kolide/fpm"
+
+// usesDocker reports whether target's build runs its tools in docker,
+// rather than on the host. Only linux targets' do. Windows packages
+// are already built in docker, and darwin ones need macOS.
+func (p *PackageOptions) usesDocker(target Target) bool {
+	return p.UseDocker && target.Platform == Linux
+}
+
+// validateUseDocker checks that target can be built in docker. snaps
+// can't, as snapcraft needs the host's snapd.
+func (p *PackageOptions) validateUseDocker(target Target) error {
+	if p.usesDocker(target) && target.Package == Snap {
+		return errors.New("snaps are built with the host's snapcraft, and can't be built in docker")
+	}
+	return nil
+}
+
+// checkDocker fails, clearly, if docker is installed, but isn't
+// usable, eg: as its daemon isn't running.
+func (p *PackageOptions) checkDocker(ctx context.Context) error {
+	if _, err := p.execOut(ctx, "docker", "info", "--format", "{{.ServerVersion}}"); err != nil {
+		return errors.Wrap(err, "building in docker, but docker isn't available. Is it running?")
+	}
+	return nil
+}
+
+// execTargetOut runs a build tool for the target, like execOut. With
+// UseDocker, linux targets' tools run in a container, with dir
+// mounted at the same path, so paths under it work in both.
+func (p *PackageOptions) execTargetOut(ctx context.Context, dir, argv0 string, args ...string) (string, error) {
+	if !p.usesDocker(p.target) {
+		return p.execOut(ctx, argv0, args...)
+	}
+
+	dockerArgs := []string{
+		"run", "--rm",
+		"-v", fmt.Sprintf("%s:%s", dir, dir),
+	}
+	if p.NoNetwork {
+		dockerArgs = append(dockerArgs, "--network", "none", "--pull", "never")
+	}
+	dockerArgs = append(dockerArgs, dockerImage, argv0)
+
+	return p.execOut(ctx, "docker", append(dockerArgs, args...)...)
+}
@@ -0,0 +1,71 @@
+package packaging
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecTargetOut(t *testing.T) {
+	t.Parallel()
+
+	var ran []string
+	recordCommand := func(ctx context.Context, command string, args ...string) *exec.Cmd {
+		ran = append([]string{command}, args...)
+		return exec.CommandContext(ctx, "true")
+	}
+
+	linux := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	darwin := Target{Platform: Darwin, Init: LaunchD, Package: Pkg}
+
+	// Without UseDocker, tools run on the host
+	p := &PackageOptions{target: linux, execCC: recordCommand}
+	_, err := p.execTargetOut(context.TODO(), "/tmp/bin", "strip", "/tmp/bin/launcher")
+	require.NoError(t, err)
+	require.Equal(t, []string{"strip", "/tmp/bin/launcher"}, ran)
+
+	// With it, linux targets' tools run in the container
+	p = &PackageOptions{target: linux, UseDocker: true, NoNetwork: true, execCC: recordCommand}
+	_, err = p.execTargetOut(context.TODO(), "/tmp/bin", "strip", "/tmp/bin/launcher")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"docker", "run", "--rm",
+		"-v", "/tmp/bin:/tmp/bin",
+		"--network", "none", "--pull", "never",
+		"kolide/fpm", "strip", "/tmp/bin/launcher",
+	}, ran)
+
+	// Other platforms' still run on the host
+	p = &PackageOptions{target: darwin, UseDocker: true, execCC: recordCommand}
+	_, err = p.execTargetOut(context.TODO(), "/tmp/bin", "strip", "/tmp/bin/launcher")
+	require.NoError(t, err)
+	require.Equal(t, []string{"strip", "/tmp/bin/launcher"}, ran)
+}
+
+func TestCheckDocker(t *testing.T) {
+	t.Parallel()
+
+	p := &PackageOptions{execCC: func(ctx context.Context, command string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "false")
+	}}
+	err := p.checkDocker(context.TODO())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "docker isn't available")
+
+	p.execCC = func(ctx context.Context, command string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "true")
+	}
+	require.NoError(t, p.checkDocker(context.TODO()))
+}
+
+func TestValidateUseDocker(t *testing.T) {
+	t.Parallel()
+
+	p := &PackageOptions{UseDocker: true}
+	require.NoError(t, p.validateUseDocker(Target{Platform: Linux, Init: SystemD, Package: Rpm}))
+	require.NoError(t, p.validateUseDocker(Target{Platform: Darwin, Init: LaunchD, Package: Pkg}))
+	require.Error(t, p.validateUseDocker(Target{Platform: Linux, Init: SystemD, Package: Snap}))
+	require.NoError(t, (&PackageOptions{}).validateUseDocker(Target{Platform: Linux, Init: SystemD, Package: Snap}))
+}
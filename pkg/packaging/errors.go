@@ -0,0 +1,63 @@
+package packaging
+
+import (
+	"fmt"
+	"strings"
+)
+
+// These error types let callers handle failures programmatically, eg:
+// to retry transient download failures, but not bad targets. They're
+// often wrapped with more context, so check them with errors.Cause:
+//
+//	if de, ok := errors.Cause(err).(*packaging.DownloadError); ok && de.Transient {
+//
+// They deliberately don't implement Cause themselves, so that's where
+// errors.Cause stops.
+
+// UnsupportedTargetError is returned for targets that can't be built.
+type UnsupportedTargetError struct {
+	Target Target
+	Err    error
+}
+
+func (e *UnsupportedTargetError) Error() string {
+	return fmt.Sprintf("invalid target %s: %s", e.Target.String(), e.Err)
+}
+
+// DownloadError is returned when a binary can't be fetched.
+type DownloadError struct {
+	Target    string // eg: darwin-launchd-pkg
+	Component string // the binary, eg: osqueryd
+	Version   string
+	Transient bool // Network, or server, failures that may succeed on retry
+	Err       error
+}
+
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("could not fetch path to binary %s %s: %s", e.Component, e.Version, e.Err)
+}
+
+// SigningError is returned when a package can't be signed, has an
+// invalid signature, or fails notarization.
+type SigningError struct {
+	Target string
+	Err    error
+}
+
+func (e *SigningError) Error() string {
+	return fmt.Sprintf("signing %s: %s", e.Target, e.Err)
+}
+
+// BuildAllError is returned by BuildAll when any target fails. Errors
+// has one entry for each failed target.
+type BuildAllError struct {
+	Errors []error
+}
+
+func (e *BuildAllError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("could not generate packages:\n  %s", strings.Join(msgs, "\n  "))
+}
@@ -0,0 +1,88 @@
+package packaging
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExtensionSpec is an additional osquery extension to package, next to
+// launcher, and have launcher autoload, alongside the standard, or
+// ExtensionName's, one.
+type ExtensionSpec struct {
+	Name    string // File name it's installed as, eg: acme-extension.ext
+	Version string // A channel, version, or local path, like ExtensionVersion
+}
+
+// ParseExtensionSpec parses an extension, as either a local path,
+// packaged under its file name, or name=version, where version is a
+// channel, version, or local path, eg: acme-extension.ext=stable.
+func ParseExtensionSpec(spec string) (ExtensionSpec, error) {
+	if i := strings.Index(spec, "="); i >= 0 {
+		name, version := spec[:i], spec[i+1:]
+		if name == "" || version == "" {
+			return ExtensionSpec{}, errors.Errorf("invalid extension %q. Expected a path, or name=version", spec)
+		}
+		return ExtensionSpec{Name: name, Version: version}, nil
+	}
+
+	if !isLocalVersion(spec) {
+		return ExtensionSpec{}, errors.Errorf("extension %s needs a name to fetch it by, as name=%s", spec, spec)
+	}
+	return ExtensionSpec{Name: filepath.Base(spec), Version: spec}, nil
+}
+
+// ParseExtensionSpecs parses each of specs. See ParseExtensionSpec.
+func ParseExtensionSpecs(specs []string) ([]ExtensionSpec, error) {
+	extensions := []ExtensionSpec{}
+	for _, spec := range specs {
+		extension, err := ParseExtensionSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions, nil
+}
+
+// validateExtensions checks the additional extensions can be packaged
+// for target, and that no two extensions share a name, as they'd be
+// installed over each other.
+func (p *PackageOptions) validateExtensions(target Target) error {
+	if len(p.Extensions) == 0 {
+		return nil
+	}
+
+	if p.FetchAtInstall {
+		return errors.New("fetch at install can't download additional extensions")
+	}
+
+	seen := map[string]bool{p.extensionName(target): true}
+	for _, extension := range p.Extensions {
+		if extension.Name == "" || extension.Version == "" {
+			return errors.Errorf("extension %s=%s needs a name and a version", extension.Name, extension.Version)
+		}
+		if err := validateExtensionName(extension.Name); err != nil {
+			return err
+		}
+		if err := validateScriptValue("extension name", extension.Name, target); err != nil {
+			return err
+		}
+		if seen[extension.Name] {
+			return errors.Errorf("extension name %s is used more than once", extension.Name)
+		}
+		seen[extension.Name] = true
+	}
+	return nil
+}
+
+// extensionNames returns the file names of every extension packaged
+// for target, the standard, or ExtensionName's, one first.
+func (p *PackageOptions) extensionNames(target Target) []string {
+	names := []string{p.extensionName(target)}
+	for _, extension := range p.Extensions {
+		names = append(names, extension.Name)
+	}
+	return names
+}
@@ -0,0 +1,78 @@
+package packaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtensionSpec(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		spec     string
+		expected ExtensionSpec
+	}{
+		{spec: "./build/acme-extension.ext", expected: ExtensionSpec{Name: "acme-extension.ext", Version: "./build/acme-extension.ext"}},
+		{spec: "/opt/build/acme.ext", expected: ExtensionSpec{Name: "acme.ext", Version: "/opt/build/acme.ext"}},
+		{spec: "acme-extension.ext=stable", expected: ExtensionSpec{Name: "acme-extension.ext", Version: "stable"}},
+		{spec: "renamed.ext=./build/acme.ext", expected: ExtensionSpec{Name: "renamed.ext", Version: "./build/acme.ext"}},
+	}
+
+	for _, tt := range tests {
+		spec, err := ParseExtensionSpec(tt.spec)
+		require.NoError(t, err, tt.spec)
+		require.Equal(t, tt.expected, spec)
+	}
+
+	// Channels need a name to fetch them by
+	for _, bad := range []string{"stable", "=stable", "acme.ext=", ""} {
+		_, err := ParseExtensionSpec(bad)
+		require.Error(t, err, bad)
+	}
+
+	specs, err := ParseExtensionSpecs([]string{"a.ext=stable", "./b.ext"})
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+
+	_, err = ParseExtensionSpecs([]string{"a.ext=stable", "nightly"})
+	require.Error(t, err)
+}
+
+func TestValidateExtensions(t *testing.T) {
+	t.Parallel()
+
+	linux := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	windows := Target{Platform: Windows, Init: WindowsService, Package: Msi}
+
+	p := &PackageOptions{Extensions: []ExtensionSpec{
+		{Name: "acme.ext", Version: "stable"},
+		{Name: "other.ext", Version: "./build/other.ext"},
+	}}
+	require.NoError(t, p.validateExtensions(linux))
+	require.Equal(t, []string{"osquery-extension.ext", "acme.ext", "other.ext"}, p.extensionNames(linux))
+	require.Equal(t, []string{"osquery-extension.exe", "acme.ext", "other.ext"}, p.extensionNames(windows))
+
+	for _, extensions := range [][]ExtensionSpec{
+		// Duplicates, of each other, or of the first extension
+		{{Name: "acme.ext", Version: "stable"}, {Name: "acme.ext", Version: "nightly"}},
+		{{Name: "osquery-extension.ext", Version: "stable"}},
+		// Names that aren't file names, or are another binary's
+		{{Name: "bin/acme.ext", Version: "stable"}},
+		{{Name: "launcher", Version: "stable"}},
+		{{Name: "acme$(id).ext", Version: "stable"}},
+		// Missing versions
+		{{Name: "acme.ext"}},
+	} {
+		p := &PackageOptions{Extensions: extensions}
+		require.Error(t, p.validateExtensions(linux), "%v", extensions)
+	}
+
+	// A custom first extension can't be repeated either
+	p = &PackageOptions{ExtensionName: "acme.ext", Extensions: []ExtensionSpec{{Name: "acme.ext", Version: "stable"}}}
+	require.Error(t, p.validateExtensions(linux))
+
+	// The postinstall only downloads the standard binaries
+	p = &PackageOptions{FetchAtInstall: true, Extensions: []ExtensionSpec{{Name: "acme.ext", Version: "stable"}}}
+	require.Error(t, p.validateExtensions(linux))
+}
@@ -0,0 +1,85 @@
+package packaging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kolide/kit/fs"
+	"github.com/pkg/errors"
+)
+
+// ParseExtraFiles parses src:dest pairs into a map of destination to
+// source, as used by PackageOptions.ExtraFiles. Sources must be
+// existing files. The last colon separates the two, so windows
+// sources, like C:\ca.pem:etc/ca.pem, work.
+func ParseExtraFiles(specs []string) (map[string]string, error) {
+	extraFiles := make(map[string]string)
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, ":")
+		if i < 1 || i == len(spec)-1 {
+			return nil, errors.Errorf("invalid extra file %q. Expected src:dest", spec)
+		}
+		src, dest := spec[:i], spec[i+1:]
+
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extra file %s", src)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, errors.Errorf("extra file %s is not a regular file", src)
+		}
+
+		if err := validateExtraFileDest(dest); err != nil {
+			return nil, err
+		}
+
+		if existing, ok := extraFiles[dest]; ok {
+			return nil, errors.Errorf("extra files %s and %s have the same destination %s", existing, src, dest)
+		}
+		extraFiles[dest] = src
+	}
+	return extraFiles, nil
+}
+
+// validateExtraFileDest checks that dest is a path inside the package
+// root.
+func validateExtraFileDest(dest string) error {
+	if filepath.IsAbs(dest) || strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, `\`) {
+		return errors.Errorf("extra file destination %s must be relative to the package root", dest)
+	}
+
+	clean := filepath.Clean(filepath.FromSlash(dest))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return errors.Errorf("extra file destination %s is outside the package root", dest)
+	}
+	return nil
+}
+
+// copyExtraFiles copies ExtraFiles into the package root. They're
+// copied after everything else, so they can't silently replace a file
+// the package needs.
+func (p *PackageOptions) copyExtraFiles() error {
+	dests := []string{}
+	for dest := range p.ExtraFiles {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	for _, dest := range dests {
+		path := filepath.Join(p.packageRoot, filepath.FromSlash(dest))
+		if _, err := os.Stat(path); err == nil {
+			return errors.Errorf("extra file destination %s is already in the package", dest)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), fs.DirMode); err != nil {
+			return errors.Wrapf(err, "mkdir for extra file %s", dest)
+		}
+
+		if err := fs.CopyFile(p.ExtraFiles[dest], path); err != nil {
+			return errors.Wrapf(err, "copy extra file %s", p.ExtraFiles[dest])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package packaging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtraFiles(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-extra-files")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(src, []byte("ca"), 0644))
+
+	extraFiles, err := ParseExtraFiles([]string{src + ":etc/kolide-app/ca.pem", src + ":usr/local/kolide-app/ca.pem"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"etc/kolide-app/ca.pem":       src,
+		"usr/local/kolide-app/ca.pem": src,
+	}, extraFiles)
+
+	var tests = []string{
+		src,
+		src + ":",
+		":etc/ca.pem",
+		filepath.Join(dir, "missing") + ":etc/ca.pem",
+		dir + ":etc/ca.pem",
+		src + ":/etc/ca.pem",
+		src + ":../ca.pem",
+		src + ":etc/../../ca.pem",
+		src + ":.",
+	}
+	for _, spec := range tests {
+		_, err := ParseExtraFiles([]string{spec})
+		require.Error(t, err, spec)
+	}
+
+	_, err = ParseExtraFiles([]string{src + ":etc/ca.pem", src + ":etc/ca.pem"})
+	require.Error(t, err)
+}
+
+func TestCopyExtraFiles(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-extra-files")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "run.sh")
+	require.NoError(t, ioutil.WriteFile(src, []byte("#!/bin/sh"), 0755))
+
+	p := &PackageOptions{
+		packageRoot: filepath.Join(dir, "root"),
+		ExtraFiles:  map[string]string{"usr/local/kolide-app/bin/run.sh": src},
+	}
+	require.NoError(t, p.copyExtraFiles())
+
+	copied := filepath.Join(p.packageRoot, "usr", "local", "kolide-app", "bin", "run.sh")
+	contents, err := ioutil.ReadFile(copied)
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh", string(contents))
+
+	info, err := os.Stat(copied)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// Files already in the package aren't replaced
+	require.Error(t, p.copyExtraFiles())
+}
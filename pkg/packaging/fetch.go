@@ -2,86 +2,809 @@ package packaging
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Masterminds/semver"
+	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/fs"
 	"github.com/kolide/launcher/pkg/contexts/ctxlog"
 	"github.com/pkg/errors"
 )
 
-// FetchOsquerydBinary will synchronously download a binary as per the
+// fetchLocks serializes fetches of the same binary. This allows
+// concurrent builds to share a cache directory, without clobbering
+// each other's downloads.
+var fetchLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+// lockFetch locks the given cache path, and returns the unlock function.
+func lockFetch(path string) func() {
+	fetchLocks.Lock()
+	lock, ok := fetchLocks.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		fetchLocks.locks[path] = lock
+	}
+	fetchLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+type fetchOptions struct {
+	refreshCache    bool
+	noNetwork       bool
+	notaryURL       string
+	tufRoot         *TUFRoot // if set, TUF metadata is verified against it
+	mirrorURLs      []string // tried in order, until one succeeds
+	client          *http.Client
+	retries         int
+	retryBackoff    time.Duration
+	downloadTimeout time.Duration
+}
+
+type FetchOpt func(*fetchOptions)
+
+// WithRefreshCache ignores anything in the cache, and downloads fresh copies.
+func WithRefreshCache() FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.refreshCache = true
+	}
+}
+
+// WithNoNetwork forbids network access. Binaries, and their TUF
+// metadata, must already be in the cache, from a previous fetch of the
+// same component, version, platform, and arch. Anything else is an
+// error, rather than a download.
+func WithNoNetwork() FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.noNetwork = true
+	}
+}
+
+// WithNotaryURL sets the notary server TUF metadata is fetched from.
+func WithNotaryURL(url string) FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.notaryURL = url
+	}
+}
+
+// WithTUFRoot verifies the TUF metadata from notary against root,
+// for self-hosted notary servers. Without it, the TUF metadata is
+// used to check the integrity of downloads, but its signatures
+// aren't verified.
+func WithTUFRoot(root *TUFRoot) FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.tufRoot = root
+	}
+}
+
+// WithMirrorURL sets the mirror binaries are downloaded from.
+func WithMirrorURL(url string) FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.mirrorURLs = []string{url}
+	}
+}
+
+// WithMirrorURLs sets mirrors to download binaries from, in order. If
+// a download from one fails, after any retries, the next is tried.
+// Downloads are always checked against the TUF metadata, so the
+// mirrors needn't be trusted.
+func WithMirrorURLs(urls ...string) FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.mirrorURLs = urls
+	}
+}
+
+// WithDownloadTimeout limits each download attempt to timeout. A
+// stalled download is then retried, or falls through to the next
+// mirror, rather than hanging the build.
+func WithDownloadTimeout(timeout time.Duration) FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.downloadTimeout = timeout
+	}
+}
+
+// WithRetries retries transient download failures, such as network
+// errors and 5xx responses, up to retries times. The wait between
+// attempts starts at backoff, and doubles each time.
+func WithRetries(retries int, backoff time.Duration) FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.retries = retries
+		fo.retryBackoff = backoff
+	}
+}
+
+// WithProxy sends downloads through the given proxy. Without it, the
+// standard HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables
+// are honored.
+func WithProxy(proxyURL *url.URL) FetchOpt {
+	return func(fo *fetchOptions) {
+		fo.client = newProxyClient(proxyURL)
+	}
+}
+
+// newProxyClient returns an http client that uses proxyURL. The
+// transport settings match http.DefaultTransport.
+func newProxyClient(proxyURL *url.URL) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyURL),
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
+// pinnedHashPrefix marks versions that are the sha256 of a release
+// tarball, rather than a channel or version.
+const pinnedHashPrefix = "sha256:"
+
+// pinnedCommitPrefix marks versions that are the git commit a binary
+// was built from, such as a launcher dev build.
+const pinnedCommitPrefix = "sha:"
+
+// FetchBinary will synchronously download a binary as per the
 // supplied desired version and platform identifiers. The path to the
 // downloaded binary is returned or an error if the operation did not
 // succeed.
 //
-// You must specify a localCacheDir, to reuse downloads
-func FetchBinary(ctx context.Context, localCacheDir, name, version, platform string) (string, error) {
+// version may be a channel, such as stable, an exact version, such as
+// 3.3.1, sha256:<hex> to pin the release tarball with that hash, or
+// sha:<commit> for the build of that git commit. It's an error if
+// there's no matching TUF target.
+//
+// You must specify a localCacheDir, to reuse downloads. The cache is
+// keyed by component, channel, platform, and arch. Cached downloads
+// are checked against the current TUF metadata before being used, so
+// a channel that has moved on, or a corrupt file, is re-downloaded.
+// The TUF metadata is cached too, so that WithNoNetwork can use the
+// cache without notary.
+func FetchBinary(ctx context.Context, localCacheDir, name, version, platform, arch string, fetchOpts ...FetchOpt) (string, error) {
 	logger := ctxlog.FromContext(ctx)
+	fo := newFetchOptions(fetchOpts...)
 
 	// Create the cache directory if it doesn't already exist
 	if localCacheDir == "" {
 		return "", errors.New("Empty cache dir argument")
 	}
 
-	localBinaryPath := filepath.Join(localCacheDir, fmt.Sprintf("%s-%s-%s", name, platform, version), name)
-	localPackagePath := filepath.Join(localCacheDir, fmt.Sprintf("%s-%s-%s.tar.gz", name, platform, version))
+	if arch == "" {
+		arch = string(Amd64)
+	}
+
+	rt, err := fo.resolve(ctx, localCacheDir, name, version, platform, arch)
+	if err != nil {
+		return "", err
+	}
+	baseName, platformArch, targetName, version, meta := rt.baseName, rt.platformArch, rt.targetName, rt.version, rt.meta
+
+	key := cacheKey(name, version, platform, arch)
+	localBinaryPath := filepath.Join(localCacheDir, key, name)
+	localPackagePath := filepath.Join(localCacheDir, fmt.Sprintf("%s.tar.gz", key))
+
+	unlock := lockFetch(localPackagePath)
+	defer unlock()
+
+	// See if a verified local package exists on disk already. If so,
+	// use it. The binary is only reused if it's unchanged since it was
+	// extracted from it, as only the package can be checked against
+	// TUF. See extractVerified.
+	if !fo.refreshCache {
+		if err := meta.verify(localPackagePath); err == nil {
+			level.Info(logger).Log("msg", "using cached download", "path", localPackagePath)
+			return extractVerified(ctx, name, targetName, localBinaryPath, localPackagePath, meta)
+		} else if !os.IsNotExist(errors.Cause(err)) {
+			level.Debug(logger).Log("msg", "ignoring cached download", "path", localPackagePath, "err", err)
+		}
+	}
+
+	if fo.noNetwork {
+		return "", errors.Errorf("%s is not in the cache, or doesn't match its metadata, and network access is disabled", targetName)
+	}
+
+	// Fresh copies don't resume earlier downloads either
+	if fo.refreshCache {
+		os.Remove(localPackagePath + ".partial")
+	}
+
+	// If not we have to download the package. Try each mirror in
+	// turn, retrying transient failures, until one works.
+	tarPath := dlTarPath(baseName, version, platformArch)
+	var downloadErr error
+	for i, mirror := range fo.mirrorURLs {
+		url := fmt.Sprintf("%s/%s", strings.TrimSuffix(mirror, "/"), tarPath)
+
+		downloadErr = fo.retry(ctx, "downloading", func() error {
+			downloadCtx := ctx
+			if fo.downloadTimeout > 0 {
+				var cancel context.CancelFunc
+				downloadCtx, cancel = context.WithTimeout(ctx, fo.downloadTimeout)
+				defer cancel()
+			}
+			return download(downloadCtx, fo.client, name, url, localPackagePath, meta)
+		})
+		if downloadErr == nil {
+			level.Info(logger).Log("msg", "downloaded from mirror", "target", targetName, "mirror", mirror)
+			return extractVerified(ctx, name, targetName, localBinaryPath, localPackagePath, meta)
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(fo.mirrorURLs)-1 {
+			level.Warn(logger).Log("msg", "download failed, trying the next mirror", "target", targetName, "mirror", mirror, "err", downloadErr)
+		}
+	}
+
+	if len(fo.mirrorURLs) > 1 {
+		return "", errors.Wrapf(downloadErr, "downloading %s, all %d mirrors failed. Last error", targetName, len(fo.mirrorURLs))
+	}
+	return "", errors.Wrapf(downloadErr, "downloading %s", targetName)
+}
+
+// extractedMarker is the file, next to an extracted binary, that
+// records the sha256 of the package it came from, and its own.
+const extractedMarker = ".extracted"
 
-	// See if a local package exists on disk already. If so, return the cached path
-	if _, err := os.Stat(localBinaryPath); err == nil {
+// extractVerified extracts the binary from a verified package, and
+// logs the hashes of both, so a build can be traced back to exactly
+// what was downloaded. A binary already extracted from the same
+// package is reused, if it's unchanged. Parallel builds may still be
+// copying it, so it's only ever replaced, by renaming a fresh
+// extraction into place, when it doesn't match.
+func extractVerified(ctx context.Context, name, targetName, localBinaryPath, localPackagePath string, meta *targetMeta) (string, error) {
+	logger := ctxlog.FromContext(ctx)
+	extractedDir := filepath.Dir(localBinaryPath)
+
+	if binarySum, ok := reusableBinary(localBinaryPath, meta.sha256Hex()); ok {
+		level.Debug(logger).Log(
+			"msg", "using extracted binary",
+			"name", name,
+			"target", targetName,
+			"package_sha256", meta.sha256Hex(),
+			"binary_sha256", binarySum,
+		)
 		return localBinaryPath, nil
 	}
 
-	// If not we have to download the package. First, create download
-	// URI. Notary stores things by name, sans extension. So just strip
-	// it off.
-	baseName := strings.TrimSuffix(name, filepath.Ext(name))
-	url := fmt.Sprintf("https://dl.kolide.co/%s", dlTarPath(baseName, version, platform))
+	tmpDir, err := ioutil.TempDir(filepath.Dir(extractedDir), fmt.Sprintf(".%s.extract-", filepath.Base(extractedDir)))
+	if err != nil {
+		return "", errors.Wrap(err, "making extraction dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpBinaryPath, err := untarCached(filepath.Join(tmpDir, filepath.Base(localBinaryPath)), localPackagePath)
+	if err != nil {
+		return "", err
+	}
+
+	_, binarySum, err := hashFile(tmpBinaryPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "hashing %s", name)
+	}
+
+	marker := fmt.Sprintf("%s %s\n", meta.sha256Hex(), binarySum)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, extractedMarker), []byte(marker), 0644); err != nil {
+		return "", errors.Wrap(err, "writing extraction marker")
+	}
+
+	// Move a stale extraction aside, rather than removing it in place
+	staleDir := tmpDir + ".stale"
+	if err := os.Rename(extractedDir, staleDir); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "couldn't move stale binary aside")
+	}
+	defer os.RemoveAll(staleDir)
+
+	if err := os.Rename(tmpDir, extractedDir); err != nil {
+		return "", errors.Wrap(err, "couldn't move binary into cache")
+	}
 
 	level.Debug(logger).Log(
-		"msg", "starting download",
-		"url", url,
+		"msg", "verified download",
+		"name", name,
+		"target", targetName,
+		"package_sha256", meta.sha256Hex(),
+		"binary_sha256", binarySum,
 	)
 
-	// Download the package
-	downloadReq, err := http.NewRequest("GET", url, nil)
+	return localBinaryPath, nil
+}
+
+// reusableBinary returns the sha256 of the binary at localBinaryPath,
+// if it was extracted from the package whose sha256 is packageSum, and
+// hasn't changed since.
+func reusableBinary(localBinaryPath, packageSum string) (string, bool) {
+	marker, err := ioutil.ReadFile(filepath.Join(filepath.Dir(localBinaryPath), extractedMarker))
 	if err != nil {
-		return "", errors.Wrap(err, "new request")
+		return "", false
+	}
+
+	fields := strings.Fields(string(marker))
+	if len(fields) != 2 || fields[0] != packageSum {
+		return "", false
+	}
+
+	_, binarySum, err := hashFile(localBinaryPath)
+	if err != nil || binarySum != fields[1] {
+		return "", false
+	}
+	return binarySum, true
+}
+
+// LookupBinary checks that there's a TUF target for the binary, as
+// FetchBinary would fetch, without downloading anything. It returns
+// the TUF target name, eg: linux/osqueryd-3.3.1.tar.gz
+func LookupBinary(ctx context.Context, name, version, platform, arch string, fetchOpts ...FetchOpt) (string, error) {
+	if arch == "" {
+		arch = string(Amd64)
+	}
+
+	rt, err := newFetchOptions(fetchOpts...).resolve(ctx, "", name, version, platform, arch)
+	if err != nil {
+		return "", err
+	}
+	return rt.targetName, nil
+}
+
+// ResolveVersion returns the exact version of the binary FetchBinary
+// would fetch. Channels are resolved through TUF, to the version whose
+// release tarball they point at, eg: stable to 0.11.4. It's an error
+// if there's no such version, or, as that needs notary, if network
+// access is disabled.
+func ResolveVersion(ctx context.Context, name, version, platform, arch string, fetchOpts ...FetchOpt) (string, error) {
+	if arch == "" {
+		arch = string(Amd64)
+	}
+
+	fo := newFetchOptions(fetchOpts...)
+	rt, err := fo.resolve(ctx, "", name, version, platform, arch)
+	if err != nil {
+		return "", err
+	}
+	if isExactVersion(rt.version) {
+		return rt.version, nil
+	}
+
+	var targetName string
+	if err := fo.retry(ctx, "looking up TUF metadata", func() error {
+		var err error
+		targetName, _, err = fetchTargetMetaByHash(ctx, fo.client, fo.notaryURL, fo.tufRoot, path.Join("kolide", rt.baseName), rt.platformArch, rt.meta.sha256Hex())
+		return err
+	}); err != nil {
+		return "", errors.Wrapf(err, "resolving %s %s", name, version)
+	}
+
+	resolved := targetVersion(rt.baseName, targetName)
+	if !isExactVersion(resolved) {
+		return "", errors.Errorf("%s %s for %s doesn't point at a versioned release", name, version, rt.platformArch)
+	}
+	return resolved, nil
+}
+
+// Download is a release tarball, as it's laid out on a mirror.
+type Download struct {
+	Path    string // relative to the mirror, eg: kolide/osqueryd/linux/osqueryd-3.3.1.tar.gz
+	SHA256  string // hex sha256 of the tarball, per TUF
+	Version string // the version, with channels resolved, eg: 3.3.1
+}
+
+// LookupDownload returns the release tarball FetchBinary would
+// download, without downloading it. Channels are resolved to the
+// version they point at, so the tarball still matches its hash once
+// the channel moves on.
+func LookupDownload(ctx context.Context, name, version, platform, arch string, fetchOpts ...FetchOpt) (*Download, error) {
+	if arch == "" {
+		arch = string(Amd64)
+	}
+
+	resolved, err := ResolveVersion(ctx, name, version, platform, arch, fetchOpts...)
+	if err != nil {
+		return nil, err
 	}
-	downloadReq = downloadReq.WithContext(ctx)
 
-	httpClient := http.DefaultClient
-	response, err := httpClient.Do(downloadReq)
+	rt, err := newFetchOptions(fetchOpts...).resolve(ctx, "", name, resolved, platform, arch)
 	if err != nil {
-		return "", errors.Wrap(err, "couldn't download binary archive")
+		return nil, err
+	}
+	return &Download{
+		Path:    dlTarPath(rt.baseName, rt.version, rt.platformArch),
+		SHA256:  rt.meta.sha256Hex(),
+		Version: rt.version,
+	}, nil
+}
+
+// targetVersion returns the version, or channel, in a TUF target name.
+func targetVersion(baseName, targetName string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(path.Base(targetName), baseName+"-"), ".tar.gz")
+}
+
+// isExactVersion reports whether version is a version number, like
+// 0.11.4, rather than a channel, like stable.
+func isExactVersion(version string) bool {
+	_, err := semver.NewVersion(version)
+	return err == nil
+}
+
+func newFetchOptions(fetchOpts ...FetchOpt) *fetchOptions {
+	fo := &fetchOptions{
+		notaryURL:  defaultNotaryURL,
+		mirrorURLs: []string{defaultMirrorURL},
+		client:     http.DefaultClient,
+	}
+	for _, opt := range fetchOpts {
+		opt(fo)
+	}
+
+	// Belt and braces. Nothing should try, but if it does, it fails.
+	if fo.noNetwork {
+		fo.client = &http.Client{Transport: noNetworkTransport{}}
+	}
+	return fo
+}
+
+// noNetworkTransport fails every request. See WithNoNetwork.
+type noNetworkTransport struct{}
+
+func (noNetworkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.Errorf("network access is disabled, not requesting %s", req.URL)
+}
+
+// resolvedTarget is a binary version resolved to its TUF target
+type resolvedTarget struct {
+	baseName     string // name, sans extension, as notary stores things
+	platformArch string // eg: linux, or linux/arm64
+	targetName   string
+	version      string // pinned hashes are resolved to the version
+	meta         *targetMeta
+}
+
+// resolve looks up the TUF target for a binary. If localCacheDir is
+// set, the result is cached there. Without network access, that cache
+// is used instead of notary.
+func (fo *fetchOptions) resolve(ctx context.Context, localCacheDir, name, version, platform, arch string) (*resolvedTarget, error) {
+	// amd64 binaries predate multiple architectures, and live at the
+	// unqualified paths.
+	platformArch := platform
+	if arch != string(Amd64) {
+		platformArch = path.Join(platform, arch)
+	}
+
+	// Notary stores things by name, sans extension. So just strip it
+	// off.
+	baseName := strings.TrimSuffix(name, filepath.Ext(name))
+	gun := path.Join("kolide", baseName)
+	targetName := path.Join(platformArch, fmt.Sprintf("%s-%s.tar.gz", baseName, version))
+
+	metaCachePath := ""
+	if localCacheDir != "" {
+		metaCachePath = filepath.Join(localCacheDir, metaCacheName(name, version, platform, arch))
+	}
+
+	if fo.noNetwork {
+		if metaCachePath == "" {
+			return nil, errors.New("network access is disabled, and there's no cache to look up TUF metadata in")
+		}
+		cached, err := loadCachedTarget(metaCachePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s %s for %s isn't in the cache, and network access is disabled", name, version, platformArch)
+		}
+		return &resolvedTarget{
+			baseName:     baseName,
+			platformArch: platformArch,
+			targetName:   cached.TargetName,
+			version:      cached.Version,
+			meta:         &cached.Meta,
+		}, nil
+	}
+
+	var meta *targetMeta
+	if err := fo.retry(ctx, "looking up TUF metadata", func() error {
+		var err error
+		switch {
+		case strings.HasPrefix(version, pinnedHashPrefix):
+			targetName, meta, err = fetchTargetMetaByHash(ctx, fo.client, fo.notaryURL, fo.tufRoot, gun, platformArch, strings.TrimPrefix(version, pinnedHashPrefix))
+		case strings.HasPrefix(version, pinnedCommitPrefix):
+			targetName, meta, err = fetchTargetMetaByCommit(ctx, fo.client, fo.notaryURL, fo.tufRoot, gun, platformArch, baseName, strings.TrimPrefix(version, pinnedCommitPrefix))
+		default:
+			meta, err = fetchTargetMeta(ctx, fo.client, fo.notaryURL, fo.tufRoot, gun, targetName)
+		}
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "looking up TUF metadata")
+	}
+
+	// A pinned hash, or commit, resolves to a version. Use that from
+	// here on, so the urls and cache are the same as asking for it
+	// directly.
+	switch {
+	case strings.HasPrefix(version, pinnedHashPrefix):
+		version = targetVersion(baseName, targetName)
+		level.Info(ctxlog.FromContext(ctx)).Log("msg", "resolved pinned hash", "name", name, "target", targetName)
+	case strings.HasPrefix(version, pinnedCommitPrefix):
+		level.Info(ctxlog.FromContext(ctx)).Log("msg", "resolved commit", "name", name, "commit", strings.TrimPrefix(version, pinnedCommitPrefix), "target", targetName)
+		version = targetVersion(baseName, targetName)
+	}
+
+	if metaCachePath != "" {
+		cached := &cachedTarget{TargetName: targetName, Version: version, Meta: *meta}
+		if err := cached.save(metaCachePath); err != nil {
+			level.Info(ctxlog.FromContext(ctx)).Log("msg", "couldn't cache TUF metadata, it won't be usable offline", "path", metaCachePath, "err", err)
+		}
+	}
+
+	return &resolvedTarget{
+		baseName:     baseName,
+		platformArch: platformArch,
+		targetName:   targetName,
+		version:      version,
+		meta:         meta,
+	}, nil
+}
+
+// cachedTarget is a resolved TUF target, as cached for WithNoNetwork.
+type cachedTarget struct {
+	TargetName string     `json:"target"`
+	Version    string     `json:"version"`
+	Meta       targetMeta `json:"meta"`
+}
+
+// cacheKey is the cache name of a downloaded binary version. The
+// package is cached as <key>.tar.gz, and extracted to <key>/.
+func cacheKey(name, version, platform, arch string) string {
+	return fmt.Sprintf("%s-%s-%s-%s", name, version, platform, arch)
+}
+
+// metaCacheName is the cache file name for the TUF metadata of a
+// binary version, as requested. Pinned hashes have a colon, which
+// windows doesn't allow in file names.
+func metaCacheName(name, version, platform, arch string) string {
+	return fmt.Sprintf("%s-%s-%s-%s.tuf.json", name, strings.Replace(version, ":", "-", -1), platform, arch)
+}
+
+func loadCachedTarget(path string) (*cachedTarget, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cached TUF metadata")
+	}
+	var cached cachedTarget
+	if err := json.Unmarshal(contents, &cached); err != nil {
+		return nil, errors.Wrapf(err, "parsing cached TUF metadata %s", path)
+	}
+	return &cached, nil
+}
+
+// save writes the cached target to path. It's written to a temporary
+// file, and renamed into place, so concurrent builds never see half
+// of one.
+func (c *cachedTarget) save(path string) error {
+	contents, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "encoding TUF metadata")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), fs.DirMode); err != nil {
+		return errors.Wrap(err, "creating cache dir")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary metadata file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "writing TUF metadata")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "closing TUF metadata")
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// download fetches url into the cache at localPackagePath. It
+// downloads to a partial file, and renames it into place once it's
+// verified, so an interrupted, or corrupt, download never looks
+// complete. Interrupted downloads leave the partial file, and the next
+// attempt, eg: a retry, resumes it with a range request. Servers that
+// don't support them send the whole file, which replaces it.
+func download(ctx context.Context, client *http.Client, name, url, localPackagePath string, meta *targetMeta) error {
+	logger := ctxlog.FromContext(ctx)
+
+	partialPath := localPackagePath + ".partial"
+	offset := partialSize(partialPath, meta.Length)
+
+	level.Info(logger).Log(
+		"msg", "starting download",
+		"url", url,
+		"offset", offset,
+	)
+	start := time.Now()
+
+	response, err := getRange(ctx, client, url, offset)
+	if err != nil {
+		return err
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != 200 {
-		return "", errors.Errorf("Failed download. Got http status %s", response.Status)
+	// A resume only works if the server sends the rest of the file.
+	// Other ranges, or none, start over. Errors keep the partial file,
+	// for the next attempt.
+	if offset > 0 && !isResumed(response, offset) {
+		switch response.StatusCode {
+		case http.StatusOK:
+			// The server doesn't support ranges, and sent the whole file
+			level.Debug(logger).Log("msg", "server didn't resume download, starting over", "url", url)
+			offset = 0
+		case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+			level.Debug(logger).Log("msg", "server didn't resume download, starting over", "url", url, "status", response.Status)
+			offset = 0
+			response.Body.Close()
+			if response, err = getRange(ctx, client, url, 0); err != nil {
+				return err
+			}
+			defer response.Body.Close()
+		}
 	}
 
-	// Store it in cache
-	writeHandle, err := os.Create(localPackagePath)
+	if response.StatusCode != http.StatusOK && !(offset > 0 && response.StatusCode == http.StatusPartialContent) {
+		err := errors.Errorf("Failed download. Got http status %s", response.Status)
+		if response.StatusCode >= 500 {
+			return transientError{err}
+		}
+		return err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	writeHandle, err := os.OpenFile(partialPath, flags, 0644)
 	if err != nil {
-		return "", errors.Wrap(err, "couldn't create file handle at local package download path")
+		return errors.Wrap(err, "couldn't create file handle at local package download path")
 	}
 	defer writeHandle.Close()
 
-	_, err = io.Copy(writeHandle, response.Body)
+	progress := &progressWriter{logger: logger, url: url, total: offset + response.ContentLength, written: offset, logged: offset}
+	size, err := io.Copy(io.MultiWriter(writeHandle, progress), response.Body)
 	if err != nil {
-		return "", errors.Wrap(err, "couldn't copy HTTP response body to file")
+		// Keep what was downloaded, for the next attempt to resume
+		return transientError{errors.Wrap(err, "couldn't copy HTTP response body to file")}
 	}
 
-	// explicitly close the write handle before untaring the archive
+	level.Info(logger).Log(
+		"msg", "finished download",
+		"url", url,
+		"bytes", size,
+		"resumed_at", offset,
+		"duration", time.Since(start).String(),
+	)
+
+	// explicitly close the write handle before verifying
 	writeHandle.Close()
 
+	if err := meta.verify(partialPath); err != nil {
+		os.Remove(partialPath)
+		// The partial file may have been from a different, or corrupt,
+		// download, so it's worth trying again from scratch.
+		if offset > 0 {
+			return transientError{errors.Wrapf(err, "verifying resumed download of %s", name)}
+		}
+		return errors.Wrapf(err, "verifying download of %s", name)
+	}
+
+	if err := os.Rename(partialPath, localPackagePath); err != nil {
+		return errors.Wrap(err, "couldn't move download into cache")
+	}
+
+	return nil
+}
+
+// partialSize returns how much of a download there is to resume at
+// path. Partial files that are empty, or as long as the whole file, or
+// longer, can't be resumed, so are removed.
+func partialSize(path string, length int64) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	if info.Size() == 0 || info.Size() >= length {
+		os.Remove(path)
+		return 0
+	}
+	return info.Size()
+}
+
+// getRange requests url, from offset onwards, if it's set.
+func getRange(ctx context.Context, client *http.Client, url string, offset int64) (*http.Response, error) {
+	downloadReq, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+	downloadReq = downloadReq.WithContext(ctx)
+	if offset > 0 {
+		downloadReq.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	response, err := client.Do(downloadReq)
+	if err != nil {
+		return nil, transientError{errors.Wrap(err, "couldn't download binary archive")}
+	}
+	return response, nil
+}
+
+// isResumed reports whether response is the rest of a file, from
+// offset.
+func isResumed(response *http.Response, offset int64) bool {
+	return response.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(response.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))
+}
+
+// transientError marks errors that are worth retrying, such as
+// network failures and server errors.
+type transientError struct {
+	error
+}
+
+func isTransient(err error) bool {
+	_, ok := errors.Cause(err).(transientError)
+	return ok
+}
+
+// retry calls fn, retrying transient failures with exponential
+// backoff.
+func (fo *fetchOptions) retry(ctx context.Context, what string, fn func() error) error {
+	logger := ctxlog.FromContext(ctx)
+
+	backoff := fo.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= fo.retries || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		level.Info(logger).Log(
+			"msg", "retrying after transient failure",
+			"what", what,
+			"attempt", attempt+1,
+			"backoff", backoff.String(),
+			"err", err,
+		)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errors.Wrapf(err, "gave up retrying: %v", ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// untarCached extracts a cached package, and returns the path to the
+// binary inside it.
+func untarCached(localBinaryPath, localPackagePath string) (string, error) {
 	if err := os.MkdirAll(filepath.Dir(localBinaryPath), fs.DirMode); err != nil {
 		return "", errors.Wrap(err, "couldn't create directory for binary")
 	}
@@ -100,6 +823,37 @@ func FetchBinary(ctx context.Context, localCacheDir, name, version, platform str
 	return localBinaryPath, nil
 }
 
+// progressWriter logs download progress at debug level, roughly
+// every 10%. If the total size is unknown, it logs every 10MB.
+type progressWriter struct {
+	logger  log.Logger
+	url     string
+	total   int64
+	written int64
+	logged  int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.written += int64(len(p))
+
+	step := pw.total / 10
+	if step <= 0 {
+		step = 10 * 1024 * 1024
+	}
+
+	if pw.written-pw.logged >= step {
+		pw.logged = pw.written
+		level.Debug(pw.logger).Log(
+			"msg", "download progress",
+			"url", pw.url,
+			"bytes", pw.written,
+			"total", pw.total,
+		)
+	}
+
+	return len(p), nil
+}
+
 func dlTarPath(name, version, platform string) string {
 	return path.Join("kolide", name, platform, fmt.Sprintf("%s-%s.tar.gz", name, version))
 }
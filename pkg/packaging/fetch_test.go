@@ -0,0 +1,875 @@
+package packaging
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+func TestLockFetch(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	var running, maxRunning int
+	var mu sync.Mutex
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := lockFetch("/cache/osqueryd-linux-stable.tar.gz")
+			defer unlock()
+
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 1, maxRunning)
+
+	// Different paths shouldn't block each other
+	unlockA := lockFetch("/cache/a.tar.gz")
+	unlockB := lockFetch("/cache/b.tar.gz")
+	unlockA()
+	unlockB()
+}
+
+// fakeRelease serves a tarball from a fake mirror, and its TUF
+// metadata from a fake notary.
+type fakeRelease struct {
+	mu        sync.Mutex
+	tarball   []byte // what the mirror serves
+	published []byte // what the TUF metadata describes
+	downloads int
+	failures  int        // how many more downloads should fail with a 503
+	truncate  int        // if set, the next download is cut off after this many bytes
+	noRanges  bool       // ignore range requests, as some servers do
+	ranges    []string   // the Range header of each download
+	platforms []string   // platform paths to publish, eg: darwin/arm64. If unset, linux.
+	versions  []string   // channels, and versions, to publish. If unset, stable and 1.2.3.
+	signer    *tufSigner // if set, the TUF metadata is signed, and there's a root
+}
+
+func (f *fakeRelease) platformPaths() []string {
+	if len(f.platforms) == 0 {
+		return []string{"linux"}
+	}
+	return f.platforms
+}
+
+func (f *fakeRelease) versionNames() []string {
+	if len(f.versions) == 0 {
+		return []string{"stable", "1.2.3"}
+	}
+	return f.versions
+}
+
+func (f *fakeRelease) setRelease(t *testing.T, contents string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "osqueryd", Mode: 0755, Size: int64(len(contents))}))
+	_, err := tw.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+
+	f.tarball = buf.Bytes()
+	f.published = buf.Bytes()
+}
+
+func (f *fakeRelease) notary(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.signer != nil {
+		f.signedNotary(w, r)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v2/kolide/osqueryd/_trust/tuf/targets.json":
+		fmt.Fprint(w, `{"signed":{"targets":{},"delegations":{"roles":[{"name":"targets/releases"}]}}}`)
+	case "/v2/kolide/osqueryd/_trust/tuf/targets/releases.json":
+		// The channel, and the version it points at
+		sum := base64.StdEncoding.EncodeToString(f.publishedSum())
+		targets := []string{}
+		for _, platform := range f.platformPaths() {
+			for _, version := range f.versionNames() {
+				targets = append(targets, fmt.Sprintf(`"%s/osqueryd-%s.tar.gz":{"length":%d,"hashes":{"sha256":"%s"}}`, platform, version, len(f.published), sum))
+			}
+		}
+		fmt.Fprintf(w, `{"signed":{"targets":{%s}}}`, strings.Join(targets, ","))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// signedNotary serves the same releases as notary, but signed. The
+// caller holds the lock.
+func (f *fakeRelease) signedNotary(w http.ResponseWriter, r *http.Request) {
+	var contents []byte
+	var err error
+	switch r.URL.Path {
+	case "/v2/kolide/osqueryd/_trust/tuf/root.json":
+		contents = f.signer.root
+	case "/v2/kolide/osqueryd/_trust/tuf/targets.json":
+		contents, err = f.signer.signTargets(data.CanonicalTargetsRole, data.Files{})
+	case "/v2/kolide/osqueryd/_trust/tuf/targets/releases.json":
+		files := data.Files{}
+		for _, platform := range f.platformPaths() {
+			for _, version := range f.versionNames() {
+				files[fmt.Sprintf("%s/osqueryd-%s.tar.gz", platform, version)] = data.FileMeta{
+					Length: int64(len(f.published)),
+					Hashes: data.Hashes{"sha256": f.publishedSum()},
+				}
+			}
+		}
+		contents, err = f.signer.signTargets("targets/releases", files)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(contents)
+}
+
+func (f *fakeRelease) mirror(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	found := false
+	for _, platform := range f.platformPaths() {
+		for _, version := range f.versionNames() {
+			if r.URL.Path == fmt.Sprintf("/kolide/osqueryd/%s/osqueryd-%s.tar.gz", platform, version) {
+				found = true
+			}
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	f.downloads++
+	f.ranges = append(f.ranges, r.Header.Get("Range"))
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+
+	body := f.tarball
+	var offset int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset); err == nil && !f.noRanges {
+		if offset >= len(f.tarball) {
+			http.Error(w, "bad range", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		body = f.tarball[offset:]
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(f.tarball)-1, len(f.tarball)))
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	}
+
+	// Cut the download off part way, as a flaky connection would
+	if f.truncate > 0 {
+		body = body[:f.truncate]
+		f.truncate = 0
+	}
+	w.Write(body)
+}
+
+func (f *fakeRelease) publishedSum() []byte {
+	sum := sha256.Sum256(f.published)
+	return sum[:]
+}
+
+func (f *fakeRelease) downloadCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.downloads
+}
+
+func TestFetchBinaryCache(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fetch := func(opts ...FetchOpt) (string, error) {
+		opts = append(opts, WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+		return FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "", opts...)
+	}
+
+	requireContents := func(path, expected string) {
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(contents))
+	}
+
+	// First fetch downloads
+	binPath, err := fetch()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cacheDir, "osqueryd-stable-linux-amd64", "osqueryd"), binPath)
+	requireContents(binPath, "osqueryd v1")
+	require.Equal(t, 1, release.downloadCount())
+
+	// Second fetch is served from the cache
+	_, err = fetch()
+	require.NoError(t, err)
+	require.Equal(t, 1, release.downloadCount())
+
+	// The extracted binary is reused, rather than replaced, as other
+	// builds may be copying it
+	before, err := os.Stat(binPath)
+	require.NoError(t, err)
+	_, err = fetch()
+	require.NoError(t, err)
+	after, err := os.Stat(binPath)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(before, after), "extracted binary should be reused")
+
+	// A missing binary is re-extracted from the cached tarball
+	require.NoError(t, os.Remove(binPath))
+	_, err = fetch()
+	require.NoError(t, err)
+	requireContents(binPath, "osqueryd v1")
+	require.Equal(t, 1, release.downloadCount())
+
+	// As is a corrupted one, since only the tarball can be verified
+	require.NoError(t, ioutil.WriteFile(binPath, []byte("corrupt"), 0755))
+	_, err = fetch()
+	require.NoError(t, err)
+	requireContents(binPath, "osqueryd v1")
+	require.Equal(t, 1, release.downloadCount())
+
+	// Refreshing ignores the cache
+	_, err = fetch(WithRefreshCache())
+	require.NoError(t, err)
+	require.Equal(t, 2, release.downloadCount())
+
+	// When the channel moves on, the cache no longer verifies
+	release.setRelease(t, "osqueryd v2")
+	_, err = fetch()
+	require.NoError(t, err)
+	requireContents(binPath, "osqueryd v2")
+	require.Equal(t, 3, release.downloadCount())
+
+	// A download that doesn't match the metadata is rejected, and
+	// doesn't replace the cache
+	release.mu.Lock()
+	release.tarball = []byte("corrupt")
+	release.mu.Unlock()
+	_, err = fetch(WithRefreshCache())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "length mismatch")
+	_, err = fetch()
+	require.NoError(t, err)
+	requireContents(binPath, "osqueryd v2")
+
+	// As is one of the right length, with the hashes, and component,
+	// in the error
+	release.mu.Lock()
+	release.tarball = bytes.Repeat([]byte("x"), len(release.published))
+	corruptSum := sha256.Sum256(release.tarball)
+	release.mu.Unlock()
+	_, err = fetch(WithRefreshCache())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "verifying download of osqueryd")
+	require.Contains(t, err.Error(), "hash mismatch")
+	require.Contains(t, err.Error(), hex.EncodeToString(release.publishedSum()))
+	require.Contains(t, err.Error(), hex.EncodeToString(corruptSum[:]))
+	_, err = fetch()
+	require.NoError(t, err)
+	requireContents(binPath, "osqueryd v2")
+}
+
+func TestFetchBinaryNoNetwork(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	pinned := "sha256:" + hex.EncodeToString(release.publishedSum())
+	fetch := func(version string, opts ...FetchOpt) (string, error) {
+		opts = append(opts, WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+		return FetchBinary(context.TODO(), cacheDir, "osqueryd", version, "linux", "", opts...)
+	}
+
+	// An online build seeds the cache
+	for _, version := range []string{"stable", pinned} {
+		_, err := fetch(version)
+		require.NoError(t, err, version)
+	}
+	require.Equal(t, 2, release.downloadCount())
+
+	// Offline, nothing can be reached
+	notary.Close()
+	mirror.Close()
+
+	for _, version := range []string{"stable", pinned} {
+		binPath, err := fetch(version, WithNoNetwork())
+		require.NoError(t, err, version)
+		contents, err := ioutil.ReadFile(binPath)
+		require.NoError(t, err)
+		require.Equal(t, "osqueryd v1", string(contents))
+	}
+
+	// Anything that wasn't fetched before fails, naming it
+	_, err = fetch("1.2.3", WithNoNetwork())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "osqueryd 1.2.3 for linux isn't in the cache")
+
+	// As does a cache that's been tampered with
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, "osqueryd-stable-linux-amd64.tar.gz"), []byte("corrupt"), 0644))
+	_, err = fetch("stable", WithNoNetwork())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "network access is disabled")
+
+	// There's no cache to look things up in
+	_, err = LookupBinary(context.TODO(), "osqueryd", "stable", "linux", "", WithNoNetwork())
+	require.Error(t, err)
+}
+
+func TestFetchBinaryRetries(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-retries")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fetch := func(opts ...FetchOpt) (string, error) {
+		opts = append(opts, WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL), WithRefreshCache())
+		return FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "", opts...)
+	}
+
+	// Without retries, a server error fails
+	release.failures = 1
+	_, err = fetch()
+	require.Error(t, err)
+	require.Equal(t, 1, release.downloadCount())
+
+	// With retries, it's retried until it succeeds
+	release.failures = 2
+	_, err = fetch(WithRetries(3, time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 4, release.downloadCount())
+
+	// Unless it runs out of retries
+	release.failures = 5
+	_, err = fetch(WithRetries(2, time.Millisecond))
+	require.Error(t, err)
+	require.Equal(t, 7, release.downloadCount())
+
+	// Bad downloads aren't retried
+	release.failures = 0
+	release.tarball = []byte("corrupt")
+	_, err = fetch(WithRetries(3, time.Millisecond))
+	require.Error(t, err)
+	require.Equal(t, 8, release.downloadCount())
+
+	// Nor are missing ones
+	_, err = FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "arm64",
+		WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL), WithRetries(3, time.Millisecond))
+	require.Error(t, err)
+}
+
+func TestFetchBinaryConcurrent(t *testing.T) {
+	t.Parallel()
+
+	contents := strings.Repeat("osqueryd v1 ", 100000)
+	release := &fakeRelease{}
+	release.setRelease(t, contents)
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-concurrent")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	// Parallel builds fetch the same binary, and read it after the
+	// fetch returns, so a fetch mustn't disturb another's binary
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*10)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				binPath, err := FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "", WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+				if err != nil {
+					errs <- err
+					continue
+				}
+				// Read it twice, as getBinary copies, then hashes, it
+				for k := 0; k < 2; k++ {
+					fetched, err := ioutil.ReadFile(binPath)
+					if err != nil {
+						errs <- err
+					} else if string(fetched) != contents {
+						errs <- fmt.Errorf("fetched binary was changed while it was read")
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, release.downloadCount())
+}
+
+func TestFetchBinaryResume(t *testing.T) {
+	t.Parallel()
+
+	contents := strings.Repeat("osqueryd v1 ", 100)
+	release := &fakeRelease{}
+	release.setRelease(t, contents)
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-resume")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	packagePath := filepath.Join(cacheDir, "osqueryd-stable-linux-amd64.tar.gz")
+	partialPath := packagePath + ".partial"
+	half := len(release.tarball) / 2
+	resume := fmt.Sprintf("bytes=%d-", half)
+
+	// fetch downloads afresh, and returns the Range headers it sent
+	fetch := func(opts ...FetchOpt) ([]string, error) {
+		os.Remove(packagePath)
+		release.mu.Lock()
+		release.ranges = nil
+		release.mu.Unlock()
+
+		opts = append(opts, WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+		binPath, err := FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "", opts...)
+		if err != nil {
+			return release.ranges, err
+		}
+
+		fetched, err := ioutil.ReadFile(binPath)
+		require.NoError(t, err)
+		require.Equal(t, contents, string(fetched))
+		_, err = os.Stat(partialPath)
+		require.True(t, os.IsNotExist(err), "partial download should be renamed into place")
+		return release.ranges, nil
+	}
+
+	// An interrupted download is resumed by the retry
+	release.truncate = half
+	ranges, err := fetch(WithRetries(1, time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, []string{"", resume}, ranges)
+
+	// Without retries, it's kept for the next fetch to resume
+	release.truncate = half
+	_, err = fetch()
+	require.Error(t, err)
+	info, err := os.Stat(partialPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(half), info.Size())
+
+	ranges, err = fetch()
+	require.NoError(t, err)
+	require.Equal(t, []string{resume}, ranges)
+
+	// Refreshing the cache starts over
+	require.NoError(t, ioutil.WriteFile(partialPath, release.tarball[:half], 0644))
+	ranges, err = fetch(WithRefreshCache())
+	require.NoError(t, err)
+	require.Equal(t, []string{""}, ranges)
+
+	// Servers that ignore ranges send the whole file, which replaces it
+	require.NoError(t, ioutil.WriteFile(partialPath, release.tarball[:half], 0644))
+	release.noRanges = true
+	ranges, err = fetch()
+	require.NoError(t, err)
+	require.Equal(t, []string{resume}, ranges)
+	release.noRanges = false
+
+	// A partial download of something else fails to verify, so
+	// it's removed, and the retry starts over
+	require.NoError(t, ioutil.WriteFile(partialPath, []byte("corrupt"), 0644))
+	ranges, err = fetch(WithRetries(1, time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=7-", ""}, ranges)
+
+	require.NoError(t, ioutil.WriteFile(partialPath, []byte("corrupt"), 0644))
+	_, err = fetch()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "verifying resumed download")
+	_, err = os.Stat(partialPath)
+	require.True(t, os.IsNotExist(err), "corrupt partial download should be removed")
+}
+
+func TestFetchBinaryMirrors(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	// A mirror that's missing everything, and one that never answers
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	stalled := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hanging.Close()
+	defer close(stalled)
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-mirrors")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fetch := func(opts ...FetchOpt) (string, error) {
+		opts = append(opts, WithNotaryURL(notary.URL), WithRefreshCache())
+		return FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "", opts...)
+	}
+
+	// Failing mirrors fall through to the next
+	binPath, err := fetch(WithMirrorURLs(missing.URL, hanging.URL, mirror.URL), WithDownloadTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	contents, err := ioutil.ReadFile(binPath)
+	require.NoError(t, err)
+	require.Equal(t, "osqueryd v1", string(contents))
+	require.Equal(t, 1, release.downloadCount())
+
+	// Each mirror is retried before moving on
+	release.failures = 1
+	_, err = fetch(WithMirrorURLs(mirror.URL, missing.URL), WithRetries(1, time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 3, release.downloadCount())
+
+	// When they all fail, it's the last error that's returned
+	release.failures = 1
+	_, err = fetch(WithMirrorURLs(missing.URL, mirror.URL))
+	require.Error(t, err)
+	require.True(t, isTransient(err))
+	require.Contains(t, err.Error(), "all 2 mirrors failed")
+}
+
+func TestFetchBinaryPinned(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-pinned")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fetch := func(version string) (string, error) {
+		return FetchBinary(context.TODO(), cacheDir, "osqueryd", version, "linux", "", WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+	}
+
+	// Exact versions are fetched like channels
+	binPath, err := fetch("1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cacheDir, "osqueryd-1.2.3-linux-amd64", "osqueryd"), binPath)
+
+	_, err = fetch("9.9.9")
+	require.Error(t, err)
+
+	// A pinned hash resolves to the version, and shares its cache
+	binPath, err = fetch("sha256:" + hex.EncodeToString(release.publishedSum()))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cacheDir, "osqueryd-1.2.3-linux-amd64", "osqueryd"), binPath)
+	require.Equal(t, 1, release.downloadCount())
+
+	sum := sha256.Sum256([]byte("something else"))
+	_, err = fetch("sha256:" + hex.EncodeToString(sum[:]))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no TUF target")
+
+	_, err = fetch("sha256:abc")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid sha256")
+}
+
+func TestFetchBinaryCommit(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{versions: []string{"stable", "1.2.3", "1.2.4-3-gabcdef0123", "dev-abcdef9"}}
+	release.setRelease(t, "osqueryd dev")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-commit")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fetch := func(version string) (string, error) {
+		return FetchBinary(context.TODO(), cacheDir, "osqueryd", version, "linux", "", WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+	}
+
+	// A commit resolves to the version built from it, and shares its
+	// cache
+	binPath, err := fetch("sha:abcdef0")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cacheDir, "osqueryd-1.2.4-3-gabcdef0123-linux-amd64", "osqueryd"), binPath)
+
+	binPath, err = fetch("sha:ABCDEF0123")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cacheDir, "osqueryd-1.2.4-3-gabcdef0123-linux-amd64", "osqueryd"), binPath)
+
+	_, err = fetch("sha:1234567")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no build of commit 1234567")
+
+	for _, bad := range []string{"sha:abc", "sha:notahash", "sha:"} {
+		_, err = fetch(bad)
+		require.Error(t, err, bad)
+		require.Contains(t, err.Error(), "invalid commit", bad)
+	}
+}
+
+func TestVersionCommit(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		version string
+		commit  string
+	}{
+		{version: "0.11.4-3-gabcdef0", commit: "abcdef0"},
+		{version: "0.11.4-3-gabcdef0-dirty", commit: ""},
+		{version: "abcdef0123456789abcdef0123456789abcdef01", commit: "abcdef0123456789abcdef0123456789abcdef01"},
+		{version: "0.11.4", commit: ""},
+		{version: "stable", commit: ""},
+		{version: "nightly-abcdef0", commit: ""},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.commit, versionCommit(tt.version), tt.version)
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+
+	resolve := func(version string, opts ...FetchOpt) (string, error) {
+		opts = append(opts, WithNotaryURL(notary.URL))
+		return ResolveVersion(context.TODO(), "osqueryd", version, "linux", "", opts...)
+	}
+
+	// Channels, exact versions, and pinned hashes, all resolve to the version
+	for _, version := range []string{"stable", "1.2.3", "sha256:" + hex.EncodeToString(release.publishedSum())} {
+		resolved, err := resolve(version)
+		require.NoError(t, err, version)
+		require.Equal(t, "1.2.3", resolved, version)
+	}
+
+	_, err := resolve("nightly")
+	require.Error(t, err)
+
+	// Resolving needs notary
+	_, err = resolve("stable", WithNoNetwork())
+	require.Error(t, err)
+}
+
+func TestLookupBinary(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	lookup := func(version string) (string, error) {
+		return LookupBinary(context.TODO(), "osqueryd", version, "linux", "", WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL))
+	}
+
+	targetName, err := lookup("stable")
+	require.NoError(t, err)
+	require.Equal(t, "linux/osqueryd-stable.tar.gz", targetName)
+
+	targetName, err = lookup("sha256:" + hex.EncodeToString(release.publishedSum()))
+	require.NoError(t, err)
+	require.Equal(t, "linux/osqueryd-1.2.3.tar.gz", targetName)
+
+	_, err = lookup("nightly")
+	require.Error(t, err)
+
+	// Nothing is downloaded
+	require.Equal(t, 0, release.downloadCount())
+}
+
+func TestLookupDownload(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+
+	lookup := func(version string) (*Download, error) {
+		return LookupDownload(context.TODO(), "osqueryd", version, "linux", "", WithNotaryURL(notary.URL))
+	}
+
+	// Channels, and pins, are resolved to the versioned tarball
+	for _, version := range []string{"stable", "1.2.3", "sha256:" + hex.EncodeToString(release.publishedSum())} {
+		download, err := lookup(version)
+		require.NoError(t, err, version)
+		require.Equal(t, "kolide/osqueryd/linux/osqueryd-1.2.3.tar.gz", download.Path, version)
+		require.Equal(t, hex.EncodeToString(release.publishedSum()), download.SHA256, version)
+	}
+
+	_, err := lookup("nightly")
+	require.Error(t, err)
+	require.Equal(t, 0, release.downloadCount())
+}
+
+func TestFetchBinaryProxy(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	// The proxy serves both, since the real hosts don't resolve
+	var proxied []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.Host)
+		mu.Unlock()
+
+		switch r.Host {
+		case "notary.invalid":
+			release.notary(w, r)
+		case "mirror.invalid":
+			release.mirror(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	_, err = FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "",
+		WithNotaryURL("http://notary.invalid"), WithMirrorURL("http://mirror.invalid"), WithProxy(proxyURL))
+	require.NoError(t, err)
+	require.Equal(t, 1, release.downloadCount())
+	require.Equal(t, []string{"notary.invalid", "notary.invalid", "mirror.invalid"}, proxied)
+}
+
+func TestProgressWriter(t *testing.T) {
+	t.Parallel()
+
+	var logBytes bytes.Buffer
+	pw := &progressWriter{
+		logger: log.NewLogfmtLogger(&logBytes),
+		url:    "https://dl.example.com/osqueryd.tar.gz",
+		total:  100,
+	}
+
+	for i := 0; i < 20; i++ {
+		_, err := pw.Write(make([]byte, 5))
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 10, strings.Count(logBytes.String(), "download progress"))
+	require.Contains(t, logBytes.String(), "bytes=100 total=100")
+}
@@ -0,0 +1,107 @@
+package packaging
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// launcherManagedOsqueryFlags are the osquery flags launcher sets
+// itself. See createOsquerydCommand in pkg/osquery/runtime. Changing
+// these would break launcher's management of osquery.
+var launcherManagedOsqueryFlags = map[string]bool{
+	"pidfile":              true,
+	"database_path":        true,
+	"extensions_socket":    true,
+	"extensions_autoload":  true,
+	"config_plugin":        true,
+	"logger_plugin":        true,
+	"distributed_plugin":   true,
+	"disable_distributed":  true,
+	"distributed_interval": true,
+	"pack_delimiter":       true,
+	"config_refresh":       true,
+	"host_identifier":      true,
+	"force":                true,
+	"disable_watchdog":     true,
+	"utc":                  true,
+	"allow_unsafe":         true,
+	"flagfile":             true,
+}
+
+// ValidateOsqueryFlagfile checks that an osquery flagfile is
+// readable, and doesn't set any flags launcher manages.
+func ValidateOsqueryFlagfile(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening osquery flagfile")
+	}
+	defer fh.Close()
+
+	conflicts := []string{}
+
+	scanner := bufio.NewScanner(fh)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "--") {
+			return errors.Errorf("osquery flagfile %s line %d: expected a --flag, got %q", path, lineNum, line)
+		}
+
+		name := strings.TrimPrefix(line, "--")
+		if i := strings.IndexAny(name, "= \t"); i != -1 {
+			name = name[:i]
+		}
+
+		// gflags negates booleans with a no prefix
+		if launcherManagedOsqueryFlags[name] || launcherManagedOsqueryFlags[strings.TrimPrefix(name, "no")] {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "reading osquery flagfile")
+	}
+
+	if len(conflicts) > 0 {
+		return errors.Errorf("osquery flagfile %s sets flags managed by launcher: %s", path, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// ValidateOsqueryConfig checks that a static osquery config is a JSON
+// object, and that its options don't set any flags launcher manages.
+func ValidateOsqueryConfig(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading osquery config")
+	}
+
+	var config struct {
+		Options map[string]json.RawMessage `json:"options"`
+	}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return errors.Wrapf(err, "osquery config %s is not a JSON object", path)
+	}
+
+	conflicts := []string{}
+	for name := range config.Options {
+		if launcherManagedOsqueryFlags[name] {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return errors.Errorf("osquery config %s sets options managed by launcher: %s", path, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
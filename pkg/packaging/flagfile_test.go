@@ -0,0 +1,80 @@
+package packaging
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOsqueryFlagfile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-flagfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		contents string
+		err      string
+	}{
+		{contents: "--watchdog_memory_limit=350\n\n# comment\n--verbose\n"},
+		{contents: ""},
+		{contents: "--watchdog_memory_limit=350\n--config_plugin=filesystem\n", err: "config_plugin"},
+		{contents: "--utc=false\n--nodisable_watchdog\n", err: "utc, nodisable_watchdog"},
+		{contents: "--flagfile /tmp/other\n", err: "flagfile"},
+		{contents: "verbose\n", err: "expected a --flag"},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("osquery-%d.flags", i))
+		require.NoError(t, ioutil.WriteFile(path, []byte(tt.contents), 0644))
+
+		err := ValidateOsqueryFlagfile(path)
+		if tt.err == "" {
+			require.NoError(t, err, tt.contents)
+			continue
+		}
+		require.Error(t, err, tt.contents)
+		require.Contains(t, err.Error(), tt.err)
+	}
+
+	require.Error(t, ValidateOsqueryFlagfile(filepath.Join(dir, "missing.flags")))
+}
+
+func TestValidateOsqueryConfig(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-osquery-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		contents string
+		err      string
+	}{
+		{contents: `{"schedule": {"uptime": {"query": "select * from uptime", "interval": 60}}}`},
+		{contents: `{"options": {"schedule_splay_percent": 10}}`},
+		{contents: `{"options": {"utc": false, "config_plugin": "filesystem"}}`, err: "config_plugin, utc"},
+		{contents: `{"schedule": `, err: "not a JSON object"},
+		{contents: `["schedule"]`, err: "not a JSON object"},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("osquery-%d.conf", i))
+		require.NoError(t, ioutil.WriteFile(path, []byte(tt.contents), 0644))
+
+		err := ValidateOsqueryConfig(path)
+		if tt.err == "" {
+			require.NoError(t, err, tt.contents)
+			continue
+		}
+		require.Error(t, err, tt.contents)
+		require.Contains(t, err.Error(), tt.err)
+	}
+
+	require.Error(t, ValidateOsqueryConfig(filepath.Join(dir, "missing.conf")))
+}
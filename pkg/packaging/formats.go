@@ -0,0 +1,146 @@
+package packaging
+
+import (
+	"context"
+	"io"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+)
+
+// These are the Builders of the package formats package-builder knows
+// about. See builders.
+
+// fpmBuilder builds linux packages with fpm, in docker. signingTool
+// is needed to sign them, with a LinuxSigningKey, unless UseDocker
+// signs them in the container too.
+type fpmBuilder struct {
+	extension   string
+	outputType  packagekit.FpmOpt
+	signingTool string
+	sysVInit    bool // The init.d script uses start-stop-daemon, which is debian specific
+}
+
+func (b *fpmBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	fpmOpts, err := p.fpmOpts(b.outputType)
+	if err != nil {
+		return err
+	}
+	return packagekit.PackageFPM(ctx, w, p.packagekitops, fpmOpts...)
+}
+
+func (b *fpmBuilder) Extension() string { return b.extension }
+
+func (b *fpmBuilder) Supports(target Target) bool {
+	return target.Platform == Linux && (target.Init != SysVInit || b.sysVInit)
+}
+
+func (b *fpmBuilder) Tools(p *PackageOptions, target Target) []string {
+	if p.LinuxSigningKey != "" && b.signingTool != "" && !p.usesDocker(target) {
+		return []string{"docker", b.signingTool}
+	}
+	return []string{"docker"}
+}
+
+// pkgBuilder builds macOS pkgs with pkgbuild. They're signed with
+// pkgutil, and notarized with xcrun, if asked.
+type pkgBuilder struct{}
+
+func (pkgBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	pkgOpts := []packagekit.PkgOpt{}
+	if p.Notarize != nil {
+		pkgOpts = append(pkgOpts, packagekit.WithNotarization(p.Notarize))
+	}
+	return packagekit.PackagePkg(ctx, w, p.packagekitops, pkgOpts...)
+}
+
+func (pkgBuilder) Extension() string { return "pkg" }
+
+func (pkgBuilder) Supports(target Target) bool { return target.Platform == Darwin }
+
+func (pkgBuilder) Tools(p *PackageOptions, target Target) []string {
+	tools := []string{"pkgbuild"}
+	if p.SigningKey != "" {
+		tools = append(tools, "pkgutil")
+	}
+	if p.Notarize != nil {
+		tools = append(tools, "xcrun")
+	}
+	return tools
+}
+
+// tarBuilder builds tarballs of the package root, in Go.
+type tarBuilder struct{}
+
+func (tarBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	return packagekit.PackageTar(ctx, w, p.packagekitops)
+}
+
+func (tarBuilder) Extension() string { return "tar.gz" }
+
+func (tarBuilder) Supports(target Target) bool {
+	return target.Platform != Windows && target.Init != SysVInit
+}
+
+func (tarBuilder) Tools(p *PackageOptions, target Target) []string { return nil }
+
+// wixBuilder builds windows packages with wix, in docker.
+type wixBuilder struct {
+	extension  string
+	packageWix func(context.Context, io.Writer, *packagekit.PackageOptions, ...packagekit.WixOpt) error
+}
+
+func (b *wixBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	return b.packageWix(ctx, w, p.packagekitops, p.wixOpts()...)
+}
+
+func (b *wixBuilder) Extension() string { return b.extension }
+
+func (b *wixBuilder) Supports(target Target) bool { return target.Platform == Windows }
+
+func (b *wixBuilder) Tools(p *PackageOptions, target Target) []string { return []string{"docker"} }
+
+// freeBSDBuilder builds FreeBSD pkgng packages, in Go.
+type freeBSDBuilder struct{}
+
+func (freeBSDBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	return packagekit.PackageFreeBSD(ctx, w, p.packagekitops)
+}
+
+func (freeBSDBuilder) Extension() string { return "pkg" }
+
+func (freeBSDBuilder) Supports(target Target) bool { return target.Platform == FreeBSD }
+
+func (freeBSDBuilder) Tools(p *PackageOptions, target Target) []string { return nil }
+
+// snapBuilder builds snaps with snapcraft. snapd runs the daemon as a
+// systemd service, from the snap's definition of it, so they only
+// support systemd.
+type snapBuilder struct{}
+
+func (snapBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	return packagekit.PackageSnap(ctx, w, p.packagekitops, p.snapOpts()...)
+}
+
+func (snapBuilder) Extension() string { return "snap" }
+
+func (snapBuilder) Supports(target Target) bool {
+	return target.Platform == Linux && target.Init == SystemD
+}
+
+func (snapBuilder) Tools(p *PackageOptions, target Target) []string { return []string{"snapcraft"} }
+
+// ociBuilder builds OCI images, in Go. The container runtime runs
+// launcher directly, so they have no init system.
+type ociBuilder struct{}
+
+func (ociBuilder) Build(ctx context.Context, p *PackageOptions, target Target, w io.Writer) error {
+	return packagekit.PackageOCI(ctx, w, p.packagekitops, p.ociOpts()...)
+}
+
+func (ociBuilder) Extension() string { return "oci.tar" }
+
+func (ociBuilder) Supports(target Target) bool {
+	return target.Platform == Linux && target.Init == NoInit
+}
+
+func (ociBuilder) Tools(p *PackageOptions, target Target) []string { return nil }
@@ -1,7 +1,17 @@
 package packaging
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
 )
 
 // sanitizeHostname will replace any ":" characters in a given hostname with "-"
@@ -9,3 +19,193 @@ import (
 func sanitizeHostname(hostname string) string {
 	return strings.Replace(hostname, ":", "-", -1)
 }
+
+// ParseHostnames splits a comma separated list of gRPC servers, in
+// priority order. Each must be a host, with an optional port.
+func ParseHostnames(s string) ([]string, error) {
+	hostnames := []string{}
+	for _, hostname := range strings.Split(s, ",") {
+		hostname = strings.TrimSpace(hostname)
+		if err := validateHostname(hostname); err != nil {
+			return nil, errors.Wrapf(err, "invalid hostname %q", hostname)
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames, nil
+}
+
+func validateHostname(hostname string) error {
+	host := hostname
+	if strings.Contains(hostname, ":") {
+		var port string
+		var err error
+		if host, port, err = net.SplitHostPort(hostname); err != nil {
+			return err
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return errors.Errorf("invalid port %q", port)
+		}
+	}
+
+	if host == "" {
+		return errors.New("empty host")
+	}
+	// The hostname is interpolated into the init files and scripts,
+	// so only allow what DNS names, and IPs, need.
+	if !hostRegexp.MatchString(host) {
+		return errors.New("host contains invalid characters")
+	}
+	return nil
+}
+
+// hostRegexp matches DNS names, and IPv4 and IPv6 addresses.
+var hostRegexp = regexp.MustCompile(`^[a-zA-Z0-9._:-]+$`)
+
+var identifierRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// ValidateIdentifier checks identifier is a valid package identifier.
+// It ends up in paths, service names, and the install scripts, so is
+// kept to characters that are safe in all of them.
+func ValidateIdentifier(identifier string) error {
+	if !identifierRegexp.MatchString(identifier) || identifier == "." || identifier == ".." {
+		return errors.Errorf("invalid identifier %q. Identifiers may only contain letters, numbers, '.', '_', and '-'", identifier)
+	}
+	return nil
+}
+
+// unsafeScriptChars could break out of, or be expanded in, the init
+// files and install scripts.
+const unsafeScriptChars = " \t\"'`$\\;&|<>(){}[]*?!#~"
+
+// validateScriptValue checks that value, the option name, is safe to
+// interpolate into the init files and install scripts. windows
+// services are defined in the MSI's XML, so only control characters
+// matter there.
+func validateScriptValue(name, value string, target Target) error {
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return errors.Errorf("%s can't contain control characters, such as newlines", name)
+		}
+	}
+	if target.Platform != Windows && strings.ContainsAny(value, unsafeScriptChars) {
+		return errors.Errorf("%s %q can't contain whitespace, quotes, or shell metacharacters", name, value)
+	}
+	return nil
+}
+
+// launcherLogLevels are the values launcher's --log_level accepts.
+var launcherLogLevels = []string{"debug", "info", "warn", "error"}
+
+// ValidateLauncherLogLevel checks that logLevel is one launcher
+// accepts. Empty is allowed, and leaves launcher at its default.
+func ValidateLauncherLogLevel(logLevel string) error {
+	if logLevel == "" {
+		return nil
+	}
+	for _, l := range launcherLogLevels {
+		if logLevel == l {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown launcher log level %s (options: %s)", logLevel, strings.Join(launcherLogLevels, ", "))
+}
+
+// ParseRootPEMs splits a comma separated list of PEM files, and
+// checks that each contains at least one valid certificate.
+func ParseRootPEMs(s string) ([]string, error) {
+	paths := []string{}
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if err := validatePEM(path); err != nil {
+			return nil, errors.Wrapf(err, "invalid root PEM %s", path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func validatePEM(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading")
+	}
+
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			return errors.New("no certificates found")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return errors.Wrap(err, "parsing certificate")
+		}
+		return nil
+	}
+}
+
+// mergePEMs concatenates PEM files into a single bundle at dst.
+func mergePEMs(dst string, paths []string) error {
+	var bundle bytes.Buffer
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", path)
+		}
+		bundle.Write(contents)
+		if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+			bundle.WriteByte('\n')
+		}
+	}
+
+	return ioutil.WriteFile(dst, bundle.Bytes(), 0600)
+}
+
+// sizeUnits are the suffixes ParseSize accepts, largest first so
+// "MB" isn't read as "B". These are decimal units, as used by most
+// deployment tools when quoting limits.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1000 * 1000 * 1000},
+	{"MB", 1000 * 1000},
+	{"KB", 1000},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable size, such as 50MB or 1.5GB, into
+// bytes. A bare number is bytes.
+func ParseSize(s string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, errors.Errorf("invalid size %q. Expected a number, with an optional KB, MB, or GB suffix", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatSize formats bytes in the largest unit ParseSize accepts.
+func FormatSize(bytes int64) string {
+	for _, unit := range sizeUnits[:len(sizeUnits)-1] {
+		if bytes >= unit.bytes {
+			return strconv.FormatFloat(float64(bytes)/float64(unit.bytes), 'f', 1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10) + "B"
+}
@@ -1,7 +1,19 @@
 package packaging
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -27,3 +39,151 @@ func TestSanitizeHostname(t *testing.T) {
 		require.Equal(t, tt.out, sanitizeHostname(tt.in))
 	}
 }
+
+func TestParseHostnames(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		in  string
+		out []string
+		err bool
+	}{
+		{in: "grpc.example.com:443", out: []string{"grpc.example.com:443"}},
+		{in: "grpc.example.com", out: []string{"grpc.example.com"}},
+		{in: "a.example.com:443, b.example.com:8443", out: []string{"a.example.com:443", "b.example.com:8443"}},
+		{in: "[::1]:443", out: []string{"[::1]:443"}},
+		{in: "", err: true},
+		{in: "a.example.com:443,", err: true},
+		{in: ":443", err: true},
+		{in: "a.example.com:https", err: true},
+		{in: "a.example.com:99999", err: true},
+		{in: "https://a.example.com", err: true},
+		{in: "a.example.com$(reboot):443", err: true},
+		{in: "a.example.com\"; reboot; \":443", err: true},
+		{in: "a.example.com\n:443", err: true},
+		{in: "`reboot`", err: true},
+	}
+
+	for _, tt := range tests {
+		out, err := ParseHostnames(tt.in)
+		if tt.err {
+			require.Error(t, err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.out, out)
+	}
+}
+
+func TestValidateLauncherLogLevel(t *testing.T) {
+	t.Parallel()
+
+	for _, logLevel := range []string{"", "debug", "info", "warn", "error"} {
+		require.NoError(t, ValidateLauncherLogLevel(logLevel), logLevel)
+	}
+
+	for _, logLevel := range []string{"DEBUG", "warning", "trace", " info"} {
+		require.Error(t, ValidateLauncherLogLevel(logLevel), logLevel)
+	}
+}
+
+func TestParseRootPEMs(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-root-pems")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caOne := filepath.Join(dir, "one.pem")
+	caTwo := filepath.Join(dir, "two.pem")
+	notCert := filepath.Join(dir, "key.pem")
+	garbage := filepath.Join(dir, "garbage.pem")
+
+	require.NoError(t, ioutil.WriteFile(caOne, testCertPEM(t, "one"), 0644))
+	require.NoError(t, ioutil.WriteFile(caTwo, bytes.TrimRight(testCertPEM(t, "two"), "\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(notCert, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), 0644))
+	require.NoError(t, ioutil.WriteFile(garbage, []byte("not a pem"), 0644))
+
+	paths, err := ParseRootPEMs(caOne + ", " + caTwo)
+	require.NoError(t, err)
+	require.Equal(t, []string{caOne, caTwo}, paths)
+
+	paths, err = ParseRootPEMs("")
+	require.NoError(t, err)
+	require.Empty(t, paths)
+
+	for _, bad := range []string{notCert, garbage, filepath.Join(dir, "missing.pem"), caOne + "," + garbage} {
+		_, err := ParseRootPEMs(bad)
+		require.Error(t, err, bad)
+	}
+
+	// Merging them produces one bundle, with both certificates
+	bundlePath := filepath.Join(dir, "roots.pem")
+	require.NoError(t, mergePEMs(bundlePath, []string{caOne, caTwo}))
+
+	bundle, err := ioutil.ReadFile(bundlePath)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(bundle))
+	require.Len(t, pool.Subjects(), 2)
+}
+
+func testCertPEM(t *testing.T, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		in  string
+		out int64
+		err bool
+	}{
+		{in: "50MB", out: 50 * 1000 * 1000},
+		{in: "50mb", out: 50 * 1000 * 1000},
+		{in: "1.5GB", out: 1500 * 1000 * 1000},
+		{in: "512 KB", out: 512 * 1000},
+		{in: "100B", out: 100},
+		{in: "100", out: 100},
+		{in: "", err: true},
+		{in: "MB", err: true},
+		{in: "-1MB", err: true},
+		{in: "50TB", err: true},
+		{in: "fifty", err: true},
+	}
+
+	for _, tt := range tests {
+		out, err := ParseSize(tt.in)
+		if tt.err {
+			require.Error(t, err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.out, out, tt.in)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "999B", FormatSize(999))
+	require.Equal(t, "1.0KB", FormatSize(1000))
+	require.Equal(t, "61.2MB", FormatSize(61234567))
+	require.Equal(t, "2.5GB", FormatSize(2500*1000*1000))
+}
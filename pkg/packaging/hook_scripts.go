@@ -0,0 +1,111 @@
+package packaging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateHookScript checks that path is a non-empty script, starting
+// with a #! line, for use as a PreinstallScript, PostinstallScript, or
+// PreremoveScript.
+func ValidateHookScript(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading install script %s", path)
+	}
+	if len(bytes.TrimSpace(contents)) == 0 {
+		return errors.Errorf("install script %s is empty", path)
+	}
+	if !bytes.HasPrefix(contents, []byte("#!")) {
+		return errors.Errorf("install script %s must start with a #! line", path)
+	}
+	return nil
+}
+
+// hookScripts maps the package script names to the user's scripts, for
+// the ones that are set.
+func (p *PackageOptions) hookScripts() map[string]string {
+	hooks := make(map[string]string)
+	for name, path := range map[string]string{
+		"preinstall":  p.PreinstallScript,
+		"postinstall": p.PostinstallScript,
+		"prerm":       p.PreremoveScript,
+	} {
+		if path != "" {
+			hooks[name] = path
+		}
+	}
+	return hooks
+}
+
+// validateHookScripts checks the user's install scripts are valid, and
+// can be run by target's package type.
+func (p *PackageOptions) validateHookScripts(target Target) error {
+	hooks := p.hookScripts()
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	for _, path := range hooks {
+		if err := ValidateHookScript(path); err != nil {
+			return err
+		}
+	}
+
+	switch target.Package {
+	case Deb, Rpm, Pacman, FreeBSDPkg:
+	case Pkg:
+		if _, ok := hooks["prerm"]; ok {
+			return errors.New("macOS pkgs have no uninstall, so can't run a preremove script")
+		}
+	case Tar:
+		return errors.New("tar packages don't run install scripts")
+	default:
+		return errors.Errorf("install scripts aren't supported for %s", target.String())
+	}
+	return nil
+}
+
+// setupHookScripts appends the user's install scripts to the generated
+// ones, so the generated logic runs first. Where nothing is generated,
+// the user's script is used as is. When appended, the #! line is
+// dropped, so the user's script is run by the generated script's
+// shell, with its `set -e`.
+func (p *PackageOptions) setupHookScripts() error {
+	for name, hookPath := range p.hookScripts() {
+		hook, err := ioutil.ReadFile(hookPath)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s script %s", name, hookPath)
+		}
+
+		scriptPath := filepath.Join(p.scriptRoot, name)
+		generated, err := ioutil.ReadFile(scriptPath)
+		switch {
+		case os.IsNotExist(err):
+			generated = hook
+		case err != nil:
+			return errors.Wrapf(err, "reading generated %s script", name)
+		default:
+			if i := bytes.IndexByte(hook, '\n'); i >= 0 {
+				hook = hook[i+1:]
+			} else {
+				hook = nil
+			}
+			generated = append(bytes.TrimRight(generated, "\n"), '\n', '\n')
+			generated = append(generated, []byte("# "+filepath.Base(hookPath)+"\n")...)
+			generated = append(generated, hook...)
+		}
+
+		if err := ioutil.WriteFile(scriptPath, generated, 0755); err != nil {
+			return errors.Wrapf(err, "writing %s script", name)
+		}
+		if err := os.Chmod(scriptPath, 0755); err != nil {
+			return errors.Wrapf(err, "chmod %s script", name)
+		}
+	}
+	return nil
+}
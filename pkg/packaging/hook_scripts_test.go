@@ -0,0 +1,96 @@
+package packaging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHookScripts(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-hook-scripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.sh")
+	require.NoError(t, ioutil.WriteFile(good, []byte("#!/bin/sh\necho hi\n"), 0755))
+	empty := filepath.Join(dir, "empty.sh")
+	require.NoError(t, ioutil.WriteFile(empty, []byte("\n"), 0755))
+	noShebang := filepath.Join(dir, "no-shebang.sh")
+	require.NoError(t, ioutil.WriteFile(noShebang, []byte("echo hi\n"), 0755))
+
+	require.NoError(t, ValidateHookScript(good))
+	require.Error(t, ValidateHookScript(empty))
+	require.Error(t, ValidateHookScript(noShebang))
+	require.Error(t, ValidateHookScript(filepath.Join(dir, "missing.sh")))
+
+	deb := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	pkg := Target{Platform: Darwin, Init: LaunchD, Package: Pkg}
+	tar := Target{Platform: Linux, Init: SystemD, Package: Tar}
+	msi := Target{Platform: Windows, Init: WindowsService, Package: Msi}
+
+	var tests = []struct {
+		po     PackageOptions
+		target Target
+		valid  bool
+	}{
+		{po: PackageOptions{}, target: tar, valid: true},
+		{po: PackageOptions{PreinstallScript: good, PostinstallScript: good, PreremoveScript: good}, target: deb, valid: true},
+		{po: PackageOptions{PreinstallScript: good, PostinstallScript: good}, target: pkg, valid: true},
+		{po: PackageOptions{PreremoveScript: good}, target: pkg},
+		{po: PackageOptions{PostinstallScript: good}, target: tar},
+		{po: PackageOptions{PostinstallScript: good}, target: msi},
+		{po: PackageOptions{PostinstallScript: noShebang}, target: deb},
+	}
+
+	for i, tt := range tests {
+		err := tt.po.validateHookScripts(tt.target)
+		if tt.valid {
+			require.NoError(t, err, i)
+		} else {
+			require.Error(t, err, i)
+		}
+	}
+}
+
+func TestSetupHookScripts(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-hook-scripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hook := filepath.Join(dir, "provision.sh")
+	require.NoError(t, ioutil.WriteFile(hook, []byte("#!/bin/sh\n/opt/acme/provision\n"), 0644))
+
+	p := &PackageOptions{
+		scriptRoot:        filepath.Join(dir, "scripts"),
+		PreinstallScript:  hook,
+		PostinstallScript: hook,
+	}
+	require.NoError(t, os.MkdirAll(p.scriptRoot, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(p.scriptRoot, "postinstall"), []byte("#!/bin/sh\nset -e\nsystemctl restart launcher"), 0755))
+
+	require.NoError(t, p.setupHookScripts())
+
+	// The generated script runs first
+	postinstall, err := ioutil.ReadFile(filepath.Join(p.scriptRoot, "postinstall"))
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh\nset -e\nsystemctl restart launcher\n\n# provision.sh\n/opt/acme/provision\n", string(postinstall))
+
+	// Without a generated script, the hook is used as is
+	preinstall, err := ioutil.ReadFile(filepath.Join(p.scriptRoot, "preinstall"))
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh\n/opt/acme/provision\n", string(preinstall))
+
+	info, err := os.Stat(filepath.Join(p.scriptRoot, "preinstall"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	_, err = os.Stat(filepath.Join(p.scriptRoot, "prerm"))
+	require.True(t, os.IsNotExist(err))
+}
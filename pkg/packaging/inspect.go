@@ -0,0 +1,470 @@
+package packaging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/groob/plist"
+	"github.com/pkg/errors"
+)
+
+// PackageInfo describes the launcher configuration embedded in a
+// built package.
+type PackageInfo struct {
+	Path           string
+	Platform       PlatformFlavor
+	InitFile       string            // path of the init file, inside the package
+	Flags          []string          // flags launcher is started with
+	Environment    map[string]string // environment launcher is started with
+	SecretIncluded bool              // whether the enroll secret is in the package
+	Binaries       map[string]string // bundled binaries, and their versions, per Metadata. Versions are unknown without it.
+	Metadata       *BuildMetadata    // the embedded version.json, if the package has one
+}
+
+// Hostname is the gRPC server the package enrolls with.
+func (i *PackageInfo) Hostname() string {
+	return i.Environment["KOLIDE_LAUNCHER_HOSTNAME"]
+}
+
+// UpdateChannel is the autoupdate channel, if set.
+func (i *PackageInfo) UpdateChannel() string {
+	return i.Environment["KOLIDE_LAUNCHER_UPDATE_CHANNEL"]
+}
+
+// CertPins are the pinned certificate hashes, if set.
+func (i *PackageInfo) CertPins() string {
+	return i.Environment["KOLIDE_LAUNCHER_CERT_PINS"]
+}
+
+// InspectPackage extracts a built deb, rpm, pkg, or msi, and reports
+// the launcher configuration inside it. Extraction uses the
+// platform's own tooling: debs and rpms are extracted in the
+// kolide/fpm docker container, pkgs require macOS' pkgutil, and msis
+// require msitools.
+func InspectPackage(ctx context.Context, packagePath string) (*PackageInfo, error) {
+	packagePath, err := filepath.Abs(packagePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "absolute path")
+	}
+
+	if _, err := os.Stat(packagePath); err != nil {
+		return nil, errors.Wrap(err, "stat package")
+	}
+
+	extractDir, err := ioutil.TempDir("", "package.inspect")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create temporary extraction directory")
+	}
+	defer os.RemoveAll(extractDir)
+
+	info := &PackageInfo{
+		Path:        packagePath,
+		Environment: make(map[string]string),
+		Binaries:    make(map[string]string),
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(packagePath)); ext {
+	case ".deb":
+		info.Platform = Linux
+		err = extractInDocker(ctx, packagePath, extractDir, `cd /out && ar x /pkg/"$0" && tar -xf data.tar.* && rm data.tar.* control.tar.* debian-binary`)
+	case ".rpm":
+		info.Platform = Linux
+		err = extractInDocker(ctx, packagePath, extractDir, `cd /out && rpm2cpio /pkg/"$0" | cpio -idm --quiet`)
+	case ".pkg":
+		info.Platform = Darwin
+		err = extractPkg(ctx, packagePath, extractDir)
+	case ".msi":
+		info.Platform = Windows
+		err = extractMsi(ctx, packagePath, extractDir, info)
+	default:
+		return nil, errors.Errorf("Don't know how to inspect %s packages", ext)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "extracting %s", packagePath)
+	}
+
+	if err := inspectRoot(ctx, extractDir, info); err != nil {
+		return nil, errors.Wrapf(err, "inspecting %s", packagePath)
+	}
+
+	return info, nil
+}
+
+func extractInDocker(ctx context.Context, packagePath, extractDir, script string) error {
+	dockerArgs := []string{
+		"run", "--rm",
+		"-v", fmt.Sprintf("%s:/pkg", filepath.Dir(packagePath)),
+		"-v", fmt.Sprintf("%s:/out", extractDir),
+		"kolide/fpm",
+		"sh", "-c", script, filepath.Base(packagePath),
+	}
+
+	return runQuiet(ctx, "docker", dockerArgs...)
+}
+
+// extractPkg expands a macOS package. pkgutil insists on creating
+// the destination itself.
+func extractPkg(ctx context.Context, packagePath, extractDir string) error {
+	if runtime.GOOS != "darwin" {
+		return errors.New("inspecting macOS packages requires pkgutil, which is only available on macOS")
+	}
+
+	return runQuiet(ctx, "pkgutil", "--expand-full", packagePath, filepath.Join(extractDir, "expanded"))
+}
+
+// extractMsi extracts the files from an MSI. The service
+// configuration isn't a file, so it's read from the MSI's tables.
+func extractMsi(ctx context.Context, packagePath, extractDir string, info *PackageInfo) error {
+	if err := runQuiet(ctx, "msiextract", "-C", extractDir, packagePath); err != nil {
+		return errors.Wrap(err, "msiextract. Is msitools installed?")
+	}
+
+	services, err := msiTable(ctx, packagePath, "ServiceInstall")
+	if err != nil {
+		return errors.Wrap(err, "reading services")
+	}
+	if len(services) > 0 {
+		info.InitFile = fmt.Sprintf("service %s", services[0]["Name"])
+		info.Flags = strings.Fields(services[0]["Arguments"])
+	}
+
+	registry, err := msiTable(ctx, packagePath, "Registry")
+	if err != nil {
+		return errors.Wrap(err, "reading registry")
+	}
+	for _, row := range registry {
+		if row["Name"] != "Environment" {
+			continue
+		}
+		// MSIs store multistring values delimited by [~]
+		for _, kv := range strings.Split(row["Value"], "[~]") {
+			if s := strings.SplitN(kv, "=", 2); len(s) == 2 {
+				info.Environment[s[0]] = s[1]
+			}
+		}
+	}
+
+	return nil
+}
+
+// msiTable exports an MSI table, as a row per map. msiinfo exports
+// tab separated rows. The first row is the column names, followed
+// by the column types, and the table's keys.
+func msiTable(ctx context.Context, packagePath, table string) ([]map[string]string, error) {
+	cmd := exec.CommandContext(ctx, "msiinfo", "export", packagePath, table)
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		// Tables that don't exist can't be exported
+		if strings.Contains(stderr.String(), "not found") {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "msiinfo export %s: %s", table, stderr)
+	}
+
+	lines := strings.Split(strings.TrimRight(stdout.String(), "\r\n"), "\n")
+	if len(lines) < 3 {
+		return nil, nil
+	}
+
+	columns := strings.Split(strings.TrimRight(lines[0], "\r"), "\t")
+	rows := []map[string]string{}
+	for _, line := range lines[3:] {
+		row := make(map[string]string)
+		for i, value := range strings.Split(strings.TrimRight(line, "\r"), "\t") {
+			if i < len(columns) {
+				row[columns[i]] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// inspectRoot examines an extracted package tree.
+func inspectRoot(ctx context.Context, root string, info *PackageInfo) error {
+	files := []string{}
+	if err := filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			files = append(files, file)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "walking package")
+	}
+
+	for _, file := range files {
+		relPath := filepath.ToSlash(strings.TrimPrefix(file, root))
+
+		var err error
+		switch {
+		case strings.Contains(relPath, "/Library/LaunchDaemons/") && strings.HasSuffix(relPath, ".plist"):
+			err = parseLaunchd(file, info)
+		case strings.Contains(relPath, "/systemd/system/") && strings.HasSuffix(relPath, ".service"):
+			err = parseInitConf(file, "Environment=", "ExecStart=", info)
+		case strings.Contains(relPath, "/etc/init/") && strings.HasSuffix(relPath, ".conf"):
+			err = parseInitConf(file, "env ", "exec ", info)
+		case strings.Contains(relPath, "/etc/init.d/"):
+			err = parseInitd(file, info)
+		default:
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "parsing init file %s", relPath)
+		}
+		info.InitFile = relPath
+	}
+
+	// The enroll secret path is always set, so look for the secret
+	// there, and the build metadata next to it. Extracted packages
+	// have different prefixes, so match on the suffix.
+	if secretPath := installedToRelative(info.Environment["KOLIDE_LAUNCHER_ENROLL_SECRET_PATH"]); secretPath != "" {
+		metadataPath := path.Join(path.Dir(secretPath), buildMetadataName)
+		for _, file := range files {
+			switch {
+			case strings.HasSuffix(filepath.ToSlash(file), secretPath):
+				info.SecretIncluded = true
+			case strings.HasSuffix(filepath.ToSlash(file), metadataPath):
+				metadata, err := readBuildMetadata(file)
+				if err != nil {
+					return err
+				}
+				info.Metadata = metadata
+			}
+		}
+	}
+
+	// The package may not be trustworthy, so binaries are never run to
+	// ask their versions. They're taken from the build metadata.
+	binDir := path.Dir(installedToRelative(info.Environment["KOLIDE_LAUNCHER_OSQUERYD_PATH"]))
+	for _, file := range files {
+		if binDir == "." || !strings.HasSuffix(filepath.ToSlash(filepath.Dir(file)), binDir) {
+			continue
+		}
+		info.Binaries[filepath.Base(file)] = packagedVersion(info.Metadata, file)
+	}
+
+	return nil
+}
+
+func readBuildMetadata(file string) (*BuildMetadata, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading build metadata")
+	}
+	var metadata BuildMetadata
+	if err := json.Unmarshal(contents, &metadata); err != nil {
+		return nil, errors.Wrap(err, "parsing build metadata")
+	}
+	return &metadata, nil
+}
+
+// packagedVersion returns a packaged binary's version, per the build
+// metadata. It's only known if the binary is the one the metadata
+// describes.
+func packagedVersion(metadata *BuildMetadata, file string) string {
+	if metadata == nil {
+		return ""
+	}
+
+	name := filepath.Base(file)
+	if _, sum, err := hashFile(file); err != nil || metadata.Binaries[name] != sum {
+		return ""
+	}
+
+	switch strings.TrimSuffix(name, ".exe") {
+	case "launcher":
+		return metadata.LauncherVersion
+	case "osqueryd":
+		return metadata.OsqueryVersion
+	}
+	if version, ok := metadata.Extensions[name]; ok {
+		return version
+	}
+	return metadata.ExtensionVersion
+}
+
+// installedToRelative reverses installedPath, returning a slash
+// separated path without the leading root.
+func installedToRelative(installed string) string {
+	if installed == "" {
+		return ""
+	}
+	installed = strings.TrimPrefix(installed, `C:\Program Files\`)
+	installed = strings.Replace(installed, `\`, "/", -1)
+	return "/" + strings.TrimPrefix(installed, "/")
+}
+
+func parseLaunchd(file string, info *PackageInfo) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "reading")
+	}
+
+	var launchd struct {
+		Environment map[string]string `plist:"EnvironmentVariables"`
+		Args        []string          `plist:"ProgramArguments"`
+	}
+	if err := plist.Unmarshal(contents, &launchd); err != nil {
+		return errors.Wrap(err, "plist decode")
+	}
+
+	for k, v := range launchd.Environment {
+		info.Environment[k] = v
+	}
+	if len(launchd.Args) > 1 {
+		info.Flags = launchd.Args[1:]
+	}
+	return nil
+}
+
+// parseInitConf parses the line based init files, systemd units and
+// upstart confs. Environment lines are prefixed with envPrefix, and
+// the command with execPrefix. The command may be continued onto
+// following lines with a trailing backslash.
+func parseInitConf(file, envPrefix, execPrefix string, info *PackageInfo) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "opening")
+	}
+	defer fh.Close()
+
+	var command string
+	inCommand := false
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case inCommand:
+			command += " " + strings.TrimSuffix(line, `\`)
+			inCommand = strings.HasSuffix(line, `\`)
+		case strings.HasPrefix(line, envPrefix):
+			if s := strings.SplitN(strings.TrimPrefix(line, envPrefix), "=", 2); len(s) == 2 {
+				info.Environment[s[0]] = s[1]
+			}
+		case strings.HasPrefix(line, execPrefix):
+			command = strings.TrimSuffix(strings.TrimPrefix(line, execPrefix), `\`)
+			inCommand = strings.HasSuffix(line, `\`)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "reading")
+	}
+
+	if fields := strings.Fields(command); len(fields) > 1 {
+		info.Flags = fields[1:]
+	}
+	return nil
+}
+
+// parseInitd parses the init.d scripts rendered by
+// packagekit.RenderInit. Environment is set by KOLIDE_ prefixed
+// assignments, and the flags are in DAEMON_OPTS. Both are sh quoted,
+// and DAEMON_OPTS continues over lines.
+func parseInitd(file string, info *PackageInfo) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "opening")
+	}
+	defer fh.Close()
+
+	var opts string
+	inOpts := false
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case inOpts:
+			opts += "\n" + line
+		case strings.HasPrefix(line, "KOLIDE_"):
+			if s := strings.SplitN(line, "=", 2); len(s) == 2 {
+				info.Environment[s[0]], _ = shellUnquote(s[1])
+			}
+			continue
+		case strings.HasPrefix(line, "DAEMON_OPTS="):
+			opts = strings.TrimPrefix(line, "DAEMON_OPTS=")
+		default:
+			continue
+		}
+		_, closed := shellUnquote(opts)
+		inOpts = !closed
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "reading")
+	}
+
+	// DAEMON_OPTS is expanded unquoted, so it's split into flags on
+	// whitespace
+	opts, _ = shellUnquote(opts)
+	info.Flags = strings.Fields(opts)
+	return nil
+}
+
+// shellUnquote undoes the sh quoting of a word: single quotes, double
+// quotes, backslash escapes, and line continuations. It reports
+// whether the quotes were all closed, as a double quoted word may
+// continue onto the next line.
+func shellUnquote(s string) (string, bool) {
+	var word strings.Builder
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch next := s[i]; {
+			case next == '\n':
+				// A line continuation
+			case quote == '"' && !strings.ContainsRune("\\\"$`", rune(next)):
+				// In double quotes, only these are escaped
+				word.WriteByte(c)
+				word.WriteByte(next)
+			default:
+				word.WriteByte(next)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		default:
+			word.WriteByte(c)
+		}
+	}
+	return word.String(), quote == 0
+}
+
+func runQuiet(ctx context.Context, argv0 string, args ...string) error {
+	cmd := exec.CommandContext(ctx, argv0, args...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "run command %s %v, stderr=%s", argv0, args, stderr)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package packaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectRoot(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name       string
+		initFile   string
+		renderFunc func(context.Context, io.Writer, *packagekit.InitOptions) error
+		omitSecret bool
+	}{
+		{name: "systemd", initFile: "etc/systemd/system/launcher.kolide-app.service", renderFunc: systemdRenderer},
+		{name: "launchd", initFile: "Library/LaunchDaemons/com.kolide-app.launcher.plist", renderFunc: launchdRenderer},
+		{name: "upstart", initFile: "etc/init/launcher-kolide-app.conf", renderFunc: upstartRenderer, omitSecret: true},
+		{name: "sysvinit", initFile: "etc/init.d/launcher.kolide-app", renderFunc: packagekit.RenderInit},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			root, err := ioutil.TempDir("", "packaging-inspect")
+			require.NoError(t, err)
+			defer os.RemoveAll(root)
+
+			// Nest the tree, like extracted packages often are
+			packageRoot := filepath.Join(root, "Payload")
+
+			initOptions := &packagekit.InitOptions{
+				Name:        "launcher",
+				Description: "The Kolide Launcher",
+				Identifier:  "kolide-app",
+				Path:        "/usr/local/kolide-app/bin/launcher",
+				Flags:       []string{"--autoupdate", "--insecure"},
+				Environment: map[string]string{
+					"KOLIDE_LAUNCHER_HOSTNAME":           "device.example.com:443",
+					"KOLIDE_LAUNCHER_UPDATE_CHANNEL":     "beta",
+					"KOLIDE_LAUNCHER_OSQUERYD_PATH":      "/usr/local/kolide-app/bin/osqueryd",
+					"KOLIDE_LAUNCHER_ENROLL_SECRET_PATH": "/etc/kolide-app/secret",
+				},
+			}
+
+			writeFile(t, filepath.Join(packageRoot, "usr/local/kolide-app/bin/launcher"), "launcher")
+			writeFile(t, filepath.Join(packageRoot, "usr/local/kolide-app/bin/osqueryd"), "osqueryd")
+			if !tt.omitSecret {
+				writeFile(t, filepath.Join(packageRoot, "etc/kolide-app/secret"), "secret")
+			}
+
+			initPath := filepath.Join(packageRoot, tt.initFile)
+			require.NoError(t, os.MkdirAll(filepath.Dir(initPath), 0755))
+			fh, err := os.Create(initPath)
+			require.NoError(t, err)
+			require.NoError(t, tt.renderFunc(context.TODO(), fh, initOptions))
+			require.NoError(t, fh.Close())
+
+			info := &PackageInfo{
+				Platform:    Linux,
+				Environment: make(map[string]string),
+				Binaries:    make(map[string]string),
+			}
+			require.NoError(t, inspectRoot(context.TODO(), root, info))
+
+			require.Equal(t, "/Payload/"+tt.initFile, info.InitFile)
+			require.Equal(t, "device.example.com:443", info.Hostname())
+			require.Equal(t, "beta", info.UpdateChannel())
+			require.Equal(t, []string{"--autoupdate", "--insecure"}, info.Flags)
+			require.Equal(t, !tt.omitSecret, info.SecretIncluded)
+			require.Equal(t, map[string]string{"launcher": "", "osqueryd": ""}, info.Binaries)
+			require.Nil(t, info.Metadata)
+		})
+	}
+}
+
+func TestInspectRootMetadata(t *testing.T) {
+	t.Parallel()
+
+	root, err := ioutil.TempDir("", "packaging-inspect")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	initOptions := &packagekit.InitOptions{
+		Name:        "launcher",
+		Description: "The Kolide Launcher",
+		Identifier:  "kolide-app",
+		Path:        "/usr/local/kolide-app/bin/launcher",
+		Environment: map[string]string{
+			"KOLIDE_LAUNCHER_HOSTNAME":           "device.example.com:443",
+			"KOLIDE_LAUNCHER_OSQUERYD_PATH":      "/usr/local/kolide-app/bin/osqueryd",
+			"KOLIDE_LAUNCHER_ENROLL_SECRET_PATH": "/etc/kolide-app/secret",
+		},
+	}
+	initPath := filepath.Join(root, "etc/systemd/system/launcher.kolide-app.service")
+	require.NoError(t, os.MkdirAll(filepath.Dir(initPath), 0755))
+	fh, err := os.Create(initPath)
+	require.NoError(t, err)
+	require.NoError(t, packagekit.RenderSystemd(context.TODO(), fh, initOptions))
+	require.NoError(t, fh.Close())
+
+	// osqueryd doesn't match the metadata, so its version isn't known.
+	// Nothing is run to find out.
+	writeFile(t, filepath.Join(root, "usr/local/kolide-app/bin/launcher"), "launcher")
+	writeFile(t, filepath.Join(root, "usr/local/kolide-app/bin/osqueryd"), "#!/bin/sh\necho osqueryd version 9.9.9\n")
+	writeFile(t, filepath.Join(root, "usr/local/kolide-app/bin/osquery-extension.ext"), "osquery-extension.ext")
+
+	sum := func(contents string) string {
+		h := sha256.Sum256([]byte(contents))
+		return hex.EncodeToString(h[:])
+	}
+	metadata, err := json.Marshal(BuildMetadata{
+		LauncherVersion:  "0.11.4",
+		OsqueryVersion:   "3.3.1",
+		ExtensionVersion: "local",
+		Binaries: map[string]string{
+			"launcher":              sum("launcher"),
+			"osqueryd":              sum("osqueryd"),
+			"osquery-extension.ext": sum("osquery-extension.ext"),
+		},
+	})
+	require.NoError(t, err)
+	writeFile(t, filepath.Join(root, "etc/kolide-app/version.json"), string(metadata))
+
+	info := &PackageInfo{
+		Platform:    Linux,
+		Environment: make(map[string]string),
+		Binaries:    make(map[string]string),
+	}
+	require.NoError(t, inspectRoot(context.TODO(), root, info))
+
+	require.NotNil(t, info.Metadata)
+	require.Equal(t, map[string]string{
+		"launcher":              "0.11.4",
+		"osqueryd":              "",
+		"osquery-extension.ext": "local",
+	}, info.Binaries)
+}
+
+func TestParseInitd(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-inspect")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Values that need quoting, and escaping, round trip
+	flags := []string{"--autoupdate", "--update_channel=$beta", `--label="x"`, `--path=C:\\launcher`, "--tag=`id`"}
+	environment := map[string]string{
+		"KOLIDE_LAUNCHER_HOSTNAME":       "device.example.com:443",
+		"KOLIDE_LAUNCHER_ROOT_DIRECTORY": "/var/kolide app/it's",
+		"KOLIDE_LAUNCHER_UPDATE_CHANNEL": `$HOME "beta"`,
+	}
+
+	initPath := filepath.Join(dir, "launcher.kolide-app")
+	fh, err := os.Create(initPath)
+	require.NoError(t, err)
+	require.NoError(t, packagekit.RenderInit(context.TODO(), fh, &packagekit.InitOptions{
+		Name:        "launcher",
+		Description: "The Kolide Launcher",
+		Identifier:  "kolide-app",
+		Path:        "/usr/local/kolide-app/bin/launcher",
+		Flags:       flags,
+		Environment: environment,
+	}))
+	require.NoError(t, fh.Close())
+
+	info := &PackageInfo{Environment: make(map[string]string)}
+	require.NoError(t, parseInitd(initPath, info))
+	require.Equal(t, flags, info.Flags)
+	require.Equal(t, environment, info.Environment)
+}
+
+func TestShellUnquote(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		in     string
+		out    string
+		closed bool
+	}{
+		{in: "plain", out: "plain", closed: true},
+		{in: `'it'\''s here'`, out: "it's here", closed: true},
+		{in: `"a \"b\" \$c \\d \e"`, out: `a "b" $c \d \e`, closed: true},
+		{in: "\"--a \\\n--b\"", out: "--a --b", closed: true},
+		{in: `"--a \`, out: `--a \`, closed: false},
+		{in: `'open`, out: "open", closed: false},
+	}
+	for _, tt := range tests {
+		out, closed := shellUnquote(tt.in)
+		require.Equal(t, tt.out, out, tt.in)
+		require.Equal(t, tt.closed, closed, tt.in)
+	}
+}
+
+func TestInstalledToRelative(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "", installedToRelative(""))
+	require.Equal(t, "/etc/launcher/secret", installedToRelative("/etc/launcher/secret"))
+	require.Equal(t, "/Launcher-kolide-app/conf/secret", installedToRelative(`C:\Program Files\Launcher-kolide-app\conf\secret`))
+}
+
+func systemdRenderer(ctx context.Context, w io.Writer, initOptions *packagekit.InitOptions) error {
+	return packagekit.RenderSystemd(ctx, w, initOptions)
+}
+
+func launchdRenderer(ctx context.Context, w io.Writer, initOptions *packagekit.InitOptions) error {
+	return packagekit.RenderLaunchd(ctx, w, initOptions)
+}
+
+func upstartRenderer(ctx context.Context, w io.Writer, initOptions *packagekit.InitOptions) error {
+	return packagekit.RenderUpstart(ctx, w, initOptions)
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0755))
+}
@@ -0,0 +1,100 @@
+package packaging
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// launcherFlagRegexp matches launcher's flag names, eg: debug_log_file
+var launcherFlagRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// managedLauncherFlags are the launcher flags that PackageOptions
+// sets, as flags or their environment variables. See launcherConfig.
+var managedLauncherFlags = []string{
+	"autoupdate",
+	"cert_pins",
+	"connect_timeout",
+	"control_get_shells_interval",
+	"control_hostname",
+	"control_root_pem",
+	"disable_control_tls",
+	"enroll_secret_path",
+	"hostname",
+	"insecure",
+	"insecure_grpc",
+	"log_level",
+	"mirror_url",
+	"osquery_config_path",
+	"osquery_extension_name",
+	"osquery_flagfile",
+	"osqueryd_path",
+	"request_timeout",
+	"root_directory",
+	"root_pem",
+	"update_channel",
+	"watchdog_memory_limit",
+	"watchdog_utilization_limit",
+	"with_initial_runner",
+}
+
+// ParseLauncherFlags parses name=value launcher flags, as used by
+// PackageOptions.ExtraLauncherFlags. A name alone is a boolean flag.
+// Names are as launcher spells them, without the leading dashes.
+func ParseLauncherFlags(specs []string) (map[string]string, error) {
+	flags := make(map[string]string)
+	for _, spec := range specs {
+		name, value := spec, ""
+		if i := strings.Index(spec, "="); i >= 0 {
+			name, value = spec[:i], spec[i+1:]
+		}
+
+		if strings.HasPrefix(name, "-") {
+			return nil, errors.Errorf("invalid launcher flag %q. Leave off the leading dashes", spec)
+		}
+		if !launcherFlagRegexp.MatchString(name) {
+			return nil, errors.Errorf("invalid launcher flag %q. Flag names may only contain lowercase letters, numbers, and '_'", spec)
+		}
+		if _, ok := flags[name]; ok {
+			return nil, errors.Errorf("launcher flag %s is set more than once", name)
+		}
+		flags[name] = value
+	}
+	return flags, nil
+}
+
+// ManagedLauncherFlags returns the names in flags that PackageOptions
+// already sets. The extra flags are passed after the managed ones, so
+// they win, but it's likely a mistake.
+func ManagedLauncherFlags(flags map[string]string) []string {
+	managed := []string{}
+	for _, name := range managedLauncherFlags {
+		if _, ok := flags[name]; ok {
+			managed = append(managed, name)
+		}
+	}
+	return managed
+}
+
+// extraLauncherFlags returns ExtraLauncherFlags as command line flags,
+// sorted by name, so builds are reproducible.
+func (p *PackageOptions) extraLauncherFlags() []string {
+	names := make([]string, 0, len(p.ExtraLauncherFlags))
+	for name := range p.ExtraLauncherFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := []string{}
+	for _, name := range names {
+		if value := p.ExtraLauncherFlags[name]; value != "" {
+			flags = append(flags, fmt.Sprintf("--%s=%s", name, value))
+		} else {
+			flags = append(flags, "--"+name)
+		}
+	}
+	return flags
+}
@@ -0,0 +1,58 @@
+package packaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLauncherFlags(t *testing.T) {
+	t.Parallel()
+
+	flags, err := ParseLauncherFlags([]string{"debug_log_file=/var/log/launcher.log", "enable_initial_runner", "log_level=debug", "empty="})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"debug_log_file":        "/var/log/launcher.log",
+		"enable_initial_runner": "",
+		"log_level":             "debug",
+		"empty":                 "",
+	}, flags)
+	require.Equal(t, []string{"log_level"}, ManagedLauncherFlags(flags))
+
+	var tests = [][]string{
+		{"--debug"},
+		{"-debug=true"},
+		{"=value"},
+		{"Debug=true"},
+		{"debug-log=true"},
+		{"debug=true", "debug=false"},
+	}
+	for _, specs := range tests {
+		_, err := ParseLauncherFlags(specs)
+		require.Error(t, err, specs)
+	}
+}
+
+func TestExtraLauncherFlags(t *testing.T) {
+	t.Parallel()
+
+	p := &PackageOptions{
+		Hostname:           "device.example.com:443",
+		Identifier:         "kolide-app",
+		OmitSecret:         true,
+		Autoupdate:         true,
+		ExtraLauncherFlags: map[string]string{"transport": "jsonrpc", "debug": "", "autoupdate_interval": "1h"},
+	}
+	target := Target{Platform: Linux, Init: SystemD, Package: Deb}
+
+	// Sorted, and after the managed flags, so they win
+	config, err := p.LauncherConfig(target)
+	require.NoError(t, err)
+	require.Equal(t, []string{"--autoupdate", "--autoupdate_interval=1h", "--debug", "--transport=jsonrpc"}, config.Flags)
+
+	p.ExtraLauncherFlags["transport"] = "jsonrpc; reboot"
+	require.Error(t, p.Validate(target))
+
+	p.ExtraLauncherFlags = map[string]string{"--transport": "jsonrpc"}
+	require.Error(t, p.Validate(target))
+}
@@ -0,0 +1,192 @@
+package packaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lockfileVersion is the version of the Lockfile format
+const lockfileVersion = 1
+
+// Lockfile records the exact inputs, and output, of each package in a
+// build, for supply chain attestation. A rebuild with the same options
+// can be checked against it with Verify.
+type Lockfile struct {
+	Version  int             `json:"version"`
+	Packages []LockedPackage `json:"packages"`
+}
+
+// LockedPackage is a single package in a Lockfile.
+type LockedPackage struct {
+	Target string            `json:"target"`
+	Tenant string            `json:"tenant,omitempty"`
+	File   string            `json:"file"`
+	Size   int64             `json:"size"`
+	SHA256 string            `json:"sha256"`
+	Inputs map[string]string `json:"inputs"`
+}
+
+// NewLockfile records results. Packages are sorted, so the same build
+// always makes the same lockfile.
+func NewLockfile(results []BuildResult) *Lockfile {
+	lock := &Lockfile{Version: lockfileVersion, Packages: []LockedPackage{}}
+	for _, result := range results {
+		lock.Packages = append(lock.Packages, LockedPackage{
+			Target: result.Target,
+			Tenant: result.Tenant,
+			File:   filepath.Base(result.Path),
+			Size:   result.Size,
+			SHA256: result.SHA256,
+			Inputs: result.Inputs,
+		})
+	}
+	sort.Slice(lock.Packages, func(i, j int) bool {
+		return lock.Packages[i].key() < lock.Packages[j].key()
+	})
+	return lock
+}
+
+func (lp LockedPackage) key() string {
+	if lp.Tenant == "" {
+		return lp.Target
+	}
+	return lp.Tenant + "/" + lp.Target
+}
+
+// WriteLockfile writes the lockfile for results to path.
+func WriteLockfile(path string, results []BuildResult) error {
+	contents, err := json.MarshalIndent(NewLockfile(results), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding lockfile")
+	}
+	if err := ioutil.WriteFile(path, append(contents, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "writing lockfile %s", path)
+	}
+	return nil
+}
+
+// ReadLockfile reads a lockfile written by WriteLockfile.
+func ReadLockfile(path string) (*Lockfile, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading lockfile %s", path)
+	}
+
+	var lock Lockfile
+	if err := json.Unmarshal(contents, &lock); err != nil {
+		return nil, errors.Wrapf(err, "parsing lockfile %s", path)
+	}
+	if lock.Version != lockfileVersion {
+		return nil, errors.Errorf("lockfile %s is version %d, expected %d", path, lock.Version, lockfileVersion)
+	}
+	return &lock, nil
+}
+
+// Verify checks that results are exactly the packages in the
+// lockfile, built from the same inputs. Every difference is reported.
+// Packages are only byte for byte the same when the build is
+// reproducible, see PackageOptions.SourceDateEpoch.
+func (l *Lockfile) Verify(results []BuildResult) error {
+	locked := make(map[string]LockedPackage)
+	for _, lp := range l.Packages {
+		locked[lp.key()] = lp
+	}
+
+	built := NewLockfile(results)
+	problems := []string{}
+	for _, bp := range built.Packages {
+		lp, ok := locked[bp.key()]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s isn't in the lockfile", bp.key()))
+			continue
+		}
+		delete(locked, bp.key())
+
+		for _, path := range inputPaths(lp.Inputs, bp.Inputs) {
+			switch {
+			case lp.Inputs[path] == "":
+				problems = append(problems, fmt.Sprintf("%s has an unlocked input %s", bp.key(), path))
+			case bp.Inputs[path] == "":
+				problems = append(problems, fmt.Sprintf("%s is missing the input %s", bp.key(), path))
+			case lp.Inputs[path] != bp.Inputs[path]:
+				problems = append(problems, fmt.Sprintf("%s input %s is %s, expected %s", bp.key(), path, bp.Inputs[path], lp.Inputs[path]))
+			}
+		}
+
+		if bp.SHA256 != lp.SHA256 {
+			problems = append(problems, fmt.Sprintf("%s package is %s, expected %s", bp.key(), bp.SHA256, lp.SHA256))
+		}
+	}
+
+	for _, lp := range l.Packages {
+		if _, ok := locked[lp.key()]; ok {
+			problems = append(problems, fmt.Sprintf("%s wasn't built", lp.key()))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("build doesn't match the lockfile:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// inputPaths returns the sorted union of the input paths.
+func inputPaths(inputs ...map[string]string) []string {
+	seen := make(map[string]bool)
+	paths := []string{}
+	for _, m := range inputs {
+		for path := range m {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// hashInputs hashes the files in the package that come from the
+// build's inputs: the binaries, root PEMs, osquery config, and extra
+// files. Generated files, like the init scripts, and the secret,
+// aren't included. They're keyed by their path in the package.
+func (p *PackageOptions) hashInputs() (map[string]string, error) {
+	paths := []string{}
+	for _, name := range p.binaryNames(p.target) {
+		paths = append(paths, filepath.Join(p.binDir, name))
+	}
+	// Upgrade only packages don't carry config. See setupConfig.
+	if !p.UpgradeOnly {
+		if len(p.rootPEMs()) > 0 {
+			paths = append(paths, filepath.Join(p.confDir, "roots.pem"))
+		}
+		if p.ControlRootPEM != "" {
+			paths = append(paths, filepath.Join(p.confDir, "control-roots.pem"))
+		}
+		if p.OsqueryFlagfile != "" {
+			paths = append(paths, filepath.Join(p.confDir, "osquery.flags"))
+		}
+		if p.OsqueryConfigPath != "" {
+			paths = append(paths, filepath.Join(p.confDir, "osquery.conf"))
+		}
+	}
+	for dest := range p.ExtraFiles {
+		paths = append(paths, filepath.FromSlash(dest))
+	}
+
+	inputs := make(map[string]string)
+	for _, path := range paths {
+		_, sum, err := hashFile(filepath.Join(p.packageRoot, path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "hashing %s", path)
+		}
+		inputs[strings.TrimPrefix(filepath.ToSlash(path), "/")] = sum
+	}
+	return inputs, nil
+}
@@ -0,0 +1,94 @@
+package packaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockfile(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-build-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-build-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+	pem := filepath.Join(binDir, "roots.pem")
+	require.NoError(t, ioutil.WriteFile(pem, []byte("-----BEGIN CERTIFICATE-----\n"), 0644))
+
+	po := PackageOptions{
+		PackageVersion:  "1.2.3",
+		LocalBuildDir:   binDir,
+		Hostname:        "device.example.com:443",
+		Identifier:      "kolide-app",
+		Secret:          "secret",
+		RootPEM:         pem,
+		SourceDateEpoch: time.Unix(1600000000, 0),
+		ExtraFiles:      map[string]string{"etc/kolide-app/extra": pem},
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	launcherSum := sha256.Sum256([]byte("launcher"))
+	require.Equal(t, hex.EncodeToString(launcherSum[:]), results[0].Inputs["usr/local/kolide-app/bin/launcher"])
+	require.Len(t, results[0].Inputs, 5)
+	for _, path := range []string{
+		"usr/local/kolide-app/bin/osqueryd",
+		"usr/local/kolide-app/bin/launcher",
+		"usr/local/kolide-app/bin/osquery-extension.ext",
+		"etc/kolide-app/roots.pem",
+		"etc/kolide-app/extra",
+	} {
+		require.Len(t, results[0].Inputs[path], 64, path)
+	}
+	require.NotContains(t, results[0].Inputs, "etc/kolide-app/secret")
+
+	lockPath := filepath.Join(outputDir, "launcher.lock.json")
+	require.NoError(t, WriteLockfile(lockPath, results))
+	lock, err := ReadLockfile(lockPath)
+	require.NoError(t, err)
+	require.Equal(t, []LockedPackage{{
+		Target: "linux-systemd-tar",
+		File:   "launcher.linux-systemd-tar.tar.gz",
+		Size:   results[0].Size,
+		SHA256: results[0].SHA256,
+		Inputs: results[0].Inputs,
+	}}, lock.Packages)
+
+	// A reproducible rebuild matches
+	rebuilt, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.NoError(t, lock.Verify(rebuilt))
+
+	// A changed binary doesn't, and says why
+	require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "launcher"), []byte("launcher v2"), 0755))
+	changed, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	err = lock.Verify(changed)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "input usr/local/kolide-app/bin/launcher")
+	require.Contains(t, err.Error(), "linux-systemd-tar package is")
+
+	// As do missing, and extra, packages
+	require.Contains(t, lock.Verify(nil).Error(), "linux-systemd-tar wasn't built")
+	extra := append([]BuildResult{}, rebuilt...)
+	extra = append(extra, BuildResult{Target: "linux-systemd-deb", Tenant: "acme"})
+	require.Contains(t, lock.Verify(extra).Error(), "acme/linux-systemd-deb isn't in the lockfile")
+}
@@ -0,0 +1,39 @@
+package packaging
+
+import (
+	"os"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/pkg/errors"
+)
+
+// validateOCI checks the options can be used for target, if it's an
+// OCI image. Images have no install scripts, so anything done at
+// install time is checked for elsewhere, as for tarballs.
+func (p *PackageOptions) validateOCI(target Target) error {
+	if target.Package != OCI || p.OCIBase == "" {
+		return nil
+	}
+
+	info, err := os.Stat(p.OCIBase)
+	if err != nil {
+		return errors.Wrap(err, "oci base")
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Errorf("oci base %s should be an OCI layout tarball", p.OCIBase)
+	}
+
+	return nil
+}
+
+// ociOpts are the options for OCI images. The entrypoint is launcher,
+// run as its service would be.
+func (p *PackageOptions) ociOpts() []packagekit.OCIOpt {
+	ociOpts := []packagekit.OCIOpt{
+		packagekit.WithOCIService(p.initOptions),
+	}
+	if p.OCIBase != "" {
+		ociOpts = append(ociOpts, packagekit.WithOCIBase(p.OCIBase))
+	}
+	return ociOpts
+}
@@ -0,0 +1,117 @@
+package packaging
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOCI(t *testing.T) {
+	t.Parallel()
+
+	target := Target{Platform: Linux, Init: NoInit, Package: OCI}
+
+	require.NoError(t, (&PackageOptions{}).validateOCI(target))
+
+	base, err := ioutil.TempFile("", "packaging-oci-base")
+	require.NoError(t, err)
+	require.NoError(t, base.Close())
+	defer os.Remove(base.Name())
+
+	require.NoError(t, (&PackageOptions{OCIBase: base.Name()}).validateOCI(target))
+	require.Error(t, (&PackageOptions{OCIBase: base.Name() + ".missing"}).validateOCI(target))
+	require.Error(t, (&PackageOptions{OCIBase: os.TempDir()}).validateOCI(target))
+
+	// Images don't run install scripts
+	require.Error(t, (&PackageOptions{SecretFromEnv: "ENROLL_SECRET"}).validateSecretFromEnv(target))
+	require.Error(t, (&PackageOptions{RunAsUser: "kolide"}).validateRunAs(target))
+}
+
+func TestBuildOCI(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-oci-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-oci-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion: "1.2.3",
+		LocalBuildDir:  binDir,
+		Hostname:       "device.example.com:443",
+		Identifier:     "kolide-app",
+		Secret:         "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: NoInit, Package: OCI, Arch: Arm64}}
+
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.True(t, strings.HasSuffix(results[0].Path, ".oci.tar"), results[0].Path)
+
+	fh, err := os.Open(results[0].Path)
+	require.NoError(t, err)
+	defer fh.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(fh)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		contents, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = contents
+	}
+
+	blob := func(digest string) []byte {
+		contents, ok := files["blobs/sha256/"+strings.TrimPrefix(digest, "sha256:")]
+		require.True(t, ok, digest)
+		return contents
+	}
+
+	var index struct {
+		Manifests []struct{ Digest string }
+	}
+	require.NoError(t, json.Unmarshal(files["index.json"], &index))
+	require.Len(t, index.Manifests, 1)
+
+	var manifest struct {
+		Config struct{ Digest string }
+		Layers []struct{ Digest string }
+	}
+	require.NoError(t, json.Unmarshal(blob(index.Manifests[0].Digest), &manifest))
+	require.Len(t, manifest.Layers, 1)
+
+	var config struct {
+		Architecture string
+		Config       struct {
+			Entrypoint []string
+			Env        []string
+		}
+	}
+	require.NoError(t, json.Unmarshal(blob(manifest.Config.Digest), &config))
+
+	// launcher runs as its service would
+	require.Equal(t, "arm64", config.Architecture)
+	require.Equal(t, []string{"/usr/local/kolide-app/bin/launcher"}, config.Config.Entrypoint)
+	require.Contains(t, config.Config.Env, "KOLIDE_LAUNCHER_HOSTNAME=device.example.com:443")
+	require.Contains(t, config.Config.Env, "KOLIDE_LAUNCHER_ENROLL_SECRET_PATH=/etc/kolide-app/secret")
+}
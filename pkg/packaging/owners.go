@@ -0,0 +1,133 @@
+package packaging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/pkg/errors"
+)
+
+// declaresOwners reports whether the package declares the run as
+// user's files, rather than relying on the postinstall's chowns. rpms
+// do with %attr, and debs with dpkg-statoverride, which dpkg applies as
+// it unpacks. Either way, the user has to exist first, so it's created
+// by the preinstall.
+func (p *PackageOptions) declaresOwners() bool {
+	return p.RunAsUser != "" && !p.UpgradeOnly && (p.target.Package == Deb || p.target.Package == Rpm)
+}
+
+// fileOwners are the packaged paths owned by the run as user:
+// launcher's root directory, the config directory, and the secret, if
+// it's packaged.
+func (p *PackageOptions) fileOwners() ([]packagekit.FileOwner, error) {
+	if !p.declaresOwners() {
+		return nil, nil
+	}
+
+	group := p.RunAsGroup
+	if group == "" {
+		// useradd --user-group names it after the user
+		group = p.RunAsUser
+	}
+
+	owners := []packagekit.FileOwner{}
+	for _, path := range []string{p.rootDir, p.confDir, filepath.Join(p.confDir, "secret")} {
+		fi, err := os.Stat(filepath.Join(p.packageRoot, path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat %s", path)
+		}
+		owners = append(owners, packagekit.FileOwner{
+			Path:  p.installedPath(path),
+			User:  p.RunAsUser,
+			Group: group,
+			Dir:   fi.IsDir(),
+		})
+	}
+	return owners, nil
+}
+
+// setupPreinst creates the run as user before the package is
+// unpacked, for packages that declare its files. For debs, it also
+// registers their owners with dpkg-statoverride, if they aren't
+// already, so dpkg installs them owned by the user.
+func (p *PackageOptions) setupPreinst() error {
+	owners, err := p.fileOwners()
+	if err != nil {
+		return err
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+
+	type override struct {
+		Path  string
+		User  string
+		Group string
+		Mode  string
+	}
+	overrides := []override{}
+	if p.target.Package == Deb {
+		for _, owner := range owners {
+			fi, err := os.Stat(filepath.Join(p.packageRoot, owner.Path))
+			if err != nil {
+				return errors.Wrapf(err, "stat %s", owner.Path)
+			}
+			overrides = append(overrides, override{
+				Path:  packagekit.ShellEscape(owner.Path),
+				User:  owner.User,
+				Group: owner.Group,
+				Mode:  fmt.Sprintf("%04o", fi.Mode().Perm()),
+			})
+		}
+	}
+
+	var data = struct {
+		User      string
+		Group     string
+		RootDir   string
+		Overrides []override
+	}{
+		User:      p.RunAsUser,
+		Group:     p.RunAsGroup,
+		RootDir:   packagekit.ShellEscape(p.installedPath(p.rootDir)),
+		Overrides: overrides,
+	}
+
+	t, err := template.New("preinstall").Parse(preinstallRunAsTemplate())
+	if err != nil {
+		return errors.Wrap(err, "not able to parse template")
+	}
+
+	preinstPath := filepath.Join(p.scriptRoot, "preinstall")
+	fh, err := os.Create(preinstPath)
+	if err != nil {
+		return errors.Wrapf(err, "create preinstall filehandle")
+	}
+	defer fh.Close()
+
+	if err := os.Chmod(preinstPath, 0755); err != nil {
+		return errors.Wrap(err, "chmod preinstall")
+	}
+
+	if err := t.Execute(fh, data); err != nil {
+		return errors.Wrap(err, "executing template")
+	}
+
+	return nil
+}
+
+func preinstallRunAsTemplate() string {
+	return `#!/bin/sh
+set -e
+` + runAsUserTemplate() + `
+{{- range .Overrides }}
+dpkg-statoverride --list "{{.Path}}" >/dev/null || dpkg-statoverride --add {{.User}} {{.Group}} {{.Mode}} "{{.Path}}"
+{{- end }}
+`
+}
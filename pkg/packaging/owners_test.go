@@ -0,0 +1,89 @@
+package packaging
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupPreinstRunAs(t *testing.T) {
+	t.Parallel()
+
+	packageRoot, err := ioutil.TempDir("", "test-packaging-owners-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(packageRoot)
+
+	scriptRoot, err := ioutil.TempDir("", "test-packaging-owners-scripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(scriptRoot)
+
+	p := &PackageOptions{
+		target:      Target{Platform: Linux, Init: SystemD, Package: Deb},
+		Identifier:  "test",
+		Hostname:    "device.kolide.com:443",
+		RunAsUser:   "kolide",
+		packageRoot: packageRoot,
+		scriptRoot:  scriptRoot,
+	}
+	require.NoError(t, p.setupDirectories())
+	require.NoError(t, ioutil.WriteFile(filepath.Join(packageRoot, p.confDir, "secret"), []byte("secret"), secretPerms))
+
+	// Without a group, the owners get the user's own
+	owners, err := p.fileOwners()
+	require.NoError(t, err)
+	require.Equal(t, []packagekit.FileOwner{
+		{Path: "/var/test/device.kolide.com-443", User: "kolide", Group: "kolide", Dir: true},
+		{Path: "/etc/test", User: "kolide", Group: "kolide", Dir: true},
+		{Path: "/etc/test/secret", User: "kolide", Group: "kolide"},
+	}, owners)
+
+	// debs register them with dpkg, after the user's created
+	require.NoError(t, p.setupPreinst())
+	preinstall := filepath.Join(scriptRoot, "preinstall")
+	require.NoError(t, exec.Command("/bin/sh", "-n", preinstall).Run())
+
+	contents, err := ioutil.ReadFile(preinstall)
+	require.NoError(t, err)
+	script := string(contents)
+	require.Contains(t, script, "--user-group kolide")
+	require.Contains(t, script, `dpkg-statoverride --list "/etc/test" >/dev/null || dpkg-statoverride --add kolide kolide 0700 "/etc/test"`)
+	require.Contains(t, script, `dpkg-statoverride --list "/etc/test/secret" >/dev/null || dpkg-statoverride --add kolide kolide 0600 "/etc/test/secret"`)
+	require.True(t, strings.Index(script, "useradd") < strings.Index(script, "dpkg-statoverride"))
+
+	// rpms declare them with %attr, so only need the user
+	require.NoError(t, os.Remove(preinstall))
+	p.target = Target{Platform: Linux, Init: SystemD, Package: Rpm}
+	p.RunAsGroup = "kolide-group"
+	require.NoError(t, p.setupPreinst())
+	contents, err = ioutil.ReadFile(preinstall)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "groupadd --system kolide-group")
+	require.NotContains(t, string(contents), "dpkg-statoverride")
+
+	owners, err = p.fileOwners()
+	require.NoError(t, err)
+	require.Equal(t, "kolide-group", owners[0].Group)
+
+	// Unpackaged secrets aren't declared
+	require.NoError(t, os.Remove(filepath.Join(packageRoot, p.confDir, "secret")))
+	owners, err = p.fileOwners()
+	require.NoError(t, err)
+	require.Len(t, owners, 2)
+
+	// Nor is anything without a user, or for other packages
+	for _, po := range []*PackageOptions{
+		{target: Target{Platform: Linux, Init: SystemD, Package: Rpm}},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Pacman}, RunAsUser: "kolide"},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Deb}, RunAsUser: "kolide", UpgradeOnly: true},
+	} {
+		owners, err := po.fileOwners()
+		require.NoError(t, err)
+		require.Empty(t, owners)
+	}
+}
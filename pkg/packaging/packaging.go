@@ -6,46 +6,128 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"text/template"
+	"time"
 
+	"github.com/Masterminds/semver"
+	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/fs"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
 	"github.com/kolide/launcher/pkg/packagekit"
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 )
 
 const (
-	// Enroll secret should be readable only by root
+	// Enroll secret should be readable only by root, unless
+	// SecretFileMode says otherwise
 	secretPerms = 0600
+
+	// Permissions are set explicitly, rather than left to the umask
+	// of whoever runs the build
+	binaryPerms  = 0755
+	binDirPerms  = 0755
+	confDirPerms = 0700
+	rootDirPerms = 0700
 )
 
 // PackageOptions encapsulates the launcher build options. It's
 // populated by callers, such as command line flags. It may change.
 type PackageOptions struct {
-	PackageVersion    string // What version in this package. If unset, autodetection will be attempted.
-	OsqueryVersion    string
-	LauncherVersion   string
-	ExtensionVersion  string
-	Hostname          string
-	Secret            string
-	SigningKey        string
-	Insecure          bool
-	InsecureGrpc      bool
-	Autoupdate        bool
-	UpdateChannel     string
-	Control           bool
-	InitialRunner     bool
-	ControlHostname   string
-	DisableControlTLS bool
-	Identifier        string
-	OmitSecret        bool
-	CertPins          string
-	RootPEM           string
-	CacheDir          string
+	PackageVersion     string // What version in this package. If unset, autodetection will be attempted.
+	OsqueryVersion     string
+	OmitOsquery        bool   // Don't package osqueryd. Launcher uses the installed OsquerydPath instead.
+	OsquerydPath       string // Path to the host's osqueryd, with OmitOsquery
+	LauncherVersion    string
+	MinLauncherVersion string // If set, building fails if the launcher, with channels resolved, is older than this
+	ExtensionVersion   string
+	ExtensionName      string          // File name of a custom osquery extension. If unset, osquery-extension.ext, or .exe on windows.
+	Extensions         []ExtensionSpec // Additional extensions, autoloaded with the first. See ParseExtensionSpec.
+	InstallPrefix      string          // If set, binaries and config are installed under this, rather than /usr/local and /etc. Not for windows.
+	LocalBuildDir      string          // If set, binaries are copied from this directory, rather than per the versions
+	FetchAtInstall     bool            // Package only launcher. The postinstall downloads osqueryd and the extension, and checks their hashes. See validateFetchAtInstall.
+	InstallMirrorURL   string          // Where the installing host downloads binaries from, with FetchAtInstall
+	StripBinaries      bool            // Strip symbols from packaged linux and darwin binaries, when the build host can. See stripBinary.
+	UseDocker          bool            // Run linux targets' build tools, like strip and gpg signing, in docker, so any host with docker can build them
+	OCIBase            string          // OCI layout tarball that OCI images are built on. If unset, they're built from scratch.
+	Compression        string          // deb, rpm, and pacman compression: none, gzip, xz, or zstd. If unset, the package type's default.
+	Hostname           string
+	Hostnames          []string // gRPC servers, in priority order. If set, the first is used as Hostname.
+	Secret             string
+	Tenant             string // If set, the tenant this package is for, included in its file name. See ParseSecretsFile.
+	SigningKey         string
+	LinuxSigningKey    string // GPG key ID to sign deb and rpm packages with
+	Insecure           bool
+	InsecureGrpc       bool
+	Autoupdate         bool
+	UpdateChannel      string
+	AutoupdateMirror   string            // Mirror the installed launcher downloads updates from. If unset, launcher's default.
+	LauncherLogLevel   string            // Passed to launcher's --log_level. If unset, launcher logs at info.
+	ExtraLauncherFlags map[string]string // Additional launcher flags, name to value, for those without an option here. See ParseLauncherFlags.
+	Control            bool
+	InitialRunner      bool
+	NoStart            bool   // Install the service, but don't enable or start it
+	RunAsUser          string // If set, linux services run as this user, created at install if missing, rather than root
+	RunAsGroup         string // If set, with RunAsUser, linux services run as this group
+	RestartPolicy      string // systemd Restart= policy. Upstart supports always, on-failure, and no. If unset, on-failure.
+	RestartSec         int    // Seconds to wait before restarting the service. If unset, 3 for systemd, and none for upstart.
+	LaunchdTemplate    string // Path to a text/template for darwin's launchd plist, rather than the default. See packagekit.WithLaunchdTemplate.
+	ControlHostname    string
+	DisableControlTLS  bool
+	Identifier         string
+	Vendor             string // Package metadata. Unset ones use packagekit's defaults.
+	Maintainer         string
+	Description        string
+	License            string
+	Homepage           string
+	OmitSecret         bool
+	SecretFromEnv      string      // If set, the secret is read from this environment variable at install time, rather than packaged
+	SecretFileMode     os.FileMode // Permissions of the installed secret file. If unset, 0600.
+	UpgradeOnly        bool        // Only upgrade an existing install's binaries and init file, leaving its config and secret. See validateUpgradeOnly.
+	CertPins           string
+	RootPEM            string
+	RootPEMs           []string          // Additional root PEM files. These are merged with RootPEM into a single bundle.
+	OsqueryFlagfile    string            // Path to an osquery flagfile to include in the package
+	OsqueryConfigPath  string            // Path to a static osquery config, merged with the server's, to include in the package
+	PreinstallScript   string            // Path to a script run before install. See setupHookScripts.
+	PostinstallScript  string            // Path to a script run after install, after the generated postinstall
+	PreremoveScript    string            // Path to a script run before removal
+	ExtraFiles         map[string]string // Additional files, destination (relative to the package root) to source. See ParseExtraFiles.
+	CacheDir           string
+	RefreshCache       bool     // Ignore cached downloads, and fetch fresh copies
+	NoNetwork          bool     // Forbid network access. Binaries must be local, or in CacheDir from a previous build.
+	MirrorURL          string   // Where to download binaries from. If unset, the Kolide mirror.
+	MirrorURLs         []string // Mirrors to download binaries from, tried in order. Exclusive with MirrorURL.
+	NotaryURL          string   // Where to fetch TUF metadata from. If unset, the Kolide notary.
+	TUFRootJSON        string   // Path to trusted TUF root metadata. If set, TUF metadata is verified against it.
+	Proxy              string   // Proxy for downloads. If unset, HTTP_PROXY and friends are honored.
+	KeepTemp           bool     // Keep the temporary package and script roots, for debugging
+	VerboseBuild       bool     // Log the packaging tools' output, at debug level, as they run
+	OmitBuildMetadata  bool     // Don't embed version.json in the package. See BuildMetadata.
+
+	SourceDateEpoch time.Time // If set, pins file mtimes and embedded timestamps, for reproducible builds
+
+	Notarize *packagekit.NotarizeOptions // If set, darwin pkgs are notarized by Apple after signing
+
+	WatchdogMemoryLimitMB    int // If set, osquery is restarted when it uses more MB of memory than this
+	WatchdogUtilizationLimit int // If set, osquery is restarted when it uses more than this percentage of CPU
+
+	ControlRequestInterval time.Duration // How often launcher polls the control server. If unset, launcher's default.
+	ControlRootPEM         string        // Path to a PEM file of roots to verify the control server against, rather than the system's
+
+	LauncherConnectTimeout time.Duration // Passed to launcher's --connect_timeout. If unset, launcher's default, 1s.
+	LauncherRequestTimeout time.Duration // Passed to launcher's --request_timeout. If unset, launcher's default, 60s.
+
+	DownloadRetries      int           // How many times to retry transient download failures
+	DownloadRetryBackoff time.Duration // Initial wait between retries. Doubles each attempt.
+	DownloadTimeout      time.Duration // If set, how long each download attempt may take
 
 	target        Target                     // Target build platform
 	initOptions   *packagekit.InitOptions    // options we'll pass to the packagekit renderers
@@ -62,7 +144,15 @@ type PackageOptions struct {
 	confDir  string // where to place configs (eg: /etc/<name>)
 	initFile string // init file, the path is used in the various scripts.
 
-	execCC func(context.Context, string, ...string) *exec.Cmd
+	inputs   map[string]string // sha256 of the packaged inputs, by path. See hashInputs.
+	timings  []PhaseTiming     // how long each phase of Build took
+	metadata *BuildMetadata    // the package's build metadata, even when it's omitted from it
+	versions map[string]string // exact version of each fetched binary, by name. See recordVersion.
+
+	installDownloads []installDownload // binaries the postinstall downloads, with FetchAtInstall
+
+	execCC       func(context.Context, string, ...string) *exec.Cmd
+	hostPlatform PlatformFlavor // the build host's platform, for tests. If unset, runtime.GOOS.
 }
 
 // NewPackager returns a PackageOptions struct. You can, however, just
@@ -71,33 +161,693 @@ func NewPackager() *PackageOptions {
 	return &PackageOptions{}
 }
 
+// Validate checks that these options can build target, without
+// building anything. Build calls it first.
+func (p *PackageOptions) Validate(target Target) error {
+	if err := target.Validate(); err != nil {
+		return &UnsupportedTargetError{Target: target, Err: err}
+	}
+
+	if err := ValidateLauncherLogLevel(p.LauncherLogLevel); err != nil {
+		return err
+	}
+
+	if p.LauncherConnectTimeout < 0 || p.LauncherRequestTimeout < 0 {
+		return errors.New("launcher connect and request timeouts can't be negative")
+	}
+
+	if err := p.validateScriptValues(target); err != nil {
+		return err
+	}
+
+	if err := p.validateSecretFromEnv(target); err != nil {
+		return err
+	}
+
+	if err := p.validateRunAs(target); err != nil {
+		return err
+	}
+
+	if err := p.validateUpgradeOnly(target); err != nil {
+		return err
+	}
+
+	if err := p.validateRestartPolicy(target); err != nil {
+		return err
+	}
+
+	if err := p.validateLaunchdTemplate(target); err != nil {
+		return err
+	}
+
+	if err := p.validateCompression(target); err != nil {
+		return err
+	}
+
+	if err := p.validateInstallPrefix(target); err != nil {
+		return err
+	}
+
+	if err := p.validateHookScripts(target); err != nil {
+		return err
+	}
+
+	if err := p.validateSnap(target); err != nil {
+		return err
+	}
+
+	if err := p.validateOCI(target); err != nil {
+		return err
+	}
+
+	if err := p.validateUseDocker(target); err != nil {
+		return err
+	}
+
+	if err := p.validateMinLauncherVersion(); err != nil {
+		return err
+	}
+
+	if p.TUFRootJSON != "" {
+		if _, err := ReadTUFRoot(p.TUFRootJSON); err != nil {
+			return err
+		}
+	}
+
+	if p.MirrorURL != "" && len(p.MirrorURLs) > 0 {
+		return errors.New("only one of MirrorURL and MirrorURLs can be set")
+	}
+
+	if p.WatchdogMemoryLimitMB < 0 || p.WatchdogUtilizationLimit < 0 {
+		return errors.New("watchdog limits can't be negative")
+	}
+
+	if err := validateSecretFileMode(p.SecretFileMode); err != nil {
+		return err
+	}
+
+	if err := validateExtensionName(p.ExtensionName); err != nil {
+		return err
+	}
+
+	if err := p.validateExtensions(target); err != nil {
+		return err
+	}
+
+	if err := p.validateMetadata(); err != nil {
+		return err
+	}
+
+	if p.Tenant != "" {
+		if err := validateTenant(p.Tenant); err != nil {
+			return err
+		}
+	}
+
+	if err := p.validateNoNetwork(); err != nil {
+		return err
+	}
+
+	if err := p.validateControl(); err != nil {
+		return err
+	}
+
+	if err := p.validateAutoupdateMirror(); err != nil {
+		return err
+	}
+
+	if err := p.validateFetchAtInstall(target); err != nil {
+		return err
+	}
+
+	for dest := range p.ExtraFiles {
+		if err := validateExtraFileDest(dest); err != nil {
+			return err
+		}
+	}
+
+	if err := p.validateOsquerydPath(target); err != nil {
+		return err
+	}
+
+	if p.LocalBuildDir != "" {
+		for _, name := range p.binaryNames(target) {
+			if err := checkExecutable(filepath.Join(p.LocalBuildDir, name)); err != nil {
+				return errors.Wrapf(err, "local build dir is missing %s for %s", name, target.String())
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateOsquerydPath checks that, without bundled osquery, launcher
+// is told where the host's osqueryd is.
+func (p *PackageOptions) validateOsquerydPath(target Target) error {
+	if !p.OmitOsquery {
+		if p.OsquerydPath != "" {
+			return errors.New("osqueryd path is only used when omitting osquery")
+		}
+		return nil
+	}
+
+	if p.OsquerydPath == "" {
+		return errors.New("omitting osquery requires an osqueryd path")
+	}
+
+	absolute := path.IsAbs(p.OsquerydPath)
+	if target.Platform == Windows {
+		absolute = windowsAbsPathRegexp.MatchString(p.OsquerydPath)
+	}
+	if !absolute {
+		return errors.Errorf("osqueryd path %s must be an absolute path", p.OsquerydPath)
+	}
+	return nil
+}
+
+// windowsAbsPathRegexp matches absolute windows paths, eg C:\osquery
+var windowsAbsPathRegexp = regexp.MustCompile(`^[a-zA-Z]:\\`)
+
+// binaryNames returns the file names of the binaries packaged for
+// target. With FetchAtInstall, that's only launcher.
+func (p *PackageOptions) binaryNames(target Target) []string {
+	if p.FetchAtInstall {
+		return []string{target.PlatformBinaryName("launcher")}
+	}
+	names := []string{}
+	if !p.OmitOsquery {
+		names = append(names, target.PlatformBinaryName("osqueryd"))
+	}
+	names = append(names, target.PlatformBinaryName("launcher"))
+	return append(names, p.extensionNames(target)...)
+}
+
+// validateScriptValues checks the options that are interpolated into
+// the init files and install scripts, so they can't break, or inject
+// commands into, them. The scripts quote them too, but systemd units
+// and upstart jobs can't safely hold everything a shell can.
+func (p *PackageOptions) validateScriptValues(target Target) error {
+	if p.Identifier != "" {
+		if err := ValidateIdentifier(p.Identifier); err != nil {
+			return err
+		}
+	}
+
+	for _, hostname := range append([]string{p.Hostname}, p.Hostnames...) {
+		if hostname == "" {
+			continue
+		}
+		if err := validateHostname(hostname); err != nil {
+			return errors.Wrapf(err, "invalid hostname %q", hostname)
+		}
+	}
+
+	if p.ControlHostname != "" {
+		if err := validateHostname(p.ControlHostname); err != nil {
+			return errors.Wrapf(err, "invalid control hostname %q", p.ControlHostname)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"update channel": p.UpdateChannel,
+		"update mirror":  p.AutoupdateMirror,
+		"install mirror": p.InstallMirrorURL,
+		"cert pins":      p.CertPins,
+		"install prefix": p.InstallPrefix,
+		"extension name": p.ExtensionName,
+		"osqueryd path":  p.OsquerydPath,
+	} {
+		if err := validateScriptValue(name, value, target); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range p.ExtraLauncherFlags {
+		if !launcherFlagRegexp.MatchString(name) {
+			return errors.Errorf("invalid launcher flag name %q", name)
+		}
+		if err := validateScriptValue("launcher flag "+name, value, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateNoNetwork checks that a build without network access has
+// somewhere to get binaries from, and doesn't need the network for
+// anything else.
+func (p *PackageOptions) validateNoNetwork() error {
+	if !p.NoNetwork {
+		return nil
+	}
+	if p.RefreshCache {
+		return errors.New("refreshing the cache requires network access")
+	}
+	if p.Notarize != nil {
+		return errors.New("notarization requires network access")
+	}
+	if p.CacheDir == "" && p.fetchesBinaries() {
+		return errors.New("without network access, binaries must be local, or in a cache dir from a previous build")
+	}
+	return nil
+}
+
+// validateControl checks the control server options are only set with
+// control, and make sense together.
+func (p *PackageOptions) validateControl() error {
+	if !p.Control && (p.ControlRequestInterval != 0 || p.ControlRootPEM != "") {
+		return errors.New("control request interval and control root PEM require control")
+	}
+	if p.ControlRequestInterval < 0 {
+		return errors.New("control request interval can't be negative")
+	}
+	if p.ControlRootPEM == "" {
+		return nil
+	}
+	if p.DisableControlTLS {
+		return errors.New("control root PEM can't be used with DisableControlTLS")
+	}
+	if err := validatePEM(p.ControlRootPEM); err != nil {
+		return errors.Wrapf(err, "invalid control root PEM %s", p.ControlRootPEM)
+	}
+	return nil
+}
+
+// validateAutoupdateMirror checks the installed launcher's update
+// mirror is only set with autoupdate, and is https, unless the package
+// is insecure anyway.
+func (p *PackageOptions) validateAutoupdateMirror() error {
+	if p.AutoupdateMirror == "" {
+		return nil
+	}
+	if !p.Autoupdate {
+		return errors.New("autoupdate mirror requires autoupdate")
+	}
+	return p.validateInstalledMirror("autoupdate mirror", p.AutoupdateMirror)
+}
+
+// validateFetchAtInstall checks that packages which download their
+// binaries at install have somewhere to download them from, and run a
+// postinstall to do it. The binaries must be released ones, so there's
+// a tarball on the mirror, and a hash in TUF, for each.
+func (p *PackageOptions) validateFetchAtInstall(target Target) error {
+	if !p.FetchAtInstall {
+		if p.InstallMirrorURL != "" {
+			return errors.New("install mirror requires fetch at install")
+		}
+		return nil
+	}
+
+	if p.InstallMirrorURL == "" {
+		return errors.New("fetch at install requires an install mirror to download binaries from")
+	}
+	if err := p.validateInstalledMirror("install mirror", p.InstallMirrorURL); err != nil {
+		return err
+	}
+
+	if p.LocalBuildDir != "" || isLocalVersion(p.ExtensionVersion) || (!p.OmitOsquery && isLocalVersion(p.OsqueryVersion)) {
+		return errors.New("fetch at install downloads released binaries, so can't use local osqueryd, or extension, binaries")
+	}
+	if p.ExtensionName != "" {
+		return errors.New("fetch at install can't download a custom extension")
+	}
+
+	switch {
+	case target.Arch == Universal:
+		return errors.New("fetch at install can't build universal packages, as there are no universal binaries to download")
+	case target.Package == Tar, target.Package == Msi, target.Package == Chocolatey, target.Package == Snap, target.Package == OCI:
+		return errors.Errorf("%s packages don't run install scripts, so can't fetch binaries at install", target.Package)
+	}
+	return nil
+}
+
+// validateInstalledMirror checks a mirror the installed package
+// downloads from is an https url, unless the package is insecure
+// anyway.
+func (p *PackageOptions) validateInstalledMirror(name, mirror string) error {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s %s", name, mirror)
+	}
+	if u.Host == "" {
+		return errors.Errorf("%s %s has no host", name, mirror)
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && p.Insecure:
+	case u.Scheme == "http":
+		return errors.Errorf("%s %s is not https. Only insecure packages may use http", name, mirror)
+	default:
+		return errors.Errorf("%s %s has unsupported scheme %s", name, mirror, u.Scheme)
+	}
+	return nil
+}
+
+// validateExtensionName checks that a custom extension name is a file
+// name. It's installed next to launcher, so can't be a path.
+func validateExtensionName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return errors.Errorf("extension name %s must be a file name, not a path", name)
+	}
+	switch name {
+	case "osqueryd", "osqueryd.exe", "launcher", "launcher.exe":
+		return errors.Errorf("extension name %s is already used by another binary", name)
+	}
+	return nil
+}
+
+// extensionName returns the file name of the extension to package for
+// target.
+func (p *PackageOptions) extensionName(target Target) string {
+	if p.ExtensionName != "" {
+		return p.ExtensionName
+	}
+	return target.PlatformExtensionName("osquery-extension")
+}
+
+// validateMetadata checks the package metadata. Apart from the
+// description, they're single line fields in the package formats.
+func (p *PackageOptions) validateMetadata() error {
+	for name, value := range map[string]string{
+		"vendor":     p.Vendor,
+		"maintainer": p.Maintainer,
+		"license":    p.License,
+		"homepage":   p.Homepage,
+	} {
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.Errorf("package %s can't contain newlines", name)
+		}
+	}
+
+	if p.Homepage != "" {
+		u, err := url.Parse(p.Homepage)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("package homepage %s must be an http or https url", p.Homepage)
+		}
+	}
+	return nil
+}
+
+// validateSecretFileMode checks that the secret stays readable by its
+// owner, and can't be changed by anyone else.
+func validateSecretFileMode(mode os.FileMode) error {
+	switch {
+	case mode == 0:
+		return nil
+	case mode&^os.ModePerm != 0:
+		return errors.Errorf("secret file mode %o isn't a permission mode", mode)
+	case mode&0400 == 0:
+		return errors.Errorf("secret file mode %04o must be readable by its owner", mode)
+	case mode&0022 != 0:
+		return errors.Errorf("secret file mode %04o must not be writable by group or others", mode)
+	}
+	return nil
+}
+
+// secretFileMode returns the permissions for the installed secret.
+func (p *PackageOptions) secretFileMode() os.FileMode {
+	if p.SecretFileMode == 0 {
+		return secretPerms
+	}
+	return p.SecretFileMode
+}
+
+// packageCompressions are the compressions each package type can be
+// built with. Other package types ignore Compression.
+var packageCompressions = map[PackageFlavor][]packagekit.Compression{
+	Deb:    {packagekit.CompressionNone, packagekit.CompressionGzip, packagekit.CompressionXz},
+	Rpm:    {packagekit.CompressionNone, packagekit.CompressionGzip, packagekit.CompressionXz},
+	Pacman: {packagekit.CompressionNone, packagekit.CompressionGzip, packagekit.CompressionXz, packagekit.CompressionZstd},
+}
+
+// validateCompression checks Compression can be used for target.
+func (p *PackageOptions) validateCompression(target Target) error {
+	switch packagekit.Compression(p.Compression) {
+	case "":
+		return nil
+	case packagekit.CompressionNone, packagekit.CompressionGzip, packagekit.CompressionXz, packagekit.CompressionZstd:
+	default:
+		return errors.Errorf("unknown compression %s", p.Compression)
+	}
+
+	supported, ok := packageCompressions[target.Package]
+	if !ok {
+		return nil
+	}
+	for _, c := range supported {
+		if packagekit.Compression(p.Compression) == c {
+			return nil
+		}
+	}
+	return errors.Errorf("%s packages can't be compressed with %s", target.Package, p.Compression)
+}
+
+// checkExecutable checks that path is an executable file.
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Errorf("%s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return errors.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
 func (p *PackageOptions) Build(ctx context.Context, packageWriter io.Writer, target Target) error {
 
+	if err := p.Validate(target); err != nil {
+		return err
+	}
+
+	if err := p.CheckTools(target); err != nil {
+		return err
+	}
+
+	if p.usesDocker(target) {
+		if err := p.checkDocker(ctx); err != nil {
+			return err
+		}
+	}
+
 	p.target = target
 	p.packageWriter = packageWriter
+	p.timings = nil
+	p.versions = make(map[string]string)
+
+	// The first failover server is the primary, which names the root
+	// directory.
+	if len(p.Hostnames) > 0 {
+		p.Hostname = p.Hostnames[0]
+	}
 
 	var err error
 
 	if p.packageRoot, err = ioutil.TempDir("", "package.packageRoot"); err != nil {
 		return errors.Wrap(err, "unable to create temporary packaging root directory")
 	}
-	defer os.RemoveAll(p.packageRoot)
+	defer p.removeTemp(ctx, p.packageRoot)
 
 	if p.scriptRoot, err = ioutil.TempDir("", fmt.Sprintf("package.scriptRoot")); err != nil {
 		return errors.Wrap(err, "unable to create temporary packaging root directory")
 	}
-	defer os.RemoveAll(p.scriptRoot)
+	defer p.removeTemp(ctx, p.scriptRoot)
 
 	if err := p.setupDirectories(); err != nil {
 		return errors.Wrap(err, "setup directories")
 	}
 
+	// Upgrades keep the installed config, so there's none to package
+	if !p.UpgradeOnly {
+		if err := p.setupConfig(); err != nil {
+			return err
+		}
+	}
+
+	// Install binaries into packageRoot
+	// TODO parallization, osquery-extension.ext
+	// TODO windows file extensions
+	// With FetchAtInstall, only launcher is packaged, and the
+	// postinstall downloads the rest.
+	if err := p.lookupInstallDownloads(ctx); err != nil {
+		return err
+	}
+
+	if !p.OmitOsquery && !p.FetchAtInstall {
+		if err := p.getBinary(ctx, p.target.PlatformBinaryName("osqueryd"), p.OsqueryVersion); err != nil {
+			return errors.Wrapf(err, "fetching binary osqueryd")
+		}
+	}
+
+	if err := p.getBinary(ctx, p.target.PlatformBinaryName("launcher"), p.LauncherVersion); err != nil {
+		return errors.Wrapf(err, "fetching binary launcher")
+	}
+
+	if err := p.checkMinLauncherVersion(ctx); err != nil {
+		return err
+	}
+
+	if !p.FetchAtInstall {
+		if err := p.getBinary(ctx, p.extensionName(p.target), p.ExtensionVersion); err != nil {
+			return errors.Wrapf(err, "fetching binary launcher")
+		}
+	}
+
+	for _, extension := range p.Extensions {
+		if err := p.getBinary(ctx, extension.Name, extension.Version); err != nil {
+			return errors.Wrapf(err, "fetching extension %s", extension.Name)
+		}
+	}
+
+	// Some darwin specific bits
+	if p.target.Platform == Darwin {
+		if err := p.renderNewSyslogConfig(ctx); err != nil {
+			return errors.Wrap(err, "render")
+		}
+
+		// launchd seems to need the log directory to be pre-created. So,
+		// we'll do this here. This is a bit ugly, since we're duplicate
+		// the directory expansion we do in packagekit.RenderLaunchd. TODO
+		// merge logic, and pass the logs paths as an option.
+		logDir := filepath.Join(p.packageRoot, "var", "log", p.Identifier)
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			return errors.Wrapf(err, "mkdir logdir %s", logDir)
+		}
+	}
+
+	// The version string is the version of _launcher_ which we don't
+	// know until we've downloaded it.
+	if p.PackageVersion == "" {
+		if err := p.detectLauncherVersion(ctx); err != nil {
+			return errors.Wrap(err, "version detection")
+		}
+	}
+
+	if err := p.writeBuildMetadata(); err != nil {
+		return err
+	}
+
+	scriptsStart := time.Now()
+	if err := p.setupScripts(ctx); err != nil {
+		return err
+	}
+	p.recordPhase("scripts", scriptsStart)
+
+	if err := p.copyExtraFiles(); err != nil {
+		return errors.Wrap(err, "copy extra files")
+	}
+
+	if p.inputs, err = p.hashInputs(); err != nil {
+		return errors.Wrap(err, "hashing inputs")
+	}
+
+	p.packagekitops = &packagekit.PackageOptions{
+		Name:       "launcher",
+		Identifier: p.Identifier,
+		Root:       p.packageRoot,
+		Scripts:    p.scriptRoot,
+		SigningKey: p.signingKey(),
+		Version:    p.PackageVersion,
+		Arch:       string(p.target.GetArch()),
+		NoNetwork:  p.NoNetwork,
+		Verbose:    p.VerboseBuild,
+
+		SignInDocker: p.usesDocker(p.target),
+
+		Vendor:      p.Vendor,
+		Maintainer:  p.Maintainer,
+		Description: p.Description,
+		License:     p.License,
+		Homepage:    p.Homepage,
+
+		SourceDateEpoch: p.SourceDateEpoch,
+	}
+
+	packageStart := time.Now()
+	if err := p.makePackage(ctx); err != nil {
+		if _, ok := errors.Cause(err).(*packagekit.SigningError); ok {
+			return &SigningError{Target: p.target.String(), Err: err}
+		}
+		return errors.Wrap(err, "making package")
+	}
+	p.recordPhase("packaging", packageStart)
+
+	return nil
+}
+
+// setupConfig writes the enroll secret, and other config, into the
+// package root's config directory.
+func (p *PackageOptions) setupConfig() error {
+	// Unless we're omitting the secret, or writing it at install
+	// time, write it into the package.
+	// Note that we _always_ set KOLIDE_LAUNCHER_ENROLL_SECRET_PATH
+	if !p.OmitSecret && p.SecretFromEnv == "" {
+		secretPath := filepath.Join(p.packageRoot, p.confDir, "secret")
+		if err := ioutil.WriteFile(secretPath, []byte(p.Secret), p.secretFileMode()); err != nil {
+			return errors.Wrap(err, "could not write secret string to file for packaging")
+		}
+		if err := os.Chmod(secretPath, p.secretFileMode()); err != nil {
+			return errors.Wrap(err, "chmod secret")
+		}
+	}
+
+	if rootPEMs := p.rootPEMs(); len(rootPEMs) > 0 {
+		if err := mergePEMs(filepath.Join(p.packageRoot, p.confDir, "roots.pem"), rootPEMs); err != nil {
+			return errors.Wrap(err, "merge root PEMs")
+		}
+	}
+
+	if p.ControlRootPEM != "" {
+		if err := mergePEMs(filepath.Join(p.packageRoot, p.confDir, "control-roots.pem"), []string{p.ControlRootPEM}); err != nil {
+			return errors.Wrap(err, "copy control root PEM")
+		}
+	}
+
+	if p.OsqueryFlagfile != "" {
+		if err := fs.CopyFile(p.OsqueryFlagfile, filepath.Join(p.packageRoot, p.confDir, "osquery.flags")); err != nil {
+			return errors.Wrap(err, "copy osquery flagfile")
+		}
+	}
+
+	if p.OsqueryConfigPath != "" {
+		if err := fs.CopyFile(p.OsqueryConfigPath, filepath.Join(p.packageRoot, p.confDir, "osquery.conf")); err != nil {
+			return errors.Wrap(err, "copy osquery config")
+		}
+	}
+
+	return nil
+}
+
+// launcherConfig returns the environment, and flags, the installed
+// launcher runs with. setupDirectories must have been called.
+func (p *PackageOptions) launcherConfig() (map[string]string, []string) {
+	// launcher takes failover servers as a comma separated list
+	launcherHostname := p.Hostname
+	if len(p.Hostnames) > 0 {
+		launcherHostname = strings.Join(p.Hostnames, ",")
+	}
+
 	launcherEnv := map[string]string{
-		"KOLIDE_LAUNCHER_HOSTNAME":           p.Hostname,
-		"KOLIDE_LAUNCHER_UPDATE_CHANNEL":     p.UpdateChannel,
-		"KOLIDE_LAUNCHER_ROOT_DIRECTORY":     p.rootDir,
-		"KOLIDE_LAUNCHER_OSQUERYD_PATH":      filepath.Join(p.binDir, "osqueryd"),
-		"KOLIDE_LAUNCHER_ENROLL_SECRET_PATH": filepath.Join(p.confDir, "secret"),
+		"KOLIDE_LAUNCHER_HOSTNAME":           launcherHostname,
+		"KOLIDE_LAUNCHER_ROOT_DIRECTORY":     p.installedPath(p.rootDir),
+		"KOLIDE_LAUNCHER_OSQUERYD_PATH":      p.installedPath(filepath.Join(p.binDir, p.target.PlatformBinaryName("osqueryd"))),
+		"KOLIDE_LAUNCHER_ENROLL_SECRET_PATH": p.installedPath(filepath.Join(p.confDir, "secret")),
+	}
+
+	if p.OmitOsquery {
+		launcherEnv["KOLIDE_LAUNCHER_OSQUERYD_PATH"] = p.OsquerydPath
+	}
+
+	// Snaps are read only. Their common directory is writable, and
+	// kept across upgrades.
+	if p.target.Package == Snap {
+		launcherEnv["KOLIDE_LAUNCHER_ROOT_DIRECTORY"] = path.Join("$SNAP_COMMON", sanitizeHostname(p.primaryHostname()))
 	}
 
 	launcherFlags := []string{}
@@ -110,9 +860,24 @@ func (p *PackageOptions) Build(ctx context.Context, packageWriter io.Writer, tar
 		launcherEnv["KOLIDE_CONTROL_HOSTNAME"] = p.ControlHostname
 	}
 
-	if p.Autoupdate && p.UpdateChannel != "" {
+	if p.Control && p.ControlRequestInterval > 0 {
+		launcherEnv["KOLIDE_CONTROL_GET_SHELLS_INTERVAL"] = p.ControlRequestInterval.String()
+	}
+
+	if p.Control && p.ControlRootPEM != "" {
+		launcherEnv["KOLIDE_LAUNCHER_CONTROL_ROOT_PEM"] = p.installedPath(filepath.Join(p.confDir, "control-roots.pem"))
+	}
+
+	// An empty channel leaves launcher to its default. Setting it to
+	// the empty string would be an invalid channel.
+	if p.Autoupdate {
 		launcherFlags = append(launcherFlags, "--autoupdate")
-		launcherEnv["KOLIDE_LAUNCHER_UPDATE_CHANNEL"] = p.UpdateChannel
+		if p.UpdateChannel != "" {
+			launcherEnv["KOLIDE_LAUNCHER_UPDATE_CHANNEL"] = p.UpdateChannel
+		}
+		if p.AutoupdateMirror != "" {
+			launcherEnv["KOLIDE_LAUNCHER_MIRROR_SERVER_URL"] = p.AutoupdateMirror
+		}
 	}
 
 	if p.CertPins != "" {
@@ -131,136 +896,620 @@ func (p *PackageOptions) Build(ctx context.Context, packageWriter io.Writer, tar
 		launcherFlags = append(launcherFlags, "--insecure")
 	}
 
-	// Unless we're omitting the secret, write it into the package.
-	// Note that we _always_ set KOLIDE_LAUNCHER_ENROLL_SECRET_PATH
-	if !p.OmitSecret {
-		if err := ioutil.WriteFile(
-			filepath.Join(p.packageRoot, p.confDir, "secret"),
-			[]byte(p.Secret),
-			secretPerms,
-		); err != nil {
-			return errors.Wrap(err, "could not write secret string to file for packaging")
-		}
+	if p.LauncherLogLevel != "" {
+		launcherFlags = append(launcherFlags, "--log_level="+p.LauncherLogLevel)
+	}
+
+	if p.LauncherConnectTimeout > 0 {
+		launcherFlags = append(launcherFlags, "--connect_timeout="+p.LauncherConnectTimeout.String())
+	}
+
+	if p.LauncherRequestTimeout > 0 {
+		launcherFlags = append(launcherFlags, "--request_timeout="+p.LauncherRequestTimeout.String())
+	}
+
+	if p.WatchdogMemoryLimitMB > 0 {
+		launcherFlags = append(launcherFlags, fmt.Sprintf("--watchdog_memory_limit=%d", p.WatchdogMemoryLimitMB))
+	}
+
+	if p.WatchdogUtilizationLimit > 0 {
+		launcherFlags = append(launcherFlags, fmt.Sprintf("--watchdog_utilization_limit=%d", p.WatchdogUtilizationLimit))
+	}
+
+	if len(p.rootPEMs()) > 0 {
+		launcherEnv["KOLIDE_LAUNCHER_ROOT_PEM"] = p.installedPath(filepath.Join(p.confDir, "roots.pem"))
+	}
+
+	if p.OsqueryFlagfile != "" {
+		launcherEnv["KOLIDE_LAUNCHER_OSQUERY_FLAGFILE"] = p.installedPath(filepath.Join(p.confDir, "osquery.flags"))
 	}
 
+	if p.OsqueryConfigPath != "" {
+		launcherEnv["KOLIDE_LAUNCHER_OSQUERY_CONFIG_PATH"] = p.installedPath(filepath.Join(p.confDir, "osquery.conf"))
+	}
+
+	if p.ExtensionName != "" || len(p.Extensions) > 0 {
+		launcherEnv["KOLIDE_LAUNCHER_OSQUERY_EXTENSION_NAME"] = strings.Join(p.extensionNames(p.target), ",")
+	}
+
+	launcherFlags = append(launcherFlags, p.extraLauncherFlags()...)
+
+	return launcherEnv, launcherFlags
+}
+
+// rootPEMs returns the root PEM files to merge into the package.
+func (p *PackageOptions) rootPEMs() []string {
+	rootPEMs := p.RootPEMs
 	if p.RootPEM != "" {
-		rootPemPath := filepath.Join(p.confDir, "roots.pem")
-		launcherEnv["KOLIDE_LAUNCHER_ROOT_PEM"] = rootPemPath
+		rootPEMs = append([]string{p.RootPEM}, rootPEMs...)
+	}
+	return rootPEMs
+}
+
+// setupScripts renders the init file, and the install scripts, into
+// the package and script roots.
+func (p *PackageOptions) setupScripts(ctx context.Context) error {
+	launcherEnv, launcherFlags := p.launcherConfig()
 
-		if err := fs.CopyFile(p.RootPEM, filepath.Join(p.packageRoot, rootPemPath)); err != nil {
-			return errors.Wrap(err, "copy root PEM")
+	p.initOptions = &packagekit.InitOptions{
+		Name:        "launcher",
+		Description: "The Kolide Launcher",
+		Path:        filepath.Join(p.binDir, p.target.PlatformBinaryName("launcher")),
+		Identifier:  p.Identifier,
+		Flags:       launcherFlags,
+		Environment: launcherEnv,
+		User:        p.RunAsUser,
+		Group:       p.RunAsGroup,
+	}
+
+	if err := p.setupInit(ctx); err != nil {
+		return errors.Wrapf(err, "setup init script for %s", p.target.String())
+	}
+
+	if err := p.setupPreinst(); err != nil {
+		return errors.Wrapf(err, "setup preinst for %s", p.target.String())
+	}
+
+	if err := p.setupPostinst(ctx); err != nil {
+		return errors.Wrapf(err, "setup postInst for %s", p.target.String())
+	}
+
+	if err := p.setupPrerm(ctx); err != nil {
+		return errors.Wrapf(err, "setup prerm for %s", p.target.String())
+	}
+
+	if err := p.setupPostrm(ctx); err != nil {
+		return errors.Wrapf(err, "setup postrm for %s", p.target.String())
+	}
+
+	if err := p.setupUninstallScript(); err != nil {
+		return errors.Wrapf(err, "setup uninstall script for %s", p.target.String())
+	}
+
+	if err := p.setupHookScripts(); err != nil {
+		return errors.Wrapf(err, "setup install scripts for %s", p.target.String())
+	}
+
+	return nil
+}
+
+// secretEnvRegexp matches valid shell variable names
+var secretEnvRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateSecretFromEnv checks SecretFromEnv can be used for target.
+// The secret is written by the postinstall script, so packages
+// without one can't use it.
+func (p *PackageOptions) validateSecretFromEnv(target Target) error {
+	if p.SecretFromEnv == "" {
+		return nil
+	}
+
+	if !secretEnvRegexp.MatchString(p.SecretFromEnv) {
+		return errors.Errorf("invalid secret environment variable name %q", p.SecretFromEnv)
+	}
+
+	if p.Secret != "" || p.OmitSecret {
+		return errors.New("secret from env can't be combined with a secret, or omitting it")
+	}
+
+	switch target.Package {
+	case Tar, Msi, Chocolatey, Snap, OCI:
+		return errors.Errorf("%s packages don't run install scripts, so can't read the secret from the environment", target.Package)
+	}
+
+	return nil
+}
+
+// validateUpgradeOnly checks UpgradeOnly can be used for target. The
+// installed config has to survive the upgrade, though it isn't in the
+// new package. debs keep it, as files in /etc are conffiles, and
+// macOS pkgs and tarballs don't remove files. rpm, pacman, and FreeBSD
+// pkg remove files the new package doesn't have, and MSIs run no
+// install scripts, so they're not supported.
+func (p *PackageOptions) validateUpgradeOnly(target Target) error {
+	if !p.UpgradeOnly {
+		return nil
+	}
+
+	if p.Secret != "" || p.SecretFromEnv != "" {
+		return errors.New("upgrade only packages keep the installed secret, so can't set one")
+	}
+
+	switch target.Package {
+	case Deb:
+		if p.InstallPrefix != "" {
+			return errors.New("upgrade only debs can't use an install prefix, as only config in /etc is kept over upgrades")
 		}
+	case Pkg, Tar:
+	default:
+		return errors.Errorf("upgrade only packages are only supported for deb, pkg, and tar, not %s", target.Package)
+	}
+
+	return nil
+}
 
-		if err := os.Chmod(filepath.Join(p.packageRoot, rootPemPath), 0600); err != nil {
-			return errors.Wrap(err, "chmod root PEM")
+// posixNameRegexp matches portable POSIX user and group names, as
+// useradd accepts them. They can't start with a digit, so can't be
+// mistaken for an id.
+var posixNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// validateRunAs checks RunAsUser and RunAsGroup can be used for
+// target.
+func (p *PackageOptions) validateRunAs(target Target) error {
+	if p.RunAsUser == "" && p.RunAsGroup == "" {
+		return nil
+	}
+
+	if p.RunAsUser == "" {
+		return errors.New("run as group requires a run as user")
+	}
+
+	for _, name := range []string{p.RunAsUser, p.RunAsGroup} {
+		if name != "" && !posixNameRegexp.MatchString(name) {
+			return errors.Errorf("invalid user or group name %q", name)
 		}
 	}
 
-	// Install binaries into packageRoot
-	// TODO parallization, osquery-extension.ext
-	// TODO windows file extensions
-	if err := p.getBinary(ctx, p.target.PlatformBinaryName("osqueryd"), p.OsqueryVersion); err != nil {
-		return errors.Wrapf(err, "fetching binary osqueryd")
+	switch {
+	case target.Platform == Linux && (target.Init == SystemD || target.Init == Upstart || target.Init == SysVInit):
+	default:
+		return errors.Errorf("running as a user is only supported by the linux init systems, not %s", target.String())
+	}
+
+	switch target.Package {
+	case Tar:
+		return errors.New("tar packages don't run install scripts, so can't create the run as user")
+	case Snap:
+		return errors.New("snap daemons run as root")
+	}
+
+	return nil
+}
+
+// validateRestartPolicy checks that RestartPolicy is one systemd
+// allows, and that the target's init system can honor it. Upstart only
+// respawns on unexpected exits, so always and on-failure are the same
+// there.
+func (p *PackageOptions) validateRestartPolicy(target Target) error {
+	if p.RestartPolicy == "" && p.RestartSec == 0 {
+		return nil
+	}
+
+	if p.RestartSec < 0 {
+		return errors.New("restart sec can't be negative")
+	}
+
+	if p.RestartPolicy != "" {
+		valid := false
+		for _, policy := range packagekit.SystemdRestartPolicies {
+			if p.RestartPolicy == policy {
+				valid = true
+			}
+		}
+		if !valid {
+			return errors.Errorf("invalid restart policy %q, must be one of %s",
+				p.RestartPolicy, strings.Join(packagekit.SystemdRestartPolicies, ", "))
+		}
+	}
+
+	switch {
+	case target.Platform == Linux && target.Init == SystemD:
+	case target.Platform == Linux && target.Init == Upstart:
+		switch p.RestartPolicy {
+		case "", "always", "on-failure", "no":
+		default:
+			return errors.Errorf("upstart doesn't support the %s restart policy, only always, on-failure, and no", p.RestartPolicy)
+		}
+	default:
+		return errors.Errorf("restart policies are only supported by systemd and upstart, not %s", target.String())
+	}
+
+	return nil
+}
+
+// launchdOpts are the options for launchd plists. Without starting,
+// the plist is disabled, or launchd would load it at boot.
+func (p *PackageOptions) launchdOpts() []packagekit.LaunchdOption {
+	var opts []packagekit.LaunchdOption
+	if p.NoStart {
+		opts = append(opts, packagekit.WithLaunchdDisabled())
+	}
+	return opts
+}
+
+// validateLaunchdTemplate checks that LaunchdTemplate is
+// for a launchd target, and renders a valid plist.
+func (p *PackageOptions) validateLaunchdTemplate(target Target) error {
+	if p.LaunchdTemplate == "" {
+		return nil
+	}
+
+	if target.Init != LaunchD {
+		return errors.Errorf("launchd plist templates are only supported by launchd, not %s", target.String())
+	}
+
+	tmpl, err := ioutil.ReadFile(p.LaunchdTemplate)
+	if err != nil {
+		return errors.Wrap(err, "reading launchd plist template")
+	}
+	return packagekit.ValidateLaunchdTemplate(string(tmpl), p.launchdOpts()...)
+}
+
+// removeTemp removes a temporary build directory, unless KeepTemp is
+// set.
+func (p *PackageOptions) removeTemp(ctx context.Context, dir string) {
+	if p.KeepTemp {
+		level.Info(ctxlog.FromContext(ctx)).Log("msg", "keeping temp dir", "target", p.target.String(), "path", dir)
+		return
+	}
+	os.RemoveAll(dir)
+}
+
+// fetchOpts returns the FetchOpts for downloading binaries per these
+// options.
+func (p *PackageOptions) fetchOpts() ([]FetchOpt, error) {
+	var fetchOpts []FetchOpt
+	if p.RefreshCache {
+		fetchOpts = append(fetchOpts, WithRefreshCache())
+	}
+	if p.MirrorURL != "" {
+		fetchOpts = append(fetchOpts, WithMirrorURL(p.MirrorURL))
+	}
+	if len(p.MirrorURLs) > 0 {
+		fetchOpts = append(fetchOpts, WithMirrorURLs(p.MirrorURLs...))
+	}
+	if p.NotaryURL != "" {
+		fetchOpts = append(fetchOpts, WithNotaryURL(p.NotaryURL))
+	}
+	if p.TUFRootJSON != "" {
+		root, err := ReadTUFRoot(p.TUFRootJSON)
+		if err != nil {
+			return nil, err
+		}
+		fetchOpts = append(fetchOpts, WithTUFRoot(root))
+	}
+	if p.DownloadRetries > 0 {
+		fetchOpts = append(fetchOpts, WithRetries(p.DownloadRetries, p.DownloadRetryBackoff))
+	}
+	if p.DownloadTimeout > 0 {
+		fetchOpts = append(fetchOpts, WithDownloadTimeout(p.DownloadTimeout))
+	}
+	if p.NoNetwork {
+		fetchOpts = append(fetchOpts, WithNoNetwork())
+	}
+	if p.Proxy != "" {
+		proxyURL, err := url.Parse(p.Proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing proxy %s", p.Proxy)
+		}
+		fetchOpts = append(fetchOpts, WithProxy(proxyURL))
+	}
+	return fetchOpts, nil
+}
+
+// getBinary will fetch binaries from places and copy them into our
+// package root. The default case is to assume binaryVersion is a
+// string, and to download from TUF. But it it starts with a character
+// that looks like a file path, treat is as something on the
+// filesystem.
+//
+// TODO: add in file:// URLs
+func (p *PackageOptions) getBinary(ctx context.Context, binaryName, binaryVersion string) error {
+	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("packaging.getBinary.%s", binaryName))
+	defer span.End()
+	defer p.recordPhase("fetch "+binaryName, time.Now())
+
+	var err error
+	var localPath string
+
+	switch {
+	case p.LocalBuildDir != "":
+		localPath = filepath.Join(p.LocalBuildDir, binaryName)
+	case isLocalVersion(binaryVersion):
+		localPath = binaryVersion
+	case p.target.Arch == Universal:
+		universalDir, err := ioutil.TempDir("", "universal-binary")
+		if err != nil {
+			return errors.Wrap(err, "making universal binary dir")
+		}
+		defer os.RemoveAll(universalDir)
+
+		localPath = filepath.Join(universalDir, binaryName)
+		if err := p.fetchUniversalBinary(ctx, localPath, binaryName, binaryVersion); err != nil {
+			return err
+		}
+		p.recordVersion(ctx, binaryName, binaryVersion)
+	default:
+		fetchOpts, err := p.fetchOpts()
+		if err != nil {
+			return err
+		}
+		localPath, err = FetchBinary(ctx, p.CacheDir, binaryName, binaryVersion, string(p.target.Platform), string(p.target.Arch), fetchOpts...)
+		if err != nil {
+			return &DownloadError{
+				Target:    p.target.String(),
+				Component: binaryName,
+				Version:   binaryVersion,
+				Transient: isTransient(err),
+				Err:       err,
+			}
+		}
+		p.recordVersion(ctx, binaryName, binaryVersion)
+	}
+
+	packagedPath := filepath.Join(p.packageRoot, p.binDir, binaryName)
+	if err := fs.CopyFile(localPath, packagedPath); err != nil {
+		return errors.Wrapf(err, "could not copy binary %s", binaryName)
+	}
+	if err := os.Chmod(packagedPath, binaryPerms); err != nil {
+		return errors.Wrapf(err, "chmod binary %s", binaryName)
+	}
+
+	if err := p.stripBinary(ctx, packagedPath); err != nil {
+		return err
+	}
+
+	// This is the hash of what's packaged, after any stripping
+	_, sum, err := hashFile(packagedPath)
+	if err != nil {
+		return errors.Wrapf(err, "hashing packaged binary %s", binaryName)
+	}
+
+	level.Debug(ctxlog.FromContext(ctx)).Log("msg", "packaged binary", "name", binaryName, "version", binaryVersion, "sha256", sum)
+	return nil
+}
+
+// recordVersion records the exact version of a fetched binary, for the
+// build result. Channels are resolved through TUF, to the version they
+// point at. If that fails, eg: offline, the requested version is
+// recorded instead.
+func (p *PackageOptions) recordVersion(ctx context.Context, binaryName, binaryVersion string) {
+	p.setVersion(binaryName, binaryVersion)
+	if isExactVersion(binaryVersion) {
+		return
+	}
+
+	// Universal binaries are fetched per arch, from the same channel
+	arch := p.target.Arch
+	if arch == Universal {
+		arch = universalArches[0]
+	}
+
+	fetchOpts, err := p.fetchOpts()
+	if err != nil {
+		return
 	}
+	resolved, err := ResolveVersion(ctx, binaryName, binaryVersion, string(p.target.Platform), string(arch), fetchOpts...)
+	if err != nil {
+		level.Debug(ctxlog.FromContext(ctx)).Log("msg", "couldn't resolve binary version, recording it as requested", "name", binaryName, "version", binaryVersion, "err", err)
+		return
+	}
+	p.setVersion(binaryName, resolved)
+}
 
-	if err := p.getBinary(ctx, p.target.PlatformBinaryName("launcher"), p.LauncherVersion); err != nil {
-		return errors.Wrapf(err, "fetching binary launcher")
+func (p *PackageOptions) setVersion(binaryName, version string) {
+	if p.versions == nil {
+		p.versions = make(map[string]string)
 	}
+	p.versions[binaryName] = version
+}
 
-	if err := p.getBinary(ctx, p.target.PlatformExtensionName("osquery-extension"), p.ExtensionVersion); err != nil {
-		return errors.Wrapf(err, "fetching binary launcher")
+// fetchedVersion returns the exact version of a fetched binary, or,
+// for local binaries, and those that weren't fetched, binaryVersion.
+func (p *PackageOptions) fetchedVersion(binaryName, binaryVersion string) string {
+	if version, ok := p.versions[binaryName]; ok {
+		return version
 	}
+	return binaryVersion
+}
 
-	// Some darwin specific bits
-	if p.target.Platform == Darwin {
-		if err := p.renderNewSyslogConfig(ctx); err != nil {
-			return errors.Wrap(err, "render")
-		}
+// universalArches are the architectures combined into a universal
+// macOS binary.
+var universalArches = []ArchFlavor{Amd64, Arm64}
 
-		// launchd seems to need the log directory to be pre-created. So,
-		// we'll do this here. This is a bit ugly, since we're duplicate
-		// the directory expansion we do in packagekit.RenderLaunchd. TODO
-		// merge logic, and pass the logs paths as an option.
-		logDir := filepath.Join(p.packageRoot, "var", "log", p.Identifier)
-		if err := os.MkdirAll(logDir, 0755); err != nil {
-			return errors.Wrapf(err, "mkdir logdir %s", logDir)
-		}
+// installDownload is a binary the postinstall downloads, and checks,
+// with FetchAtInstall.
+type installDownload struct {
+	name   string
+	url    string
+	sha256 string
+}
+
+// lookupInstallDownloads looks up the binaries the postinstall
+// downloads, with FetchAtInstall. That's osqueryd, unless it's
+// omitted, and the extension.
+func (p *PackageOptions) lookupInstallDownloads(ctx context.Context) error {
+	p.installDownloads = nil
+	if !p.FetchAtInstall {
+		return nil
 	}
 
-	// The version string is the version of _launcher_ which we don't
-	// know until we've downloaded it.
-	if p.PackageVersion == "" {
-		if err := p.detectLauncherVersion(ctx); err != nil {
-			return errors.Wrap(err, "version detection")
+	if !p.OmitOsquery {
+		if err := p.lookupInstallDownload(ctx, p.target.PlatformBinaryName("osqueryd"), p.OsqueryVersion); err != nil {
+			return errors.Wrap(err, "looking up binary osqueryd")
 		}
 	}
-
-	p.initOptions = &packagekit.InitOptions{
-		Name:        "launcher",
-		Description: "The Kolide Launcher",
-		Path:        filepath.Join(p.binDir, "launcher"),
-		Identifier:  p.Identifier,
-		Flags:       launcherFlags,
-		Environment: launcherEnv,
+	if err := p.lookupInstallDownload(ctx, p.extensionName(p.target), p.ExtensionVersion); err != nil {
+		return errors.Wrap(err, "looking up binary extension")
 	}
+	return nil
+}
 
-	if err := p.setupInit(ctx); err != nil {
-		return errors.Wrapf(err, "setup init script for %s", p.target.String())
+// lookupInstallDownload looks up the release tarball of a binary, for
+// the postinstall to download, rather than packaging the binary.
+func (p *PackageOptions) lookupInstallDownload(ctx context.Context, binaryName, binaryVersion string) error {
+	fetchOpts, err := p.fetchOpts()
+	if err != nil {
+		return err
 	}
-
-	if err := p.setupPostinst(ctx); err != nil {
-		return errors.Wrapf(err, "setup postInst for %s", p.target.String())
+	download, err := LookupDownload(ctx, binaryName, binaryVersion, string(p.target.Platform), string(p.target.Arch), fetchOpts...)
+	if err != nil {
+		return &DownloadError{
+			Target:    p.target.String(),
+			Component: binaryName,
+			Version:   binaryVersion,
+			Transient: isTransient(err),
+			Err:       err,
+		}
 	}
 
-	if err := p.setupPrerm(ctx); err != nil {
-		return errors.Wrapf(err, "setup setupPrerm for %s", p.target.String())
+	p.setVersion(binaryName, download.Version)
+	p.installDownloads = append(p.installDownloads, installDownload{
+		name:   binaryName,
+		url:    fmt.Sprintf("%s/%s", strings.TrimSuffix(p.InstallMirrorURL, "/"), download.Path),
+		sha256: download.SHA256,
+	})
+
+	level.Debug(ctxlog.FromContext(ctx)).Log("msg", "binary is downloaded at install", "name", binaryName, "version", binaryVersion, "path", download.Path, "sha256", download.SHA256)
+	return nil
+}
+
+// isLocalVersion reports whether a binary version is a path on disk,
+// rather than a channel or version to fetch.
+func isLocalVersion(version string) bool {
+	return strings.HasPrefix(version, "./") || strings.HasPrefix(version, "/")
+}
+
+// fetchesBinaries reports whether any of the binaries are fetched,
+// rather than copied from local paths. Local binaries are packaged as
+// they are, so must already be universal for universal targets.
+func (p *PackageOptions) fetchesBinaries() bool {
+	if p.LocalBuildDir != "" {
+		return false
+	}
+	fetchesOsquery := !p.OmitOsquery && !isLocalVersion(p.OsqueryVersion)
+	if fetchesOsquery || !isLocalVersion(p.LauncherVersion) || !isLocalVersion(p.ExtensionVersion) {
+		return true
+	}
+	for _, extension := range p.Extensions {
+		if !isLocalVersion(extension.Version) {
+			return true
+		}
 	}
+	return false
+}
 
-	p.packagekitops = &packagekit.PackageOptions{
-		Name:       "launcher",
-		Identifier: p.Identifier,
-		Root:       p.packageRoot,
-		Scripts:    p.scriptRoot,
-		SigningKey: p.SigningKey,
-		Version:    p.PackageVersion,
+// fetchUniversalBinary fetches the binary for each of the
+// universalArches, and combines them with lipo into output. If any
+// arch is missing, it fails with a DownloadError, rather than
+// packaging a binary that won't run everywhere.
+func (p *PackageOptions) fetchUniversalBinary(ctx context.Context, output, binaryName, binaryVersion string) error {
+	fetchOpts, err := p.fetchOpts()
+	if err != nil {
+		return err
 	}
 
-	if err := p.makePackage(ctx); err != nil {
-		return errors.Wrap(err, "making package")
+	archPaths := []string{}
+	for _, arch := range universalArches {
+		archPath, err := FetchBinary(ctx, p.CacheDir, binaryName, binaryVersion, string(p.target.Platform), string(arch), fetchOpts...)
+		if err != nil {
+			return &DownloadError{
+				Target:    p.target.String(),
+				Component: binaryName,
+				Version:   binaryVersion,
+				Transient: isTransient(err),
+				Err:       errors.Wrapf(err, "%s binary for universal build", arch),
+			}
+		}
+		archPaths = append(archPaths, archPath)
 	}
 
+	args := append([]string{"-create", "-output", output}, archPaths...)
+	if _, err := p.execOut(ctx, "lipo", args...); err != nil {
+		return errors.Wrapf(err, "combining universal binary %s", binaryName)
+	}
 	return nil
 }
 
-// getBinary will fetch binaries from places and copy them into our
-// package root. The default case is to assume binaryVersion is a
-// string, and to download from TUF. But it it starts with a character
-// that looks like a file path, treat is as something on the
-// filesystem.
-//
-// TODO: add in file:// URLs
-func (p *PackageOptions) getBinary(ctx context.Context, binaryName, binaryVersion string) error {
-	ctx, span := trace.StartSpan(ctx, fmt.Sprintf("packaging.getBinary.%s", binaryName))
-	defer span.End()
+// CheckVersions checks that the osqueryd, launcher, and extension
+// versions can be fetched for each target, without downloading them.
+// Local paths must exist, and channels and versions must have TUF
+// metadata. With NoNetwork, they must be in the cache. Failures are
+// returned as a DownloadError.
+func (p *PackageOptions) CheckVersions(ctx context.Context, targets []Target) error {
+	fetchOpts, err := p.fetchOpts()
+	if err != nil {
+		return err
+	}
 
-	var err error
-	var localPath string
+	logger := ctxlog.FromContext(ctx)
 
-	switch {
-	case strings.HasPrefix(binaryVersion, "./"), strings.HasPrefix(binaryVersion, "/"):
-		localPath = binaryVersion
-	default:
-		localPath, err = FetchBinary(ctx, p.CacheDir, binaryName, binaryVersion, string(p.target.Platform))
-		if err != nil {
-			return errors.Wrapf(err, "could not fetch path to binary %s %s", binaryName, binaryVersion)
+	lookup := func(name, version string, platform PlatformFlavor, arch ArchFlavor) (string, error) {
+		if p.NoNetwork {
+			// Offline, the cache is all there is, and fetching from
+			// it verifies it.
+			return FetchBinary(ctx, p.CacheDir, name, version, string(platform), string(arch), fetchOpts...)
 		}
+		return LookupBinary(ctx, name, version, string(platform), string(arch), fetchOpts...)
 	}
 
-	if err := fs.CopyFile(
-		localPath,
-		filepath.Join(p.packageRoot, p.binDir, binaryName),
-	); err != nil {
-		return errors.Wrapf(err, "could not copy binary %s", binaryName)
+	for _, target := range targets {
+		binaries := []struct{ name, version string }{
+			{target.PlatformBinaryName("osqueryd"), p.OsqueryVersion},
+			{target.PlatformBinaryName("launcher"), p.LauncherVersion},
+			{p.extensionName(target), p.ExtensionVersion},
+		}
+		if p.OmitOsquery {
+			binaries = binaries[1:]
+		}
+		for _, extension := range p.Extensions {
+			binaries = append(binaries, struct{ name, version string }{extension.Name, extension.Version})
+		}
+
+		for _, b := range binaries {
+			var err error
+			switch {
+			case p.LocalBuildDir != "":
+				err = checkExecutable(filepath.Join(p.LocalBuildDir, b.name))
+			case isLocalVersion(b.version):
+				_, err = os.Stat(b.version)
+			case target.Arch == Universal:
+				for _, arch := range universalArches {
+					var targetName string
+					targetName, err = lookup(b.name, b.version, target.Platform, arch)
+					if err != nil {
+						err = errors.Wrapf(err, "%s binary for universal build", arch)
+						break
+					}
+					level.Debug(logger).Log("msg", "found binary", "target", target.String(), "name", b.name, "version", b.version, "tuf_target", targetName)
+				}
+			default:
+				var targetName string
+				targetName, err = lookup(b.name, b.version, target.Platform, target.Arch)
+				if err == nil {
+					level.Debug(logger).Log("msg", "found binary", "target", target.String(), "name", b.name, "version", b.version, "tuf_target", targetName)
+				}
+			}
+			if err != nil {
+				return &DownloadError{
+					Target:    target.String(),
+					Component: b.name,
+					Version:   b.version,
+					Transient: isTransient(err),
+					Err:       err,
+				}
+			}
+		}
 	}
 	return nil
 }
@@ -269,30 +1518,57 @@ func (p *PackageOptions) makePackage(ctx context.Context) error {
 	ctx, span := trace.StartSpan(ctx, "packaging.makePackage")
 	defer span.End()
 
+	builder, ok := LookupBuilder(p.target.Package)
+	if !ok {
+		return &UnsupportedTargetError{Target: p.target, Err: errors.New("Don't know how to package")}
+	}
+	if err := builder.Build(ctx, p, p.target, p.packageWriter); err != nil {
+		return errors.Wrapf(err, "packaging, target %s", p.target.String())
+	}
+
+	return nil
+}
+
+// PackagekitOptions returns the options of the package being built,
+// including its package root, and scripts. It's only set during
+// Build, for Builders.
+func (p *PackageOptions) PackagekitOptions() *packagekit.PackageOptions {
+	return p.packagekitops
+}
+
+func (p *PackageOptions) fpmOpts(outputType packagekit.FpmOpt) ([]packagekit.FpmOpt, error) {
 	// Linux packages used to be distributed named "launcher". We've
 	// moved to naming them "launcher-<identifier>". To provide a
 	// cleaner package replacement, we can flag this to the underlying
 	// packaging systems.
 	oldPackageNames := []string{"launcher"}
 
-	switch {
-	case p.target.Package == Deb:
-		if err := packagekit.PackageFPM(ctx, p.packageWriter, p.packagekitops, packagekit.AsDeb(), packagekit.WithReplaces(oldPackageNames)); err != nil {
-			return errors.Wrapf(err, "packaging, target %s", p.target.String())
-		}
-	case p.target.Package == Rpm:
-		if err := packagekit.PackageFPM(ctx, p.packageWriter, p.packagekitops, packagekit.AsRPM(), packagekit.WithReplaces(oldPackageNames)); err != nil {
-			return errors.Wrapf(err, "packaging, target %s", p.target.String())
+	fpmOpts := []packagekit.FpmOpt{outputType, packagekit.WithReplaces(oldPackageNames)}
+	if p.Compression != "" {
+		fpmOpts = append(fpmOpts, packagekit.WithCompression(packagekit.Compression(p.Compression)))
+	}
+
+	// debs' owners are set by dpkg-statoverride, in the preinstall
+	if p.target.Package == Rpm {
+		owners, err := p.fileOwners()
+		if err != nil {
+			return nil, err
 		}
-	case p.target.Package == Pkg:
-		if err := packagekit.PackagePkg(ctx, p.packageWriter, p.packagekitops); err != nil {
-			return errors.Wrapf(err, "packaging, target %s", p.target.String())
+		if len(owners) > 0 {
+			fpmOpts = append(fpmOpts, packagekit.WithOwners(owners))
 		}
-	default:
-		return errors.Errorf("Don't know how to package %s", p.target.String())
 	}
+	return fpmOpts, nil
+}
 
-	return nil
+// wixOpts are the options for windows packages, which are both built
+// with WiX.
+func (p *PackageOptions) wixOpts() []packagekit.WixOpt {
+	wixOpts := []packagekit.WixOpt{packagekit.WithService(p.initOptions)}
+	if p.NoStart {
+		wixOpts = append(wixOpts, packagekit.WithoutServiceStart())
+	}
+	return wixOpts
 }
 
 func (p *PackageOptions) renderNewSyslogConfig(ctx context.Context) error {
@@ -336,6 +1612,13 @@ func (p *PackageOptions) setupInit(ctx context.Context) error {
 		return nil
 	}
 
+	// Windows services aren't defined by a file, they're registered by
+	// the MSI itself. Nor are snap daemons, snapd writes their systemd
+	// units. See makePackage.
+	if p.target.Init == WindowsService || p.target.Package == Snap {
+		return nil
+	}
+
 	if p.initOptions == nil {
 		return errors.New("Missing initOptions")
 	}
@@ -348,17 +1631,52 @@ func (p *PackageOptions) setupInit(ctx context.Context) error {
 	case p.target.Platform == Darwin && p.target.Init == LaunchD:
 		dir = "/Library/LaunchDaemons"
 		file = fmt.Sprintf("com.%s.launcher.plist", p.Identifier)
-		renderFunc = packagekit.RenderLaunchd
+		renderFunc = func(ctx context.Context, w io.Writer, io *packagekit.InitOptions) error {
+			opts := p.launchdOpts()
+			if p.LaunchdTemplate != "" {
+				tmpl, err := ioutil.ReadFile(p.LaunchdTemplate)
+				if err != nil {
+					return errors.Wrap(err, "reading launchd plist template")
+				}
+				opts = append(opts, packagekit.WithLaunchdTemplate(string(tmpl)))
+			}
+			return packagekit.RenderLaunchd(ctx, w, io, opts...)
+		}
 	case p.target.Platform == Linux && p.target.Init == SystemD:
 		dir = "/etc/systemd/system"
 		file = fmt.Sprintf("launcher.%s.service", p.Identifier)
-		renderFunc = packagekit.RenderSystemd
+		renderFunc = func(ctx context.Context, w io.Writer, io *packagekit.InitOptions) error {
+			var opts []packagekit.SystemdOption
+			if p.RestartPolicy != "" {
+				opts = append(opts, packagekit.WithRestart(p.RestartPolicy))
+			}
+			if p.RestartSec != 0 {
+				opts = append(opts, packagekit.WithRestartSec(p.RestartSec))
+			}
+			return packagekit.RenderSystemd(ctx, w, io, opts...)
+		}
 	case p.target.Platform == Linux && p.target.Init == Upstart:
 		dir = "/etc/init"
 		file = fmt.Sprintf("launcher-%s.conf", p.Identifier)
 		renderFunc = func(ctx context.Context, w io.Writer, io *packagekit.InitOptions) error {
-			return packagekit.RenderUpstart(ctx, w, io)
+			opts := []packagekit.UpstartOption{
+				packagekit.WithRespawn(p.RestartPolicy != "no"),
+				packagekit.WithRespawnDelay(p.RestartSec),
+			}
+			// Without starting, it mustn't start at boot either
+			if p.NoStart {
+				opts = append(opts, packagekit.WithManualStart())
+			}
+			return packagekit.RenderUpstart(ctx, w, io, opts...)
 		}
+	case p.target.Platform == Linux && p.target.Init == SysVInit:
+		dir = "/etc/init.d"
+		file = fmt.Sprintf("launcher.%s", p.Identifier)
+		renderFunc = packagekit.RenderInit
+	case p.target.Platform == FreeBSD && p.target.Init == RCD:
+		dir = "/usr/local/etc/rc.d"
+		file = packagekit.RCName(p.initOptions)
+		renderFunc = packagekit.RenderRCD
 	default:
 		return errors.Errorf("Unsupported target %s", p.target.String())
 	}
@@ -379,36 +1697,23 @@ func (p *PackageOptions) setupInit(ctx context.Context) error {
 		return errors.Wrapf(err, "rendering init file (%s), target %s", p.initFile, p.target.String())
 	}
 
-	return nil
-}
-
-func (p *PackageOptions) setupPrerm(ctx context.Context) error {
-	if p.target.Init == NoInit {
-		return nil
-	}
-
-	switch {
-	case p.target.Platform == Darwin && p.target.Init == LaunchD:
-	case p.target.Platform == Linux && p.target.Init == SystemD:
-	case p.target.Platform == Linux && p.target.Init == Init:
-		// TODO double check if this is init, or what
+	// init.d and rc.d scripts are executed directly
+	if p.target.Init == SysVInit || p.target.Init == RCD {
+		if err := fh.Chmod(0755); err != nil {
+			return errors.Wrapf(err, "chmod init file (%s), target %s", p.initFile, p.target.String())
+		}
 	}
 
-	// If we don't match in the case statement, log that we're ignoring
-	// the setup, and move on. Don't throw an error. FIXME: Setup
-	// logging
 	return nil
 }
 
 func (p *PackageOptions) setupPostinst(ctx context.Context) error {
-	if p.target.Init == NoInit {
-		return nil
-	}
-
 	var postinstTemplate string
 	identifier := p.Identifier
 
 	switch {
+	case p.target.Init == NoInit, p.target.Package == Snap:
+		// Nothing to start, or snapd starts it
 	case p.target.Platform == Darwin && p.target.Init == LaunchD:
 		postinstTemplate = postinstallLauncherTemplate()
 		identifier = fmt.Sprintf("com.%s.launcher", p.Identifier)
@@ -416,8 +1721,11 @@ func (p *PackageOptions) setupPostinst(ctx context.Context) error {
 		postinstTemplate = postinstallSystemdTemplate()
 	case p.target.Platform == Linux && p.target.Init == Upstart:
 		postinstTemplate = postinstallUpstartTemplate()
-	case p.target.Platform == Linux && p.target.Init == Init:
-		postinstTemplate = postinstallInitTemplate()
+	case p.target.Platform == Linux && p.target.Init == SysVInit:
+		postinstTemplate = postinstallSysVInitTemplate()
+	case p.target.Platform == FreeBSD && p.target.Init == RCD:
+		postinstTemplate = postinstallRCDTemplate()
+		identifier = packagekit.RCName(p.initOptions)
 	default:
 		// If we don't match in the case statement, log that we're ignoring
 		// the setup, and move on. Don't throw an error.
@@ -425,12 +1733,106 @@ func (p *PackageOptions) setupPostinst(ctx context.Context) error {
 		return nil
 	}
 
+	// Without starting, there's nothing to do after install, except
+	// for systemd, which needs to reload its units to see ours.
+	if p.NoStart {
+		postinstTemplate = ""
+		if p.target.Init == SystemD {
+			postinstTemplate = postinstallSystemdNoStartTemplate()
+		}
+	}
+
+	// The secret, and the user, have to be in place before anything
+	// starts, so they go straight after the #! line. The user comes
+	// second, as it owns the secret. debs and rpms usually created it
+	// in their preinstall, but older installs' files still need their
+	// chowns.
+	// Upgrades instead check that there's an install to upgrade, and
+	// leave its secret, and user, as they are.
+	// Either way, downloaded binaries come last, so a failed download
+	// doesn't leave a half configured install.
+	// The prelude can run before set -e, which upstart's postinstall
+	// only sets after stopping, and darwin's never does, so its
+	// commands exit on failure explicitly.
+	prelude := []string{}
+	switch {
+	case p.UpgradeOnly:
+		prelude = append(prelude, postinstallUpgradeOnlyTemplate())
+	default:
+		if p.SecretFromEnv != "" {
+			prelude = append(prelude, postinstallSecretFromEnvTemplate())
+		}
+		if p.RunAsUser != "" {
+			prelude = append(prelude, postinstallRunAsTemplate())
+		}
+	}
+	if len(p.installDownloads) > 0 {
+		prelude = append(prelude, postinstallDownloadTemplate())
+	}
+	if len(prelude) > 0 {
+		if postinstTemplate == "" {
+			postinstTemplate = "#!/bin/sh\nset -e"
+			if p.target.Platform == Darwin {
+				postinstTemplate = "#!/bin/bash\n\n" + darwinVolumeGuard
+			}
+		}
+		// It goes after the #! line, and on darwin, after the guard
+		// that skips installs to a volume other than the boot one.
+		header := strings.Index(postinstTemplate, "\n") + 1
+		if i := strings.Index(postinstTemplate, darwinVolumeGuard); i >= 0 {
+			header = i + len(darwinVolumeGuard)
+		}
+		postinstTemplate = strings.TrimSuffix(postinstTemplate[:header], "\n") + "\n" + strings.Join(prelude, "\n") + "\n" + strings.TrimPrefix(postinstTemplate[header:], "\n")
+	}
+
+	if postinstTemplate == "" {
+		return nil
+	}
+
+	type download struct {
+		Name   string
+		URL    string
+		Path   string
+		SHA256 string
+	}
+	downloads := []download{}
+	for _, d := range p.installDownloads {
+		downloads = append(downloads, download{
+			Name:   d.name,
+			URL:    packagekit.ShellEscape(d.url),
+			Path:   packagekit.ShellEscape(p.installedPath(filepath.Join(p.binDir, d.name))),
+			SHA256: d.sha256,
+		})
+	}
+
+	// The templates double quote paths, so they're escaped for that.
+	// The identifier, and names, are validated to be safe as they are.
 	var data = struct {
-		Identifier string
-		Path       string
+		Identifier      string
+		Path            string
+		SecretEnv       string
+		SecretPath      string
+		SecretMode      string
+		ConfDir         string
+		User            string
+		Group           string
+		RootDir         string
+		Downloads       []download
+		DownloadCommand string
+		SHA256Command   string
 	}{
-		Identifier: identifier,
-		Path:       p.initFile,
+		Identifier:      identifier,
+		Path:            packagekit.ShellEscape(p.initFile),
+		SecretEnv:       p.SecretFromEnv,
+		SecretPath:      packagekit.ShellEscape(p.installedPath(filepath.Join(p.confDir, "secret"))),
+		SecretMode:      fmt.Sprintf("%04o", p.secretFileMode()),
+		ConfDir:         packagekit.ShellEscape(p.installedPath(p.confDir)),
+		User:            p.RunAsUser,
+		Group:           p.RunAsGroup,
+		RootDir:         packagekit.ShellEscape(p.installedPath(p.rootDir)),
+		Downloads:       downloads,
+		DownloadCommand: downloadCommand(p.target.Platform),
+		SHA256Command:   sha256Command(p.target.Platform),
 	}
 
 	t, err := template.New("postinstall").Parse(postinstTemplate)
@@ -455,22 +1857,38 @@ func (p *PackageOptions) setupPostinst(ctx context.Context) error {
 	return nil
 }
 
-func postinstallInitTemplate() string {
+// postinstallSysVInitTemplate registers the init.d script to start
+// on boot, and restarts the daemon.
+func postinstallSysVInitTemplate() string {
+	return `#!/bin/sh
+set -e
+update-rc.d launcher.{{.Identifier}} defaults
+service launcher.{{.Identifier}} restart`
+}
+
+// postinstallRCDTemplate enables the rc.d service, and restarts it.
+func postinstallRCDTemplate() string {
 	return `#!/bin/sh
-sudo service launcher.{{.Identifier}} restart`
+set -e
+sysrc {{.Identifier}}_enable=YES
+service {{.Identifier}} restart`
 }
 
+// darwinVolumeGuard skips the rest of a pkg's script when it's
+// installed to a volume other than the boot one.
+const darwinVolumeGuard = `[[ $3 != "/" ]] && exit 0`
+
 func postinstallLauncherTemplate() string {
 	return `#!/bin/bash
 
-[[ $3 != "/" ]] && exit 0
+` + darwinVolumeGuard + `
 
 /bin/launchctl stop {{.Identifier}}
 
 sleep 5
 
-/bin/launchctl unload {{.Path}}
-/bin/launchctl load {{.Path}}`
+/bin/launchctl unload "{{.Path}}"
+/bin/launchctl load "{{.Path}}"`
 }
 
 // postinstallUpstartTemplate is a post install restart script.
@@ -492,30 +1910,236 @@ systemctl enable launcher.{{.Identifier}}
 systemctl restart launcher.{{.Identifier}}`
 }
 
+// postinstallSecretFromEnvTemplate writes the enroll secret from an
+// environment variable. If it's unset, a secret that's already been
+// provisioned, eg: by a previous install, is kept.
+func postinstallSecretFromEnvTemplate() string {
+	return `if [ -n "${{.SecretEnv}}" ]; then
+  mkdir -p "$(dirname "{{.SecretPath}}")" || exit 1
+  (umask 077 && printf '%s' "${{.SecretEnv}}" > "{{.SecretPath}}") || exit 1
+  chmod {{.SecretMode}} "{{.SecretPath}}" || exit 1
+elif [ ! -s "{{.SecretPath}}" ]; then
+  echo "{{.SecretEnv}} is not set, and there's no enroll secret at {{.SecretPath}}" >&2
+  exit 1
+fi`
+}
+
+// postinstallUpgradeOnlyTemplate fails the install unless launcher is
+// already installed and enrolled, as upgrade only packages carry no
+// secret to enroll with.
+func postinstallUpgradeOnlyTemplate() string {
+	return `if [ ! -s "{{.SecretPath}}" ]; then
+  echo "There's no enroll secret at {{.SecretPath}}. This package only upgrades an existing launcher install" >&2
+  exit 1
+fi`
+}
+
+// postinstallRunAsTemplate creates the user, and group, launcher runs
+// as, if they're missing. It gives them launcher's root directory, the
+// config directory, and the enroll secret, which are otherwise only
+// readable by root.
+func postinstallRunAsTemplate() string {
+	return runAsUserTemplate() + `
+mkdir -p "{{.RootDir}}" || exit 1
+chown -R {{.User}}{{ if .Group }}:{{.Group}}{{ end }} "{{.RootDir}}" || exit 1
+chown {{.User}}{{ if .Group }}:{{.Group}}{{ end }} "{{.ConfDir}}" || exit 1
+if [ -f "{{.SecretPath}}" ]; then
+  chown {{.User}}{{ if .Group }}:{{.Group}}{{ end }} "{{.SecretPath}}" || exit 1
+fi`
+}
+
+// runAsUserTemplate creates the run as user, and its group, if they
+// don't already exist.
+func runAsUserTemplate() string {
+	return `{{- if .Group }}
+getent group {{.Group}} >/dev/null || groupadd --system {{.Group}} || exit 1
+{{- end }}
+if ! id -u {{.User}} >/dev/null 2>&1; then
+  useradd --system --no-create-home --home-dir "{{.RootDir}}" --shell /sbin/nologin{{ if .Group }} --gid {{.Group}}{{ else }} --user-group{{ end }} {{.User}} || exit 1
+fi`
+}
+
+// postinstallDownloadTemplate downloads the binaries that weren't
+// packaged, with FetchAtInstall, and installs them once their release
+// tarball matches the hash it had at build time. It may run before
+// set -e, so failures exit explicitly.
+func postinstallDownloadTemplate() string {
+	return `download_dir="$(mktemp -d)" || exit 1
+download_failed() {
+  echo "$1" >&2
+  rm -rf "$download_dir"
+  exit 1
+}
+{{- range .Downloads }}
+{{$.DownloadCommand}} "$download_dir/{{.Name}}.tar.gz" "{{.URL}}" || download_failed "couldn't download {{.Name}} from {{.URL}}"
+[ "$({{$.SHA256Command}} "$download_dir/{{.Name}}.tar.gz" | cut -d ' ' -f 1)" = "{{.SHA256}}" ] || download_failed "{{.URL}} doesn't match its sha256, {{.SHA256}}"
+tar -xzf "$download_dir/{{.Name}}.tar.gz" -C "$download_dir" || download_failed "couldn't extract {{.Name}}"
+cp "$download_dir/{{.Name}}" "{{.Path}}.new" && chmod 0755 "{{.Path}}.new" && mv -f "{{.Path}}.new" "{{.Path}}" || download_failed "couldn't install {{.Path}}"
+{{- end }}
+rm -rf "$download_dir"`
+}
+
+// downloadCommand is the command that downloads a url to a file,
+// given the file and then the url, on platform. FreeBSD doesn't have
+// curl in its base system.
+func downloadCommand(platform PlatformFlavor) string {
+	if platform == FreeBSD {
+		return "fetch -q -o"
+	}
+	return "curl --fail --silent --show-error --location --retry 3 --output"
+}
+
+// sha256Command is the command that prints a file's sha256, as hex
+// followed by the file name, on platform.
+func sha256Command(platform PlatformFlavor) string {
+	switch platform {
+	case Darwin:
+		return "shasum -a 256"
+	case FreeBSD:
+		return "sha256 -r"
+	default:
+		return "sha256sum"
+	}
+}
+
+func postinstallSystemdNoStartTemplate() string {
+	return `#!/bin/sh
+set -e
+systemctl daemon-reload`
+}
+
+// validateInstallPrefix checks InstallPrefix is an absolute path, on a
+// platform that can use it. The init files and scripts don't quote
+// paths, so it can't have whitespace either.
+func (p *PackageOptions) validateInstallPrefix(target Target) error {
+	if p.InstallPrefix == "" {
+		return nil
+	}
+	if target.Platform == Windows {
+		return errors.Errorf("install prefix isn't supported for %s. Windows packages install to Program Files", target.String())
+	}
+	if !path.IsAbs(p.InstallPrefix) {
+		return errors.Errorf("install prefix %s must be an absolute path", p.InstallPrefix)
+	}
+	if strings.ContainsAny(p.InstallPrefix, " \t\r\n") {
+		return errors.Errorf("install prefix %q can't contain whitespace", p.InstallPrefix)
+	}
+	return nil
+}
+
+// setupDirectories creates the target's directories in the package
+// root. See setDirectories.
 func (p *PackageOptions) setupDirectories() error {
+	if err := p.setDirectories(); err != nil {
+		return err
+	}
+
+	for _, d := range []struct {
+		path string
+		perm os.FileMode
+	}{
+		{p.binDir, binDirPerms},
+		{p.confDir, confDirPerms},
+		{p.rootDir, rootDirPerms},
+	} {
+		if err := os.MkdirAll(filepath.Join(p.packageRoot, d.path), fs.DirMode); err != nil {
+			return errors.Wrapf(err, "create dir (%s) for %s", d.path, p.target.String())
+		}
+		if err := os.Chmod(filepath.Join(p.packageRoot, d.path), d.perm); err != nil {
+			return errors.Wrapf(err, "chmod dir (%s) for %s", d.path, p.target.String())
+		}
+	}
+	return nil
+}
+
+// primaryHostname is the first failover server, or the only one. It
+// names launcher's root directory.
+func (p *PackageOptions) primaryHostname() string {
+	if len(p.Hostnames) > 0 {
+		return p.Hostnames[0]
+	}
+	return p.Hostname
+}
+
+// setDirectories sets where the target installs binaries, config,
+// and launcher's root directory.
+func (p *PackageOptions) setDirectories() error {
 	switch p.target.Platform {
 	case Linux, Darwin:
 		p.binDir = filepath.Join("/usr/local", p.Identifier, "bin")
 		p.confDir = filepath.Join("/etc", p.Identifier)
-		p.rootDir = filepath.Join("/var", p.Identifier, sanitizeHostname(p.Hostname))
+		p.rootDir = filepath.Join("/var", p.Identifier, sanitizeHostname(p.primaryHostname()))
+	case FreeBSD:
+		// Third party software lives in /usr/local, config included
+		p.binDir = filepath.Join("/usr/local", p.Identifier, "bin")
+		p.confDir = filepath.Join("/usr/local/etc", p.Identifier)
+		p.rootDir = filepath.Join("/var/db", p.Identifier, sanitizeHostname(p.primaryHostname()))
+	case Windows:
+		// These are relative to Program Files. See installedPath
+		p.binDir = filepath.Join(fmt.Sprintf("Launcher-%s", p.Identifier), "bin")
+		p.confDir = filepath.Join(fmt.Sprintf("Launcher-%s", p.Identifier), "conf")
+		p.rootDir = filepath.Join(fmt.Sprintf("Launcher-%s", p.Identifier), "data", sanitizeHostname(p.primaryHostname()))
 
 	default:
 		return errors.Errorf("Unknown platform %s", string(p.target.Platform))
 	}
 
-	for _, d := range []string{p.binDir, p.confDir, p.rootDir} {
-		if err := os.MkdirAll(filepath.Join(p.packageRoot, d), fs.DirMode); err != nil {
-			return errors.Wrapf(err, "create dir (%s) for %s", d, p.target.String())
-		}
+	// A custom prefix moves binaries and config, FreeBSD style. The
+	// data directory stays in /var, as it has to be writable.
+	if p.InstallPrefix != "" && p.target.Platform != Windows {
+		prefix := path.Clean(p.InstallPrefix)
+		p.binDir = path.Join(prefix, p.Identifier, "bin")
+		p.confDir = path.Join(prefix, "etc", p.Identifier)
 	}
+
 	return nil
 }
 
+// signingKey returns the key to sign the target's package with. macOS
+// and linux use different signing systems, so have different keys.
+func (p *PackageOptions) signingKey() string {
+	switch {
+	case p.target.Platform == Darwin && p.target.Package == Pkg:
+		return p.SigningKey
+	case p.target.Platform == Linux && (p.target.Package == Deb || p.target.Package == Rpm):
+		return p.LinuxSigningKey
+	}
+	return ""
+}
+
+// installedPath converts a path internal to the package, into the
+// path it will have on the installed system. For most platforms
+// these are the same, but windows MSIs install relative to Program
+// Files, and want backslashes, and snaps are mounted at $SNAP.
+func (p *PackageOptions) installedPath(path string) string {
+	if p.target.Package == Snap {
+		return "$SNAP" + path
+	}
+	if p.target.Platform != Windows {
+		return path
+	}
+
+	return `C:\Program Files\` + strings.Replace(path, "/", `\`, -1)
+}
+
 func (p *PackageOptions) detectLauncherVersion(ctx context.Context) error {
+	version, err := p.packagedLauncherVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.PackageVersion = version
+	return nil
+}
+
+// packagedLauncherVersion asks the packaged launcher its version.
+// That only works when it can run here, or, with UseDocker, in the
+// linux container.
+func (p *PackageOptions) packagedLauncherVersion(ctx context.Context) (string, error) {
 	launcherPath := filepath.Join(p.packageRoot, p.binDir, p.target.PlatformBinaryName("launcher"))
-	stdout, err := p.execOut(ctx, launcherPath, "-version")
+	stdout, err := p.execTargetOut(ctx, filepath.Dir(launcherPath), launcherPath, "-version")
 	if err != nil {
-		return errors.Wrap(err, "Failed to exec. Perhaps -- Can't autodetect while cross compiling")
+		return "", errors.Wrap(err, "Failed to exec. Perhaps -- Can't autodetect while cross compiling")
 	}
 
 	stdoutSplit := strings.Split(stdout, "\n")
@@ -523,13 +2147,96 @@ func (p *PackageOptions) detectLauncherVersion(ctx context.Context) error {
 	version := versionLine[len(versionLine)-1]
 
 	if version == "" {
-		return errors.New("Unable to parse launcher version.")
+		return "", errors.New("Unable to parse launcher version.")
 	}
 
-	p.PackageVersion = version
+	return version, nil
+}
+
+// validateMinLauncherVersion checks that MinLauncherVersion, if set,
+// is a version number, rather than a channel.
+func (p *PackageOptions) validateMinLauncherVersion() error {
+	if p.MinLauncherVersion != "" && !isExactVersion(p.MinLauncherVersion) {
+		return errors.Errorf("min launcher version %s isn't a version number", p.MinLauncherVersion)
+	}
+	return nil
+}
+
+// checkMinLauncherVersion fails if the packaged launcher is older than
+// MinLauncherVersion. Channels are resolved, through TUF, to the
+// version they point at. Local binaries, or offline builds, ask the
+// packaged launcher instead. Development builds, like 0.11.4-3-gabcdef,
+// count as the release they're based on.
+func (p *PackageOptions) checkMinLauncherVersion(ctx context.Context) error {
+	if p.MinLauncherVersion == "" {
+		return nil
+	}
+
+	minVersion, err := semver.NewVersion(p.MinLauncherVersion)
+	if err != nil {
+		return errors.Wrapf(err, "parsing min launcher version %s", p.MinLauncherVersion)
+	}
+
+	source := p.LauncherVersion
+	if p.LocalBuildDir != "" {
+		source = filepath.Join(p.LocalBuildDir, p.target.PlatformBinaryName("launcher"))
+	}
+
+	resolved, err := p.resolveLauncherVersion(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "resolving launcher %s to check the min launcher version", source)
+	}
+
+	version, err := semver.NewVersion(resolved)
+	if err != nil {
+		return errors.Wrapf(err, "launcher %s resolved to %s, which isn't a version number", source, resolved)
+	}
+	release, err := version.SetPrerelease("")
+	if err != nil {
+		return errors.Wrapf(err, "parsing launcher version %s", resolved)
+	}
+
+	if release.LessThan(minVersion) {
+		return errors.Errorf("launcher %s resolved to %s, older than the min launcher version %s", source, resolved, p.MinLauncherVersion)
+	}
+
+	level.Debug(ctxlog.FromContext(ctx)).Log("msg", "launcher meets the min version", "launcher", source, "resolved", resolved, "min", p.MinLauncherVersion)
 	return nil
 }
 
+// resolveLauncherVersion returns the exact version of the packaged
+// launcher. See checkMinLauncherVersion.
+func (p *PackageOptions) resolveLauncherVersion(ctx context.Context) (string, error) {
+	if p.LocalBuildDir != "" || isLocalVersion(p.LauncherVersion) {
+		return p.packagedLauncherVersion(ctx)
+	}
+
+	// Already resolved when it was fetched
+	if version := p.fetchedVersion(p.target.PlatformBinaryName("launcher"), p.LauncherVersion); isExactVersion(version) {
+		return version, nil
+	}
+
+	// Universal binaries are fetched per arch, from the same channel
+	arch := p.target.Arch
+	if arch == Universal {
+		arch = universalArches[0]
+	}
+
+	fetchOpts, err := p.fetchOpts()
+	if err != nil {
+		return "", err
+	}
+	resolved, err := ResolveVersion(ctx, p.target.PlatformBinaryName("launcher"), p.LauncherVersion, string(p.target.Platform), string(arch), fetchOpts...)
+	if err != nil {
+		// Offline builds can't ask notary. Try the binary itself.
+		if version, execErr := p.packagedLauncherVersion(ctx); execErr == nil {
+			return version, nil
+		}
+		return "", err
+	}
+	return resolved, nil
+}
+
 func (p *PackageOptions) execOut(ctx context.Context, argv0 string, args ...string) (string, error) {
 	// Since PackageOptions is sometimes instantiated directly, set execCC if it's nil.
 	if p.execCC == nil {
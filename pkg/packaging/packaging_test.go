@@ -4,13 +4,18 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/groob/plist"
 	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,6 +43,42 @@ func TestLauncherVersionDetection(t *testing.T) {
 	require.Equal(t, "0.5.6-19-g17c8589", p.PackageVersion)
 }
 
+func TestCheckMinLauncherVersion(t *testing.T) {
+	t.Parallel()
+
+	p := &PackageOptions{
+		LocalBuildDir: "/build",
+		target:        Target{Platform: Linux, Init: SystemD, Package: Deb},
+	}
+	p.execCC = helperCommandContext
+
+	// Without a minimum, nothing is checked
+	require.NoError(t, p.checkMinLauncherVersion(context.TODO()))
+
+	// Development builds count as their release
+	for _, min := range []string{"0.5.0", "0.5.6"} {
+		p.MinLauncherVersion = min
+		require.NoError(t, p.checkMinLauncherVersion(context.TODO()), min)
+	}
+
+	p.MinLauncherVersion = "0.6.0"
+	err := p.checkMinLauncherVersion(context.TODO())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "launcher /build/launcher resolved to 0.5.6-19-g17c8589, older than the min launcher version 0.6.0")
+
+	// When notary can't be reached, the packaged launcher is asked
+	notary := httptest.NewServer(http.NotFoundHandler())
+	defer notary.Close()
+	p.LocalBuildDir = ""
+	p.LauncherVersion = "stable"
+	p.NotaryURL = notary.URL
+	err = p.checkMinLauncherVersion(context.TODO())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "launcher stable resolved to 0.5.6-19-g17c8589")
+
+	require.Error(t, (&PackageOptions{MinLauncherVersion: "stable"}).validateMinLauncherVersion())
+}
+
 func TestExecOut(t *testing.T) {
 	t.Parallel()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -95,6 +136,699 @@ func TestInitStuff(t *testing.T) {
 	}
 }
 
+func TestSetupPostinstNoStart(t *testing.T) {
+	t.Parallel()
+
+	targets := append(testedTargets(), Target{Platform: Linux, Init: Upstart, Package: Deb})
+	for _, target := range targets {
+		testScriptDir, err := ioutil.TempDir("", fmt.Sprintf("test-packaging-script-%s", target.String()))
+		require.NoError(t, err)
+		defer os.RemoveAll(testScriptDir)
+
+		testPackageRoot, err := ioutil.TempDir("", fmt.Sprintf("test-packaging-root-%s", target.String()))
+		require.NoError(t, err)
+		defer os.RemoveAll(testPackageRoot)
+
+		p := &PackageOptions{
+			target:      target,
+			Identifier:  "test",
+			NoStart:     true,
+			initFile:    "/usr/bin/true",
+			scriptRoot:  testScriptDir,
+			packageRoot: testPackageRoot,
+			initOptions: &packagekit.InitOptions{
+				Name:       "test",
+				Identifier: "test",
+				Path:       "/usr/local/test/bin/launcher",
+			},
+		}
+		require.NoError(t, p.setupInit(context.TODO()))
+		require.NoError(t, p.setupPostinst(context.TODO()))
+
+		// Nor does the init system start it at boot
+		initFile, err := ioutil.ReadFile(filepath.Join(testPackageRoot, p.initFile))
+		switch target.Init {
+		case Upstart:
+			require.NoError(t, err)
+			require.Contains(t, string(initFile), "\nmanual\n")
+			require.NotContains(t, string(initFile), "start on")
+		case LaunchD:
+			require.NoError(t, err)
+			var launchd struct {
+				Disabled bool `plist:"Disabled"`
+			}
+			require.NoError(t, plist.Unmarshal(initFile, &launchd))
+			require.True(t, launchd.Disabled, "%s plist is disabled", target.String())
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(testScriptDir, "postinstall"))
+		if target.Init == SystemD {
+			require.NoError(t, err)
+			require.Contains(t, string(contents), "systemctl daemon-reload")
+			require.NotContains(t, string(contents), "enable")
+			require.NotContains(t, string(contents), "restart")
+			continue
+		}
+		require.True(t, os.IsNotExist(err), "%s has no postinstall", target.String())
+	}
+}
+
+func TestSetupPostinstSecretFromEnv(t *testing.T) {
+	t.Parallel()
+
+	testScriptDir, err := ioutil.TempDir("", "test-packaging-script-secret")
+	require.NoError(t, err)
+	defer os.RemoveAll(testScriptDir)
+
+	confDir, err := ioutil.TempDir("", "test-packaging-conf-secret")
+	require.NoError(t, err)
+	defer os.RemoveAll(confDir)
+
+	p := &PackageOptions{
+		target:        Target{Platform: Linux, Init: NoInit, Package: Deb},
+		Identifier:    "test",
+		SecretFromEnv: "TEST_ENROLL_SECRET",
+		scriptRoot:    testScriptDir,
+		confDir:       filepath.Join(confDir, "etc", "test"),
+	}
+	require.NoError(t, p.validateSecretFromEnv(p.target))
+	require.NoError(t, p.setupPostinst(context.TODO()))
+
+	postinstall := filepath.Join(testScriptDir, "postinstall")
+	secretPath := filepath.Join(p.confDir, "secret")
+	runPostinstall := func(env ...string) error {
+		cmd := exec.Command("/bin/sh", postinstall)
+		cmd.Env = env
+		return cmd.Run()
+	}
+
+	// Without the variable, or a provisioned secret, it fails
+	require.Error(t, runPostinstall())
+
+	require.NoError(t, runPostinstall("TEST_ENROLL_SECRET=s3cret"))
+	contents, err := ioutil.ReadFile(secretPath)
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", string(contents))
+	info, err := os.Stat(secretPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// On upgrades, the existing secret is kept
+	require.NoError(t, runPostinstall())
+	contents, err = ioutil.ReadFile(secretPath)
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", string(contents))
+
+	// The secret goes before anything is started
+	p.target = Target{Platform: Linux, Init: SystemD, Package: Deb}
+	require.NoError(t, p.setupPostinst(context.TODO()))
+	script, err := ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	require.True(t, strings.Index(string(script), "TEST_ENROLL_SECRET") < strings.Index(string(script), "systemctl"))
+
+	// upstart's postinstall sets -e after stopping, but failing to
+	// write the secret still fails the install, before starting
+	fakeBin := filepath.Join(confDir, "bin")
+	started := filepath.Join(confDir, "started")
+	require.NoError(t, os.MkdirAll(fakeBin, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fakeBin, "stop"), []byte("#!/bin/sh\nexit 0\n"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fakeBin, "start"), []byte("#!/bin/sh\ntouch "+started+"\n"), 0755))
+	notADir := filepath.Join(confDir, "not-a-dir")
+	require.NoError(t, ioutil.WriteFile(notADir, nil, 0644))
+
+	p.target = Target{Platform: Linux, Init: Upstart, Package: Deb}
+	p.confDir = filepath.Join(notADir, "etc", "test")
+	require.NoError(t, p.setupPostinst(context.TODO()))
+	require.Error(t, runPostinstall("PATH="+fakeBin+":/usr/bin:/bin", "TEST_ENROLL_SECRET=s3cret"))
+	_, err = os.Stat(started)
+	require.True(t, os.IsNotExist(err), "launcher isn't started")
+
+	// darwin pkgs installed to another volume leave the boot volume's
+	// secret alone
+	p.target = Target{Platform: Darwin, Init: LaunchD, Package: Pkg}
+	p.confDir = filepath.Join(confDir, "darwin", "etc", "test")
+	require.NoError(t, p.validateSecretFromEnv(p.target))
+	require.NoError(t, p.setupPostinst(context.TODO()))
+	script, err = ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	require.True(t, strings.Index(string(script), darwinVolumeGuard) < strings.Index(string(script), "TEST_ENROLL_SECRET"))
+	cmd := exec.Command("/bin/bash", postinstall, "", "", "/Volumes/Other")
+	cmd.Env = []string{"TEST_ENROLL_SECRET=s3cret"}
+	require.NoError(t, cmd.Run())
+	_, err = os.Stat(filepath.Join(p.confDir, "secret"))
+	require.True(t, os.IsNotExist(err), "secret isn't written")
+
+	require.Error(t, (&PackageOptions{SecretFromEnv: "NOT-VALID"}).validateSecretFromEnv(p.target))
+	require.Error(t, (&PackageOptions{SecretFromEnv: "SECRET", Secret: "s3cret"}).validateSecretFromEnv(p.target))
+	require.Error(t, (&PackageOptions{SecretFromEnv: "SECRET"}).validateSecretFromEnv(Target{Platform: Windows, Init: WindowsService, Package: Msi}))
+}
+
+func TestSetupPostinstUpgradeOnly(t *testing.T) {
+	t.Parallel()
+
+	testScriptDir, err := ioutil.TempDir("", "test-packaging-script-upgrade")
+	require.NoError(t, err)
+	defer os.RemoveAll(testScriptDir)
+
+	confDir, err := ioutil.TempDir("", "test-packaging-conf-upgrade")
+	require.NoError(t, err)
+	defer os.RemoveAll(confDir)
+
+	p := &PackageOptions{
+		target:      Target{Platform: Linux, Init: NoInit, Package: Deb},
+		Identifier:  "test",
+		UpgradeOnly: true,
+		RunAsUser:   "kolide",
+		scriptRoot:  testScriptDir,
+		confDir:     filepath.Join(confDir, "etc", "test"),
+	}
+	require.NoError(t, p.validateUpgradeOnly(p.target))
+	require.NoError(t, p.setupPostinst(context.TODO()))
+
+	postinstall := filepath.Join(testScriptDir, "postinstall")
+	contents, err := ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	require.NotContains(t, string(contents), "useradd")
+
+	// Without an existing install, it fails
+	require.Error(t, exec.Command("/bin/sh", postinstall).Run())
+
+	require.NoError(t, os.MkdirAll(p.confDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(p.confDir, "secret"), []byte("s3cret"), 0600))
+	require.NoError(t, exec.Command("/bin/sh", postinstall).Run())
+
+	var tests = []struct {
+		po     PackageOptions
+		target Target
+		valid  bool
+	}{
+		{po: PackageOptions{UpgradeOnly: true, OmitSecret: true}, target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, valid: true},
+		{po: PackageOptions{UpgradeOnly: true}, target: Target{Platform: Linux, Init: NoInit, Package: Tar}, valid: true},
+		{po: PackageOptions{UpgradeOnly: true, Secret: "s3cret"}, target: p.target},
+		{po: PackageOptions{UpgradeOnly: true, InstallPrefix: "/opt"}, target: p.target},
+		{po: PackageOptions{UpgradeOnly: true}, target: Target{Platform: Linux, Init: SystemD, Package: Rpm}},
+		{po: PackageOptions{UpgradeOnly: true}, target: Target{Platform: Windows, Init: WindowsService, Package: Msi}},
+	}
+	for _, tt := range tests {
+		err := tt.po.validateUpgradeOnly(tt.target)
+		if tt.valid {
+			require.NoError(t, err, tt.target.String())
+		} else {
+			require.Error(t, err, tt.target.String())
+		}
+	}
+
+	// darwin pkgs installed to another volume don't check the boot
+	// volume's install
+	p.target = Target{Platform: Darwin, Init: LaunchD, Package: Pkg}
+	p.RunAsUser = ""
+	p.OmitSecret = true
+	p.confDir = filepath.Join(confDir, "darwin", "etc", "test")
+	require.NoError(t, p.validateUpgradeOnly(p.target))
+	require.NoError(t, p.setupPostinst(context.TODO()))
+	contents, err = ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	require.True(t, strings.Index(string(contents), darwinVolumeGuard) < strings.Index(string(contents), "This package only upgrades"))
+	require.NoError(t, exec.Command("/bin/bash", postinstall, "", "", "/Volumes/Other").Run())
+	require.Error(t, exec.Command("/bin/bash", postinstall, "", "", "/").Run())
+}
+
+func TestSetupPostinstRunAs(t *testing.T) {
+	t.Parallel()
+
+	testScriptDir, err := ioutil.TempDir("", "test-packaging-script-runas")
+	require.NoError(t, err)
+	defer os.RemoveAll(testScriptDir)
+
+	p := &PackageOptions{
+		target:        Target{Platform: Linux, Init: SystemD, Package: Deb},
+		Identifier:    "test",
+		SecretFromEnv: "TEST_ENROLL_SECRET",
+		RunAsUser:     "kolide",
+		RunAsGroup:    "kolide-group",
+		initFile:      "/usr/bin/true",
+		scriptRoot:    testScriptDir,
+		confDir:       "/etc/test",
+		rootDir:       "/var/test/device.kolide.com-443",
+	}
+	require.NoError(t, p.validateRunAs(p.target))
+	require.NoError(t, p.setupPostinst(context.TODO()))
+
+	postinstall := filepath.Join(testScriptDir, "postinstall")
+	require.NoError(t, exec.Command("/bin/sh", "-n", postinstall).Run())
+
+	contents, err := ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	script := string(contents)
+	require.Contains(t, script, "groupadd --system kolide-group")
+	require.Contains(t, script, `useradd --system --no-create-home --home-dir "/var/test/device.kolide.com-443" --shell /sbin/nologin --gid kolide-group kolide`)
+	require.Contains(t, script, `chown -R kolide:kolide-group "/var/test/device.kolide.com-443"`)
+	require.Contains(t, script, `chown kolide:kolide-group "/etc/test/secret"`)
+	require.Contains(t, script, `chown kolide:kolide-group "/etc/test"`)
+
+	// The secret is written, then given to the user, before anything
+	// is started
+	require.True(t, strings.Index(script, "TEST_ENROLL_SECRET") < strings.Index(script, "useradd"))
+	require.True(t, strings.Index(script, "useradd") < strings.Index(script, "systemctl"))
+
+	// Without a group, the user gets its own
+	p.RunAsGroup = ""
+	require.NoError(t, p.setupPostinst(context.TODO()))
+	contents, err = ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	require.NotContains(t, string(contents), "groupadd")
+	require.Contains(t, string(contents), "--user-group kolide")
+
+	// upstart's postinstall sets -e after stopping, but failing to
+	// create the user still fails the install, before starting
+	installRoot, err := ioutil.TempDir("", "test-packaging-root-runas")
+	require.NoError(t, err)
+	defer os.RemoveAll(installRoot)
+	fakeBin := filepath.Join(installRoot, "bin")
+	started := filepath.Join(installRoot, "started")
+	require.NoError(t, os.MkdirAll(fakeBin, 0755))
+	for name, script := range map[string]string{
+		"id":      "exit 1",
+		"useradd": "exit 1",
+		"stop":    "exit 0",
+		"start":   "touch " + started,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(fakeBin, name), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	}
+
+	p.target = Target{Platform: Linux, Init: Upstart, Package: Deb}
+	p.SecretFromEnv = ""
+	p.confDir = filepath.Join(installRoot, "etc", "test")
+	p.rootDir = filepath.Join(installRoot, "var", "test")
+	require.NoError(t, p.setupPostinst(context.TODO()))
+	cmd := exec.Command("/bin/sh", postinstall)
+	cmd.Env = []string{"PATH=" + fakeBin + ":/usr/bin:/bin"}
+	require.Error(t, cmd.Run())
+	_, err = os.Stat(started)
+	require.True(t, os.IsNotExist(err), "launcher isn't started")
+
+	var tests = []struct {
+		user   string
+		group  string
+		target Target
+		valid  bool
+	}{
+		{user: "kolide", target: p.target, valid: true},
+		{user: "_kolide", group: "kolide-agents", target: Target{Platform: Linux, Init: Upstart, Package: Rpm}, valid: true},
+		{user: "kolide", target: Target{Platform: Linux, Init: SysVInit, Package: Deb}, valid: true},
+		{group: "kolide", target: p.target},
+		{user: "Kolide", target: p.target},
+		{user: "1000", target: p.target},
+		{user: "-kolide", target: p.target},
+		{user: "kolide", group: "kolide group", target: p.target},
+		{user: "averyveryveryveryverylongusername", target: p.target},
+		{user: "kolide", target: Target{Platform: Linux, Init: SystemD, Package: Tar}},
+		{user: "kolide", target: Target{Platform: Linux, Init: NoInit, Package: Deb}},
+		{user: "kolide", target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}},
+	}
+	for _, tt := range tests {
+		err := (&PackageOptions{RunAsUser: tt.user, RunAsGroup: tt.group}).validateRunAs(tt.target)
+		if tt.valid {
+			require.NoError(t, err, "%s:%s for %s", tt.user, tt.group, tt.target.String())
+		} else {
+			require.Error(t, err, "%s:%s for %s", tt.user, tt.group, tt.target.String())
+		}
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		compression string
+		pkg         PackageFlavor
+		valid       bool
+	}{
+		{compression: "", pkg: Deb, valid: true},
+		{compression: "xz", pkg: Deb, valid: true},
+		{compression: "none", pkg: Rpm, valid: true},
+		{compression: "zstd", pkg: Pacman, valid: true},
+		{compression: "zstd", pkg: Pkg, valid: true}, // ignored
+		{compression: "zstd", pkg: Deb},
+		{compression: "zstd", pkg: Rpm},
+		{compression: "bzip2", pkg: Deb},
+		{compression: "bzip2", pkg: Pkg},
+	}
+
+	for _, tt := range tests {
+		err := (&PackageOptions{Compression: tt.compression}).validateCompression(Target{Platform: Linux, Package: tt.pkg})
+		if tt.valid {
+			require.NoError(t, err, "%s for %s", tt.compression, tt.pkg)
+		} else {
+			require.Error(t, err, "%s for %s", tt.compression, tt.pkg)
+		}
+	}
+}
+
+func TestValidateScriptValues(t *testing.T) {
+	t.Parallel()
+
+	linux := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	windows := Target{Platform: Windows, Init: WindowsService, Package: Msi}
+
+	var tests = []struct {
+		po     PackageOptions
+		target Target
+		valid  bool
+	}{
+		{po: PackageOptions{Identifier: "kolide-app", Hostname: "device.example.com:443", UpdateChannel: "nightly"}, target: linux, valid: true},
+		{po: PackageOptions{Hostnames: []string{"[::1]:443", "10.0.0.1:443"}}, target: linux, valid: true},
+		{po: PackageOptions{OsquerydPath: `C:\Program Files\osquery\osqueryd.exe`}, target: windows, valid: true},
+		{po: PackageOptions{Identifier: "kolide;reboot"}, target: linux},
+		{po: PackageOptions{Identifier: "kolide app"}, target: windows},
+		{po: PackageOptions{Hostname: "device.example.com$(reboot):443"}, target: linux},
+		{po: PackageOptions{Hostname: "device.example.com\nExecStartPre=/bin/reboot"}, target: linux},
+		{po: PackageOptions{Hostnames: []string{"a.example.com:443", "b.example.com'\":443"}}, target: linux},
+		{po: PackageOptions{ControlHostname: "control.example.com`reboot`"}, target: linux},
+		{po: PackageOptions{UpdateChannel: "stable\nExecStartPre=/bin/reboot"}, target: linux},
+		{po: PackageOptions{UpdateChannel: "stable\r\n"}, target: windows},
+		{po: PackageOptions{CertPins: "abc;reboot"}, target: linux},
+		{po: PackageOptions{InstallPrefix: "/opt/$(reboot)"}, target: linux},
+		{po: PackageOptions{OsquerydPath: "/opt/osquery bin/osqueryd"}, target: linux},
+		{po: PackageOptions{ExtensionName: "ext'name"}, target: linux},
+	}
+
+	for _, tt := range tests {
+		err := tt.po.validateScriptValues(tt.target)
+		if tt.valid {
+			require.NoError(t, err, "%+v", tt.po)
+		} else {
+			require.Error(t, err, "%+v", tt.po)
+		}
+	}
+}
+
+func TestSetupPostinstEscaping(t *testing.T) {
+	t.Parallel()
+
+	testScriptDir, err := ioutil.TempDir("", "test-packaging-script-escaping")
+	require.NoError(t, err)
+	defer os.RemoveAll(testScriptDir)
+	marker := filepath.Join(testScriptDir, "injected")
+
+	// Paths are validated, but the scripts don't rely on it
+	p := &PackageOptions{
+		target:      Target{Platform: Linux, Init: NoInit, Package: Deb},
+		Identifier:  "test",
+		UpgradeOnly: true,
+		scriptRoot:  testScriptDir,
+		confDir:     "/etc/te\"st$(touch " + marker + ")`touch " + marker + "`",
+	}
+	require.NoError(t, p.setupPostinst(context.TODO()))
+
+	postinstall := filepath.Join(testScriptDir, "postinstall")
+	require.NoError(t, exec.Command("/bin/sh", "-n", postinstall).Run())
+	out, err := exec.Command("/bin/sh", postinstall).CombinedOutput()
+	require.Error(t, err)
+	require.Contains(t, string(out), "no enroll secret at "+p.confDir+"/secret.")
+	_, err = os.Stat(marker)
+	require.True(t, os.IsNotExist(err), "injected command ran")
+}
+
+func TestValidateRestartPolicy(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		policy string
+		sec    int
+		init   InitFlavor
+		valid  bool
+	}{
+		{init: SysVInit, valid: true}, // unset
+		{policy: "always", sec: 10, init: SystemD, valid: true},
+		{policy: "on-abnormal", init: SystemD, valid: true},
+		{policy: "no", init: Upstart, valid: true},
+		{sec: 10, init: Upstart, valid: true},
+		{policy: "on-abnormal", init: Upstart},
+		{policy: "sometimes", init: SystemD},
+		{sec: -1, init: SystemD},
+		{policy: "always", init: SysVInit},
+		{sec: 10, init: NoInit},
+	}
+
+	for _, tt := range tests {
+		err := (&PackageOptions{RestartPolicy: tt.policy, RestartSec: tt.sec}).validateRestartPolicy(Target{Platform: Linux, Init: tt.init})
+		if tt.valid {
+			require.NoError(t, err, "%s/%d for %s", tt.policy, tt.sec, tt.init)
+		} else {
+			require.Error(t, err, "%s/%d for %s", tt.policy, tt.sec, tt.init)
+		}
+	}
+}
+
+func TestValidateLaunchdTemplate(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "test-packaging-launchd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.plist.tmpl")
+	require.NoError(t, ioutil.WriteFile(valid, []byte(`<plist version="1.0"><dict><key>Label</key><string>{{.Label}}</string><key>ProgramArguments</key><array><string>{{.Program}}</string></array><key>KeepAlive</key><true/></dict></plist>`), 0644))
+	invalid := filepath.Join(dir, "invalid.plist.tmpl")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte(`<plist version="1.0"><dict>{{.Label}}`), 0644))
+
+	darwin := Target{Platform: Darwin, Init: LaunchD, Package: Pkg}
+	require.NoError(t, (&PackageOptions{}).validateLaunchdTemplate(darwin))
+	require.NoError(t, (&PackageOptions{LaunchdTemplate: valid}).validateLaunchdTemplate(darwin))
+	require.Error(t, (&PackageOptions{LaunchdTemplate: invalid}).validateLaunchdTemplate(darwin))
+	require.Error(t, (&PackageOptions{LaunchdTemplate: filepath.Join(dir, "missing")}).validateLaunchdTemplate(darwin))
+	require.Error(t, (&PackageOptions{LaunchdTemplate: valid}).validateLaunchdTemplate(Target{Platform: Linux, Init: SystemD, Package: Deb}))
+
+	// The template is used for the init file
+	root, err := ioutil.TempDir("", "test-packaging-root-launchd")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	p := &PackageOptions{
+		target:          darwin,
+		Identifier:      "test",
+		LaunchdTemplate: valid,
+		packageRoot:     root,
+		initOptions:     &packagekit.InitOptions{Identifier: "test", Path: "/usr/local/test/bin/launcher"},
+	}
+	require.NoError(t, p.setupInit(context.TODO()))
+	rendered, err := ioutil.ReadFile(filepath.Join(root, "Library/LaunchDaemons/com.test.launcher.plist"))
+	require.NoError(t, err)
+	require.Equal(t, `<plist version="1.0"><dict><key>Label</key><string>com.test.launcher</string><key>ProgramArguments</key><array><string>/usr/local/test/bin/launcher</string></array><key>KeepAlive</key><true/></dict></plist>`, string(rendered))
+}
+
+func TestValidateMetadata(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		po    PackageOptions
+		valid bool
+	}{
+		{po: PackageOptions{}, valid: true},
+		{po: PackageOptions{Vendor: "Example Co", Maintainer: "IT <it@example.com>", License: "Proprietary", Homepage: "https://example.com/launcher"}, valid: true},
+		{po: PackageOptions{Description: "Example endpoint agent.\nManaged by IT."}, valid: true},
+		{po: PackageOptions{Vendor: "Example\nCo"}},
+		{po: PackageOptions{Maintainer: "IT\r\n"}},
+		{po: PackageOptions{Homepage: "example.com"}},
+		{po: PackageOptions{Homepage: "ftp://example.com"}},
+		{po: PackageOptions{Homepage: "https://"}},
+	}
+
+	for i, tt := range tests {
+		err := tt.po.validateMetadata()
+		if tt.valid {
+			require.NoError(t, err, i)
+		} else {
+			require.Error(t, err, i)
+		}
+	}
+}
+
+func TestValidateNoNetwork(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		po    PackageOptions
+		valid bool
+	}{
+		{po: PackageOptions{OsqueryVersion: "stable"}, valid: true},
+		{po: PackageOptions{NoNetwork: true, OsqueryVersion: "stable", CacheDir: "/tmp/cache"}, valid: true},
+		{po: PackageOptions{NoNetwork: true, LocalBuildDir: "./build"}, valid: true},
+		{po: PackageOptions{NoNetwork: true, OsqueryVersion: "/bin/osqueryd", LauncherVersion: "./launcher", ExtensionVersion: "./osquery-extension.ext"}, valid: true},
+		{po: PackageOptions{NoNetwork: true, OsqueryVersion: "stable"}},
+		{po: PackageOptions{NoNetwork: true, LocalBuildDir: "./build", RefreshCache: true}},
+		{po: PackageOptions{NoNetwork: true, LocalBuildDir: "./build", Notarize: &packagekit.NotarizeOptions{KeychainProfile: "notary"}}},
+	}
+
+	for i, tt := range tests {
+		err := tt.po.validateNoNetwork()
+		if tt.valid {
+			require.NoError(t, err, i)
+		} else {
+			require.Error(t, err, i)
+		}
+	}
+}
+
+func TestValidateAutoupdateMirror(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		po    PackageOptions
+		valid bool
+	}{
+		{po: PackageOptions{}, valid: true},
+		{po: PackageOptions{Autoupdate: true, AutoupdateMirror: "https://updates.example.com"}, valid: true},
+		{po: PackageOptions{Autoupdate: true, Insecure: true, AutoupdateMirror: "http://updates.example.com:8080"}, valid: true},
+		{po: PackageOptions{AutoupdateMirror: "https://updates.example.com"}},
+		{po: PackageOptions{Autoupdate: true, AutoupdateMirror: "http://updates.example.com"}},
+		{po: PackageOptions{Autoupdate: true, AutoupdateMirror: "updates.example.com"}},
+		{po: PackageOptions{Autoupdate: true, AutoupdateMirror: "ftp://updates.example.com"}},
+	}
+
+	for i, tt := range tests {
+		err := tt.po.validateAutoupdateMirror()
+		if tt.valid {
+			require.NoError(t, err, i)
+		} else {
+			require.Error(t, err, i)
+		}
+	}
+
+	p := &PackageOptions{Hostname: "device.example.com:443", OmitSecret: true, Autoupdate: true, AutoupdateMirror: "https://updates.example.com"}
+	config, err := p.LauncherConfig(Target{Platform: Linux, Init: SystemD, Package: Deb})
+	require.NoError(t, err)
+	require.Equal(t, "https://updates.example.com", config.Environment["KOLIDE_LAUNCHER_MIRROR_SERVER_URL"])
+}
+
+func TestSetupPostinstFetchAtInstall(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	testScriptDir, err := ioutil.TempDir("", "test-packaging-script-fetch")
+	require.NoError(t, err)
+	defer os.RemoveAll(testScriptDir)
+
+	binDir, err := ioutil.TempDir("", "test-packaging-bin-fetch")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	p := &PackageOptions{
+		target:           Target{Platform: Linux, Init: NoInit, Package: Deb},
+		Identifier:       "test",
+		FetchAtInstall:   true,
+		InstallMirrorURL: mirror.URL + "/",
+		Insecure:         true,
+		NotaryURL:        notary.URL,
+		scriptRoot:       testScriptDir,
+		binDir:           binDir,
+	}
+	require.NoError(t, p.validateFetchAtInstall(p.target))
+	require.NoError(t, p.lookupInstallDownload(context.TODO(), "osqueryd", "stable"))
+	require.NoError(t, p.setupPostinst(context.TODO()))
+
+	// The channel is pinned to the version it points at
+	postinstall := filepath.Join(testScriptDir, "postinstall")
+	contents, err := ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), fmt.Sprintf(`"%s/kolide/osqueryd/linux/osqueryd-1.2.3.tar.gz"`, mirror.URL))
+	require.Equal(t, 0, release.downloadCount())
+
+	require.NoError(t, exec.Command("/bin/sh", postinstall).Run())
+	installed, err := ioutil.ReadFile(filepath.Join(binDir, "osqueryd"))
+	require.NoError(t, err)
+	require.Equal(t, "osqueryd v1", string(installed))
+
+	// A download that doesn't match its hash isn't installed
+	release.mu.Lock()
+	release.tarball = []byte("not the release")
+	release.mu.Unlock()
+	require.Error(t, exec.Command("/bin/sh", postinstall).Run())
+	installed, err = ioutil.ReadFile(filepath.Join(binDir, "osqueryd"))
+	require.NoError(t, err)
+	require.Equal(t, "osqueryd v1", string(installed))
+
+	deb := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	var tests = []struct {
+		po     PackageOptions
+		target Target
+		valid  bool
+	}{
+		{po: PackageOptions{}, target: deb, valid: true},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com"}, target: deb, valid: true},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com"}, target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, valid: true},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com", OmitOsquery: true, OsqueryVersion: "./osqueryd"}, target: deb, valid: true},
+		{po: PackageOptions{InstallMirrorURL: "https://dl.example.com"}, target: deb},
+		{po: PackageOptions{FetchAtInstall: true}, target: deb},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "http://dl.example.com"}, target: deb},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com", LocalBuildDir: "./build"}, target: deb},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com", ExtensionVersion: "./osquery-extension.ext"}, target: deb},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com", ExtensionName: "acme.ext"}, target: deb},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com"}, target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg, Arch: Universal}},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com"}, target: Target{Platform: Linux, Init: NoInit, Package: Tar}},
+		{po: PackageOptions{FetchAtInstall: true, InstallMirrorURL: "https://dl.example.com"}, target: Target{Platform: Windows, Init: WindowsService, Package: Msi}},
+	}
+	for i, tt := range tests {
+		err := tt.po.validateFetchAtInstall(tt.target)
+		if tt.valid {
+			require.NoError(t, err, i)
+		} else {
+			require.Error(t, err, i)
+		}
+	}
+}
+
+func TestSetDirectoriesFailover(t *testing.T) {
+	t.Parallel()
+
+	// Only the primary server names the root directory
+	p := &PackageOptions{
+		Identifier: "test",
+		Hostnames:  []string{"a.example.com:443", "b.example.com:443"},
+		target:     Target{Platform: Linux},
+	}
+	require.NoError(t, p.setDirectories())
+	require.Equal(t, "/var/test/a.example.com-443", p.rootDir)
+}
+
+func TestInstalledPath(t *testing.T) {
+	t.Parallel()
+
+	linux := &PackageOptions{target: Target{Platform: Linux}}
+	require.Equal(t, "/etc/launcher/secret", linux.installedPath("/etc/launcher/secret"))
+
+	windows := &PackageOptions{target: Target{Platform: Windows}}
+	require.Equal(t, `C:\Program Files\Launcher-launcher\conf\secret`, windows.installedPath("Launcher-launcher/conf/secret"))
+}
+
+func TestSigningKey(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		target Target
+		out    string
+	}{
+		{target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, out: "mac key"},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Deb}, out: "linux key"},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Rpm}, out: "linux key"},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Tar}, out: ""},
+		{target: Target{Platform: Windows, Init: WindowsService, Package: Msi}, out: ""},
+	}
+
+	for _, tt := range tests {
+		p := &PackageOptions{target: tt.target, SigningKey: "mac key", LinuxSigningKey: "linux key"}
+		require.Equal(t, tt.out, p.signingKey(), tt.target.String())
+	}
+}
+
 // TestHelperProcess isn't a real test. It's used as a helper process
 // for TestParameterRun. It's comes from both
 // https://github.com/golang/go/blob/master/src/os/exec/exec_test.go#L724
@@ -141,6 +875,21 @@ func TestHelperProcess(t *testing.T) {
   build date: 	2018-11-09T15:31:10Z
   build user: 	seph
   go version: 	go1.11`)
+	case cmd == "lipo" && len(args) > 3 && args[0] == "-create" && args[1] == "-output":
+		// Stand in for a fat binary, with the inputs concatenated
+		var combined []byte
+		for _, input := range args[3:] {
+			contents, err := ioutil.ReadFile(input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v", err)
+				os.Exit(2)
+			}
+			combined = append(combined, contents...)
+		}
+		if err := ioutil.WriteFile(args[2], combined, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			os.Exit(2)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "Can't mock, unknown command(%q) args(%q) -- Fix TestHelperProcess", cmd, args)
 		os.Exit(2)
@@ -148,6 +897,48 @@ func TestHelperProcess(t *testing.T) {
 
 }
 
+func TestFetchUniversalBinary(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{platforms: []string{"darwin", "darwin/arm64"}}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "test-packaging-universal")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	p := &PackageOptions{
+		CacheDir:  cacheDir,
+		NotaryURL: notary.URL,
+		MirrorURL: mirror.URL,
+		target:    Target{Platform: Darwin, Init: LaunchD, Package: Pkg, Arch: Universal},
+	}
+	p.execCC = helperCommandContext
+
+	output := filepath.Join(cacheDir, "osqueryd-universal")
+	require.NoError(t, p.fetchUniversalBinary(context.TODO(), output, "osqueryd", "stable"))
+	contents, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "osqueryd v1osqueryd v1", string(contents))
+	require.Equal(t, 2, release.downloadCount())
+
+	// Without an arm64 release, it fails, rather than packaging half
+	// a universal binary
+	release.mu.Lock()
+	release.platforms = []string{"darwin"}
+	release.mu.Unlock()
+	err = p.fetchUniversalBinary(context.TODO(), output, "osqueryd", "1.2.3")
+	require.Error(t, err)
+	downloadErr, ok := errors.Cause(err).(*DownloadError)
+	require.True(t, ok, "missing arch is a DownloadError: %v", err)
+	require.Contains(t, downloadErr.Error(), "arm64")
+}
+
 func testedTargets() []Target {
 	return []Target{
 		{
@@ -175,5 +966,45 @@ func testedTargets() []Target {
 			Init:     NoInit,
 			Package:  Deb,
 		},
+		{
+			Platform: Linux,
+			Init:     SysVInit,
+			Package:  Deb,
+		},
+		{
+			Platform: Linux,
+			Init:     SystemD,
+			Package:  Pacman,
+		},
+		{
+			Platform: Linux,
+			Init:     SystemD,
+			Package:  Tar,
+		},
+		{
+			Platform: Darwin,
+			Init:     LaunchD,
+			Package:  Tar,
+		},
+		{
+			Platform: FreeBSD,
+			Init:     RCD,
+			Package:  FreeBSDPkg,
+		},
+		{
+			Platform: FreeBSD,
+			Init:     NoInit,
+			Package:  Tar,
+		},
+		{
+			Platform: Windows,
+			Init:     WindowsService,
+			Package:  Msi,
+		},
+		{
+			Platform: Windows,
+			Init:     WindowsService,
+			Package:  Chocolatey,
+		},
 	}
 }
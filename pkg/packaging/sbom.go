@@ -0,0 +1,313 @@
+package packaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// SBOMFormat is the standard a software bill of materials is written
+// in. Both are JSON.
+type SBOMFormat string
+
+const (
+	CycloneDX SBOMFormat = "cyclonedx"
+	SPDX      SBOMFormat = "spdx"
+)
+
+// sbomExtensions are the conventional suffixes of each format's files.
+var sbomExtensions = map[SBOMFormat]string{
+	CycloneDX: ".cdx.json",
+	SPDX:      ".spdx.json",
+}
+
+// ParseSBOMFormat parses the name of an SBOM format, cyclonedx or spdx.
+func ParseSBOMFormat(input string) (SBOMFormat, error) {
+	format := SBOMFormat(input)
+	if _, ok := sbomExtensions[format]; !ok {
+		return "", errors.Errorf("unknown sbom format %s. Expected cyclonedx or spdx", input)
+	}
+	return format, nil
+}
+
+// WithSBOM writes a software bill of materials, in format, next to
+// each package, as <package>.cdx.json, or <package>.spdx.json. It
+// lists the packaged binaries, with their versions and hashes.
+func WithSBOM(format SBOMFormat) BuildOpt {
+	return func(bo *buildOptions) {
+		bo.sbom = format
+	}
+}
+
+// sbomComponent is a binary in the package.
+type sbomComponent struct {
+	name    string
+	version string
+	sha256  string
+}
+
+// sbomPackage is what an SBOM describes: the package, and what's in it.
+type sbomPackage struct {
+	name       string
+	version    string
+	sha256     string
+	target     string
+	buildTime  string
+	builder    string // package-builder's version
+	components []sbomComponent
+}
+
+// sbomPackage describes the package just built, whose sha256 is sum.
+// Versions are recorded as in the build metadata, so local binaries
+// don't leak the build host's paths.
+func (p *PackageOptions) sbomPackage(sum string) (*sbomPackage, error) {
+	if p.metadata == nil {
+		return nil, errors.New("no build metadata to describe")
+	}
+
+	name := "launcher"
+	if p.Identifier != "" {
+		name = fmt.Sprintf("launcher-%s", p.Identifier)
+	}
+
+	pkg := &sbomPackage{
+		name:      name,
+		version:   p.PackageVersion,
+		sha256:    sum,
+		target:    p.metadata.Target,
+		buildTime: p.metadata.BuildTime,
+		builder:   p.metadata.PackageBuilderVersion,
+	}
+
+	versions := map[string]string{
+		p.target.PlatformBinaryName("osqueryd"): p.metadata.OsqueryVersion,
+		p.target.PlatformBinaryName("launcher"): p.metadata.LauncherVersion,
+		p.extensionName(p.target):               p.metadata.ExtensionVersion,
+	}
+	for name, version := range p.metadata.Extensions {
+		versions[name] = version
+	}
+
+	for _, name := range p.binaryNames(p.target) {
+		pkg.components = append(pkg.components, sbomComponent{
+			name:    name,
+			version: versions[name],
+			sha256:  p.metadata.Binaries[name],
+		})
+	}
+
+	return pkg, nil
+}
+
+// writeSBOM writes pkg's SBOM, in format, next to the package at
+// packagePath, and returns its path.
+func writeSBOM(packagePath string, format SBOMFormat, pkg *sbomPackage) (string, error) {
+	var doc interface{}
+	switch format {
+	case CycloneDX:
+		doc = pkg.cycloneDX()
+	case SPDX:
+		doc = pkg.spdx()
+	default:
+		return "", errors.Errorf("unknown sbom format %s", format)
+	}
+
+	contents, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "encoding sbom")
+	}
+
+	path := packagePath + sbomExtensions[format]
+	if err := ioutil.WriteFile(path, append(contents, '\n'), 0644); err != nil {
+		return "", errors.Wrap(err, "writing sbom")
+	}
+	return path, nil
+}
+
+// sbomNamespace is the namespace of the SBOMs' UUIDs. They're derived
+// from the package's hash, so rebuilding the same package gives the
+// same SBOM.
+var sbomNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/kolide/launcher/sbom"))
+
+func (pkg *sbomPackage) uuid() string {
+	return uuid.NewSHA1(sbomNamespace, []byte(pkg.sha256)).String()
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cdxBOM struct {
+	BOMFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Version      int    `json:"version"`
+	Metadata     struct {
+		Timestamp string       `json:"timestamp"`
+		Tools     []cdxTool    `json:"tools"`
+		Component cdxComponent `json:"component"`
+	} `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// cycloneDX renders pkg as a CycloneDX 1.4 BOM.
+func (pkg *sbomPackage) cycloneDX() *cdxBOM {
+	bom := &cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:" + pkg.uuid(),
+		Version:      1,
+		Components:   []cdxComponent{},
+	}
+	bom.Metadata.Timestamp = pkg.buildTime
+	bom.Metadata.Tools = []cdxTool{{Vendor: "Kolide", Name: "package-builder", Version: pkg.builder}}
+	bom.Metadata.Component = cdxComponent{
+		Type:    "application",
+		BOMRef:  pkg.name,
+		Name:    pkg.name,
+		Version: pkg.version,
+		Hashes:  []cdxHash{{Alg: "SHA-256", Content: pkg.sha256}},
+		Properties: []cdxProperty{
+			{Name: "kolide:target", Value: pkg.target},
+		},
+	}
+
+	dependsOn := []string{}
+	for _, c := range pkg.components {
+		bom.Components = append(bom.Components, cdxComponent{
+			Type:    "application",
+			BOMRef:  c.name,
+			Name:    c.name,
+			Version: c.version,
+			Hashes:  []cdxHash{{Alg: "SHA-256", Content: c.sha256}},
+		})
+		dependsOn = append(dependsOn, c.name)
+	}
+
+	bom.Dependencies = []cdxDependency{{Ref: pkg.name, DependsOn: dependsOn}}
+
+	return bom
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxPackage struct {
+	SPDXID                string         `json:"SPDXID"`
+	Name                  string         `json:"name"`
+	VersionInfo           string         `json:"versionInfo,omitempty"`
+	DownloadLocation      string         `json:"downloadLocation"`
+	FilesAnalyzed         bool           `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum `json:"checksums"`
+	PrimaryPackagePurpose string         `json:"primaryPackagePurpose"`
+	Comment               string         `json:"comment,omitempty"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+// spdxIDRegexp matches what SPDX identifiers can't contain.
+var spdxIDRegexp = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+func spdxID(name string) string {
+	return "SPDXRef-" + spdxIDRegexp.ReplaceAllString(name, "-")
+}
+
+// spdx renders pkg as an SPDX 2.3 document.
+func (pkg *sbomPackage) spdx() *spdxDocument {
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              fmt.Sprintf("%s-%s-%s", pkg.name, pkg.version, pkg.target),
+		DocumentNamespace: "https://github.com/kolide/launcher/spdx/" + pkg.uuid(),
+	}
+	doc.CreationInfo.Created = pkg.buildTime
+	doc.CreationInfo.Creators = []string{"Organization: Kolide", "Tool: package-builder-" + pkg.builder}
+
+	// The components get their ids from their names, so the package's
+	// is prefixed, in case an extension shares its name.
+	pkgID := spdxID("package-" + pkg.name)
+	doc.Packages = append(doc.Packages, spdxPackage{
+		SPDXID:                pkgID,
+		Name:                  pkg.name,
+		VersionInfo:           pkg.version,
+		DownloadLocation:      "NOASSERTION",
+		Checksums:             []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: pkg.sha256}},
+		PrimaryPackagePurpose: "INSTALL",
+		Comment:               "target " + pkg.target,
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID:      doc.SPDXID,
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: pkgID,
+	})
+
+	for _, c := range pkg.components {
+		doc.Packages = append(doc.Packages, spdxPackage{
+			SPDXID:                spdxID(c.name),
+			Name:                  c.name,
+			VersionInfo:           c.version,
+			DownloadLocation:      "NOASSERTION",
+			Checksums:             []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: c.sha256}},
+			PrimaryPackagePurpose: "APPLICATION",
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      pkgID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: spdxID(c.name),
+		})
+	}
+
+	return doc
+}
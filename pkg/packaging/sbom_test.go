@@ -0,0 +1,114 @@
+package packaging
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSBOMFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{"cyclonedx", "spdx"} {
+		format, err := ParseSBOMFormat(input)
+		require.NoError(t, err)
+		require.Equal(t, SBOMFormat(input), format)
+	}
+
+	for _, input := range []string{"", "CycloneDX", "swid"} {
+		_, err := ParseSBOMFormat(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestBuildSBOM(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-sbom-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext", "acme.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion: "1.2.3",
+		LocalBuildDir:  binDir,
+		Extensions:     []ExtensionSpec{{Name: "acme.ext", Version: "stable"}},
+		Hostname:       "device.example.com:443",
+		Identifier:     "kolide-app",
+		Secret:         "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	binaries := []string{"osqueryd", "launcher", "osquery-extension.ext", "acme.ext"}
+
+	t.Run("cyclonedx", func(t *testing.T) {
+		outputDir, err := ioutil.TempDir("", "packaging-sbom-output")
+		require.NoError(t, err)
+		defer os.RemoveAll(outputDir)
+
+		results, err := BuildAll(context.TODO(), po, targets, outputDir, WithSBOM(CycloneDX))
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, results[0].Path+".cdx.json", results[0].SBOM)
+
+		contents, err := ioutil.ReadFile(results[0].SBOM)
+		require.NoError(t, err)
+		var bom cdxBOM
+		require.NoError(t, json.Unmarshal(contents, &bom))
+
+		require.Equal(t, "CycloneDX", bom.BOMFormat)
+		require.Equal(t, "launcher-kolide-app", bom.Metadata.Component.Name)
+		require.Equal(t, "1.2.3", bom.Metadata.Component.Version)
+		require.Equal(t, results[0].SHA256, bom.Metadata.Component.Hashes[0].Content)
+
+		require.Len(t, bom.Components, len(binaries))
+		for i, name := range binaries {
+			require.Equal(t, name, bom.Components[i].Name)
+			require.Equal(t, "local", bom.Components[i].Version)
+			require.Equal(t, results[0].Inputs["usr/local/kolide-app/bin/"+name], bom.Components[i].Hashes[0].Content)
+		}
+		require.Equal(t, binaries, bom.Dependencies[0].DependsOn)
+
+		// The serial number is derived from the package, not random
+		require.Equal(t, "urn:uuid:"+(&sbomPackage{sha256: results[0].SHA256}).uuid(), bom.SerialNumber)
+	})
+
+	t.Run("spdx", func(t *testing.T) {
+		outputDir, err := ioutil.TempDir("", "packaging-sbom-output")
+		require.NoError(t, err)
+		defer os.RemoveAll(outputDir)
+
+		results, err := BuildAll(context.TODO(), po, targets, outputDir, WithSBOM(SPDX))
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, results[0].Path+".spdx.json", results[0].SBOM)
+
+		contents, err := ioutil.ReadFile(results[0].SBOM)
+		require.NoError(t, err)
+		var doc spdxDocument
+		require.NoError(t, json.Unmarshal(contents, &doc))
+
+		require.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+		require.Len(t, doc.Packages, 1+len(binaries))
+		require.Equal(t, "launcher-kolide-app", doc.Packages[0].Name)
+		require.Equal(t, results[0].SHA256, doc.Packages[0].Checksums[0].ChecksumValue)
+		require.Equal(t, "DESCRIBES", doc.Relationships[0].RelationshipType)
+
+		for i, name := range binaries {
+			pkg := doc.Packages[i+1]
+			require.Equal(t, name, pkg.Name)
+			require.Equal(t, results[0].Inputs["usr/local/kolide-app/bin/"+name], pkg.Checksums[0].ChecksumValue)
+			require.Equal(t, "CONTAINS", doc.Relationships[i+1].RelationshipType)
+			require.Equal(t, pkg.SPDXID, doc.Relationships[i+1].RelatedSPDXElement)
+		}
+		require.Equal(t, "SPDXRef-acme.ext", doc.Packages[len(binaries)].SPDXID)
+	})
+}
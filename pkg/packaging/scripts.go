@@ -0,0 +1,118 @@
+package packaging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// RenderScripts renders the init file, and the install scripts, that
+// Build would package for target, without fetching binaries or
+// packaging. With FetchAtInstall, the binaries the postinstall
+// downloads are looked up in TUF, for their hashes. Files in the package are written under dir/root, and
+// install scripts under dir/scripts. It returns the paths of the
+// files written.
+func (p *PackageOptions) RenderScripts(ctx context.Context, target Target, dir string) ([]string, error) {
+	if err := p.Validate(target); err != nil {
+		return nil, err
+	}
+
+	p.target = target
+
+	if len(p.Hostnames) > 0 {
+		p.Hostname = p.Hostnames[0]
+	}
+
+	p.packageRoot = filepath.Join(dir, "root")
+	p.scriptRoot = filepath.Join(dir, "scripts")
+	for _, d := range []string{p.packageRoot, p.scriptRoot} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, errors.Wrapf(err, "mkdir %s", d)
+		}
+	}
+
+	if err := p.setupDirectories(); err != nil {
+		return nil, errors.Wrap(err, "setup directories")
+	}
+
+	if err := p.lookupInstallDownloads(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := p.setupScripts(ctx); err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing rendered scripts")
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// LauncherConfig is how the installed launcher runs, as baked into the
+// init file.
+type LauncherConfig struct {
+	Path        string
+	Flags       []string
+	Environment map[string]string
+	User        string // If set, launcher runs as this user, rather than root
+	Group       string
+	Secret      string // How the enroll secret is installed
+}
+
+// LauncherConfig returns how launcher runs once target's package is
+// installed, without fetching binaries or packaging.
+func (p *PackageOptions) LauncherConfig(target Target) (*LauncherConfig, error) {
+	if err := p.Validate(target); err != nil {
+		return nil, err
+	}
+
+	p.target = target
+
+	if len(p.Hostnames) > 0 {
+		p.Hostname = p.Hostnames[0]
+	}
+
+	if err := p.setDirectories(); err != nil {
+		return nil, errors.Wrap(err, "setup directories")
+	}
+
+	launcherEnv, launcherFlags := p.launcherConfig()
+
+	secret := "packaged"
+	switch {
+	case p.UpgradeOnly:
+		secret = "kept from the existing install"
+	case p.OmitSecret:
+		secret = "omitted, must be installed separately"
+	case p.SecretFromEnv != "":
+		secret = fmt.Sprintf("written at install time, from $%s", p.SecretFromEnv)
+	case p.Secret == "":
+		secret = "packaged, empty"
+	}
+
+	return &LauncherConfig{
+		Path:        p.installedPath(filepath.Join(p.binDir, p.target.PlatformBinaryName("launcher"))),
+		Flags:       launcherFlags,
+		Environment: launcherEnv,
+		User:        p.RunAsUser,
+		Group:       p.RunAsGroup,
+		Secret:      secret,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package packaging
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderScripts(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-scripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &PackageOptions{
+		Hostnames:  []string{"device.example.com:443", "failover.example.com:443"},
+		Identifier: "kolide-app",
+		Secret:     "secret",
+		Autoupdate: true,
+	}
+
+	files, err := p.RenderScripts(context.TODO(), Target{Platform: Linux, Init: SystemD, Package: Deb}, dir)
+	require.NoError(t, err)
+
+	unit := filepath.Join(dir, "root", "etc", "systemd", "system", "launcher.kolide-app.service")
+	postinstall := filepath.Join(dir, "scripts", "postinstall")
+	postremove := filepath.Join(dir, "scripts", "postremove")
+	prerm := filepath.Join(dir, "scripts", "prerm")
+	require.Equal(t, []string{unit, postinstall, postremove, prerm}, files)
+
+	contents, err := ioutil.ReadFile(unit)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "KOLIDE_LAUNCHER_HOSTNAME=device.example.com:443,failover.example.com:443")
+	require.Contains(t, string(contents), "KOLIDE_LAUNCHER_ROOT_DIRECTORY=/var/kolide-app/device.example.com-443")
+	require.Contains(t, string(contents), "--autoupdate")
+
+	contents, err = ioutil.ReadFile(postinstall)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "systemctl restart launcher.kolide-app")
+
+	contents, err = ioutil.ReadFile(prerm)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "systemctl stop launcher.kolide-app")
+
+	// Windows services are defined by the MSI, so there's nothing to
+	// render
+	files, err = (&PackageOptions{Hostname: "device.example.com:443", Identifier: "kolide-app"}).RenderScripts(context.TODO(), Target{Platform: Windows, Init: WindowsService, Package: Msi}, filepath.Join(dir, "windows"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	_, err = p.RenderScripts(context.TODO(), Target{Platform: Windows, Init: SystemD, Package: Deb}, filepath.Join(dir, "invalid"))
+	require.Error(t, err)
+}
+
+func TestLauncherConfig(t *testing.T) {
+	t.Parallel()
+
+	p := &PackageOptions{
+		Hostnames:     []string{"device.example.com:443", "failover.example.com:443"},
+		Identifier:    "kolide-app",
+		Secret:        "secret",
+		Autoupdate:    true,
+		UpdateChannel: "nightly",
+		CertPins:      "abc",
+		RunAsUser:     "kolide",
+	}
+
+	config, err := p.LauncherConfig(Target{Platform: Linux, Init: SystemD, Package: Deb})
+	require.NoError(t, err)
+	require.Equal(t, "/usr/local/kolide-app/bin/launcher", config.Path)
+	require.Equal(t, []string{"--autoupdate"}, config.Flags)
+	require.Equal(t, "device.example.com:443,failover.example.com:443", config.Environment["KOLIDE_LAUNCHER_HOSTNAME"])
+	require.Equal(t, "nightly", config.Environment["KOLIDE_LAUNCHER_UPDATE_CHANNEL"])
+	require.Equal(t, "abc", config.Environment["KOLIDE_LAUNCHER_CERT_PINS"])
+	require.Equal(t, "kolide", config.User)
+	require.Equal(t, "packaged", config.Secret)
+	require.NotContains(t, fmt.Sprint(config), "secret\"")
+
+	p = &PackageOptions{Hostname: "device.example.com:443", Identifier: "kolide-app", SecretFromEnv: "ENROLL_SECRET"}
+	config, err = p.LauncherConfig(Target{Platform: Linux, Init: SystemD, Package: Rpm})
+	require.NoError(t, err)
+	require.Contains(t, config.Secret, "$ENROLL_SECRET")
+
+	p = &PackageOptions{Hostname: "device.example.com:443", Identifier: "kolide-app", OmitSecret: true, LauncherConnectTimeout: 10 * time.Second, LauncherRequestTimeout: 2 * time.Minute}
+	config, err = p.LauncherConfig(Target{Platform: Linux, Init: SystemD, Package: Deb})
+	require.NoError(t, err)
+	require.Equal(t, []string{"--connect_timeout=10s", "--request_timeout=2m0s"}, config.Flags)
+
+	p.LauncherRequestTimeout = -time.Second
+	_, err = p.LauncherConfig(Target{Platform: Linux, Init: SystemD, Package: Deb})
+	require.Error(t, err)
+
+	p = &PackageOptions{Hostname: "device.example.com:443", Identifier: "kolide-app", OmitSecret: true}
+	config, err = p.LauncherConfig(Target{Platform: Windows, Init: WindowsService, Package: Msi})
+	require.NoError(t, err)
+	require.Equal(t, `C:\Program Files\Launcher-kolide-app\bin\launcher.exe`, config.Path)
+	require.Contains(t, config.Secret, "omitted")
+
+	_, err = p.LauncherConfig(Target{Platform: Windows, Init: SystemD, Package: Deb})
+	require.Error(t, err)
+}
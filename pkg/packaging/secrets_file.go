@@ -0,0 +1,62 @@
+package packaging
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// tenantRegexp matches tenant ids. They're used in file names.
+var tenantRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// validateTenant checks that tenant is safe to use in a file name.
+func validateTenant(tenant string) error {
+	if !tenantRegexp.MatchString(tenant) || tenant == "." || tenant == ".." {
+		return errors.Errorf("invalid tenant %q. Tenants may only contain letters, numbers, '.', '_', and '-'", tenant)
+	}
+	return nil
+}
+
+// ParseSecretsFile reads a YAML, or JSON, file mapping tenant ids to
+// enroll secrets, eg:
+//
+//	acme: secret-for-acme
+//	globex: secret-for-globex
+//
+// A package is built for each tenant, with its secret, and the tenant
+// in the file name. See PackageOptions.Tenant.
+func ParseSecretsFile(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading secrets file %s", path)
+	}
+
+	// JSON is valid YAML, so this handles both.
+	jsonContents, err := yaml.YAMLToJSON(contents)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing secrets file %s", path)
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(jsonContents, &secrets); err != nil {
+		return nil, errors.Wrapf(err, "parsing secrets file %s. Expected a map of tenant to enroll secret", path)
+	}
+
+	if len(secrets) == 0 {
+		return nil, errors.Errorf("secrets file %s has no tenants", path)
+	}
+
+	for tenant, secret := range secrets {
+		if err := validateTenant(tenant); err != nil {
+			return nil, errors.Wrapf(err, "secrets file %s", path)
+		}
+		if secret == "" {
+			return nil, errors.Errorf("secrets file %s has an empty secret for %s", path, tenant)
+		}
+	}
+
+	return secrets, nil
+}
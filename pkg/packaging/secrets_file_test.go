@@ -0,0 +1,50 @@
+package packaging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSecretsFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-secrets-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+		return path
+	}
+
+	secrets, err := ParseSecretsFile(write("secrets.yaml", "acme: secret-a\nglobex.eu: secret-b\n"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"acme": "secret-a", "globex.eu": "secret-b"}, secrets)
+
+	secrets, err = ParseSecretsFile(write("secrets.json", `{"acme": "secret-a"}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"acme": "secret-a"}, secrets)
+
+	var tests = []string{
+		"",
+		"- acme\n- globex\n",
+		"acme: 12345\n",
+		"acme: \"\"\n",
+		"../acme: secret\n",
+		"acme corp: secret\n",
+		"..: secret\n",
+		"acme: [secret]\n",
+	}
+	for i, contents := range tests {
+		_, err := ParseSecretsFile(write("bad.yaml", contents))
+		require.Error(t, err, i)
+	}
+
+	_, err = ParseSecretsFile(filepath.Join(dir, "missing.yaml"))
+	require.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package packaging
+
+import (
+	"fmt"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/pkg/errors"
+)
+
+// validateSnap checks the options can be used for target, if it's a
+// snap. Snaps are read only, and mounted at $SNAP, so launcher can't
+// update itself, and files can't be moved. snapd runs daemons as root.
+func (p *PackageOptions) validateSnap(target Target) error {
+	if target.Package != Snap {
+		return nil
+	}
+
+	if p.Identifier != "" {
+		if err := packagekit.ValidateSnapName(fmt.Sprintf("launcher-%s", p.Identifier)); err != nil {
+			return errors.Wrapf(err, "identifier %s can't be used for snaps", p.Identifier)
+		}
+	}
+
+	if p.Autoupdate {
+		return errors.New("snaps are read only, so launcher can't autoupdate them")
+	}
+
+	if p.InstallPrefix != "" {
+		return errors.New("snaps are mounted at $SNAP, so can't use an install prefix")
+	}
+
+	return nil
+}
+
+// snapOpts are the options for snap packages. The daemon is launcher's
+// service, as the other init systems would run it.
+func (p *PackageOptions) snapOpts() []packagekit.SnapOpt {
+	snapOpts := []packagekit.SnapOpt{
+		packagekit.WithSnapService(p.initOptions),
+		packagekit.WithSnapRestart(p.RestartPolicy, p.RestartSec),
+	}
+	if p.NoStart {
+		snapOpts = append(snapOpts, packagekit.WithoutSnapStart())
+	}
+	return snapOpts
+}
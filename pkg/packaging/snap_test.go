@@ -0,0 +1,57 @@
+package packaging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSnap(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "packaging-snap")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "postinstall.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\necho installed\n"), 0755))
+
+	target := Target{Platform: Linux, Init: SystemD, Package: Snap}
+	newOptions := func() *PackageOptions {
+		return &PackageOptions{Hostname: "device.example.com:443", Identifier: "kolide-app", Secret: "secret"}
+	}
+	require.NoError(t, newOptions().Validate(target))
+
+	var tests = []func(p *PackageOptions){
+		func(p *PackageOptions) { p.Identifier = "kolide_app" },
+		func(p *PackageOptions) { p.Autoupdate = true },
+		func(p *PackageOptions) { p.InstallPrefix = "/opt" },
+		func(p *PackageOptions) { p.RunAsUser = "kolide" },
+		func(p *PackageOptions) { p.Secret, p.SecretFromEnv = "", "ENROLL_SECRET" },
+		func(p *PackageOptions) { p.PostinstallScript = script },
+	}
+	for i, setup := range tests {
+		p := newOptions()
+		setup(p)
+		require.Error(t, p.Validate(target), i)
+	}
+
+	// Other targets don't care about snap names
+	p := newOptions()
+	p.Identifier = "kolide_app"
+	require.NoError(t, p.Validate(Target{Platform: Linux, Init: SystemD, Package: Deb}))
+}
+
+func TestSnapLauncherConfig(t *testing.T) {
+	t.Parallel()
+
+	p := &PackageOptions{Hostname: "device.example.com:443", Identifier: "kolide-app", Secret: "secret"}
+	config, err := p.LauncherConfig(Target{Platform: Linux, Init: SystemD, Package: Snap})
+	require.NoError(t, err)
+	require.Equal(t, "$SNAP/usr/local/kolide-app/bin/launcher", config.Path)
+	require.Equal(t, "$SNAP/usr/local/kolide-app/bin/osqueryd", config.Environment["KOLIDE_LAUNCHER_OSQUERYD_PATH"])
+	require.Equal(t, "$SNAP/etc/kolide-app/secret", config.Environment["KOLIDE_LAUNCHER_ENROLL_SECRET_PATH"])
+	require.Equal(t, "$SNAP_COMMON/device.example.com-443", config.Environment["KOLIDE_LAUNCHER_ROOT_DIRECTORY"])
+}
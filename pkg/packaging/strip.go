@@ -0,0 +1,127 @@
+package packaging
+
+import (
+	"context"
+	"debug/macho"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/contexts/ctxlog"
+	"github.com/pkg/errors"
+)
+
+// loadCmdCodeSignature is mach-o's LC_CODE_SIGNATURE, which
+// debug/macho doesn't name.
+const loadCmdCodeSignature = 0x1d
+
+// stripsBinaries reports whether target's binaries are stripped. Only
+// linux and darwin binaries are, and only when there's a strip that
+// can read them. See canStrip.
+func (p *PackageOptions) stripsBinaries(target Target) bool {
+	return p.StripBinaries && (target.Platform == Linux || target.Platform == Darwin) && p.canStrip(target)
+}
+
+// canStrip reports whether there's a strip that understands target's
+// binaries. The build host's only understands its own platform's, eg:
+// Apple's strip can't read ELF binaries. With UseDocker, linux
+// targets use the container's.
+func (p *PackageOptions) canStrip(target Target) bool {
+	return p.usesDocker(target) || p.buildHostPlatform() == target.Platform
+}
+
+// buildHostPlatform is the platform package-builder is running on.
+func (p *PackageOptions) buildHostPlatform() PlatformFlavor {
+	if p.hostPlatform != "" {
+		return p.hostPlatform
+	}
+	return PlatformFlavor(runtime.GOOS)
+}
+
+// stripBinary strips the symbols from a packaged binary, with the build
+// host's strip, or, with UseDocker, the linux container's, and logs how
+// much smaller it got. Binaries for another platform than the build
+// host's are left as they are, as are signed darwin binaries, as
+// stripping would invalidate the signature.
+func (p *PackageOptions) stripBinary(ctx context.Context, binaryPath string) error {
+	logger := ctxlog.FromContext(ctx)
+	name := filepath.Base(binaryPath)
+
+	if !p.stripsBinaries(p.target) {
+		if p.StripBinaries && (p.target.Platform == Linux || p.target.Platform == Darwin) {
+			level.Debug(logger).Log("msg", "not stripping binary, the build host's strip can't read it", "name", name, "host", p.buildHostPlatform())
+		}
+		return nil
+	}
+
+	if p.target.Platform == Darwin {
+		signed, err := isCodesigned(binaryPath)
+		if err != nil {
+			return errors.Wrapf(err, "checking if %s is signed", name)
+		}
+		if signed {
+			level.Debug(logger).Log("msg", "not stripping signed binary", "name", name)
+			return nil
+		}
+	}
+
+	before, err := os.Stat(binaryPath)
+	if err != nil {
+		return errors.Wrapf(err, "stat binary %s", name)
+	}
+
+	if _, err := p.execTargetOut(ctx, filepath.Dir(binaryPath), "strip", binaryPath); err != nil {
+		return errors.Wrapf(err, "stripping %s. Turn off stripping to package it as it is", name)
+	}
+
+	// strip may rewrite the file, rather than edit it in place
+	if err := os.Chmod(binaryPath, binaryPerms); err != nil {
+		return errors.Wrapf(err, "chmod binary %s", name)
+	}
+
+	after, err := os.Stat(binaryPath)
+	if err != nil {
+		return errors.Wrapf(err, "stat binary %s", name)
+	}
+
+	level.Debug(logger).Log(
+		"msg", "stripped binary",
+		"name", name,
+		"size", before.Size(),
+		"stripped_size", after.Size(),
+		"saved", before.Size()-after.Size(),
+	)
+	return nil
+}
+
+// isCodesigned reports whether a mach-o binary, or any arch of a
+// universal one, has a code signature.
+func isCodesigned(path string) (bool, error) {
+	if fat, err := macho.OpenFat(path); err == nil {
+		defer fat.Close()
+		for _, arch := range fat.Arches {
+			if hasCodeSignature(arch.File) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	f, err := macho.Open(path)
+	if err != nil {
+		return false, errors.Wrap(err, "not a mach-o binary")
+	}
+	defer f.Close()
+	return hasCodeSignature(f), nil
+}
+
+func hasCodeSignature(f *macho.File) bool {
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) >= 4 && f.ByteOrder.Uint32(raw) == loadCmdCodeSignature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package packaging
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/kolide/kit/fs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripBinary(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("strip"); err != nil {
+		t.Skip("No strip")
+	}
+
+	binDir, err := ioutil.TempDir("", "test-packaging-strip")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	// The test binary has its symbols
+	binaryPath := filepath.Join(binDir, "launcher")
+	require.NoError(t, fs.CopyFile(os.Args[0], binaryPath))
+	before, err := os.Stat(binaryPath)
+	require.NoError(t, err)
+
+	linux := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	p := &PackageOptions{target: linux, StripBinaries: true, hostPlatform: Linux}
+	require.Contains(t, p.RequiredTools(linux), "strip")
+	require.NoError(t, p.stripBinary(context.TODO(), binaryPath))
+
+	after, err := os.Stat(binaryPath)
+	require.NoError(t, err)
+	require.True(t, after.Size() < before.Size())
+	require.Equal(t, os.FileMode(binaryPerms), after.Mode().Perm())
+
+	// Windows binaries, and darwin ones that aren't mach-o, aren't
+	// stripped
+	windows := Target{Platform: Windows, Init: WindowsService, Package: Msi}
+	require.NotContains(t, (&PackageOptions{StripBinaries: true}).RequiredTools(windows), "strip")
+	require.NoError(t, (&PackageOptions{target: windows, StripBinaries: true}).stripBinary(context.TODO(), binaryPath))
+
+	darwin := &PackageOptions{target: Target{Platform: Darwin, Init: LaunchD, Package: Pkg}, StripBinaries: true, hostPlatform: Darwin}
+	require.Error(t, darwin.stripBinary(context.TODO(), binaryPath))
+
+	// Without StripBinaries, binaries are left alone
+	require.NotContains(t, (&PackageOptions{}).RequiredTools(linux), "strip")
+	require.NoError(t, (&PackageOptions{target: linux}).stripBinary(context.TODO(), filepath.Join(binDir, "missing")))
+}
+
+func TestStripBinaryOtherPlatform(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "test-packaging-strip")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	binaryPath := filepath.Join(binDir, "launcher")
+	require.NoError(t, ioutil.WriteFile(binaryPath, []byte("not really ELF"), binaryPerms))
+
+	// A darwin host's strip can't read linux binaries, so they're
+	// packaged as they are, and strip isn't needed for them.
+	linux := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	p := &PackageOptions{target: linux, StripBinaries: true, hostPlatform: Darwin}
+	require.NotContains(t, p.RequiredTools(linux), "strip")
+	require.NoError(t, p.stripBinary(context.TODO(), binaryPath))
+
+	contents, err := ioutil.ReadFile(binaryPath)
+	require.NoError(t, err)
+	require.Equal(t, "not really ELF", string(contents))
+
+	// Its own darwin binaries are stripped
+	darwin := Target{Platform: Darwin, Init: LaunchD, Package: Pkg}
+	require.Contains(t, p.RequiredTools(darwin), "strip")
+
+	// With UseDocker, the linux container strips linux binaries
+	p.UseDocker = true
+	require.True(t, p.stripsBinaries(linux))
+}
@@ -3,6 +3,8 @@ package packaging
 import (
 	"fmt"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // Target is the platform being targetted by the build. As "platform"
@@ -11,16 +13,19 @@ type Target struct {
 	Init     InitFlavor
 	Package  PackageFlavor
 	Platform PlatformFlavor
+	Arch     ArchFlavor // If unset, amd64. Only included in String() when set.
 }
 
 type InitFlavor string
 
 const (
-	LaunchD InitFlavor = "launchd"
-	SystemD            = "systemd"
-	Init               = "init"
-	Upstart            = "upstart"
-	NoInit             = "none"
+	LaunchD        InitFlavor = "launchd"
+	SystemD                   = "systemd"
+	SysVInit                  = "sysvinit"
+	Upstart                   = "upstart"
+	WindowsService            = "service"
+	RCD                       = "rcd"
+	NoInit                    = "none"
 )
 
 type PlatformFlavor string
@@ -29,32 +34,121 @@ const (
 	Darwin  PlatformFlavor = "darwin"
 	Windows                = "windows"
 	Linux                  = "linux"
+	FreeBSD                = "freebsd"
+)
+
+type ArchFlavor string
+
+const (
+	Amd64     ArchFlavor = "amd64"
+	Arm64                = "arm64"
+	Universal            = "universal" // macOS only. The amd64 and arm64 binaries, combined with lipo.
 )
 
 type PackageFlavor string
 
 const (
-	Pkg PackageFlavor = "pkg"
-	Tar               = "tar"
-	Deb               = "deb"
-	Rpm               = "rpm"
-	Msi               = "msi"
+	Pkg        PackageFlavor = "pkg"
+	Tar                      = "tar"
+	Deb                      = "deb"
+	Rpm                      = "rpm"
+	Msi                      = "msi"
+	Pacman                   = "pacman"
+	Chocolatey               = "chocolatey"
+	FreeBSDPkg               = "pkgng"
+	Snap                     = "snap"
+	OCI                      = "oci"
 )
 
 func (t *Target) String() string {
+	if t.Arch != "" {
+		return fmt.Sprintf("%s-%s-%s-%s", t.Platform, t.Init, t.Package, t.Arch)
+	}
 	return fmt.Sprintf("%s-%s-%s", t.Platform, t.Init, t.Package)
 }
 
-// Extension returns the extension that the resulting filesystem
-// package should have. This may need to gain a PlatformFlavor in the
-// future, and not just a straight string(PackageFlavor)
+// GetArch returns the target architecture, defaulting to amd64.
+func (t *Target) GetArch() ArchFlavor {
+	if t.Arch == "" {
+		return Amd64
+	}
+	return t.Arch
+}
+
+// Validate checks that the target is a combination of platform, init,
+// and package that we know how to build.
+func (t *Target) Validate() error {
+	var inits []InitFlavor
+	var arches []ArchFlavor
+
+	switch t.Platform {
+	case Darwin:
+		inits = []InitFlavor{LaunchD, NoInit}
+		arches = []ArchFlavor{Amd64, Arm64, Universal}
+	case Linux:
+		inits = []InitFlavor{SystemD, Upstart, SysVInit, NoInit}
+		arches = []ArchFlavor{Amd64, Arm64}
+	case FreeBSD:
+		inits = []InitFlavor{RCD, NoInit}
+		arches = []ArchFlavor{Amd64, Arm64}
+	case Windows:
+		inits = []InitFlavor{WindowsService, NoInit}
+		arches = []ArchFlavor{Amd64}
+	default:
+		return errors.Errorf("unknown platform %s", t.Platform)
+	}
+
+	if !containsInit(inits, t.Init) {
+		return errors.Errorf("init %s is not supported on %s", t.Init, t.Platform)
+	}
+
+	if !containsArch(arches, t.GetArch()) {
+		return errors.Errorf("arch %s is not supported on %s", t.GetArch(), t.Platform)
+	}
+
+	// Which platforms, and inits, a package supports is up to its
+	// Builder.
+	builder, ok := LookupBuilder(t.Package)
+	if !ok {
+		return errors.Errorf("unknown package %s", t.Package)
+	}
+	if !builder.Supports(*t) {
+		return errors.Errorf("package %s is not supported on %s with init %s", t.Package, t.Platform, t.Init)
+	}
+
+	return nil
+}
+
+func containsInit(inits []InitFlavor, init InitFlavor) bool {
+	for _, i := range inits {
+		if i == init {
+			return true
+		}
+	}
+	return false
+}
+
+func containsArch(arches []ArchFlavor, arch ArchFlavor) bool {
+	for _, a := range arches {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// PkgExtension returns the extension that the resulting filesystem
+// package should have, per its Builder.
 func (t *Target) PkgExtension() string {
+	if builder, ok := LookupBuilder(t.Package); ok {
+		return builder.Extension()
+	}
 	return strings.ToLower(string(t.Package))
 }
 
 // PlatformExtensionName is a helper to return the platform specific extension name.
 func (t *Target) PlatformExtensionName(input string) string {
-	if t.Platform == "Windows" {
+	if t.Platform == Windows {
 		return input + ".exe"
 	} else {
 		return input + ".ext"
@@ -63,7 +157,7 @@ func (t *Target) PlatformExtensionName(input string) string {
 
 // PlatformBinaryName is a helper to return the platform specific binary suffix.
 func (t *Target) PlatformBinaryName(input string) string {
-	if t.Platform == "Windows" {
+	if t.Platform == Windows {
 		return input + ".exe"
 	}
 	return input
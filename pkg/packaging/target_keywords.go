@@ -0,0 +1,358 @@
+package packaging
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TargetKeyword is a human name for a set of build targets, as
+// accepted by ParseTargets.
+type TargetKeyword struct {
+	Name    string
+	Targets []Target
+}
+
+// targetKeywords is the mapping between human names and build
+// targets. Both ParseTargets and package-builder's list-targets use
+// it, so this is the place to add new ones.
+var targetKeywords = []TargetKeyword{
+	{
+		Name: "darwin",
+		Targets: []Target{
+			{Platform: Darwin, Init: LaunchD, Package: Pkg},
+		},
+	},
+	{
+		Name: "deb",
+		Targets: []Target{
+			{Platform: Linux, Init: SystemD, Package: Deb},
+		},
+	},
+	{
+		Name: "rpm",
+		Targets: []Target{
+			{Platform: Linux, Init: SystemD, Package: Rpm},
+		},
+	},
+	{
+		Name: "pacman",
+		Targets: []Target{
+			{Platform: Linux, Init: SystemD, Package: Pacman},
+		},
+	},
+	{
+		Name: "snap",
+		Targets: []Target{
+			{Platform: Linux, Init: SystemD, Package: Snap},
+		},
+	},
+	{
+		Name: "oci",
+		Targets: []Target{
+			{Platform: Linux, Init: NoInit, Package: OCI},
+		},
+	},
+	{
+		Name: "tar",
+		Targets: []Target{
+			{Platform: Linux, Init: SystemD, Package: Tar},
+			{Platform: Darwin, Init: LaunchD, Package: Tar},
+		},
+	},
+	{
+		Name: "freebsd",
+		Targets: []Target{
+			{Platform: FreeBSD, Init: RCD, Package: FreeBSDPkg},
+		},
+	},
+	{
+		Name: "windows",
+		Targets: []Target{
+			{Platform: Windows, Init: WindowsService, Package: Msi},
+		},
+	},
+	{
+		Name: "msi",
+		Targets: []Target{
+			{Platform: Windows, Init: WindowsService, Package: Msi},
+		},
+	},
+	{
+		Name: "choco",
+		Targets: []Target{
+			{Platform: Windows, Init: WindowsService, Package: Chocolatey},
+		},
+	},
+}
+
+// DefaultTargets is the set built when no targets are specified, and
+// what "all" expands to. Windows is only included if includeWindows
+// is set.
+func DefaultTargets(includeWindows bool) []Target {
+	targets := []Target{
+		{Platform: Darwin, Init: LaunchD, Package: Pkg},
+		{Platform: Linux, Init: SystemD, Package: Rpm},
+		{Platform: Linux, Init: SystemD, Package: Deb},
+		{Platform: Linux, Init: Upstart, Package: Deb},
+	}
+
+	if includeWindows {
+		targets = append(targets, Target{
+			Platform: Windows,
+			Init:     WindowsService,
+			Package:  Msi,
+		})
+	}
+
+	return targets
+}
+
+// TargetKeywords returns the keywords accepted by ParseTargets, not
+// including "all".
+func TargetKeywords() []TargetKeyword {
+	return targetKeywords
+}
+
+// TargetKeywordNames returns the names accepted by ParseTargets.
+func TargetKeywordNames() []string {
+	names := []string{"all"}
+	for _, keyword := range targetKeywords {
+		names = append(names, keyword.Name)
+	}
+	return names
+}
+
+func lookupTargetKeyword(name string) ([]Target, bool) {
+	for _, keyword := range targetKeywords {
+		if keyword.Name == name {
+			return keyword.Targets, true
+		}
+	}
+	return nil, false
+}
+
+// ParseTargets takes a comma separated list of target keywords, and
+// returns the targets they expand to. Names are case insensitive, and
+// "all" expands to the default set. Windows is only included in the
+// default set if includeWindows is set. Keywords may choose their init
+// system, as package:init. Targets named more than once, eg: by
+// windows and msi, are only returned once, where they're first named.
+func ParseTargets(input string, includeWindows bool) ([]Target, error) {
+	// Nothing specified, return a default set
+	if strings.TrimSpace(input) == "" {
+		return DefaultTargets(includeWindows), nil
+	}
+
+	// split the input, and iterate
+	targets := []Target{}
+	unknown := []string{}
+	for _, target := range strings.Split(input, ",") {
+		switch name := strings.ToLower(strings.TrimSpace(target)); name {
+		case "":
+			// Tolerate stray commas
+		case "all":
+			targets = append(targets, DefaultTargets(includeWindows)...)
+		default:
+			// Targets may choose their init system, as package:init
+			parts := strings.SplitN(name, ":", 2)
+			keywordTargets, ok := lookupTargetKeyword(parts[0])
+			if !ok {
+				unknown = append(unknown, strings.TrimSpace(target))
+				continue
+			}
+
+			if len(parts) == 2 {
+				var err error
+				if keywordTargets, err = withInit(keywordTargets, parts[1]); err != nil {
+					return nil, errors.Wrapf(err, "target %s", strings.TrimSpace(target))
+				}
+			}
+			targets = append(targets, keywordTargets...)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return nil, errors.Errorf("Unknown targets: %s", strings.Join(unknown, ", "))
+	}
+
+	return uniqueTargets(targets), nil
+}
+
+// uniqueTargets returns targets without repeats, in the order they
+// first appear.
+func uniqueTargets(targets []Target) []Target {
+	unique := []Target{}
+	seen := make(map[Target]bool)
+	for _, target := range targets {
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		unique = append(unique, target)
+	}
+	return unique
+}
+
+// ExcludeTargets removes the targets in a comma separated list of
+// target keywords, as accepted by ParseTargets, from targets. It's an
+// error if any of them aren't in targets, as that's likely a typo, or
+// if there are no targets left.
+func ExcludeTargets(targets []Target, input string, includeWindows bool) ([]Target, error) {
+	if strings.TrimSpace(input) == "" {
+		return targets, nil
+	}
+
+	excluded := make(map[Target]bool)
+	missing := []string{}
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		nameTargets, err := ParseTargets(name, includeWindows)
+		if err != nil {
+			return nil, errors.Wrap(err, "excluding targets")
+		}
+
+		found := false
+		for _, exclude := range nameTargets {
+			for _, target := range targets {
+				if target == exclude {
+					found = true
+				}
+			}
+			excluded[exclude] = true
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, errors.Errorf("Excluded targets aren't in the targets: %s", strings.Join(missing, ", "))
+	}
+
+	remaining := []Target{}
+	for _, target := range targets {
+		if !excluded[target] {
+			remaining = append(remaining, target)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return nil, errors.New("Every target is excluded")
+	}
+
+	return remaining, nil
+}
+
+// initFlavors are the init systems that can be chosen with the
+// package:init target syntax.
+var initFlavors = []InitFlavor{
+	SystemD,
+	Upstart,
+	SysVInit,
+	LaunchD,
+	RCD,
+	WindowsService,
+	NoInit,
+}
+
+// withInit sets the init system of targets. Targets that don't support
+// it, such as the darwin half of tar:systemd, are dropped. It's an
+// error if none of them do.
+func withInit(targets []Target, input string) ([]Target, error) {
+	var init InitFlavor
+	for _, flavor := range initFlavors {
+		if string(flavor) == input {
+			init = flavor
+		}
+	}
+	if init == "" {
+		return nil, errors.Errorf("Unknown init: %s", input)
+	}
+
+	var validateErr error
+	initTargets := []Target{}
+	for _, target := range targets {
+		target.Init = init
+		if err := target.Validate(); err != nil {
+			validateErr = err
+			continue
+		}
+		initTargets = append(initTargets, target)
+	}
+
+	if len(initTargets) == 0 {
+		return nil, validateErr
+	}
+
+	return initTargets, nil
+}
+
+// InitFlavors returns the init systems that can be chosen with the
+// package:init target syntax.
+func InitFlavors() []InitFlavor {
+	return initFlavors
+}
+
+// ExpandArches multiplies targets by a comma separated list of
+// architectures. If no architectures are given, the targets are
+// returned as is, and will build for the default architecture.
+// Architectures named more than once are only built once.
+func ExpandArches(targets []Target, input string) ([]Target, error) {
+	if strings.TrimSpace(input) == "" {
+		return targets, nil
+	}
+
+	arches := []ArchFlavor{}
+	seen := make(map[ArchFlavor]bool)
+	for _, arch := range strings.Split(input, ",") {
+		switch a := ArchFlavor(strings.ToLower(strings.TrimSpace(arch))); a {
+		case Amd64, Arm64:
+			if !seen[a] {
+				seen[a] = true
+				arches = append(arches, a)
+			}
+		default:
+			return nil, errors.Errorf("Unknown arch: %s", arch)
+		}
+	}
+
+	archTargets := []Target{}
+	for _, target := range targets {
+		for _, arch := range arches {
+			target.Arch = arch
+			archTargets = append(archTargets, target)
+		}
+	}
+
+	return archTargets, nil
+}
+
+// UniversalTargets makes the darwin targets universal. Targets that
+// only differ by arch become a single one. Other platforms are left
+// as they are, but at least one target must be darwin.
+func UniversalTargets(targets []Target) ([]Target, error) {
+	universal := []Target{}
+	seen := make(map[Target]bool)
+	foundDarwin := false
+	for _, target := range targets {
+		if target.Platform == Darwin {
+			foundDarwin = true
+			target.Arch = Universal
+		}
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		universal = append(universal, target)
+	}
+
+	if !foundDarwin {
+		return nil, errors.New("universal requires a darwin target")
+	}
+	return universal, nil
+}
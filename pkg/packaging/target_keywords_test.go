@@ -0,0 +1,134 @@
+package packaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTargets(t *testing.T) {
+	t.Parallel()
+
+	targets, err := ParseTargets("", false)
+	require.NoError(t, err)
+	require.Equal(t, DefaultTargets(false), targets)
+
+	targets, err = ParseTargets("", true)
+	require.NoError(t, err)
+	require.Equal(t, DefaultTargets(true), targets)
+
+	targets, err = ParseTargets(" DEB, rpm,,", false)
+	require.NoError(t, err)
+	require.Equal(t, []Target{
+		{Platform: Linux, Init: SystemD, Package: Deb},
+		{Platform: Linux, Init: SystemD, Package: Rpm},
+	}, targets)
+
+	targets, err = ParseTargets("deb:sysvinit,tar:launchd", false)
+	require.NoError(t, err)
+	require.Equal(t, []Target{
+		{Platform: Linux, Init: SysVInit, Package: Deb},
+		{Platform: Darwin, Init: LaunchD, Package: Tar},
+	}, targets)
+
+	// Targets named more than once are built once, in the order
+	// they're first named
+	targets, err = ParseTargets("all,deb", false)
+	require.NoError(t, err)
+	require.Equal(t, DefaultTargets(false), targets)
+
+	targets, err = ParseTargets("rpm,windows,msi,deb,rpm", false)
+	require.NoError(t, err)
+	require.Equal(t, []Target{
+		{Platform: Linux, Init: SystemD, Package: Rpm},
+		{Platform: Windows, Init: WindowsService, Package: Msi},
+		{Platform: Linux, Init: SystemD, Package: Deb},
+	}, targets)
+
+	for _, bad := range []string{"plan9", "deb:bogus", "rpm:sysvinit", "all:systemd", "oci:systemd"} {
+		_, err := ParseTargets(bad, false)
+		require.Error(t, err, bad)
+	}
+
+	// Every keyword should be buildable
+	for _, keyword := range TargetKeywords() {
+		for _, target := range keyword.Targets {
+			require.NoError(t, target.Validate(), keyword.Name)
+		}
+	}
+}
+
+func TestExcludeTargets(t *testing.T) {
+	t.Parallel()
+
+	targets, err := ExcludeTargets(DefaultTargets(false), "", false)
+	require.NoError(t, err)
+	require.Equal(t, DefaultTargets(false), targets)
+
+	targets, err = ExcludeTargets(DefaultTargets(false), "darwin, DEB:upstart,", false)
+	require.NoError(t, err)
+	require.Equal(t, []Target{
+		{Platform: Linux, Init: SystemD, Package: Rpm},
+		{Platform: Linux, Init: SystemD, Package: Deb},
+	}, targets)
+
+	// Keywords with several targets only need one of them in the set
+	tars, err := ParseTargets("tar:systemd,rpm", false)
+	require.NoError(t, err)
+	targets, err = ExcludeTargets(tars, "tar", false)
+	require.NoError(t, err)
+	require.Equal(t, []Target{{Platform: Linux, Init: SystemD, Package: Rpm}}, targets)
+
+	for _, bad := range []string{"plan9", "msi", "deb:sysvinit", "all", "darwin,snap"} {
+		_, err := ExcludeTargets(DefaultTargets(false), bad, false)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestExpandArches(t *testing.T) {
+	t.Parallel()
+
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Deb}}
+
+	expanded, err := ExpandArches(targets, "")
+	require.NoError(t, err)
+	require.Equal(t, targets, expanded)
+
+	expanded, err = ExpandArches(targets, "amd64, ARM64")
+	require.NoError(t, err)
+	require.Equal(t, []Target{
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: Amd64},
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: Arm64},
+	}, expanded)
+
+	// Repeats are only built once
+	expanded, err = ExpandArches(targets, "amd64,arm64,AMD64")
+	require.NoError(t, err)
+	require.Equal(t, []Target{
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: Amd64},
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: Arm64},
+	}, expanded)
+
+	_, err = ExpandArches(targets, "sparc")
+	require.Error(t, err)
+}
+
+func TestUniversalTargets(t *testing.T) {
+	t.Parallel()
+
+	targets := []Target{
+		{Platform: Darwin, Init: LaunchD, Package: Pkg, Arch: Amd64},
+		{Platform: Darwin, Init: LaunchD, Package: Pkg, Arch: Arm64},
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: Arm64},
+	}
+
+	universal, err := UniversalTargets(targets)
+	require.NoError(t, err)
+	require.Equal(t, []Target{
+		{Platform: Darwin, Init: LaunchD, Package: Pkg, Arch: Universal},
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: Arm64},
+	}, universal)
+
+	_, err = UniversalTargets(targets[2:])
+	require.Error(t, err)
+}
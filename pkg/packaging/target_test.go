@@ -0,0 +1,78 @@
+package packaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPkgExtension(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		in  Target
+		out string
+	}{
+		{in: Target{Platform: Linux, Init: SystemD, Package: Deb}, out: "deb"},
+		{in: Target{Platform: Linux, Init: SystemD, Package: Pacman}, out: "pkg.tar.zst"},
+		{in: Target{Platform: Windows, Init: WindowsService, Package: Msi}, out: "msi"},
+		{in: Target{Platform: Darwin, Init: LaunchD, Package: Tar}, out: "tar.gz"},
+		{in: Target{Platform: Windows, Init: WindowsService, Package: Chocolatey}, out: "nupkg"},
+		{in: Target{Platform: FreeBSD, Init: RCD, Package: FreeBSDPkg}, out: "pkg"},
+		{in: Target{Platform: Linux, Init: SystemD, Package: Snap}, out: "snap"},
+		{in: Target{Platform: Linux, Init: NoInit, Package: OCI}, out: "oci.tar"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.out, tt.in.PkgExtension())
+	}
+}
+
+func TestTargetString(t *testing.T) {
+	t.Parallel()
+
+	target := Target{Platform: Linux, Init: SystemD, Package: Deb}
+	require.Equal(t, "linux-systemd-deb", target.String())
+	require.Equal(t, Amd64, target.GetArch())
+
+	target.Arch = Arm64
+	require.Equal(t, "linux-systemd-deb-arm64", target.String())
+	require.Equal(t, ArchFlavor(Arm64), target.GetArch())
+
+	target = Target{Platform: Darwin, Init: LaunchD, Package: Pkg, Arch: Universal}
+	require.Equal(t, "darwin-launchd-pkg-universal", target.String())
+	require.NoError(t, target.Validate())
+}
+
+func TestTargetValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, target := range append(testedTargets(), Target{Platform: Linux, Init: SystemD, Package: Snap, Arch: Arm64}, Target{Platform: Linux, Init: NoInit, Package: OCI, Arch: Arm64}) {
+		require.NoError(t, target.Validate(), target.String())
+	}
+
+	var invalid = []Target{
+		{Platform: Darwin, Init: SystemD, Package: Pkg},
+		{Platform: Linux, Init: SystemD, Package: Pkg},
+		{Platform: Windows, Init: LaunchD, Package: Msi},
+		{Platform: "plan9", Init: NoInit, Package: Tar},
+		{Platform: Windows, Init: WindowsService, Package: Msi, Arch: Arm64},
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: Universal},
+		{Platform: Windows, Init: WindowsService, Package: Tar},
+		{Platform: Linux, Init: SystemD, Package: Deb, Arch: "sparc"},
+		{Platform: Linux, Init: SysVInit, Package: Rpm},
+		{Platform: Darwin, Init: SysVInit, Package: Tar},
+		{Platform: FreeBSD, Init: SystemD, Package: FreeBSDPkg},
+		{Platform: FreeBSD, Init: RCD, Package: Pkg},
+		{Platform: Linux, Init: RCD, Package: Deb},
+		{Platform: Linux, Init: Upstart, Package: Snap},
+		{Platform: Linux, Init: NoInit, Package: Snap},
+		{Platform: Darwin, Init: LaunchD, Package: Snap},
+		{Platform: Linux, Init: SystemD, Package: OCI},
+		{Platform: Darwin, Init: NoInit, Package: OCI},
+	}
+
+	for _, target := range invalid {
+		require.Error(t, target.Validate(), target.String())
+	}
+}
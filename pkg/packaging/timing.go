@@ -0,0 +1,49 @@
+package packaging
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// PhaseTiming is how long one phase of a target's build took.
+type PhaseTiming struct {
+	Phase    string        `json:"phase"`
+	Duration time.Duration `json:"duration"`
+}
+
+// recordPhase records that phase, started at start, has finished. It's
+// meant to be deferred, or called as the phase ends.
+func (p *PackageOptions) recordPhase(phase string, start time.Time) {
+	p.timings = append(p.timings, PhaseTiming{Phase: phase, Duration: time.Since(start)})
+}
+
+// WriteTimingReport writes a breakdown of where each result's build
+// spent its time, for results built WithTimings. Phases that aren't
+// timed, like validation and checksums, are counted in the total.
+func WriteTimingReport(w io.Writer, results []BuildResult) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "TARGET\tPHASE\tDURATION\n")
+	for _, result := range results {
+		target := result.Target
+		if result.Tenant != "" {
+			target = fmt.Sprintf("%s (%s)", result.Target, result.Tenant)
+		}
+		for _, timing := range result.Timings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", target, timing.Phase, roundDuration(timing.Duration))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", target, "total", roundDuration(result.Duration))
+	}
+	return tw.Flush()
+}
+
+// roundDuration rounds d to milliseconds, which is plenty for builds.
+// Shorter phases, eg: copying a local binary, are rounded to
+// microseconds, so they don't show as 0s.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
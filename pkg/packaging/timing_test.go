@@ -0,0 +1,66 @@
+package packaging
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTimings(t *testing.T) {
+	t.Parallel()
+
+	binDir, err := ioutil.TempDir("", "packaging-timing-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+
+	outputDir, err := ioutil.TempDir("", "packaging-timing-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	for _, name := range []string{"osqueryd", "launcher", "osquery-extension.ext"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, name), []byte(name), 0755))
+	}
+
+	po := PackageOptions{
+		PackageVersion: "1.2.3",
+		LocalBuildDir:  binDir,
+		Hostname:       "device.example.com:443",
+		Identifier:     "kolide-app",
+		Secret:         "secret",
+	}
+	targets := []Target{{Platform: Linux, Init: SystemD, Package: Tar}}
+
+	// Without WithTimings, results don't have any
+	results, err := BuildAll(context.TODO(), po, targets, outputDir)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Empty(t, results[0].Timings)
+	require.Zero(t, results[0].Duration)
+
+	results, err = BuildAll(context.TODO(), po, targets, outputDir, WithTimings())
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	phases := []string{}
+	var phasesTotal time.Duration
+	for _, timing := range results[0].Timings {
+		phases = append(phases, timing.Phase)
+		phasesTotal += timing.Duration
+	}
+	require.Equal(t, []string{"fetch osqueryd", "fetch launcher", "fetch osquery-extension.ext", "scripts", "packaging"}, phases)
+	require.True(t, results[0].Duration >= phasesTotal)
+
+	var report bytes.Buffer
+	require.NoError(t, WriteTimingReport(&report, results))
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	require.Len(t, lines, 1+len(phases)+1)
+	require.Contains(t, lines[1], "fetch osqueryd")
+	require.Contains(t, lines[len(lines)-1], "total")
+}
@@ -0,0 +1,304 @@
+package packaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+const (
+	defaultNotaryURL = "https://notary.kolide.co"
+	defaultMirrorURL = "https://dl.kolide.co"
+)
+
+// targetMeta is the file integrity metadata TUF publishes for each
+// target.
+type targetMeta struct {
+	Hashes map[string]string `json:"hashes"`
+	Length int64             `json:"length"`
+}
+
+// tufTargets is the subset of a TUF targets role we need. See
+// https://github.com/theupdateframework/specification
+type tufTargets struct {
+	Signed struct {
+		Targets     map[string]targetMeta `json:"targets"`
+		Delegations struct {
+			Roles []struct {
+				Name string `json:"name"`
+			} `json:"roles"`
+		} `json:"delegations"`
+	} `json:"signed"`
+}
+
+// fetchTargetMeta looks up the TUF metadata for targetName. It checks
+// the top level targets role, and then each of its delegations.
+//
+// Note that, unless root is set, this does not verify the signatures
+// on the TUF metadata. It's used to check the integrity of downloads
+// and cached files, the authenticity checks happen in the launcher's
+// autoupdater.
+func fetchTargetMeta(ctx context.Context, client *http.Client, notaryURL string, root *TUFRoot, gun, targetName string) (*targetMeta, error) {
+	var found *targetMeta
+	if err := walkTufTargets(ctx, client, notaryURL, gun, root, func(name string, meta targetMeta) bool {
+		if name == targetName {
+			found = &meta
+			return true
+		}
+		return false
+	}); err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, errors.Errorf("no TUF target %s in %s", targetName, gun)
+	}
+	return found, nil
+}
+
+// fetchTargetMetaByHash looks for the target in dir whose sha256 is
+// hexHash, and returns its name and metadata. Channels and versions
+// often point at the same file, so if several match, the first by
+// name is used.
+func fetchTargetMetaByHash(ctx context.Context, client *http.Client, notaryURL string, root *TUFRoot, gun, dir, hexHash string) (string, *targetMeta, error) {
+	sum, err := hex.DecodeString(hexHash)
+	if err != nil || len(sum) != sha256.Size {
+		return "", nil, errors.Errorf("invalid sha256 %s", hexHash)
+	}
+	expected := base64.StdEncoding.EncodeToString(sum)
+
+	matches := map[string]targetMeta{}
+	if err := walkTufTargets(ctx, client, notaryURL, gun, root, func(name string, meta targetMeta) bool {
+		if path.Dir(name) == dir && meta.Hashes["sha256"] == expected {
+			matches[name] = meta
+		}
+		return false
+	}); err != nil {
+		return "", nil, err
+	}
+
+	if len(matches) == 0 {
+		return "", nil, errors.Errorf("no TUF target in %s/%s with sha256 %s", gun, dir, hexHash)
+	}
+
+	names := []string{}
+	for name := range matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	meta := matches[names[0]]
+	return names[0], &meta, nil
+}
+
+// commitRegexp matches an abbreviated, or full, git commit SHA.
+var commitRegexp = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// commitVersionRegexp matches versions from git describe, eg:
+// 0.11.4-3-gabcdef0. The last group is the commit.
+var commitVersionRegexp = regexp.MustCompile(`-g([0-9a-f]{7,40})$`)
+
+// versionCommit returns the git commit a version was built from, if it
+// names one. That's either the describe suffix, or a dev build
+// versioned by its bare commit SHA.
+func versionCommit(version string) string {
+	if m := commitVersionRegexp.FindStringSubmatch(version); m != nil {
+		return m[1]
+	}
+	if commitRegexp.MatchString(version) {
+		return version
+	}
+	return ""
+}
+
+// fetchTargetMetaByCommit looks for the build of commit in dir, and
+// returns its name and metadata. commit may be abbreviated, as long as
+// it only matches one build. Several names for the same file, such as
+// a dev channel and its version, count as one build.
+func fetchTargetMetaByCommit(ctx context.Context, client *http.Client, notaryURL string, root *TUFRoot, gun, dir, baseName, commit string) (string, *targetMeta, error) {
+	commit = strings.ToLower(commit)
+	if !commitRegexp.MatchString(commit) {
+		return "", nil, errors.Errorf("invalid commit %s. Use at least 7 hex characters of the SHA", commit)
+	}
+
+	matches := map[string]targetMeta{}
+	if err := walkTufTargets(ctx, client, notaryURL, gun, root, func(name string, meta targetMeta) bool {
+		if path.Dir(name) != dir || !strings.HasPrefix(path.Base(name), baseName+"-") {
+			return false
+		}
+		if strings.HasPrefix(versionCommit(targetVersion(baseName, name)), commit) {
+			matches[name] = meta
+		}
+		return false
+	}); err != nil {
+		return "", nil, err
+	}
+
+	if len(matches) == 0 {
+		return "", nil, errors.Errorf("no build of commit %s in %s/%s. Only commits that have been built, and published to notary, can be packaged", commit, gun, dir)
+	}
+
+	names := []string{}
+	for name := range matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	meta := matches[names[0]]
+	for _, name := range names[1:] {
+		if matches[name].Hashes["sha256"] != meta.Hashes["sha256"] {
+			return "", nil, errors.Errorf("commit %s matches several builds in %s/%s: %s. Use more of the SHA", commit, gun, dir, strings.Join(names, ", "))
+		}
+	}
+	return names[0], &meta, nil
+}
+
+// walkTufTargets calls fn for each target in the top level targets
+// role, and then each of its delegations. It stops early if fn
+// returns true.
+//
+// If root is set, each role is verified before it's used: the top
+// level targets with the keys from gun's root, which must be signed by
+// root, and delegations with the keys, and paths, their parent gives
+// them.
+func walkTufTargets(ctx context.Context, client *http.Client, notaryURL, gun string, root *TUFRoot, fn func(name string, meta targetMeta) bool) error {
+	roles := []string{"targets"}
+	var verifiedRoles []data.DelegationRole
+	if root != nil {
+		targetsRole, err := root.targetsRole(ctx, client, notaryURL, gun)
+		if err != nil {
+			return err
+		}
+		verifiedRoles = append(verifiedRoles, targetsRole)
+	}
+
+	for i := 0; i < len(roles); i++ {
+		contents, err := fetchTufMetadata(ctx, client, notaryURL, gun, roles[i])
+		if err != nil {
+			return errors.Wrapf(err, "fetching TUF role %s for %s", roles[i], gun)
+		}
+
+		var targets tufTargets
+		if err := json.Unmarshal(contents, &targets); err != nil {
+			return errors.Wrapf(err, "decoding TUF role %s for %s", roles[i], gun)
+		}
+
+		if root == nil {
+			for _, delegation := range targets.Signed.Delegations.Roles {
+				roles = append(roles, delegation.Name)
+			}
+		} else {
+			role := verifiedRoles[i]
+			verified, err := verifyTargets(contents, role)
+			if err != nil {
+				return errors.Wrapf(err, "verifying TUF role %s for %s", roles[i], gun)
+			}
+
+			// Only trust what this role may sign
+			for name := range targets.Signed.Targets {
+				if !role.CheckPaths(name) {
+					delete(targets.Signed.Targets, name)
+				}
+			}
+
+			for _, delegation := range verified.GetValidDelegations(role) {
+				roles = append(roles, delegation.Name.String())
+				verifiedRoles = append(verifiedRoles, delegation)
+			}
+		}
+
+		for name, meta := range targets.Signed.Targets {
+			if fn(name, meta) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchTufMetadata fetches the TUF metadata for a role, eg: root, or
+// targets/releases.
+func fetchTufMetadata(ctx context.Context, client *http.Client, notaryURL, gun, role string) ([]byte, error) {
+	url := fmt.Sprintf("%s/v2/%s/_trust/tuf/%s.json", strings.TrimSuffix(notaryURL, "/"), gun, role)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+	req = req.WithContext(ctx)
+
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, transientError{errors.Wrap(err, "fetching TUF metadata")}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		err := errors.Errorf("fetching TUF metadata. Got http status %s", response.Status)
+		if response.StatusCode >= 500 {
+			return nil, transientError{err}
+		}
+		return nil, err
+	}
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, transientError{errors.Wrap(err, "reading TUF metadata")}
+	}
+	return contents, nil
+}
+
+// sha256Hex returns the TUF sha256 as hex, as sha256sum, and most
+// people, write it.
+func (m *targetMeta) sha256Hex() string {
+	sum, err := base64.StdEncoding.DecodeString(m.Hashes["sha256"])
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(sum)
+}
+
+// verify checks that the file at path matches the metadata.
+func (m *targetMeta) verify(path string) error {
+	expected, ok := m.Hashes["sha256"]
+	if !ok {
+		return errors.New("TUF metadata has no sha256 hash")
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening file to verify")
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	length, err := io.Copy(h, fh)
+	if err != nil {
+		return errors.Wrap(err, "hashing file")
+	}
+
+	if length != m.Length {
+		return errors.Errorf("length mismatch for %s, expected %d got %d", path, m.Length, length)
+	}
+
+	if actual := h.Sum(nil); base64.StdEncoding.EncodeToString(actual) != expected {
+		return errors.Errorf("hash mismatch for %s, expected sha256 %s got %s", path, m.sha256Hex(), hex.EncodeToString(actual))
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package packaging
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/signed"
+)
+
+// TUFRoot is trusted TUF root metadata, for notary servers other than
+// Kolide's. See WithTUFRoot.
+type TUFRoot struct {
+	rootRole data.BaseRole // the keys trusted to sign each GUN's root
+}
+
+// ReadTUFRoot reads TUF root metadata, such as a root.json from a
+// notary server's TUF repository. See ParseTUFRoot.
+func ReadTUFRoot(path string) (*TUFRoot, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading TUF root %s", path)
+	}
+
+	root, err := ParseTUFRoot(contents)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid TUF root %s", path)
+	}
+	return root, nil
+}
+
+// ParseTUFRoot parses TUF root metadata. It must be well formed,
+// unexpired, and signed by its own root keys.
+func ParseTUFRoot(contents []byte) (*TUFRoot, error) {
+	root, err := verifyRoot(contents, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rootRole, err := root.BuildBaseRole(data.CanonicalRootRole)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading root role")
+	}
+	return &TUFRoot{rootRole: rootRole}, nil
+}
+
+// verifyRoot parses root metadata, and checks it's signed by trusted,
+// or, if that's nil, by its own root keys.
+func verifyRoot(contents []byte, trusted *data.BaseRole) (*data.SignedRoot, error) {
+	var s data.Signed
+	if err := json.Unmarshal(contents, &s); err != nil {
+		return nil, errors.Wrap(err, "decoding TUF root")
+	}
+
+	root, err := data.RootFromSigned(&s)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing TUF root")
+	}
+
+	if trusted == nil {
+		rootRole, err := root.BuildBaseRole(data.CanonicalRootRole)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading root role")
+		}
+		trusted = &rootRole
+	}
+
+	if err := signed.VerifySignatures(&s, *trusted); err != nil {
+		return nil, errors.Wrap(err, "verifying TUF root signatures")
+	}
+	if err := signed.VerifyExpiry(&root.Signed.SignedCommon, data.CanonicalRootRole); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// targetsRole fetches gun's root metadata, checks it's signed by the
+// trusted root keys, and returns the role whose keys sign its targets.
+func (r *TUFRoot) targetsRole(ctx context.Context, client *http.Client, notaryURL, gun string) (data.DelegationRole, error) {
+	contents, err := fetchTufMetadata(ctx, client, notaryURL, gun, data.CanonicalRootRole.String())
+	if err != nil {
+		return data.DelegationRole{}, errors.Wrapf(err, "fetching TUF root for %s", gun)
+	}
+
+	root, err := verifyRoot(contents, &r.rootRole)
+	if err != nil {
+		return data.DelegationRole{}, errors.Wrapf(err, "TUF root for %s doesn't match the trusted root", gun)
+	}
+
+	targetsRole, err := root.BuildBaseRole(data.CanonicalTargetsRole)
+	if err != nil {
+		return data.DelegationRole{}, errors.Wrapf(err, "reading targets role for %s", gun)
+	}
+
+	// The top level targets role may sign any path
+	return data.DelegationRole{BaseRole: targetsRole, Paths: []string{""}}, nil
+}
+
+// verifyTargets checks that targets metadata is signed by role, and
+// unexpired.
+func verifyTargets(contents []byte, role data.DelegationRole) (*data.SignedTargets, error) {
+	var s data.Signed
+	if err := json.Unmarshal(contents, &s); err != nil {
+		return nil, errors.Wrap(err, "decoding TUF metadata")
+	}
+
+	if err := signed.VerifySignatures(&s, role.BaseRole); err != nil {
+		return nil, errors.Wrap(err, "verifying TUF signatures")
+	}
+
+	targets, err := data.TargetsFromSigned(&s, role.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing TUF targets")
+	}
+	if err := signed.VerifyExpiry(&targets.Signed.SignedCommon, role.Name); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
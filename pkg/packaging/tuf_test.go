@@ -0,0 +1,172 @@
+package packaging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// tufSigner signs a fake notary's TUF metadata, as a self-hosted
+// notary would: a root, the top level targets, and a targets/releases
+// delegation.
+type tufSigner struct {
+	rootKey     data.PrivateKey
+	targetsKey  data.PrivateKey
+	releasesKey data.PrivateKey
+	root        []byte
+}
+
+func newTUFSigner(t *testing.T) *tufSigner {
+	s := &tufSigner{}
+	for _, key := range []*data.PrivateKey{&s.rootKey, &s.targetsKey, &s.releasesKey} {
+		var err error
+		*key, err = utils.GenerateED25519Key(rand.Reader)
+		require.NoError(t, err)
+	}
+	var err error
+	s.root, err = s.signRoot(s.rootKey)
+	require.NoError(t, err)
+	return s
+}
+
+// signRoot returns root metadata trusting the signer's targets key,
+// signed by signingKey.
+func (s *tufSigner) signRoot(signingKey data.PrivateKey) ([]byte, error) {
+	rootPub := data.PublicKeyFromPrivate(s.rootKey)
+	targetsPub := data.PublicKeyFromPrivate(s.targetsKey)
+	keys := map[string]data.PublicKey{rootPub.ID(): rootPub, targetsPub.ID(): targetsPub}
+	roles := map[data.RoleName]*data.RootRole{
+		data.CanonicalRootRole:      {KeyIDs: []string{rootPub.ID()}, Threshold: 1},
+		data.CanonicalTargetsRole:   {KeyIDs: []string{targetsPub.ID()}, Threshold: 1},
+		data.CanonicalSnapshotRole:  {KeyIDs: []string{targetsPub.ID()}, Threshold: 1},
+		data.CanonicalTimestampRole: {KeyIDs: []string{targetsPub.ID()}, Threshold: 1},
+	}
+	root, err := data.NewRoot(keys, roles, false)
+	if err != nil {
+		return nil, err
+	}
+	root.Signed.Version = 1
+	signed, err := root.ToSigned()
+	if err != nil {
+		return nil, err
+	}
+	return sign(signed, signingKey)
+}
+
+// signTargets returns the targets metadata for role. The top level
+// targets delegates to targets/releases.
+func (s *tufSigner) signTargets(role data.RoleName, files data.Files) ([]byte, error) {
+	targets := data.NewTargets()
+	targets.Signed.Version = 1
+	targets.Signed.Targets = files
+
+	key := s.releasesKey
+	if role == data.CanonicalTargetsRole {
+		key = s.targetsKey
+		releasesPub := data.PublicKeyFromPrivate(s.releasesKey)
+		releases, err := data.NewRole("targets/releases", 1, []string{releasesPub.ID()}, []string{""})
+		if err != nil {
+			return nil, err
+		}
+		targets.Signed.Delegations.Keys = data.Keys{releasesPub.ID(): releasesPub}
+		targets.Signed.Delegations.Roles = []*data.Role{releases}
+	}
+
+	signed, err := targets.ToSigned()
+	if err != nil {
+		return nil, err
+	}
+	return sign(signed, key)
+}
+
+func sign(signed *data.Signed, key data.PrivateKey) ([]byte, error) {
+	sig, err := key.Sign(rand.Reader, *signed.Signed, nil)
+	if err != nil {
+		return nil, err
+	}
+	signed.Signatures = append(signed.Signatures, data.Signature{
+		KeyID:     data.PublicKeyFromPrivate(key).ID(),
+		Method:    key.SignatureAlgorithm(),
+		Signature: sig,
+	})
+	return json.Marshal(signed)
+}
+
+func TestParseTUFRoot(t *testing.T) {
+	t.Parallel()
+
+	signer := newTUFSigner(t)
+	_, err := ParseTUFRoot(signer.root)
+	require.NoError(t, err)
+
+	// It must be signed by its own root key
+	misSigned, err := signer.signRoot(signer.targetsKey)
+	require.NoError(t, err)
+	_, err = ParseTUFRoot(misSigned)
+	require.Error(t, err)
+
+	for _, bad := range []string{``, `{}`, `{"signed":{"_type":"Targets"},"signatures":[]}`} {
+		_, err = ParseTUFRoot([]byte(bad))
+		require.Error(t, err, bad)
+	}
+
+	dir, err := ioutil.TempDir("", "packaging-tuf-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "root.json")
+	require.NoError(t, ioutil.WriteFile(path, signer.root, 0644))
+	_, err = ReadTUFRoot(path)
+	require.NoError(t, err)
+	_, err = ReadTUFRoot(filepath.Join(dir, "missing.json"))
+	require.Error(t, err)
+}
+
+func TestFetchBinaryTUFRoot(t *testing.T) {
+	t.Parallel()
+
+	release := &fakeRelease{signer: newTUFSigner(t)}
+	release.setRelease(t, "osqueryd v1")
+
+	notary := httptest.NewServer(http.HandlerFunc(release.notary))
+	defer notary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(release.mirror))
+	defer mirror.Close()
+
+	cacheDir, err := ioutil.TempDir("", "packaging-fetch-tuf-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fetch := func(root []byte) (string, error) {
+		tufRoot, err := ParseTUFRoot(root)
+		require.NoError(t, err)
+		return FetchBinary(context.TODO(), cacheDir, "osqueryd", "stable", "linux", "",
+			WithNotaryURL(notary.URL), WithMirrorURL(mirror.URL), WithTUFRoot(tufRoot), WithRefreshCache())
+	}
+
+	// The notary's own root verifies
+	_, err = fetch(release.signer.root)
+	require.NoError(t, err)
+
+	// Anyone else's doesn't
+	_, err = fetch(newTUFSigner(t).root)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't match the trusted root")
+
+	// Nor do targets signed by the wrong key
+	release.mu.Lock()
+	release.signer.releasesKey, release.signer.targetsKey = release.signer.targetsKey, release.signer.releasesKey
+	release.mu.Unlock()
+	_, err = fetch(release.signer.root)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "verifying TUF role targets")
+}
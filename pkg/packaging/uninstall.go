@@ -0,0 +1,219 @@
+package packaging
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/kolide/launcher/pkg/packagekit"
+	"github.com/pkg/errors"
+)
+
+// uninstallScriptName is the script darwin pkgs install, alongside the
+// binaries, to uninstall them, as macOS has no uninstall of its own.
+const uninstallScriptName = "uninstall-launcher"
+
+// removalChecks are the shell tests for whether a linux package's
+// remove scripts are being run for its removal, rather than an
+// upgrade, and for a purge, which also removes its config and data.
+// rpms and pacman have no purge, so a removal is one.
+type removalChecks struct {
+	removing string
+	purging  string
+}
+
+var packageRemovalChecks = map[PackageFlavor]removalChecks{
+	Deb:    {removing: `[ "$1" = remove ] || [ "$1" = purge ]`, purging: `[ "$1" = purge ]`},
+	Rpm:    {removing: `[ "$1" = 0 ]`, purging: `[ "$1" = 0 ]`},
+	Pacman: {removing: "true", purging: "true"},
+}
+
+// removalScriptData is what the prerm, and postrm, templates need.
+// Paths are escaped for double quotes.
+type removalScriptData struct {
+	Identifier string
+	Init       InitFlavor
+	Removing   string
+	Purging    string
+	DataDir    string
+	ConfDir    string
+	Overrides  []string // dpkg-statoverride paths, from setupPreinst
+}
+
+// removalScriptData returns the data for target's remove scripts, and
+// whether it has them. Without an identifier, the directories aren't
+// the identifier's alone, so there's nothing safe to purge.
+func (p *PackageOptions) removalScriptData() (removalScriptData, bool, error) {
+	checks, ok := packageRemovalChecks[p.target.Package]
+	if !ok || p.target.Platform != Linux || p.Identifier == "" {
+		return removalScriptData{}, false, nil
+	}
+
+	data := removalScriptData{
+		Identifier: p.Identifier,
+		Init:       p.target.Init,
+		Removing:   checks.removing,
+		Purging:    checks.purging,
+		DataDir:    packagekit.ShellEscape(p.installedPath(p.dataDir())),
+		ConfDir:    packagekit.ShellEscape(p.installedPath(p.confDir)),
+	}
+
+	if p.target.Package == Deb {
+		owners, err := p.fileOwners()
+		if err != nil {
+			return removalScriptData{}, false, err
+		}
+		for _, owner := range owners {
+			data.Overrides = append(data.Overrides, packagekit.ShellEscape(owner.Path))
+		}
+	}
+
+	return data, true, nil
+}
+
+// dataDir is the identifier's directory of launcher root directories.
+// They're sharded by hostname, so it may have several.
+func (p *PackageOptions) dataDir() string {
+	return filepath.Dir(p.rootDir)
+}
+
+// setupPrerm stops, and disables, the service before a linux package
+// is removed. Upgrades leave it running, for the postinstall to
+// restart.
+func (p *PackageOptions) setupPrerm(ctx context.Context) error {
+	data, ok, err := p.removalScriptData()
+	if err != nil || !ok {
+		return err
+	}
+
+	switch p.target.Init {
+	case SystemD, Upstart, SysVInit:
+	default:
+		return nil
+	}
+
+	return renderScript(filepath.Join(p.scriptRoot, "prerm"), prermTemplate(), data)
+}
+
+// setupPostrm cleans up after a linux package is removed. Purges also
+// remove the identifier's config, including the enroll secret, and its
+// launcher root directories, which the package manager doesn't know
+// about, so a reinstall, eg: with another identifier, starts clean.
+// The run as user is left, as its files may be elsewhere too.
+func (p *PackageOptions) setupPostrm(ctx context.Context) error {
+	data, ok, err := p.removalScriptData()
+	if err != nil || !ok {
+		return err
+	}
+
+	return renderScript(filepath.Join(p.scriptRoot, "postremove"), postrmTemplate(), data)
+}
+
+// setupUninstallScript adds an uninstall script to darwin pkgs. It
+// unloads the launchd daemon, and removes everything the pkg
+// installed, or launcher created, for the identifier.
+func (p *PackageOptions) setupUninstallScript() error {
+	if p.target.Platform != Darwin || p.target.Package != Pkg || p.Identifier == "" {
+		return nil
+	}
+
+	var data = struct {
+		Identifier  string
+		PlistPath   string
+		InstallDir  string
+		DataDir     string
+		ConfDir     string
+		LogDir      string
+		SyslogPath  string
+		PackageName string
+	}{
+		Identifier:  p.Identifier,
+		PlistPath:   packagekit.ShellEscape(p.initFile),
+		InstallDir:  packagekit.ShellEscape(filepath.Dir(p.binDir)),
+		DataDir:     packagekit.ShellEscape(p.dataDir()),
+		ConfDir:     packagekit.ShellEscape(p.confDir),
+		LogDir:      packagekit.ShellEscape(filepath.Join("/var/log", p.Identifier)),
+		SyslogPath:  packagekit.ShellEscape(filepath.Join("/etc/newsyslog.d", p.Identifier+".conf")),
+		PackageName: "com." + p.Identifier + ".launcher",
+	}
+
+	return renderScript(filepath.Join(p.packageRoot, p.binDir, uninstallScriptName), uninstallDarwinTemplate(), data)
+}
+
+// renderScript renders a script template to path, executable.
+func renderScript(path, scriptTemplate string, data interface{}) error {
+	name := filepath.Base(path)
+
+	t, err := template.New(name).Parse(scriptTemplate)
+	if err != nil {
+		return errors.Wrapf(err, "not able to parse %s template", name)
+	}
+
+	fh, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "create %s filehandle", name)
+	}
+	defer fh.Close()
+
+	if err := os.Chmod(path, 0755); err != nil {
+		return errors.Wrapf(err, "chmod %s", name)
+	}
+
+	if err := t.Execute(fh, data); err != nil {
+		return errors.Wrapf(err, "executing %s template", name)
+	}
+
+	return nil
+}
+
+// prermTemplate stops the service. It may already be stopped, so
+// failures are ignored.
+func prermTemplate() string {
+	return `#!/bin/sh
+set -e
+if {{.Removing}}; then
+{{- if eq .Init "systemd" }}
+  systemctl stop launcher.{{.Identifier}} || true
+  systemctl disable launcher.{{.Identifier}} || true
+{{- else if eq .Init "upstart" }}
+  stop launcher-{{.Identifier}} || true
+{{- else if eq .Init "sysvinit" }}
+  service launcher.{{.Identifier}} stop || true
+{{- end }}
+fi
+`
+}
+
+func postrmTemplate() string {
+	return `#!/bin/sh
+set -e
+{{- if eq .Init "systemd" }}
+if {{.Removing}}; then
+  systemctl daemon-reload || true
+fi
+{{- end }}
+if {{.Purging}}; then
+{{- if eq .Init "sysvinit" }}
+  update-rc.d -f launcher.{{.Identifier}} remove >/dev/null || true
+{{- end }}
+{{- range .Overrides }}
+  if dpkg-statoverride --list "{{.}}" >/dev/null; then dpkg-statoverride --remove "{{.}}"; fi
+{{- end }}
+  rm -rf "{{.DataDir}}" "{{.ConfDir}}"
+fi
+`
+}
+
+func uninstallDarwinTemplate() string {
+	return `#!/bin/sh
+# Uninstalls launcher for {{.Identifier}}, and removes its config,
+# enroll secret, data, and logs. Run it as root.
+set -e
+/bin/launchctl unload "{{.PlistPath}}" 2>/dev/null || true
+rm -f "{{.PlistPath}}" "{{.SyslogPath}}"
+rm -rf "{{.DataDir}}" "{{.ConfDir}}" "{{.LogDir}}" "{{.InstallDir}}"
+/usr/sbin/pkgutil --forget {{.PackageName}} >/dev/null 2>&1 || true
+echo "Uninstalled launcher for {{.Identifier}}"
+`
+}
@@ -0,0 +1,161 @@
+package packaging
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupRemoveScripts(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "test-packaging-remove")
+	require.NoError(t, err)
+	defer os.RemoveAll(testDir)
+
+	// Fakes of the tools the scripts run, which log their arguments
+	binDir := filepath.Join(testDir, "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	toolLog := filepath.Join(testDir, "tools.log")
+	for _, tool := range []string{"systemctl", "dpkg-statoverride"} {
+		fake := "#!/bin/sh\necho " + tool + " \"$@\" >> " + toolLog + "\n"
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, tool), []byte(fake), 0755))
+	}
+
+	runScript := func(script string, arg string) string {
+		require.NoError(t, os.RemoveAll(toolLog))
+		cmd := exec.Command("/bin/sh", script, arg)
+		cmd.Env = []string{"PATH=" + binDir + ":/usr/bin:/bin"}
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		tools, _ := ioutil.ReadFile(toolLog)
+		return string(tools)
+	}
+
+	for _, tt := range []struct {
+		pkg      PackageFlavor
+		upgrade  string
+		removal  string
+		purge    string
+		override bool
+	}{
+		{pkg: Deb, upgrade: "upgrade", removal: "remove", purge: "purge", override: true},
+		{pkg: Rpm, upgrade: "1", removal: "0", purge: "0"},
+	} {
+		scriptDir := filepath.Join(testDir, string(tt.pkg), "scripts")
+		require.NoError(t, os.MkdirAll(scriptDir, 0755))
+
+		// What the package, and launcher, would have created. The
+		// package's own files are removed by the package manager.
+		installRoot := filepath.Join(testDir, string(tt.pkg), "installed")
+		p := &PackageOptions{
+			target:      Target{Platform: Linux, Init: SystemD, Package: tt.pkg},
+			Identifier:  "test",
+			RunAsUser:   "kolide",
+			scriptRoot:  scriptDir,
+			packageRoot: "/",
+			rootDir:     filepath.Join(installRoot, "var", "test", "device.kolide.com-443"),
+			confDir:     filepath.Join(installRoot, "etc", "test"),
+		}
+		created := []string{
+			filepath.Join(installRoot, "var", "test", "device.kolide.com-443", "launcher.db"),
+			filepath.Join(installRoot, "var", "test", "failover.kolide.com-443", "launcher.db"),
+			filepath.Join(installRoot, "etc", "test", "secret"),
+		}
+		for _, path := range created {
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, ioutil.WriteFile(path, []byte("test"), 0600))
+		}
+
+		require.NoError(t, p.setupPrerm(context.TODO()))
+		require.NoError(t, p.setupPostrm(context.TODO()))
+		prerm := filepath.Join(scriptDir, "prerm")
+		postremove := filepath.Join(scriptDir, "postremove")
+
+		// Upgrades leave the service, and everything else, alone
+		require.Empty(t, runScript(prerm, tt.upgrade))
+		require.Empty(t, runScript(postremove, tt.upgrade))
+
+		// Removal stops the service
+		require.Equal(t, "systemctl stop launcher.test\nsystemctl disable launcher.test\n", runScript(prerm, tt.removal))
+
+		// debs keep their config, and data, until they're purged
+		if tt.removal != tt.purge {
+			require.Equal(t, "systemctl daemon-reload\n", runScript(postremove, tt.removal))
+			for _, path := range created {
+				_, err := os.Stat(path)
+				require.NoError(t, err, path)
+			}
+		}
+
+		tools := runScript(postremove, tt.purge)
+		require.Contains(t, tools, "systemctl daemon-reload")
+		if tt.override {
+			require.Contains(t, tools, "dpkg-statoverride --remove "+p.confDir)
+			require.Contains(t, tools, "dpkg-statoverride --remove "+p.rootDir)
+		} else {
+			require.NotContains(t, tools, "dpkg-statoverride")
+		}
+
+		// Everything is gone, but outside the identifier's directories
+		for _, dir := range []string{filepath.Join(installRoot, "var", "test"), p.confDir} {
+			_, err := os.Stat(dir)
+			require.True(t, os.IsNotExist(err), dir)
+		}
+		_, err := os.Stat(filepath.Join(installRoot, "var"))
+		require.NoError(t, err)
+	}
+
+	// Packages without scripts, or an identifier, get none
+	for _, p := range []*PackageOptions{
+		{target: Target{Platform: Linux, Init: SystemD, Package: Tar}, Identifier: "test"},
+		{target: Target{Platform: Linux, Init: SystemD, Package: Deb}},
+	} {
+		scriptDir, err := ioutil.TempDir(testDir, "scripts")
+		require.NoError(t, err)
+		p.scriptRoot = scriptDir
+		require.NoError(t, p.setupPrerm(context.TODO()))
+		require.NoError(t, p.setupPostrm(context.TODO()))
+		files, err := ioutil.ReadDir(scriptDir)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	}
+}
+
+func TestSetupUninstallScript(t *testing.T) {
+	t.Parallel()
+
+	packageRoot, err := ioutil.TempDir("", "test-packaging-uninstall")
+	require.NoError(t, err)
+	defer os.RemoveAll(packageRoot)
+
+	p := &PackageOptions{
+		target:      Target{Platform: Darwin, Init: LaunchD, Package: Pkg},
+		Identifier:  "test",
+		Hostname:    "device.kolide.com:443",
+		packageRoot: packageRoot,
+		initFile:    "/Library/LaunchDaemons/com.test.launcher.plist",
+	}
+	require.NoError(t, p.setupDirectories())
+	require.NoError(t, p.setupUninstallScript())
+
+	uninstall := filepath.Join(packageRoot, p.binDir, uninstallScriptName)
+	require.NoError(t, exec.Command("/bin/sh", "-n", uninstall).Run())
+
+	contents, err := ioutil.ReadFile(uninstall)
+	require.NoError(t, err)
+	script := string(contents)
+	require.Contains(t, script, `/bin/launchctl unload "/Library/LaunchDaemons/com.test.launcher.plist"`)
+	require.Contains(t, script, `rm -rf "/var/test" "/etc/test" "/var/log/test" "/usr/local/test"`)
+	require.Contains(t, script, `rm -f "/Library/LaunchDaemons/com.test.launcher.plist" "/etc/newsyslog.d/test.conf"`)
+	require.Contains(t, script, "pkgutil --forget com.test.launcher")
+
+	fi, err := os.Stat(uninstall)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+}
@@ -51,7 +51,7 @@ func MakeCheckHealthEndpoint(svc KolideService) endpoint.Endpoint {
 }
 
 func (e Endpoints) CheckHealth(ctx context.Context) (int32, error) {
-	newCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	newCtx, cancel := context.WithTimeout(ctx, e.timeout())
 	defer cancel()
 	request := healthcheckRequest{}
 	response, err := e.CheckHealthEndpoint(newCtx, request)
@@ -21,9 +21,20 @@ import (
 	pb "github.com/kolide/launcher/pkg/pb/launcher"
 )
 
+// ClientOption configures the client New creates.
+type ClientOption func(*Endpoints)
+
+// WithRequestTimeout sets the duration after which each request is
+// cancelled. The default is 60s.
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(e *Endpoints) {
+		e.requestTimeout = timeout
+	}
+}
+
 // New creates a new Kolide Client (implementation of the KolideService
 // interface) using the provided gRPC client connection.
-func New(conn *grpc.ClientConn, logger log.Logger) KolideService {
+func New(conn *grpc.ClientConn, logger log.Logger, opts ...ClientOption) KolideService {
 	requestEnrollmentEndpoint := grpctransport.NewClient(
 		conn,
 		"kolide.agent.Api",
@@ -84,7 +95,7 @@ func New(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		uuid.Attach(),
 	).Endpoint()
 
-	var client KolideService = Endpoints{
+	endpoints := Endpoints{
 		RequestEnrollmentEndpoint: requestEnrollmentEndpoint,
 		RequestConfigEndpoint:     requestConfigEndpoint,
 		PublishLogsEndpoint:       publishLogsEndpoint,
@@ -92,6 +103,11 @@ func New(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		PublishResultsEndpoint:    publishResultsEndpoint,
 		CheckHealthEndpoint:       checkHealthEndpoint,
 	}
+	for _, opt := range opts {
+		opt(&endpoints)
+	}
+
+	var client KolideService = endpoints
 
 	client = LoggingMiddleware(logger)(client)
 	// Wrap with UUID middleware after logger so that UUID is available in
@@ -101,13 +117,17 @@ func New(conn *grpc.ClientConn, logger log.Logger) KolideService {
 	return client
 }
 
-// dialGRPC creates a grpc client connection.
+// dialGRPC creates a grpc client connection. If connectTimeout is
+// set, it waits until the connection is up, and fails if that takes
+// longer. Otherwise, it returns at once, and grpc connects in the
+// background.
 func DialGRPC(
 	serverURL string,
 	insecureTLS bool,
 	insecureGRPC bool,
 	certPins [][]byte,
 	rootPool *x509.CertPool,
+	connectTimeout time.Duration,
 	logger log.Logger,
 	opts ...grpc.DialOption, // Used for overrides in testing
 ) (*grpc.ClientConn, error) {
@@ -117,10 +137,9 @@ func DialGRPC(
 		"tls_secure", insecureTLS == false,
 		"grpc_secure", insecureGRPC == false,
 		"cert_pinning", len(certPins) > 0,
+		"connect_timeout", connectTimeout,
 	)
-	grpcOpts := []grpc.DialOption{
-		grpc.WithTimeout(time.Second),
-	}
+	grpcOpts := []grpc.DialOption{}
 	if insecureGRPC {
 		grpcOpts = append(grpcOpts, grpc.WithInsecure())
 	} else {
@@ -135,7 +154,16 @@ func DialGRPC(
 
 	grpcOpts = append(grpcOpts, opts...)
 
-	conn, err := grpc.Dial(serverURL, grpcOpts...)
+	ctx := context.Background()
+	if connectTimeout > 0 {
+		// grpc only honors the dial context when blocking
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, connectTimeout)
+		defer cancel()
+		grpcOpts = append(grpcOpts, grpc.WithBlock())
+	}
+
+	conn, err := grpc.DialContext(ctx, serverURL, grpcOpts...)
 	return conn, err
 }
 
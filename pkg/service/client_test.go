@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	var remaining time.Duration
+	e := Endpoints{
+		CheckHealthEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			remaining = time.Until(deadline)
+			return healthcheckResponse{}, nil
+		},
+	}
+
+	_, err := e.CheckHealth(context.Background())
+	require.NoError(t, err)
+	require.InDelta(t, defaultRequestTimeout, remaining, float64(time.Second))
+
+	WithRequestTimeout(5 * time.Minute)(&e)
+	_, err = e.CheckHealth(context.Background())
+	require.NoError(t, err)
+	require.InDelta(t, 5*time.Minute, remaining, float64(time.Second))
+}
@@ -79,7 +79,7 @@ func TestSwappingCert(t *testing.T) {
 	pool.AppendCertsFromPEM(pem1)
 	pool.AppendCertsFromPEM(pem2)
 
-	conn, err := DialGRPC("localhost:8443", false, false, nil, nil, log.NewNopLogger(),
+	conn, err := DialGRPC("localhost:8443", false, false, nil, nil, 0, log.NewNopLogger(),
 		grpc.WithTransportCredentials(&tlsCreds{credentials.NewTLS(&tls.Config{RootCAs: pool})}),
 	)
 	require.Nil(t, err)
@@ -117,7 +117,7 @@ func TestCertRemainsBad(t *testing.T) {
 	pool.AppendCertsFromPEM(pem1)
 	pool.AppendCertsFromPEM(pem2)
 
-	conn, err := DialGRPC("localhost:8443", false, false, nil, nil, log.NewNopLogger(),
+	conn, err := DialGRPC("localhost:8443", false, false, nil, nil, 0, log.NewNopLogger(),
 		grpc.WithTransportCredentials(&tlsCreds{credentials.NewTLS(&tls.Config{RootCAs: pool})}),
 	)
 	require.Nil(t, err)
@@ -185,7 +185,7 @@ func TestCertPinning(t *testing.T) {
 			tlsconf := makeTLSConfig("localhost", false, certPins, nil, log.NewNopLogger())
 			tlsconf.RootCAs = pool
 
-			conn, err := DialGRPC("localhost:8443", false, false, nil, nil, log.NewNopLogger(),
+			conn, err := DialGRPC("localhost:8443", false, false, nil, nil, 0, log.NewNopLogger(),
 				grpc.WithTransportCredentials(&tlsCreds{credentials.NewTLS(tlsconf)}),
 			)
 			require.Nil(t, err)
@@ -246,7 +246,7 @@ func TestRootCAs(t *testing.T) {
 
 	for _, tt := range testCases {
 		t.Run("", func(t *testing.T) {
-			conn, err := DialGRPC("localhost:8443", false, false, nil, tt.pool, log.NewNopLogger())
+			conn, err := DialGRPC("localhost:8443", false, false, nil, tt.pool, 0, log.NewNopLogger())
 			require.Nil(t, err)
 			defer conn.Close()
 
@@ -275,3 +275,23 @@ func parseCertPins(pins string) ([][]byte, error) {
 	}
 	return certPins, nil
 }
+
+func TestDialGRPCConnectTimeout(t *testing.T) {
+	t.Parallel()
+
+	// Nothing listens on the port once the listener is closed
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	serverURL := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	start := time.Now()
+	_, err = DialGRPC(serverURL, false, true, nil, nil, 500*time.Millisecond, log.NewNopLogger())
+	require.Error(t, err)
+	require.True(t, time.Since(start) < 5*time.Second, "dial took %s", time.Since(start))
+
+	// Without a timeout, it doesn't wait to connect
+	conn, err := DialGRPC(serverURL, false, true, nil, nil, 0, log.NewNopLogger())
+	require.NoError(t, err)
+	conn.Close()
+}
@@ -112,7 +112,7 @@ func MakePublishLogsEndpoint(svc KolideService) endpoint.Endpoint {
 
 // PublishLogs implements KolideService.PublishLogs
 func (e Endpoints) PublishLogs(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
-	newCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	newCtx, cancel := context.WithTimeout(ctx, e.timeout())
 	defer cancel()
 	request := logCollection{NodeKey: nodeKey, LogType: logType, Logs: logs}
 	response, err := e.PublishLogsEndpoint(newCtx, request)
@@ -122,7 +122,7 @@ func MakePublishResultsEndpoint(svc KolideService) endpoint.Endpoint {
 
 // PublishResults implements KolideService.PublishResults
 func (e Endpoints) PublishResults(ctx context.Context, nodeKey string, results []distributed.Result) (string, string, bool, error) {
-	newCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	newCtx, cancel := context.WithTimeout(ctx, e.timeout())
 	defer cancel()
 
 	request := resultCollection{NodeKey: nodeKey, Results: results}
@@ -66,7 +66,7 @@ func MakeRequestConfigEndpoint(svc KolideService) endpoint.Endpoint {
 
 // RequestConfig implements KolideService.RequestConfig.
 func (e Endpoints) RequestConfig(ctx context.Context, nodeKey string) (string, bool, error) {
-	newCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	newCtx, cancel := context.WithTimeout(ctx, e.timeout())
 	defer cancel()
 	request := configRequest{NodeKey: nodeKey}
 	response, err := e.RequestConfigEndpoint(newCtx, request)
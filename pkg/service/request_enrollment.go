@@ -114,12 +114,21 @@ func MakeRequestEnrollmentEndpoint(svc KolideService) endpoint.Endpoint {
 	}
 }
 
-// requestTimeout is duration after which the request is cancelled.
-const requestTimeout = 60 * time.Second
+// defaultRequestTimeout is duration after which the request is
+// cancelled, unless the client sets its own, with WithRequestTimeout.
+const defaultRequestTimeout = 60 * time.Second
+
+// timeout is the duration after which e's requests are cancelled.
+func (e Endpoints) timeout() time.Duration {
+	if e.requestTimeout > 0 {
+		return e.requestTimeout
+	}
+	return defaultRequestTimeout
+}
 
 // RequestEnrollment implements KolideService.RequestEnrollment
 func (e Endpoints) RequestEnrollment(ctx context.Context, enrollSecret, hostIdentifier string, details EnrollmentDetails) (string, bool, error) {
-	newCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	newCtx, cancel := context.WithTimeout(ctx, e.timeout())
 	defer cancel()
 
 	request := enrollmentRequest{EnrollSecret: enrollSecret, HostIdentifier: hostIdentifier, EnrollmentDetails: details}
@@ -87,7 +87,7 @@ func MakeRequestQueriesEndpoint(svc KolideService) endpoint.Endpoint {
 
 // RequestQueries implements KolideService.RequestQueries
 func (e Endpoints) RequestQueries(ctx context.Context, nodeKey string) (*distributed.GetQueriesResult, bool, error) {
-	newCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	newCtx, cancel := context.WithTimeout(ctx, e.timeout())
 	defer cancel()
 	request := queriesRequest{NodeKey: nodeKey}
 	response, err := e.RequestQueriesEndpoint(newCtx, request)
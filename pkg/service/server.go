@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
@@ -36,6 +37,8 @@ type Endpoints struct {
 	RequestQueriesEndpoint    endpoint.Endpoint
 	PublishResultsEndpoint    endpoint.Endpoint
 	CheckHealthEndpoint       endpoint.Endpoint
+
+	requestTimeout time.Duration // For clients. If unset, defaultRequestTimeout
 }
 
 func MakeServerEndpoints(svc KolideService) Endpoints {
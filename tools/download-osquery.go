@@ -40,7 +40,7 @@ func main() {
 
 	ctx := context.Background()
 
-	path, err := packaging.FetchBinary(ctx, cacheDir, "osqueryd", *flVersion, *flPlatform)
+	path, err := packaging.FetchBinary(ctx, cacheDir, "osqueryd", *flVersion, *flPlatform, "")
 	if err != nil {
 		fmt.Println("An error occurred fetching the osqueryd binary: ", err)
 		os.Exit(1)